@@ -28,10 +28,14 @@ import (
 	_ "github.com/nyaruka/mailroom/core/tasks/timeouts"
 	_ "github.com/nyaruka/mailroom/services/ivr/twiml"
 	_ "github.com/nyaruka/mailroom/services/ivr/vonage"
+	_ "github.com/nyaruka/mailroom/services/stt/command"
 	_ "github.com/nyaruka/mailroom/services/tickets/intern"
 	_ "github.com/nyaruka/mailroom/services/tickets/mailgun"
 	_ "github.com/nyaruka/mailroom/services/tickets/rocketchat"
 	_ "github.com/nyaruka/mailroom/services/tickets/zendesk"
+	_ "github.com/nyaruka/mailroom/services/tts/command"
+	_ "github.com/nyaruka/mailroom/web/broadcast"
+	_ "github.com/nyaruka/mailroom/web/campaign"
 	_ "github.com/nyaruka/mailroom/web/contact"
 	_ "github.com/nyaruka/mailroom/web/docs"
 	_ "github.com/nyaruka/mailroom/web/expression"
@@ -92,3 +92,73 @@ func TestContactFieldChanged(t *testing.T) {
 
 	handlers.RunTestCases(t, ctx, rt, tcs)
 }
+
+func TestContactFieldChangedHistory(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	gender := assets.NewFieldReference("gender", "Gender")
+	age := assets.NewFieldReference("age", "Age")
+
+	// enable field history for just the age field
+	db.MustExec(`UPDATE orgs_org SET config = '{"contact_field_history": {"fields": ["age"], "retention_days": 30}}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+	db.MustExec(`UPDATE contacts_contact SET fields = '{"903f51da-2717-47c7-a0d3-f2f32877013d": {"text":"34"}}' WHERE id = $1`, testdata.Alexandria.ID)
+
+	tcs := []handlers.TestCase{
+		{
+			Actions: handlers.ContactActionMap{
+				testdata.George: []flows.Action{
+					actions.NewSetContactField(handlers.NewActionUUID(), gender, "Male"),
+					actions.NewSetContactField(handlers.NewActionUUID(), age, "39"),
+					actions.NewSetContactField(handlers.NewActionUUID(), age, "40"),
+				},
+				testdata.Alexandria: []flows.Action{
+					actions.NewSetContactField(handlers.NewActionUUID(), age, ""),
+				},
+			},
+			SQLAssertions: []handlers.SQLAssertion{
+				{
+					SQL:   `select count(*) from contacts_contactfieldhistory where contact_id = $1 AND field_id = $2 AND old_value IS NULL AND new_value = '40'`,
+					Args:  []interface{}{testdata.George.ID, testdata.AgeField.ID},
+					Count: 1,
+				},
+				{
+					SQL:   `select count(*) from contacts_contactfieldhistory where contact_id = $1 AND field_id = $2 AND old_value = '34' AND new_value IS NULL`,
+					Args:  []interface{}{testdata.Alexandria.ID, testdata.AgeField.ID},
+					Count: 1,
+				},
+				{
+					SQL:   `select count(*) from contacts_contactfieldhistory where field_id = $1`,
+					Args:  []interface{}{testdata.GenderField.ID},
+					Count: 0,
+				},
+			},
+		},
+	}
+
+	handlers.RunTestCases(t, ctx, rt, tcs)
+
+	// without the field history table, field changes are still saved but history isn't recorded
+	db.MustExec(`ALTER TABLE contacts_contactfieldhistory RENAME TO contacts_contactfieldhistory_tmp`)
+	defer db.MustExec(`ALTER TABLE contacts_contactfieldhistory_tmp RENAME TO contacts_contactfieldhistory`)
+
+	tcs = []handlers.TestCase{
+		{
+			Actions: handlers.ContactActionMap{
+				testdata.George: []flows.Action{
+					actions.NewSetContactField(handlers.NewActionUUID(), age, "41"),
+				},
+			},
+			SQLAssertions: []handlers.SQLAssertion{
+				{
+					SQL:   `select count(*) from contacts_contact where id = $1 AND fields->$2->>'text' = '41'`,
+					Args:  []interface{}{testdata.George.ID, testdata.AgeField.UUID},
+					Count: 1,
+				},
+			},
+		},
+	}
+
+	handlers.RunTestCases(t, ctx, rt, tcs)
+}
@@ -109,6 +109,9 @@ func handleMsgCreated(ctx context.Context, rt *runtime.Runtime, tx *sqlx.Tx, oa
 	// register to have this message committed
 	scene.AppendToEventPreCommitHook(hooks.CommitMessagesHook, msg)
 
+	// and once it is, for any frequency cap slot it took to be kept
+	scene.AppendToEventPostCommitHook(hooks.ConfirmFrequencyCapSlotsHook, msg)
+
 	// don't send messages for surveyor flows
 	if scene.Session().SessionType() != models.FlowTypeSurveyor {
 		scene.AppendToEventPostCommitHook(hooks.SendMessagesHook, msg)
@@ -10,6 +10,7 @@ import (
 	"github.com/nyaruka/goflow/flows/events"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/null"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -24,8 +25,31 @@ func (h *commitFieldChangesHook) Apply(ctx context.Context, rt *runtime.Runtime,
 	// our list of updates
 	fieldUpdates := make([]interface{}, 0, len(scenes))
 	fieldDeletes := make(map[assets.FieldUUID][]interface{})
+
+	// if this org records field history, we need to know the values we're replacing
+	historyConfig, err := models.GetFieldHistoryConfig(ctx, tx, oa.Org())
+	if err != nil {
+		return err
+	}
+
+	var oldValues map[models.ContactID]map[assets.FieldUUID]string
+	fieldChanges := make([]*models.FieldChange, 0)
+
+	if historyConfig != nil {
+		contactIDs := make([]models.ContactID, 0, len(scenes))
+		for scene := range scenes {
+			contactIDs = append(contactIDs, scene.ContactID())
+		}
+
+		oldValues, err = models.LoadContactFieldTexts(ctx, tx, contactIDs)
+		if err != nil {
+			return errors.Wrapf(err, "error loading existing field values")
+		}
+	}
+
 	for scene, es := range scenes {
 		updates := make(map[assets.FieldUUID]*flows.Value, len(es))
+		lastEvents := make(map[assets.FieldUUID]*events.ContactFieldChangedEvent, len(es))
 		for _, e := range es {
 			event := e.(*events.ContactFieldChangedEvent)
 			field := oa.FieldByKey(event.Field.Key)
@@ -39,6 +63,17 @@ func (h *commitFieldChangesHook) Apply(ctx context.Context, rt *runtime.Runtime,
 			}
 
 			updates[field.UUID()] = event.Value
+			lastEvents[field.UUID()] = event
+		}
+
+		if historyConfig != nil {
+			for fieldUUID, event := range lastEvents {
+				if historyConfig.Records(event.Field.Key) {
+					if change := newFieldChange(oa, scene, event, oldValues[scene.ContactID()][fieldUUID]); change != nil {
+						fieldChanges = append(fieldChanges, change)
+					}
+				}
+			}
 		}
 
 		// trim out deletes, adding to our list of global deletes
@@ -82,9 +117,50 @@ func (h *commitFieldChangesHook) Apply(ctx context.Context, rt *runtime.Runtime,
 		}
 	}
 
+	// and finally record our history
+	if len(fieldChanges) > 0 {
+		if err := models.InsertFieldChanges(ctx, tx, fieldChanges); err != nil {
+			return errors.Wrapf(err, "error inserting field history")
+		}
+	}
+
 	return nil
 }
 
+// creates a field history change for the given event, or returns nil if the value hasn't actually changed
+func newFieldChange(oa *models.OrgAssets, scene *models.Scene, event *events.ContactFieldChangedEvent, oldValue string) *models.FieldChange {
+	newValue := ""
+	if event.Value != nil {
+		newValue = event.Value.Text.Native()
+	}
+	if newValue == oldValue {
+		return nil
+	}
+
+	// if this change was made in a flow, record which one
+	var flowID models.FlowID
+	if scene.Session() != nil {
+		run, _ := scene.Session().FindStep(event.StepUUID())
+		if run != nil {
+			flowAsset, _ := oa.FlowByUUID(run.FlowReference().UUID)
+			if flowAsset != nil {
+				flowID = flowAsset.(*models.Flow).ID()
+			}
+		}
+	}
+
+	return &models.FieldChange{
+		OrgID:       oa.OrgID(),
+		ContactID:   scene.ContactID(),
+		FieldID:     oa.FieldByKey(event.Field.Key).ID(),
+		OldValue:    null.String(oldValue),
+		NewValue:    null.String(newValue),
+		ChangedByID: scene.UserID(),
+		FlowID:      flowID,
+		ChangedOn:   event.CreatedOn(),
+	}
+}
+
 type FieldDelete struct {
 	ContactID models.ContactID `db:"contact_id"`
 	FieldUUID assets.FieldUUID `db:"field_uuid"`
@@ -0,0 +1,32 @@
+package hooks
+
+import (
+	"context"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ConfirmFrequencyCapSlotsHook is our hook for confirming the frequency cap slots of committed messages
+var ConfirmFrequencyCapSlotsHook models.EventCommitHook = &confirmFrequencyCapSlotsHook{}
+
+type confirmFrequencyCapSlotsHook struct{}
+
+// Apply confirms the frequency cap slots taken by all the messages in the passed in scenes
+func (h *confirmFrequencyCapSlotsHook) Apply(ctx context.Context, rt *runtime.Runtime, tx *sqlx.Tx, oa *models.OrgAssets, scenes map[*models.Scene][]interface{}) error {
+	msgs := make([]*models.Msg, 0, len(scenes))
+	for _, s := range scenes {
+		for _, m := range s {
+			msgs = append(msgs, m.(*models.Msg))
+		}
+	}
+
+	if err := models.ConfirmFrequencyCapSlots(rt.RP, msgs); err != nil {
+		return errors.Wrapf(err, "error confirming frequency cap slots")
+	}
+
+	return nil
+}
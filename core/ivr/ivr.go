@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
-	"path"
 	"strconv"
 	"time"
 
@@ -14,6 +13,7 @@ import (
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/gocommon/uuids"
 	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/envs"
 	"github.com/nyaruka/goflow/excellent/types"
 	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/goflow/flows/resumes"
@@ -40,6 +40,34 @@ const (
 	ErrorMessage = "An error has occurred, please try again later."
 )
 
+// when a machine is detected after the call was answered, the call is redirected to resume the flow with this wait type
+// and the flow's waiting session is resumed with this input so that it can route on it
+const (
+	machineWaitType = "machine"
+	machineInput    = "machine"
+)
+
+// MachineDetection is the type of answering machine detection requested for a call
+type MachineDetection string
+
+const (
+	MachineDetectionNone      = MachineDetection("")          // no detection
+	MachineDetectionHangup    = MachineDetection("hangup")    // end the call if a machine answers
+	MachineDetectionVoicemail = MachineDetection("voicemail") // wait for the beep if a machine answers so a voicemail can be left
+)
+
+// MachineDetectionFor returns the type of answering machine detection to request for calls on the passed in channel for
+// the passed in flow (which may be nil)
+func MachineDetectionFor(channel *models.Channel, flow *models.Flow) MachineDetection {
+	if !channel.MachineDetection() {
+		return MachineDetectionNone
+	}
+	if flow != nil && flow.IVRVoicemail() != nil {
+		return MachineDetectionVoicemail
+	}
+	return MachineDetectionHangup
+}
+
 // our map of service constructors
 var constructors = make(map[models.ChannelType]ServiceConstructor)
 
@@ -63,15 +91,21 @@ func GetService(channel *models.Channel) (Service, error) {
 
 // Service defines the interface IVR services must satisfy
 type Service interface {
-	RequestCall(number urns.URN, handleURL string, statusURL string, machineDetection bool) (CallID, *httpx.Trace, error)
+	RequestCall(number urns.URN, handleURL string, statusURL string, machineDetection MachineDetection) (CallID, *httpx.Trace, error)
 
 	HangupCall(externalID string) (*httpx.Trace, error)
 
+	// RedirectCall asks the provider to fetch new instructions for the passed in in-progress call from the passed in URL
+	RedirectCall(externalID string, url string) (*httpx.Trace, error)
+
 	WriteSessionResponse(ctx context.Context, rt *runtime.Runtime, channel *models.Channel, call *models.Call, session *models.Session, number urns.URN, resumeURL string, req *http.Request, w http.ResponseWriter) error
 	WriteRejectResponse(w http.ResponseWriter) error
 	WriteErrorResponse(w http.ResponseWriter, err error) error
 	WriteEmptyResponse(w http.ResponseWriter, msg string) error
 
+	// WriteVoicemailResponse writes a response which plays the passed in voicemail message and then hangs up
+	WriteVoicemailResponse(ctx context.Context, rt *runtime.Runtime, channel *models.Channel, call *models.Call, number urns.URN, msg *flows.MsgOut, w http.ResponseWriter) error
+
 	ResumeForRequest(r *http.Request) (Resume, error)
 
 	// StatusForRequest returns the call status for the passed in request, and if it's an error the reason,
@@ -186,6 +220,11 @@ func RequestCall(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets,
 	// get the channel for this URN
 	channel := callChannel.Asset().(*models.Channel)
 
+	flow, err := oa.FlowByID(start.FlowID())
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to load flow: %d", start.FlowID())
+	}
+
 	// create our call object
 	conn, err := models.InsertCall(
 		ctx, rt.DB, oa.OrgID(), channel.ID(), start.StartID(), contact.ID(), models.URNID(urnID),
@@ -195,7 +234,13 @@ func RequestCall(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets,
 		return nil, errors.Wrapf(err, "error creating call")
 	}
 
-	clog, err := RequestStartForCall(ctx, rt, channel, telURN, conn)
+	// if we're outside of the calling window, the call will be requested by the retry cron once the window opens
+	deferred, err := DeferCallToWindow(ctx, rt, oa, flow, conn)
+	if err != nil || deferred {
+		return conn, err
+	}
+
+	clog, err := RequestStartForCall(ctx, rt, channel, flow, telURN, conn)
 
 	// log any error inserting our channel log, but continue
 	if clog != nil {
@@ -207,7 +252,8 @@ func RequestCall(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets,
 	return conn, err
 }
 
-func RequestStartForCall(ctx context.Context, rt *runtime.Runtime, channel *models.Channel, telURN urns.URN, call *models.Call) (*models.ChannelLog, error) {
+// RequestStartForCall requests the passed in call from the IVR provider for the passed in flow (which may be nil)
+func RequestStartForCall(ctx context.Context, rt *runtime.Runtime, channel *models.Channel, flow *models.Flow, telURN urns.URN, call *models.Call) (*models.ChannelLog, error) {
 	// the domain that will be used for callbacks, can be specific for channels due to white labeling
 	domain := channel.ConfigValue(models.ChannelConfigCallbackDomain, rt.Config.Domain)
 
@@ -257,7 +303,7 @@ func RequestStartForCall(ctx context.Context, rt *runtime.Runtime, channel *mode
 	defer clog.End()
 
 	// try to request our call start
-	callID, trace, err := svc.RequestCall(telURN, resumeURL, statusURL, channel.MachineDetection())
+	callID, trace, err := svc.RequestCall(telURN, resumeURL, statusURL, MachineDetectionFor(channel, flow))
 	if trace != nil {
 		clog.HTTP(trace)
 	}
@@ -283,6 +329,131 @@ func RequestStartForCall(ctx context.Context, rt *runtime.Runtime, channel *mode
 	return clog, nil
 }
 
+// RedirectCallToVoicemail asks the provider to resume the flow of the passed in in-progress call with the machine input,
+// used when a machine is only detected after the call has been answered and the flow started. Once the flow has been
+// resumed, the voicemail is left.
+func RedirectCallToVoicemail(ctx context.Context, rt *runtime.Runtime, svc Service, channel *models.Channel, telURN urns.URN, call *models.Call) error {
+	domain := channel.ConfigValue(models.ChannelConfigCallbackDomain, rt.Config.Domain)
+
+	form := url.Values{
+		"action":     []string{"resume"},
+		"connection": []string{fmt.Sprintf("%d", call.ID())},
+		"urn":        []string{telURN.String()},
+		"wait_type":  []string{machineWaitType},
+	}
+	redirectURL := fmt.Sprintf("https://%s/mr/ivr/c/%s/handle?%s", domain, channel.UUID(), form.Encode())
+
+	clog := models.NewChannelLog(models.ChannelLogTypeIVRStart, channel, svc.RedactValues(channel))
+	clog.SetCall(call)
+	defer clog.End()
+
+	trace, redirectErr := svc.RedirectCall(call.ExternalID(), redirectURL)
+	if trace != nil {
+		clog.HTTP(trace)
+	}
+	if redirectErr != nil {
+		clog.Error(redirectErr)
+	}
+
+	if err := call.AttachLog(ctx, rt.DB, clog); err != nil {
+		logrus.WithError(err).Error("error attaching ivr channel log")
+	}
+	if err := models.InsertChannelLogs(ctx, rt.DB, []*models.ChannelLog{clog}); err != nil {
+		logrus.WithError(err).Error("error inserting ivr channel log")
+	}
+
+	if redirectErr != nil {
+		return errors.Wrap(redirectErr, "error redirecting call to voicemail")
+	}
+	return nil
+}
+
+// DeferCallToWindow checks whether the passed in call can be made now according to the flow or org calling window, and
+// if not, queues it until the window next opens
+func DeferCallToWindow(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, flow *models.Flow, call *models.Call) (bool, error) {
+	window := flow.IVRCallWindow(oa.Org())
+	if window == nil {
+		return false, nil
+	}
+
+	now := dates.Now()
+	next, err := window.NextAllowed(now, oa.Env().Timezone())
+	if err != nil {
+		logrus.WithError(err).WithField("flow_id", flow.ID()).Error("ignoring invalid call window")
+		return false, nil
+	}
+
+	if !next.After(now) {
+		return false, nil
+	}
+
+	if err := call.MarkDeferred(ctx, rt.DB, next); err != nil {
+		return false, errors.Wrapf(err, "error deferring call to window")
+	}
+
+	logrus.WithField("call_id", call.ID()).WithField("next_attempt", next).Info("call deferred until calling window opens")
+	return true, nil
+}
+
+// marks the passed in call as errored, scheduling a retry according to the flow or org retry schedule and calling window
+func markCallErrored(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, flow *models.Flow, call *models.Call, errorReason models.CallError) error {
+	now := dates.Now()
+
+	var nextAttempt *time.Time
+	if wait := flow.IVRRetryWaitFor(oa.Org(), errorReason, call.ErrorCount()); wait != nil {
+		next := now.Add(*wait)
+
+		// make sure our retry falls within the calling window
+		if window := flow.IVRCallWindow(oa.Org()); window != nil {
+			allowed, err := window.NextAllowed(next, oa.Env().Timezone())
+			if err != nil {
+				logrus.WithError(err).WithField("flow_id", flow.ID()).Error("ignoring invalid call window")
+			} else {
+				next = allowed
+			}
+		}
+
+		nextAttempt = &next
+	}
+
+	return call.MarkErrored(ctx, rt.DB, now, nextAttempt, errorReason)
+}
+
+// returns the voicemail message for the passed in call if it was started for a flow which has one
+func voicemailForCall(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, channel *models.Channel, call *models.Call, contact *models.Contact, urn urns.URN) (*flows.MsgOut, error) {
+	if call.StartID() == models.NilStartID {
+		return nil, nil
+	}
+
+	start, err := models.GetFlowStartAttributes(ctx, rt.DB, call.StartID())
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to load start: %d", call.StartID())
+	}
+	flow, err := oa.FlowByID(start.FlowID())
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to load flow: %d", start.FlowID())
+	}
+
+	return voicemailForContact(oa, flow, contact, channel, urn), nil
+}
+
+// returns the voicemail message for the passed in contact if the flow has one in the contact's language or the default
+func voicemailForContact(oa *models.OrgAssets, flow *models.Flow, contact *models.Contact, channel *models.Channel, urn urns.URN) *flows.MsgOut {
+	voicemail := flow.IVRVoicemail()
+	if voicemail == nil {
+		return nil
+	}
+
+	msg, lang := voicemail.MessageFor(contact.Language(), oa.Env().DefaultLanguage())
+	if msg == nil {
+		return nil
+	}
+
+	locale := envs.NewLocale(lang, oa.Env().DefaultCountry())
+
+	return flows.NewIVRMsgOut(urn, channel.ChannelReference(), msg.Text, msg.AudioURL, locale)
+}
+
 // HandleAsFailure marks the passed in call as errored and writes the appropriate error response to our writer
 func HandleAsFailure(ctx context.Context, db *sqlx.DB, svc Service, call *models.Call, w http.ResponseWriter, rootErr error) error {
 	err := call.MarkFailed(ctx, db, time.Now())
@@ -315,7 +486,7 @@ func StartIVRFlow(
 
 	// check that call on service side is in the state we need to continue
 	if errorReason := svc.CheckStartRequest(r); errorReason != "" {
-		err := call.MarkErrored(ctx, rt.DB, dates.Now(), flow.IVRRetryWait(), errorReason)
+		err := markCallErrored(ctx, rt, oa, flow, call, errorReason)
 		if err != nil {
 			return errors.Wrap(err, "unable to mark call as errored")
 		}
@@ -487,6 +658,12 @@ func ResumeIVRFlow(
 	case DialResume:
 		resume, svcErr, err = buildDialResume(oa, contact, res)
 
+	case MachineResume:
+		resume, svcErr, err = buildMsgResume(ctx, rt, svc, channel, contact, urn, call, oa, r, InputResume{Input: machineInput})
+		if resume != nil {
+			session.SetIncomingMsg(models.MsgID(resume.(*resumes.MsgResume).Msg().ID()), null.NullString)
+		}
+
 	default:
 		return fmt.Errorf("unknown resume type: %vvv", ivrResume)
 	}
@@ -506,6 +683,29 @@ func ResumeIVRFlow(
 		return errors.Wrapf(err, "error resuming ivr flow")
 	}
 
+	// if a machine answered, the flow has had the chance to route on that, and now we leave our voicemail
+	if _, isMachine := ivrResume.(MachineResume); isMachine {
+		voicemail, err := voicemailForCall(ctx, rt, oa, channel, call, c, urn)
+		if err != nil {
+			return errors.Wrapf(err, "error loading voicemail for call")
+		}
+
+		if voicemail != nil {
+			// the call will hang up after the voicemail so a flow which went on to wait for input can't be resumed
+			if session.Status() == models.SessionStatusWaiting {
+				if err := models.ExitSessions(ctx, rt.DB, []models.SessionID{session.ID()}, models.SessionStatusInterrupted); err != nil {
+					return errors.Wrapf(err, "error interrupting voicemail session")
+				}
+			}
+
+			if err := call.MarkVoicemail(ctx, rt.DB, dates.Now()); err != nil {
+				return errors.Wrapf(err, "error updating call status")
+			}
+
+			return svc.WriteVoicemailResponse(ctx, rt, channel, call, urn, voicemail, w)
+		}
+	}
+
 	// if still active, write out our response
 	if status == models.CallStatusInProgress {
 		err = svc.WriteSessionResponse(ctx, rt, channel, call, session, urn, resumeURL, r, w)
@@ -535,38 +735,22 @@ func buildMsgResume(
 	// our msg UUID
 	msgUUID := flows.MsgUUID(uuids.New())
 
-	// we have an attachment, download it locally
+	// we have a recording, which we store ourselves as the provider's copy will expire, and transcribe so that the flow
+	// can route on what was said. Providers can take a while to make recordings available so if we can't do that in
+	// time, we resume with the provider's copy and no transcript, and store and transcribe it later.
+	var recording *Recording
 	if resume.Attachment != NilAttachment {
-		var err error
-		var resp *http.Response
-		for retry := 0; retry < 45; retry++ {
-			resp, err = svc.DownloadMedia(resume.Attachment.URL())
-			if err == nil && resp.StatusCode == 200 {
-				break
-			}
-			time.Sleep(time.Second)
-
-			if resp != nil {
-				logrus.WithField("retry", retry).WithField("status", resp.StatusCode).WithField("url", resume.Attachment.URL()).Info("retrying download of attachment")
-			} else {
-				logrus.WithError(err).WithField("retry", retry).WithField("url", resume.Attachment.URL()).Info("retrying download of attachment")
-			}
-		}
-
-		if err != nil {
-			return nil, errors.Wrapf(err, "error downloading attachment, ending call"), nil
-		}
-
-		if resp == nil {
-			return nil, errors.Errorf("unable to download attachment, ending call"), nil
-		}
+		recording = &Recording{MsgUUID: msgUUID, ChannelID: channel.ID(), Attachment: resume.Attachment, Locale: contact.Locale(oa.Env())}
 
-		// filename is based on our org id and msg UUID
-		filename := string(msgUUID) + path.Ext(resume.Attachment.URL())
-
-		resume.Attachment, err = oa.Org().StoreAttachment(ctx, rt, filename, resume.Attachment.ContentType(), resp.Body)
+		stored, transcript, err := processRecordingForResume(ctx, rt, oa, svc, recording)
 		if err != nil {
-			return nil, errors.Wrapf(err, "unable to download and store attachment, ending call"), nil
+			logrus.WithError(err).WithField("msg_uuid", msgUUID).Warn("unable to store recording before resuming, will retry in background")
+		} else {
+			resume.Attachment = stored
+			if resume.Input == "" {
+				resume.Input = transcript
+			}
+			recording = nil
 		}
 	}
 
@@ -585,6 +769,18 @@ func buildMsgResume(
 		return nil, nil, errors.Wrapf(err, "error committing new message")
 	}
 
+	// and queue our recording to be downloaded if we couldn't store it already
+	if recording != nil {
+		recording.MsgID = models.MsgID(msg.ID())
+
+		rc := rt.RP.Get()
+		err := QueueRecording(rc, oa.OrgID(), recording)
+		rc.Close()
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "error queuing recording")
+		}
+	}
+
 	// create our msg resume event
 	return resumes.NewMsg(oa.Env(), contact, msgIn), nil, nil
 }
@@ -598,6 +794,9 @@ func HandleIVRStatus(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAss
 	if call.Status() == models.CallStatusErrored || call.Status() == models.CallStatusFailed {
 		return svc.WriteEmptyResponse(w, fmt.Sprintf("status %s ignored, already errored", status))
 	}
+	if call.Status() == models.CallStatusVoicemail {
+		return svc.WriteEmptyResponse(w, fmt.Sprintf("status %s ignored, voicemail left", status))
+	}
 
 	// if we errored schedule a retry if appropriate
 	if status == models.CallStatusErrored {
@@ -619,7 +818,27 @@ func HandleIVRStatus(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAss
 			return errors.Wrapf(err, "unable to load flow: %d", start.FlowID())
 		}
 
-		call.MarkErrored(ctx, rt.DB, dates.Now(), flow.IVRRetryWait(), errorReason)
+		// when leaving voicemails, machines are only detected after the call was answered and the flow started, in
+		// which case we have the call redirected to resume the flow with the machine input and then leave the voicemail
+		if errorReason == models.CallErrorMachine && call.Status() == models.CallStatusInProgress && flow.IVRVoicemail() != nil {
+			channel := oa.ChannelByID(call.ChannelID())
+			if channel == nil {
+				return errors.Errorf("unable to load channel: %d", call.ChannelID())
+			}
+			urn, err := models.URNForID(ctx, rt.DB, oa, call.ContactURNID())
+			if err != nil {
+				return errors.Wrapf(err, "unable to load call urn: %d", call.ContactURNID())
+			}
+			if err := RedirectCallToVoicemail(ctx, rt, svc, channel, urn, call); err != nil {
+				return err
+			}
+
+			return svc.WriteEmptyResponse(w, "machine detected, redirected to voicemail")
+		}
+
+		if err := markCallErrored(ctx, rt, oa, flow, call, errorReason); err != nil {
+			return errors.Wrapf(err, "unable to mark call as errored")
+		}
 
 		if call.Status() == models.CallStatusErrored {
 			return svc.WriteEmptyResponse(w, fmt.Sprintf("status updated: %s, next_attempt: %s", call.Status(), call.NextAttempt()))
@@ -0,0 +1,188 @@
+package ivr
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// how many times we try to download a recording, providers often take a few seconds to make them available
+const recordingDownloadAttempts = 45
+
+// how long we wait for a recording to be stored and transcribed before resuming without it
+var recordingResumeTimeout = 10 * time.Second
+
+// our map of STT service constructors
+var sttConstructors = make(map[string]STTServiceConstructor)
+
+// STTServiceConstructor defines our signature for creating a new STT service from our config
+type STTServiceConstructor func(*runtime.Config) (STTService, error)
+
+// RegisterSTTServiceType registers the passed in STT service type with the passed in constructor
+func RegisterSTTServiceType(name string, constructor STTServiceConstructor) {
+	sttConstructors[name] = constructor
+}
+
+// GetSTTService creates the configured STT service, returning nil if none is configured
+func GetSTTService(cfg *runtime.Config) (STTService, error) {
+	if cfg.STTService == "" {
+		return nil, nil
+	}
+
+	constructor := sttConstructors[cfg.STTService]
+	if constructor == nil {
+		return nil, errors.Errorf("no STT service of type: %s", cfg.STTService)
+	}
+
+	return constructor(cfg)
+}
+
+// STTService defines the interface speech-to-text services must satisfy
+type STTService interface {
+	// Transcribe returns the text spoken in the given audio
+	Transcribe(ctx context.Context, contentType string, content []byte, locale envs.Locale) (string, error)
+}
+
+// Recording is a recording made during an IVR call which needs to be downloaded from the provider
+type Recording struct {
+	MsgID      models.MsgID     `json:"msg_id"`
+	MsgUUID    flows.MsgUUID    `json:"msg_uuid"`
+	ChannelID  models.ChannelID `json:"channel_id"`
+	Attachment utils.Attachment `json:"attachment"`
+	Locale     envs.Locale      `json:"locale"`
+}
+
+// QueueRecording queues the passed in recording to be stored and transcribed in the background
+func QueueRecording(rc redis.Conn, orgID models.OrgID, recording *Recording) error {
+	return queue.AddTask(rc, queue.BatchQueue, queue.ProcessIVRRecording, int(orgID), recording, queue.DefaultPriority)
+}
+
+// ProcessRecording downloads the passed in recording from the provider, stores it in the place reserved for it, and
+// transcribes it if we have a STT service, updating its message with the transcript
+func ProcessRecording(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID, recording *Recording) error {
+	oa, err := models.GetOrgAssets(ctx, rt, orgID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to load org assets")
+	}
+
+	channel := oa.ChannelByID(recording.ChannelID)
+	if channel == nil {
+		return errors.Errorf("unable to load channel: %d", recording.ChannelID)
+	}
+
+	svc, err := GetService(channel)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create IVR service for channel: %d", recording.ChannelID)
+	}
+
+	stored, content, err := storeRecording(ctx, rt, oa, svc, recording)
+	if err != nil {
+		return err
+	}
+
+	// a failed transcription shouldn't lose us the recording
+	transcript, err := transcribeRecording(ctx, rt, stored, content, recording.Locale)
+	if err != nil {
+		logrus.WithError(err).WithField("msg_id", recording.MsgID).Error("error transcribing recording")
+	}
+
+	return models.UpdateIVRRecording(ctx, rt.DB, recording.MsgID, stored, transcript)
+}
+
+// gets the filename we store the given recording as, which is based on its message UUID
+func (r *Recording) filename() string {
+	return string(r.MsgUUID) + path.Ext(r.Attachment.URL())
+}
+
+// stores and transcribes the given recording so that a flow can be resumed with it, giving up after our timeout
+func processRecordingForResume(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, svc Service, recording *Recording) (utils.Attachment, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, recordingResumeTimeout)
+	defer cancel()
+
+	stored, content, err := storeRecording(ctx, rt, oa, svc, recording)
+	if err != nil {
+		return "", "", err
+	}
+
+	// no transcript is better than no resume
+	transcript, err := transcribeRecording(ctx, rt, stored, content, recording.Locale)
+	if err != nil {
+		logrus.WithError(err).WithField("msg_uuid", recording.MsgUUID).Error("error transcribing recording")
+	}
+
+	return stored, transcript, nil
+}
+
+// downloads the given recording from the provider and stores it, returning the stored attachment and its content
+func storeRecording(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, svc Service, recording *Recording) (utils.Attachment, []byte, error) {
+	url := recording.Attachment.URL()
+
+	var err error
+	var resp *http.Response
+	for retry := 0; retry < recordingDownloadAttempts; retry++ {
+		resp, err = svc.DownloadMedia(url)
+		if err == nil && resp.StatusCode == 200 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", nil, errors.Wrapf(ctx.Err(), "error downloading recording")
+		case <-time.After(time.Second):
+		}
+
+		if resp != nil {
+			logrus.WithField("retry", retry).WithField("status", resp.StatusCode).WithField("url", url).Info("retrying download of recording")
+		} else {
+			logrus.WithError(err).WithField("retry", retry).WithField("url", url).Info("retrying download of recording")
+		}
+	}
+
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "error downloading recording")
+	}
+	if resp == nil || resp.StatusCode != 200 {
+		return "", nil, errors.Errorf("unable to download recording")
+	}
+
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "error reading recording")
+	}
+
+	stored, err := oa.Org().StoreAttachment(ctx, rt, recording.filename(), recording.Attachment.ContentType(), io.NopCloser(bytes.NewReader(content)))
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "unable to store recording")
+	}
+
+	return stored, content, nil
+}
+
+// transcribes the given recording content if we have a STT service
+func transcribeRecording(ctx context.Context, rt *runtime.Runtime, recording utils.Attachment, content []byte, locale envs.Locale) (string, error) {
+	stt, err := GetSTTService(rt.Config)
+	if err != nil || stt == nil {
+		return "", err
+	}
+
+	transcript, err := stt.Transcribe(ctx, recording.ContentType(), content, locale)
+	if err != nil {
+		return "", errors.Wrapf(err, "error transcribing recording")
+	}
+
+	return transcript, nil
+}
@@ -0,0 +1,176 @@
+package ivr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/goflow/flows/resumes"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// a service which only supports downloading media, which is all recordings need
+type mockRecordingService struct {
+	Service
+}
+
+func (s *mockRecordingService) DownloadMedia(url string) (*http.Response, error) {
+	return http.Get(url)
+}
+
+type mockSTTService struct {
+	err error
+}
+
+func (s *mockSTTService) Transcribe(ctx context.Context, contentType string, content []byte, locale envs.Locale) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	return "I said " + string(content), nil
+}
+
+func TestRecordings(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+	defer func() { rt.Config.STTService = "" }()
+
+	// provider only makes late.mp3 available once we say so
+	lateAvailable := false
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/late.mp3" && !lateAvailable {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer provider.Close()
+
+	svc := &mockRecordingService{}
+	RegisterServiceType(models.ChannelType("ZZ"), func(*http.Client, *models.Channel) (Service, error) { return svc, nil })
+
+	stt := &mockSTTService{}
+	RegisterSTTServiceType("mock", func(*runtime.Config) (STTService, error) { return stt, nil })
+
+	db.MustExec(`UPDATE channels_channel SET channel_type = 'ZZ' WHERE id = $1`, testdata.TwilioChannel.ID)
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshChannels)
+	require.NoError(t, err)
+
+	channel := oa.ChannelByID(testdata.TwilioChannel.ID)
+	callID := testdata.InsertCall(db, testdata.Org1, testdata.TwilioChannel, testdata.Cathy)
+	call, err := models.GetCallByID(ctx, db, testdata.Org1.ID, callID)
+	require.NoError(t, err)
+
+	mc, err := models.LoadContact(ctx, db, oa, testdata.Cathy.ID)
+	require.NoError(t, err)
+	contact, err := mc.FlowContact(oa)
+	require.NoError(t, err)
+
+	urn := urns.URN("tel:+16055741111")
+
+	// resuming with digits doesn't involve any recording
+	resume, svcErr, err := buildMsgResume(ctx, rt, svc, channel, contact, urn, call, oa, nil, InputResume{Input: "12"})
+	require.NoError(t, err)
+	require.NoError(t, svcErr)
+	assert.Equal(t, "12", resume.(*resumes.MsgResume).Msg().Text())
+
+	count, err := queue.Size(rc, queue.BatchQueue)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// resuming with a recording stores and transcribes it first so the flow can route on what was said
+	rt.Config.STTService = "mock"
+
+	resume, svcErr, err = buildMsgResume(ctx, rt, svc, channel, contact, urn, call, oa, nil, InputResume{Attachment: utils.Attachment("audio/mp3:" + provider.URL + "/rec1.mp3")})
+	require.NoError(t, err)
+	require.NoError(t, svcErr)
+
+	msgIn := resume.(*resumes.MsgResume).Msg()
+	assert.Equal(t, "I said hello", msgIn.Text())
+	require.Len(t, msgIn.Attachments(), 1)
+	stored := msgIn.Attachments()[0]
+	assert.Equal(t, "audio/mp3", stored.ContentType())
+	assert.Equal(t, "_test_attachments_storage/attachments/1/"+string(msgIn.UUID())[:4]+"/"+string(msgIn.UUID())[4:8]+"/"+string(msgIn.UUID())+".mp3", stored.URL())
+
+	content, err := os.ReadFile(stored.URL())
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+	assertdb.Query(t, db, `SELECT text, attachments[1] FROM msgs_msg WHERE uuid = $1`, msgIn.UUID()).Columns(map[string]interface{}{"text": "I said hello", "attachments": string(stored)})
+
+	// and there's nothing to do in the background
+	count, err = queue.Size(rc, queue.BatchQueue)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// if the recording isn't available in time, we resume with the provider's URL and no transcript
+	defer func(timeout time.Duration) { recordingResumeTimeout = timeout }(recordingResumeTimeout)
+	recordingResumeTimeout = 100 * time.Millisecond
+
+	providerRecording := utils.Attachment("audio/mp3:" + provider.URL + "/late.mp3")
+	resume, svcErr, err = buildMsgResume(ctx, rt, svc, channel, contact, urn, call, oa, nil, InputResume{Attachment: providerRecording})
+	require.NoError(t, err)
+	require.NoError(t, svcErr)
+
+	msgIn = resume.(*resumes.MsgResume).Msg()
+	assert.Equal(t, "", msgIn.Text())
+	assert.Equal(t, []utils.Attachment{providerRecording}, msgIn.Attachments())
+	assertdb.Query(t, db, `SELECT text, attachments[1] FROM msgs_msg WHERE uuid = $1`, msgIn.UUID()).Columns(map[string]interface{}{"text": "", "attachments": string(providerRecording)})
+
+	// and the recording is queued to be downloaded
+	task, err := queue.PopNextTask(rc, queue.BatchQueue)
+	require.NoError(t, err)
+	assert.Equal(t, queue.ProcessIVRRecording, task.Type)
+
+	recording := &Recording{}
+	require.NoError(t, json.Unmarshal(task.Task, recording))
+	assert.Equal(t, msgIn.UUID(), recording.MsgUUID)
+	assert.Equal(t, models.MsgID(msgIn.ID()), recording.MsgID)
+
+	// once available, processing it stores and transcribes it
+	lateAvailable = true
+
+	err = ProcessRecording(ctx, rt, testdata.Org1.ID, recording)
+	require.NoError(t, err)
+
+	stored = utils.Attachment("audio/mp3:_test_attachments_storage/attachments/1/" + string(msgIn.UUID())[:4] + "/" + string(msgIn.UUID())[4:8] + "/" + string(msgIn.UUID()) + ".mp3")
+	content, err = os.ReadFile(stored.URL())
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+	assertdb.Query(t, db, `SELECT text, attachments[1] FROM msgs_msg WHERE uuid = $1`, msgIn.UUID()).Columns(map[string]interface{}{"text": "I said hello", "attachments": string(stored)})
+
+	// a failed transcription doesn't lose the recording
+	db.MustExec(`UPDATE msgs_msg SET text = '', attachments = '{}' WHERE uuid = $1`, msgIn.UUID())
+	stt.err = errors.New("boom")
+
+	err = ProcessRecording(ctx, rt, testdata.Org1.ID, recording)
+	require.NoError(t, err)
+	assertdb.Query(t, db, `SELECT text, attachments[1] FROM msgs_msg WHERE uuid = $1`, msgIn.UUID()).Columns(map[string]interface{}{"text": "", "attachments": string(stored)})
+
+	// and when resuming, means we resume without a transcript
+	resume, svcErr, err = buildMsgResume(ctx, rt, svc, channel, contact, urn, call, oa, nil, InputResume{Attachment: utils.Attachment("audio/mp3:" + provider.URL + "/rec2.mp3")})
+	require.NoError(t, err)
+	require.NoError(t, svcErr)
+
+	msgIn = resume.(*resumes.MsgResume).Msg()
+	assert.Equal(t, "", msgIn.Text())
+	require.Len(t, msgIn.Attachments(), 1)
+	assert.Equal(t, "_test_attachments_storage/attachments/1/"+string(msgIn.UUID())[:4]+"/"+string(msgIn.UUID())[4:8]+"/"+string(msgIn.UUID())+".mp3", msgIn.Attachments()[0].URL())
+}
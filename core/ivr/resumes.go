@@ -10,6 +10,7 @@ type ResumeType string
 const (
 	InputResumeType   = ResumeType("input")
 	DialResumeType    = ResumeType("dial")
+	MachineResumeType = ResumeType("machine")
 	TimeoutResumeType = ResumeType("timeout")
 )
 
@@ -39,3 +40,11 @@ type DialResume struct {
 func (r DialResume) Type() ResumeType {
 	return DialResumeType
 }
+
+// MachineResume is our type for resumes as consequences of a machine being detected after the call was answered
+type MachineResume struct{}
+
+// Type returns the type for MachineResume
+func (r MachineResume) Type() ResumeType {
+	return MachineResumeType
+}
@@ -0,0 +1,133 @@
+package ivr
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/flows/events"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+)
+
+const (
+	ttsCacheKey = "tts:%d:%s"
+	ttsCacheTTL = 60 * 60 * 24 * 30
+)
+
+// our map of TTS service constructors
+var ttsConstructors = make(map[string]TTSServiceConstructor)
+
+// TTSServiceConstructor defines our signature for creating a new TTS service from our config
+type TTSServiceConstructor func(*runtime.Config) (TTSService, error)
+
+// RegisterTTSServiceType registers the passed in TTS service type with the passed in constructor
+func RegisterTTSServiceType(name string, constructor TTSServiceConstructor) {
+	ttsConstructors[name] = constructor
+}
+
+// GetTTSService creates the configured TTS service, returning nil if none is configured
+func GetTTSService(cfg *runtime.Config) (TTSService, error) {
+	if cfg.TTSService == "" {
+		return nil, nil
+	}
+
+	constructor := ttsConstructors[cfg.TTSService]
+	if constructor == nil {
+		return nil, errors.Errorf("no TTS service of type: %s", cfg.TTSService)
+	}
+
+	return constructor(cfg)
+}
+
+// TTSService defines the interface text-to-speech services must satisfy
+type TTSService interface {
+	// Synthesize renders the given text as audio, returning the content type and the audio content
+	Synthesize(ctx context.Context, text string, locale envs.Locale, voice string) (string, []byte, error)
+}
+
+// SynthesizePrompts replaces any IVR messages in the passed in events which don't have audio with messages whose audio
+// is rendered by the configured TTS service. Rendered audio is stored as an attachment and cached by text and voice.
+func SynthesizePrompts(ctx context.Context, rt *runtime.Runtime, channel *models.Channel, call *models.Call, es []flows.Event) ([]flows.Event, error) {
+	tts, err := GetTTSService(rt.Config)
+	if err != nil || tts == nil {
+		return es, err
+	}
+
+	oa, err := models.GetOrgAssets(ctx, rt, call.OrgID())
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to load org assets")
+	}
+
+	voice := channel.ConfigValue(models.ChannelConfigTTSVoice, "")
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	synthesized := make([]flows.Event, len(es))
+	for i, e := range es {
+		synthesized[i] = e
+
+		event, isIVR := e.(*events.IVRCreatedEvent)
+		if !isIVR || len(event.Msg.Attachments()) > 0 || event.Msg.Text() == "" {
+			continue
+		}
+
+		audio, err := synthesizePrompt(ctx, rt, rc, tts, oa.Org(), event.Msg.Text(), event.Msg.Locale(), voice)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error synthesizing IVR prompt")
+		}
+
+		msg := event.Msg
+		synthesized[i] = events.NewIVRCreated(flows.NewIVRMsgOut(msg.URN(), msg.Channel(), msg.Text(), audio.URL(), msg.Locale()))
+	}
+
+	return synthesized, nil
+}
+
+// renders the given text to audio, or fetches it from our cache if it's already been rendered
+func synthesizePrompt(ctx context.Context, rt *runtime.Runtime, rc redis.Conn, tts TTSService, org *models.Org, text string, locale envs.Locale, voice string) (utils.Attachment, error) {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s", voice, locale, text)))
+	key := hex.EncodeToString(hash[:])
+	cacheKey := fmt.Sprintf(ttsCacheKey, org.ID(), key)
+
+	cached, err := redis.String(rc.Do("get", cacheKey))
+	if err != nil && err != redis.ErrNil {
+		return "", errors.Wrapf(err, "error reading TTS cache")
+	}
+	if cached != "" {
+		return utils.Attachment(cached), nil
+	}
+
+	contentType, content, err := tts.Synthesize(ctx, text, locale, voice)
+	if err != nil {
+		return "", err
+	}
+
+	// prompts are reused via our cache, but the filename is also based on our hash so that a prompt rendered again after
+	// its cache entry has expired overwrites the previous audio rather than adding another copy of it
+	filename := "tts_" + key
+	if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+		filename += exts[0]
+	}
+
+	audio, err := org.StoreAttachment(ctx, rt, filename, contentType, io.NopCloser(bytes.NewReader(content)))
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to store TTS audio")
+	}
+
+	if _, err := rc.Do("setex", cacheKey, ttsCacheTTL, string(audio)); err != nil {
+		return "", errors.Wrapf(err, "error writing TTS cache")
+	}
+
+	return audio, nil
+}
@@ -0,0 +1,119 @@
+package ivr_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/flows/events"
+	"github.com/nyaruka/mailroom/core/ivr"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/nyaruka/redisx/assertredis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockTTSService struct {
+	calls []string
+	err   error
+}
+
+func (s *mockTTSService) Synthesize(ctx context.Context, text string, locale envs.Locale, voice string) (string, []byte, error) {
+	s.calls = append(s.calls, fmt.Sprintf("%s|%s|%s", voice, locale, text))
+	if s.err != nil {
+		return "", nil, s.err
+	}
+	return "audio/wav", []byte("RIFF" + text), nil
+}
+
+func TestSynthesizePrompts(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+	defer func() { rt.Config.TTSService = "" }()
+
+	mock := &mockTTSService{}
+	ivr.RegisterTTSServiceType("mock", func(*runtime.Config) (ivr.TTSService, error) { return mock, nil })
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	channel := oa.ChannelByID(testdata.TwilioChannel.ID)
+	callID := testdata.InsertCall(db, testdata.Org1, testdata.TwilioChannel, testdata.Cathy)
+	call, err := models.GetCallByID(ctx, db, testdata.Org1.ID, callID)
+	require.NoError(t, err)
+
+	urn := urns.URN("tel:+16055741111")
+	withAudio := events.NewIVRCreated(flows.NewIVRMsgOut(urn, channel.ChannelReference(), "Recorded", "http://example.com/hi.mp3", envs.NilLocale))
+	withoutAudio := events.NewIVRCreated(flows.NewIVRMsgOut(urn, channel.ChannelReference(), "Hello there", "", envs.NewLocale("eng", "US")))
+	notIVR := events.NewContactNameChanged("Cathy")
+	es := []flows.Event{withAudio, withoutAudio, notIVR}
+
+	// no TTS service configured, events returned as is
+	synthesized, err := ivr.SynthesizePrompts(ctx, rt, channel, call, es)
+	assert.NoError(t, err)
+	assert.Equal(t, es, synthesized)
+
+	rt.Config.TTSService = "mock"
+
+	synthesized, err = ivr.SynthesizePrompts(ctx, rt, channel, call, es)
+	require.NoError(t, err)
+	require.Len(t, synthesized, 3)
+	assert.Equal(t, withAudio, synthesized[0])
+	assert.Equal(t, notIVR, synthesized[2])
+	assert.Equal(t, []string{"|eng-US|Hello there"}, mock.calls)
+
+	rendered := synthesized[1].(*events.IVRCreatedEvent).Msg
+	assert.Equal(t, "Hello there", rendered.Text())
+	require.Len(t, rendered.Attachments(), 1)
+	assert.Equal(t, "audio/wav", rendered.Attachments()[0].ContentType())
+
+	// audio is stored at a path based on the hash of the voice, locale and text
+	hash := sha256.Sum256([]byte("|eng-US|Hello there"))
+	key := hex.EncodeToString(hash[:])
+	assert.Equal(t, fmt.Sprintf("_test_attachments_storage/attachments/1/tts_/%s/tts_%s.wav", key[:4], key), rendered.Attachments()[0].URL())
+
+	// and is cached by org, voice, locale and text
+	cacheKey := fmt.Sprintf("tts:%d:%s", testdata.Org1.ID, key)
+	assertredis.Get(t, rp, cacheKey, string(rendered.Attachments()[0]))
+
+	synthesized, err = ivr.SynthesizePrompts(ctx, rt, channel, call, es)
+	require.NoError(t, err)
+	assert.Equal(t, rendered.Attachments(), synthesized[1].(*events.IVRCreatedEvent).Msg.Attachments())
+	assert.Len(t, mock.calls, 1)
+
+	// once the cache has expired it's rendered again, but stored in the same place
+	rc := rp.Get()
+	rc.Do("DEL", cacheKey)
+	rc.Close()
+
+	synthesized, err = ivr.SynthesizePrompts(ctx, rt, channel, call, es)
+	require.NoError(t, err)
+	assert.Equal(t, rendered.Attachments(), synthesized[1].(*events.IVRCreatedEvent).Msg.Attachments())
+	assert.Len(t, mock.calls, 2)
+
+	// but changing the channel's voice means it's rendered again
+	db.MustExec(`UPDATE channels_channel SET config = '{"tts_voice": "female"}' WHERE id = $1`, testdata.TwilioChannel.ID)
+	oa, err = models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshChannels)
+	require.NoError(t, err)
+	channel = oa.ChannelByID(testdata.TwilioChannel.ID)
+
+	_, err = ivr.SynthesizePrompts(ctx, rt, channel, call, es)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"|eng-US|Hello there", "|eng-US|Hello there", "female|eng-US|Hello there"}, mock.calls)
+
+	// errors from the service are returned
+	mock.err = fmt.Errorf("boom")
+	withoutAudio = events.NewIVRCreated(flows.NewIVRMsgOut(urn, channel.ChannelReference(), "Goodbye", "", envs.NilLocale))
+
+	_, err = ivr.SynthesizePrompts(ctx, rt, channel, call, []flows.Event{withoutAudio})
+	assert.EqualError(t, err, "error synthesizing IVR prompt: boom")
+}
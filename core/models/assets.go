@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/contactql"
 	"github.com/nyaruka/goflow/envs"
 	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/goflow/flows/engine"
@@ -16,6 +17,7 @@ import (
 	"github.com/nyaruka/mailroom/runtime"
 	cache "github.com/patrickmn/go-cache"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
 // OrgAssets is our top level cache of all things contained in an org. It is used to build
@@ -54,6 +56,9 @@ type OrgAssets struct {
 	groupsByID   map[GroupID]*Group
 	groupsByUUID map[assets.GroupUUID]*Group
 
+	smartGroupQueries     map[assets.GroupUUID]*contactql.Inspection
+	smartGroupQueriesOnce sync.Once
+
 	labels       []assets.Label
 	labelsByUUID map[assets.LabelUUID]*Label
 
@@ -615,6 +620,28 @@ func (a *OrgAssets) GroupByUUID(groupUUID assets.GroupUUID) *Group {
 	return a.groupsByUUID[groupUUID]
 }
 
+// SmartGroupQueries returns the inspections of the queries of this org's smart groups, keyed by group UUID. Queries are
+// only parsed the first time this is called for these assets, and groups whose queries can't be parsed are left out.
+func (a *OrgAssets) SmartGroupQueries() map[assets.GroupUUID]*contactql.Inspection {
+	a.smartGroupQueriesOnce.Do(func() {
+		a.smartGroupQueries = make(map[assets.GroupUUID]*contactql.Inspection)
+
+		for _, group := range a.SessionAssets().Groups().All() {
+			if !group.UsesQuery() {
+				continue
+			}
+
+			parsed, err := contactql.ParseQuery(a.Env(), group.Query(), a.SessionAssets())
+			if err != nil {
+				logrus.WithError(err).WithField("group_uuid", group.UUID()).Warn("unable to parse smart group query")
+				continue
+			}
+			a.smartGroupQueries[group.UUID()] = contactql.Inspect(parsed)
+		}
+	})
+	return a.smartGroupQueries
+}
+
 func (a *OrgAssets) Labels() ([]assets.Label, error) {
 	return a.labels, nil
 }
@@ -0,0 +1,264 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/pkg/errors"
+)
+
+// ControlState is the state of a broadcast or flow start which is being processed in batches
+type ControlState string
+
+// control state constants
+const (
+	ControlStateRunning   = ControlState("running")
+	ControlStatePaused    = ControlState("paused")
+	ControlStateCancelled = ControlState("cancelled")
+)
+
+// how long we keep the control state of a broadcast or flow start which isn't paused, and how long we keep paused state
+// and parked batches after the last change to them. The latter is long enough for a paused parent to be resumed or
+// cancelled without losing batches, but means one which is abandoned doesn't keep its batches in redis forever.
+const (
+	batchControlExpiration       = time.Hour * 24 * 7
+	pausedBatchControlExpiration = time.Hour * 24 * 90
+)
+
+// BatchControl is the control state of a broadcast or flow start, along with the number of contacts in batches which
+// have been processed, skipped because of cancellation, or parked because of pausing.
+type BatchControl struct {
+	State     ControlState `json:"state"`
+	Processed int          `json:"processed"`
+	Skipped   int          `json:"skipped"`
+	Parked    int          `json:"parked"`
+}
+
+// ParkedBatch is a batch task which was deferred because its broadcast or flow start was paused
+type ParkedBatch struct {
+	TaskType string          `json:"task_type"`
+	Task     json.RawMessage `json:"task"`
+}
+
+func broadcastControlKey(id BroadcastID) string { return fmt.Sprintf("broadcast_control:%d", id) }
+func startControlKey(id StartID) string         { return fmt.Sprintf("start_control:%d", id) }
+
+// GetBroadcastControl gets the control state of the given broadcast
+func GetBroadcastControl(rp *redis.Pool, id BroadcastID) (*BatchControl, error) {
+	return getBatchControl(rp, broadcastControlKey(id))
+}
+
+// SetBroadcastControlState changes the control state of the given broadcast, returning its previous state. Cancelled
+// broadcasts can't be changed. Moving out of the paused state returns any batches which were parked, and these should
+// be re-queued if the broadcast was resumed.
+func SetBroadcastControlState(rp *redis.Pool, id BroadcastID, state ControlState) (ControlState, []*ParkedBatch, error) {
+	return setBatchControlState(rp, broadcastControlKey(id), state)
+}
+
+// CheckBroadcastBatch checks the control state of the broadcast of the given batch and returns whether it should be
+// sent. If the broadcast is paused, the batch is parked until it is resumed. If it has been cancelled, the batch is
+// counted as skipped and if it's the last batch, the broadcast is marked as interrupted.
+func CheckBroadcastBatch(ctx context.Context, db Queryer, rp *redis.Pool, taskType string, batch *BroadcastBatch) (bool, error) {
+	if batch.BroadcastID == NilBroadcastID {
+		return true, nil
+	}
+
+	contacts := len(batch.ContactIDs) + len(batch.URNs)
+
+	state, err := checkBatch(rp, broadcastControlKey(batch.BroadcastID), taskType, contacts, batch)
+	if err != nil {
+		return false, err
+	}
+
+	if state == ControlStateCancelled {
+		if err := RecordBroadcastBatch(rp, batch.BroadcastID, 0, contacts); err != nil {
+			return false, err
+		}
+		if batch.IsLast {
+			if err := MarkBroadcastInterrupted(ctx, db, batch.BroadcastID); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	return state == ControlStateRunning, nil
+}
+
+// RecordBroadcastBatch records the number of contacts in a batch of the given broadcast which were processed or skipped
+func RecordBroadcastBatch(rp *redis.Pool, id BroadcastID, processed, skipped int) error {
+	return recordBatch(rp, broadcastControlKey(id), processed, skipped)
+}
+
+// GetStartControl gets the control state of the given flow start
+func GetStartControl(rp *redis.Pool, id StartID) (*BatchControl, error) {
+	return getBatchControl(rp, startControlKey(id))
+}
+
+// SetStartControlState changes the control state of the given flow start, returning its previous state. Cancelled
+// starts can't be changed. Moving out of the paused state returns any batches which were parked, and these should be
+// re-queued if the start was resumed.
+func SetStartControlState(rp *redis.Pool, id StartID, state ControlState) (ControlState, []*ParkedBatch, error) {
+	return setBatchControlState(rp, startControlKey(id), state)
+}
+
+// CheckStartBatch checks the control state of the flow start of the given batch and returns whether it should be
+// started. If the start is paused, the batch is parked until it is resumed. If it has been cancelled, the batch is
+// counted as skipped and if it's the last batch, the start is marked as interrupted.
+func CheckStartBatch(ctx context.Context, db Queryer, rp *redis.Pool, taskType string, batch *FlowStartBatch) (bool, error) {
+	if batch.StartID() == NilStartID {
+		return true, nil
+	}
+
+	contacts := len(batch.ContactIDs())
+
+	state, err := checkBatch(rp, startControlKey(batch.StartID()), taskType, contacts, batch)
+	if err != nil {
+		return false, err
+	}
+
+	if state == ControlStateCancelled {
+		if err := RecordStartBatch(rp, batch.StartID(), 0, contacts); err != nil {
+			return false, err
+		}
+		if batch.IsLast() {
+			if err := MarkStartInterrupted(ctx, db, batch.StartID()); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	return state == ControlStateRunning, nil
+}
+
+// RecordStartBatch records the number of contacts in a batch of the given flow start which were processed or skipped
+func RecordStartBatch(rp *redis.Pool, id StartID, processed, skipped int) error {
+	return recordBatch(rp, startControlKey(id), processed, skipped)
+}
+
+func getBatchControl(rp *redis.Pool, key string) (*BatchControl, error) {
+	rc := rp.Get()
+	defer rc.Close()
+
+	values, err := redis.StringMap(rc.Do("HGETALL", key))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading batch control state")
+	}
+
+	c := &BatchControl{State: ControlState(values["state"])}
+	if c.State == "" {
+		c.State = ControlStateRunning
+	}
+	c.Processed, _ = strconv.Atoi(values["processed"])
+	c.Skipped, _ = strconv.Atoi(values["skipped"])
+	c.Parked, _ = strconv.Atoi(values["parked"])
+	return c, nil
+}
+
+// parks the batch if the parent is paused, and returns the parent's state
+var checkBatchScript = redis.NewScript(2, `
+local key, parkedKey, task, contacts, pausedExpiration = KEYS[1], KEYS[2], ARGV[1], tonumber(ARGV[2]), tonumber(ARGV[3])
+
+local state = redis.call("HGET", key, "state") or "running"
+if state == "paused" then
+	redis.call("RPUSH", parkedKey, task)
+	redis.call("HINCRBY", key, "parked", contacts)
+	redis.call("EXPIRE", parkedKey, pausedExpiration)
+	redis.call("EXPIRE", key, pausedExpiration)
+end
+return state
+`)
+
+func checkBatch(rp *redis.Pool, key, taskType string, contacts int, batch interface{}) (ControlState, error) {
+	rc := rp.Get()
+	defer rc.Close()
+
+	parked := jsonx.MustMarshal(&ParkedBatch{TaskType: taskType, Task: jsonx.MustMarshal(batch)})
+
+	state, err := redis.String(checkBatchScript.Do(rc, key, key+":parked", parked, contacts, int(pausedBatchControlExpiration/time.Second)))
+	if err != nil {
+		return "", errors.Wrapf(err, "error checking batch control state")
+	}
+	return ControlState(state), nil
+}
+
+// changes the parent's state unless it's cancelled, and when moving out of paused, removes and returns parked batches,
+// counting their contacts as skipped if the parent is being cancelled
+var setBatchControlStateScript = redis.NewScript(2, `
+local key, parkedKey, target, expiration, pausedExpiration = KEYS[1], KEYS[2], ARGV[1], tonumber(ARGV[2]), tonumber(ARGV[3])
+
+local state = redis.call("HGET", key, "state") or "running"
+if state == "cancelled" then
+	return {state, {}}
+end
+
+redis.call("HSET", key, "state", target)
+
+local parked = {}
+if target == "paused" then
+	redis.call("EXPIRE", key, pausedExpiration)
+	redis.call("EXPIRE", parkedKey, pausedExpiration)
+else
+	redis.call("EXPIRE", key, expiration)
+
+	parked = redis.call("LRANGE", parkedKey, 0, -1)
+	redis.call("DEL", parkedKey)
+
+	if target == "cancelled" then
+		redis.call("HINCRBY", key, "skipped", redis.call("HGET", key, "parked") or 0)
+	end
+	redis.call("HSET", key, "parked", 0)
+end
+return {state, parked}
+`)
+
+func setBatchControlState(rp *redis.Pool, key string, state ControlState) (ControlState, []*ParkedBatch, error) {
+	rc := rp.Get()
+	defer rc.Close()
+
+	values, err := redis.Values(setBatchControlStateScript.Do(rc, key, key+":parked", string(state), int(batchControlExpiration/time.Second), int(pausedBatchControlExpiration/time.Second)))
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "error setting batch control state")
+	}
+
+	previous, _ := redis.String(values[0], nil)
+	tasks, err := redis.ByteSlices(values[1], nil)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "error reading parked batches")
+	}
+
+	batches := make([]*ParkedBatch, len(tasks))
+	for i, t := range tasks {
+		batches[i] = &ParkedBatch{}
+		if err := json.Unmarshal(t, batches[i]); err != nil {
+			return "", nil, errors.Wrapf(err, "error unmarshalling parked batch")
+		}
+	}
+	return ControlState(previous), batches, nil
+}
+
+// increments the parent's processed and skipped counts, keeping it for longer if it's paused
+var recordBatchScript = redis.NewScript(1, `
+local key, processed, skipped, expiration, pausedExpiration = KEYS[1], tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4])
+
+redis.call("HINCRBY", key, "processed", processed)
+redis.call("HINCRBY", key, "skipped", skipped)
+
+if redis.call("HGET", key, "state") == "paused" then
+	redis.call("EXPIRE", key, pausedExpiration)
+else
+	redis.call("EXPIRE", key, expiration)
+end
+`)
+
+func recordBatch(rp *redis.Pool, key string, processed, skipped int) error {
+	rc := rp.Get()
+	defer rc.Close()
+
+	_, err := recordBatchScript.Do(rc, key, processed, skipped, int(batchControlExpiration/time.Second), int(pausedBatchControlExpiration/time.Second))
+	return errors.Wrapf(err, "error recording batch")
+}
@@ -0,0 +1,183 @@
+package models_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroadcastControl(t *testing.T) {
+	ctx, _, db, rp := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	bcastID := testdata.InsertBroadcast(db, testdata.Org1, "eng", map[envs.Language]string{"eng": "Hi"}, models.NilScheduleID, nil, nil)
+	bcast := models.NewBroadcast(testdata.Org1.ID, bcastID, map[envs.Language]*models.BroadcastTranslation{"eng": {Text: "Hi"}}, models.TemplateStateEvaluated, "eng", nil, nil, nil, models.NilTicketID, models.NilUserID)
+
+	batch1 := bcast.CreateBatch([]models.ContactID{testdata.Cathy.ID, testdata.Bob.ID})
+	batch2 := bcast.CreateBatch([]models.ContactID{testdata.George.ID})
+	batch3 := bcast.CreateBatch([]models.ContactID{testdata.Alexandria.ID})
+	batch3.IsLast = true
+
+	// broadcasts are running by default
+	control, err := models.GetBroadcastControl(rp, bcastID)
+	require.NoError(t, err)
+	assert.Equal(t, &models.BatchControl{State: models.ControlStateRunning}, control)
+
+	send, err := models.CheckBroadcastBatch(ctx, db, rp, "send_broadcast_batch", batch1)
+	require.NoError(t, err)
+	assert.True(t, send)
+
+	err = models.RecordBroadcastBatch(rp, bcastID, 2, 0)
+	require.NoError(t, err)
+
+	// pause the broadcast and the next batch is parked
+	previous, parked, err := models.SetBroadcastControlState(rp, bcastID, models.ControlStatePaused)
+	require.NoError(t, err)
+	assert.Equal(t, models.ControlStateRunning, previous)
+	assert.Len(t, parked, 0)
+
+	send, err = models.CheckBroadcastBatch(ctx, db, rp, "send_broadcast_batch", batch2)
+	require.NoError(t, err)
+	assert.False(t, send)
+
+	control, err = models.GetBroadcastControl(rp, bcastID)
+	require.NoError(t, err)
+	assert.Equal(t, &models.BatchControl{State: models.ControlStatePaused, Processed: 2, Parked: 1}, control)
+
+	// paused state and parked batches are kept for longer than running state, but do eventually expire
+	rc := rp.Get()
+	defer rc.Close()
+
+	pausedTTL := 60 * 60 * 24 * 90
+	assert.InDelta(t, pausedTTL, redisTTL(t, rc, fmt.Sprintf("broadcast_control:%d", bcastID)), 5)
+	assert.InDelta(t, pausedTTL, redisTTL(t, rc, fmt.Sprintf("broadcast_control:%d:parked", bcastID)), 5)
+
+	// even if other batches are recorded while paused
+	err = models.RecordBroadcastBatch(rp, bcastID, 0, 0)
+	require.NoError(t, err)
+	assert.InDelta(t, pausedTTL, redisTTL(t, rc, fmt.Sprintf("broadcast_control:%d", bcastID)), 5)
+
+	// resuming returns the parked batch so it can be re-queued
+	previous, parked, err = models.SetBroadcastControlState(rp, bcastID, models.ControlStateRunning)
+	require.NoError(t, err)
+	assert.Equal(t, models.ControlStatePaused, previous)
+	require.Len(t, parked, 1)
+	assert.Equal(t, "send_broadcast_batch", parked[0].TaskType)
+
+	unparked := &models.BroadcastBatch{}
+	jsonx.MustUnmarshal(parked[0].Task, unparked)
+	assert.Equal(t, []models.ContactID{testdata.George.ID}, unparked.ContactIDs)
+
+	control, err = models.GetBroadcastControl(rp, bcastID)
+	require.NoError(t, err)
+	assert.Equal(t, &models.BatchControl{State: models.ControlStateRunning, Processed: 2}, control)
+
+	// and state expires sooner again once resumed
+	assert.InDelta(t, 60*60*24*7, redisTTL(t, rc, fmt.Sprintf("broadcast_control:%d", bcastID)), 5)
+
+	// pause again and park the re-queued batch, then cancel
+	models.SetBroadcastControlState(rp, bcastID, models.ControlStatePaused)
+	models.CheckBroadcastBatch(ctx, db, rp, "send_broadcast_batch", unparked)
+
+	previous, parked, err = models.SetBroadcastControlState(rp, bcastID, models.ControlStateCancelled)
+	require.NoError(t, err)
+	assert.Equal(t, models.ControlStatePaused, previous)
+	assert.Len(t, parked, 1)
+
+	// parked contacts are now counted as skipped, as are those in the last batch which also marks the broadcast
+	send, err = models.CheckBroadcastBatch(ctx, db, rp, "send_broadcast_batch", batch3)
+	require.NoError(t, err)
+	assert.False(t, send)
+
+	control, err = models.GetBroadcastControl(rp, bcastID)
+	require.NoError(t, err)
+	assert.Equal(t, &models.BatchControl{State: models.ControlStateCancelled, Processed: 2, Skipped: 2}, control)
+
+	assertdb.Query(t, db, `SELECT status FROM msgs_broadcast WHERE id = $1`, bcastID).Returns("F")
+
+	// which can't then be marked as sent
+	err = models.MarkBroadcastSent(ctx, db, bcastID)
+	require.NoError(t, err)
+	assertdb.Query(t, db, `SELECT status FROM msgs_broadcast WHERE id = $1`, bcastID).Returns("F")
+
+	// and cancelled broadcasts can't be resumed
+	previous, _, err = models.SetBroadcastControlState(rp, bcastID, models.ControlStateRunning)
+	require.NoError(t, err)
+	assert.Equal(t, models.ControlStateCancelled, previous)
+
+	control, err = models.GetBroadcastControl(rp, bcastID)
+	require.NoError(t, err)
+	assert.Equal(t, models.ControlStateCancelled, control.State)
+
+	status, err := models.GetBroadcastStatus(ctx, db, testdata.Org1.ID, bcastID)
+	assert.NoError(t, err)
+	assert.Equal(t, "F", status)
+
+	_, err = models.GetBroadcastStatus(ctx, db, testdata.Org2.ID, bcastID)
+	assert.Equal(t, models.ErrNotFound, err)
+
+	// batches of broadcasts which don't exist in the database are always sent
+	send, err = models.CheckBroadcastBatch(ctx, db, rp, "send_broadcast_batch", &models.BroadcastBatch{ContactIDs: []models.ContactID{testdata.Cathy.ID}})
+	require.NoError(t, err)
+	assert.True(t, send)
+}
+
+func TestStartControl(t *testing.T) {
+	ctx, _, db, rp := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	start := models.NewFlowStart(testdata.Org1.ID, models.StartTypeManual, models.FlowTypeMessaging, testdata.Favorites.ID)
+	err := models.InsertFlowStarts(ctx, db, []*models.FlowStart{start})
+	require.NoError(t, err)
+
+	batch1 := start.CreateBatch([]models.ContactID{testdata.Cathy.ID, testdata.Bob.ID}, false, 3)
+	batch2 := start.CreateBatch([]models.ContactID{testdata.George.ID}, true, 3)
+
+	started, err := models.CheckStartBatch(ctx, db, rp, "start_flow_batch", batch1)
+	require.NoError(t, err)
+	assert.True(t, started)
+
+	err = models.RecordStartBatch(rp, start.ID(), 2, 0)
+	require.NoError(t, err)
+
+	previous, _, err := models.SetStartControlState(rp, start.ID(), models.ControlStateCancelled)
+	require.NoError(t, err)
+	assert.Equal(t, models.ControlStateRunning, previous)
+
+	started, err = models.CheckStartBatch(ctx, db, rp, "start_flow_batch", batch2)
+	require.NoError(t, err)
+	assert.False(t, started)
+
+	control, err := models.GetStartControl(rp, start.ID())
+	require.NoError(t, err)
+	assert.Equal(t, &models.BatchControl{State: models.ControlStateCancelled, Processed: 2, Skipped: 1}, control)
+
+	status, err := models.GetStartStatus(ctx, db, testdata.Org1.ID, start.ID())
+	assert.NoError(t, err)
+	assert.Equal(t, models.StartStatusInterrupted, status)
+
+	// interrupted starts can't be marked as complete
+	err = models.MarkStartComplete(ctx, db, start.ID())
+	require.NoError(t, err)
+	assertdb.Query(t, db, `SELECT status FROM flows_flowstart WHERE id = $1`, start.ID()).Returns("I")
+
+	_, err = models.GetStartStatus(ctx, db, testdata.Org2.ID, start.ID())
+	assert.Equal(t, models.ErrNotFound, err)
+}
+
+func redisTTL(t *testing.T, rc redis.Conn, key string) int {
+	ttl, err := redis.Int(rc.Do("TTL", key))
+	require.NoError(t, err)
+	return ttl
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/pkg/errors"
+)
+
+// BroadcastHoldout is the key under which contacts held out of a broadcast are counted
+const BroadcastHoldout = "holdout"
+
+// how long we keep the assignment counts of a broadcast's variants
+const broadcastVariantsExpiration = time.Hour * 24 * 30
+
+// how long after a broadcast message an incoming message from the contact is counted as a reply to it
+const broadcastVariantsReplyWindow = time.Hour * 24 * 7
+
+// BroadcastVariant is one of the weighted variants of a broadcast's content, e.g.
+//
+//	{"key": "a", "weight": 3, "translations": {"eng": {"text": "Hi there"}}}
+type BroadcastVariant struct {
+	Key          string                                  `json:"key"`
+	Weight       int                                     `json:"weight"`
+	Translations map[envs.Language]*BroadcastTranslation `json:"translations"`
+}
+
+// AssignBroadcastVariant deterministically assigns the contact with the given UUID to one of the given variants, or to
+// the holdout in which case they shouldn't be sent anything. If there are no variants but the contact isn't held out,
+// nil is returned and the contact should be sent the broadcast's own translations. Assignments are salted with the
+// broadcast ID so that the same contacts aren't always held out or sent the first variant.
+func AssignBroadcastVariant(variants []*BroadcastVariant, holdoutPct int, broadcastID BroadcastID, contactUUID flows.ContactUUID) (*BroadcastVariant, bool) {
+	h := fnv.New64a()
+	h.Write([]byte(fmt.Sprintf("%d:%s", broadcastID, contactUUID)))
+	hash := h.Sum64()
+
+	// use the lowest digits of our hash to decide on holdout and the rest to pick a variant so the two are independent
+	if int(hash%100) < holdoutPct {
+		return nil, true
+	}
+
+	totalWeight := 0
+	for _, v := range variants {
+		totalWeight += v.Weight
+	}
+	if totalWeight <= 0 {
+		return nil, false
+	}
+
+	point := int((hash / 100) % uint64(totalWeight))
+	for _, v := range variants {
+		if point < v.Weight {
+			return v, false
+		}
+		point -= v.Weight
+	}
+	return nil, false
+}
+
+func broadcastVariantsKey(orgID OrgID, broadcastID BroadcastID) string {
+	return fmt.Sprintf("broadcast_variants:%d:%d", orgID, broadcastID)
+}
+
+// records how many contacts were assigned to each variant or the holdout of a broadcast
+func recordBroadcastVariantAssignments(rp *redis.Pool, orgID OrgID, broadcastID BroadcastID, counts map[string]int) error {
+	if broadcastID == NilBroadcastID || len(counts) == 0 {
+		return nil
+	}
+
+	rc := rp.Get()
+	defer rc.Close()
+
+	key := broadcastVariantsKey(orgID, broadcastID)
+
+	rc.Send("MULTI")
+	for k, c := range counts {
+		rc.Send("HINCRBY", key, k, c)
+	}
+	rc.Send("EXPIRE", key, int(broadcastVariantsExpiration/time.Second))
+	_, err := rc.Do("EXEC")
+	return err
+}
+
+// BroadcastVariantStats are the counts of messages sent and failed, and contacts who replied, for a broadcast variant
+type BroadcastVariantStats struct {
+	Key      string `json:"key"      db:"variant"`
+	Assigned int    `json:"assigned" db:"-"`
+	Sent     int    `json:"sent"     db:"sent"`
+	Failed   int    `json:"failed"   db:"failed"`
+	Replies  int    `json:"replies"  db:"replies"`
+}
+
+const sqlSelectBroadcastVariantStats = `
+  SELECT v.variant,
+         count(*) FILTER (WHERE m.status IN ('W', 'S', 'D')) AS sent,
+         count(*) FILTER (WHERE m.status = 'F') AS failed,
+         count(DISTINCT m.contact_id) FILTER (WHERE EXISTS (
+             SELECT 1 FROM msgs_msg r WHERE r.contact_id = m.contact_id AND r.direction = 'I' AND r.created_on > m.created_on AND r.created_on <= m.created_on + make_interval(secs => $3)
+         )) AS replies
+    FROM msgs_msg m, LATERAL (SELECT NULLIF(m.metadata, '')::jsonb AS metadata) md, LATERAL (SELECT md.metadata->>'variant' AS variant) v
+   WHERE m.org_id = $1 AND m.broadcast_id = $2 AND m.direction = 'O' AND v.variant IS NOT NULL AND md.metadata->>'failed_over_to' IS NULL
+GROUP BY v.variant
+ORDER BY v.variant`
+
+// GetBroadcastVariantStats gets the stats for each variant of the given broadcast, and the number of contacts who were
+// held out of it. Failed messages which were replaced by a failover message aren't counted, and contacts are only
+// counted as having replied if they did so within the reply window.
+func GetBroadcastVariantStats(ctx context.Context, db Queryer, rp *redis.Pool, orgID OrgID, broadcastID BroadcastID) ([]*BroadcastVariantStats, int, error) {
+	rows, err := db.QueryxContext(ctx, sqlSelectBroadcastVariantStats, orgID, broadcastID, broadcastVariantsReplyWindow.Seconds())
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "error selecting broadcast variant stats")
+	}
+	defer rows.Close()
+
+	stats := make([]*BroadcastVariantStats, 0, 2)
+	for rows.Next() {
+		s := &BroadcastVariantStats{}
+		if err := rows.StructScan(s); err != nil {
+			return nil, 0, errors.Wrapf(err, "error scanning broadcast variant stats")
+		}
+		stats = append(stats, s)
+	}
+
+	rc := rp.Get()
+	defer rc.Close()
+
+	assigned, err := redis.IntMap(rc.Do("HGETALL", broadcastVariantsKey(orgID, broadcastID)))
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "error reading broadcast variant assignments")
+	}
+
+	seen := make(map[string]bool, len(stats))
+	for _, s := range stats {
+		s.Assigned = assigned[s.Key]
+		seen[s.Key] = true
+	}
+
+	// include variants which were assigned contacts but have no messages
+	for key, count := range assigned {
+		if key != BroadcastHoldout && !seen[key] {
+			stats = append(stats, &BroadcastVariantStats{Key: key, Assigned: count})
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Key < stats[j].Key })
+
+	return stats, assigned[BroadcastHoldout], nil
+}
@@ -0,0 +1,140 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssignBroadcastVariant(t *testing.T) {
+	variantA := &models.BroadcastVariant{Key: "a", Weight: 3}
+	variantB := &models.BroadcastVariant{Key: "b", Weight: 1}
+	variants := []*models.BroadcastVariant{variantA, variantB}
+
+	// assignment is deterministic
+	v, heldOut := models.AssignBroadcastVariant(variants, 25, models.BroadcastID(2), testdata.Cathy.UUID)
+	assert.Equal(t, variantA, v)
+	assert.False(t, heldOut)
+	v, heldOut = models.AssignBroadcastVariant(variants, 25, models.BroadcastID(2), testdata.Cathy.UUID)
+	assert.Equal(t, variantA, v)
+	assert.False(t, heldOut)
+
+	v, heldOut = models.AssignBroadcastVariant(variants, 25, models.BroadcastID(2), testdata.Bob.UUID)
+	assert.Nil(t, v)
+	assert.True(t, heldOut)
+
+	// but differs between broadcasts
+	v, heldOut = models.AssignBroadcastVariant(variants, 25, models.BroadcastID(3), testdata.Cathy.UUID)
+	assert.Equal(t, variantB, v)
+	assert.False(t, heldOut)
+
+	v, heldOut = models.AssignBroadcastVariant(variants, 25, models.BroadcastID(3), testdata.Bob.UUID)
+	assert.Equal(t, variantA, v)
+	assert.False(t, heldOut)
+
+	// no variants means contacts are either held out or get the broadcast's own content
+	v, heldOut = models.AssignBroadcastVariant(nil, 0, models.BroadcastID(2), testdata.Bob.UUID)
+	assert.Nil(t, v)
+	assert.False(t, heldOut)
+
+	// and assignments are distributed according to weights and holdout percentage
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		v, heldOut := models.AssignBroadcastVariant(variants, 20, models.BroadcastID(2), flows.ContactUUID(uuids.New()))
+		if heldOut {
+			counts[models.BroadcastHoldout]++
+		} else {
+			counts[v.Key]++
+		}
+	}
+
+	assert.InDelta(t, 2000, counts[models.BroadcastHoldout], 200)
+	assert.InDelta(t, 6000, counts["a"], 300)
+	assert.InDelta(t, 2000, counts["b"], 200)
+}
+
+func TestBroadcastVariants(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	// assignments depend on the broadcast ID so fix it
+	db.MustExec(`ALTER SEQUENCE msgs_broadcast_id_seq RESTART WITH 30088`)
+
+	eng := envs.Language("eng")
+	bcastID := testdata.InsertBroadcast(db, testdata.Org1, eng, map[envs.Language]string{eng: "Hi"}, models.NilScheduleID, nil, nil)
+
+	bcast := models.NewBroadcast(
+		testdata.Org1.ID,
+		bcastID,
+		map[envs.Language]*models.BroadcastTranslation{eng: {Text: "Hi"}},
+		models.TemplateStateEvaluated,
+		eng,
+		nil,
+		[]models.ContactID{testdata.Cathy.ID, testdata.Bob.ID, testdata.George.ID, testdata.Alexandria.ID},
+		nil,
+		models.NilTicketID,
+		models.NilUserID,
+	)
+	bcast.SetVariants([]*models.BroadcastVariant{
+		{Key: "a", Weight: 1, Translations: map[envs.Language]*models.BroadcastTranslation{eng: {Text: "Hi from A"}}},
+		{Key: "b", Weight: 1, Translations: map[envs.Language]*models.BroadcastTranslation{eng: {Text: "Hi from B"}}},
+	}, 25)
+
+	batch := bcast.CreateBatch(bcast.ContactIDs())
+	assert.Equal(t, bcast.Variants(), batch.Variants)
+	assert.Equal(t, 25, batch.HoldoutPct)
+
+	msgs, err := batch.CreateMessages(ctx, rt, oa)
+	require.NoError(t, err)
+
+	// Bob is held out, Cathy gets variant B and everyone else variant A
+	require.Equal(t, 3, len(msgs))
+
+	byContact := make(map[models.ContactID]*models.Msg, len(msgs))
+	for _, m := range msgs {
+		byContact[m.ContactID()] = m
+	}
+	assert.Equal(t, "Hi from B", byContact[testdata.Cathy.ID].Text())
+	assert.Equal(t, "b", byContact[testdata.Cathy.ID].Metadata()["variant"])
+	assert.Equal(t, "Hi from A", byContact[testdata.George.ID].Text())
+	assert.Equal(t, "a", byContact[testdata.George.ID].Metadata()["variant"])
+	assert.Equal(t, "a", byContact[testdata.Alexandria.ID].Metadata()["variant"])
+	assert.Nil(t, byContact[testdata.Bob.ID])
+
+	// George's message fails, the others are sent and Cathy replies
+	db.MustExec(`UPDATE msgs_msg SET status = 'W', sent_on = NOW() WHERE broadcast_id = $1`, bcastID)
+	db.MustExec(`UPDATE msgs_msg SET status = 'F', sent_on = NULL WHERE broadcast_id = $1 AND contact_id = $2`, bcastID, testdata.George.ID)
+	time.Sleep(10 * time.Millisecond)
+	testdata.InsertIncomingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.Cathy, "Thanks", models.MsgStatusHandled)
+
+	// Alexandria replies too but after the reply window so isn't counted
+	reply := testdata.InsertIncomingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.Alexandria, "Hmm", models.MsgStatusHandled)
+	db.MustExec(`UPDATE msgs_msg SET created_on = NOW() + INTERVAL '8 days' WHERE id = $1`, reply.ID())
+
+	stats, holdout, err := models.GetBroadcastVariantStats(ctx, db, rp, testdata.Org1.ID, bcastID)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, holdout)
+	assert.Equal(t, []*models.BroadcastVariantStats{
+		{Key: "a", Assigned: 2, Sent: 1, Failed: 1, Replies: 0},
+		{Key: "b", Assigned: 1, Sent: 1, Failed: 0, Replies: 1},
+	}, stats)
+
+	// stats for another org's broadcast are empty
+	stats, holdout, err = models.GetBroadcastVariantStats(ctx, db, rp, testdata.Org2.ID, bcastID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(stats))
+	assert.Equal(t, 0, holdout)
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	configIVRCallWindow    = "ivr_call_window"
+	configIVRRetrySchedule = "ivr_retry_schedule"
+)
+
+// CallWindow is a window of local time in which outgoing calls can be made, e.g.
+//
+//	{"start": "08:00", "end": "19:00", "days_of_week": "MTWRFS"}
+//
+// Days of week use the same encoding as schedules and if empty, calls can be made on any day.
+type CallWindow struct {
+	Start      string `json:"start"`
+	End        string `json:"end"`
+	DaysOfWeek string `json:"days_of_week"`
+}
+
+// NextAllowed returns the earliest time at or after now when a call can be made in the given timezone
+func (w *CallWindow) NextAllowed(now time.Time, tz *time.Location) (time.Time, error) {
+	startHour, startMinute, err := parseTimeOfDay(w.Start)
+	if err != nil {
+		return now, errors.Wrapf(err, "invalid call window start")
+	}
+	endHour, endMinute, err := parseTimeOfDay(w.End)
+	if err != nil {
+		return now, errors.Wrapf(err, "invalid call window end")
+	}
+	if endHour*60+endMinute <= startHour*60+startMinute {
+		return now, errors.Errorf("call window must end after it starts")
+	}
+
+	days := make(map[time.Weekday]bool, 7)
+	for i := 0; i < len(w.DaysOfWeek); i++ {
+		day, found := dayStrToDayInt[w.DaysOfWeek[i]]
+		if !found {
+			return now, errors.Errorf("call window has unknown day of week: %s", string(w.DaysOfWeek[i]))
+		}
+		days[day] = true
+	}
+
+	local := now.In(tz)
+
+	// look at today and the following week for the first day we're allowed to call on
+	for d := 0; d <= 7; d++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+d, 0, 0, 0, 0, tz)
+		if len(days) > 0 && !days[day.Weekday()] {
+			continue
+		}
+
+		opens := time.Date(day.Year(), day.Month(), day.Day(), startHour, startMinute, 0, 0, tz)
+		closes := time.Date(day.Year(), day.Month(), day.Day(), endHour, endMinute, 0, 0, tz)
+
+		if now.Before(opens) {
+			return opens, nil
+		}
+		if now.Before(closes) {
+			return now, nil
+		}
+	}
+
+	return now, errors.Errorf("call window has no allowed days")
+}
+
+// parses a time of day in the format HH:MM
+func parseTimeOfDay(s string) (int, int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, 0, errors.Errorf("'%s' is not a valid time of day", s)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// CallRetrySchedule is a sequence of waits in minutes before each retry of a call, per error reason, e.g.
+//
+//	{"busy": [10, 30, 60], "no_answer": [60, 240], "machine": [1440]}
+//
+// A reason with no waits configured isn't retried.
+type CallRetrySchedule struct {
+	Provider []int `json:"provider,omitempty"`
+	Busy     []int `json:"busy,omitempty"`
+	NoAnswer []int `json:"no_answer,omitempty"`
+	Machine  []int `json:"machine,omitempty"`
+}
+
+// RetryWait returns the wait before the next retry of a call which has errored the given number of times for the given
+// reason (nil means no retry)
+func (s *CallRetrySchedule) RetryWait(reason CallError, errorCount int) *time.Duration {
+	var waits []int
+	switch reason {
+	case CallErrorProvider:
+		waits = s.Provider
+	case CallErrorBusy:
+		waits = s.Busy
+	case CallErrorNoAnswer:
+		waits = s.NoAnswer
+	case CallErrorMachine:
+		waits = s.Machine
+	}
+
+	if errorCount >= len(waits) || waits[errorCount] < 0 {
+		return nil
+	}
+
+	wait := time.Minute * time.Duration(waits[errorCount])
+	return &wait
+}
@@ -0,0 +1,101 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallWindowNextAllowed(t *testing.T) {
+	kgl, _ := time.LoadLocation("Africa/Kigali")
+	ny, _ := time.LoadLocation("America/New_York")
+
+	tcs := []struct {
+		window   *models.CallWindow
+		now      time.Time
+		tz       *time.Location
+		expected time.Time
+		err      string
+	}{
+		{ // within window
+			window:   &models.CallWindow{Start: "08:00", End: "19:00"},
+			now:      time.Date(2022, 11, 28, 10, 30, 0, 0, kgl),
+			tz:       kgl,
+			expected: time.Date(2022, 11, 28, 10, 30, 0, 0, kgl),
+		},
+		{ // before window opens
+			window:   &models.CallWindow{Start: "08:00", End: "19:00"},
+			now:      time.Date(2022, 11, 28, 6, 15, 0, 0, kgl),
+			tz:       kgl,
+			expected: time.Date(2022, 11, 28, 8, 0, 0, 0, kgl),
+		},
+		{ // after window closes
+			window:   &models.CallWindow{Start: "08:00", End: "19:00"},
+			now:      time.Date(2022, 11, 28, 19, 0, 0, 0, kgl),
+			tz:       kgl,
+			expected: time.Date(2022, 11, 29, 8, 0, 0, 0, kgl),
+		},
+		{ // saturday night, no sundays
+			window:   &models.CallWindow{Start: "08:00", End: "19:00", DaysOfWeek: "MTWRFS"},
+			now:      time.Date(2022, 12, 3, 21, 0, 0, 0, kgl),
+			tz:       kgl,
+			expected: time.Date(2022, 12, 5, 8, 0, 0, 0, kgl),
+		},
+		{ // now given in UTC but window in org timezone
+			window:   &models.CallWindow{Start: "08:00", End: "19:00"},
+			now:      time.Date(2022, 11, 28, 5, 0, 0, 0, time.UTC),
+			tz:       kgl,
+			expected: time.Date(2022, 11, 28, 8, 0, 0, 0, kgl),
+		},
+		{ // across a DST change
+			window:   &models.CallWindow{Start: "09:00", End: "17:00"},
+			now:      time.Date(2022, 11, 5, 20, 0, 0, 0, ny),
+			tz:       ny,
+			expected: time.Date(2022, 11, 6, 9, 0, 0, 0, ny),
+		},
+		{
+			window: &models.CallWindow{Start: "8am", End: "19:00"},
+			now:    time.Date(2022, 11, 28, 10, 30, 0, 0, kgl),
+			tz:     kgl,
+			err:    "invalid call window start: '8am' is not a valid time of day",
+		},
+		{
+			window: &models.CallWindow{Start: "19:00", End: "08:00"},
+			now:    time.Date(2022, 11, 28, 10, 30, 0, 0, kgl),
+			tz:     kgl,
+			err:    "call window must end after it starts",
+		},
+		{
+			window: &models.CallWindow{Start: "08:00", End: "19:00", DaysOfWeek: "MX"},
+			now:    time.Date(2022, 11, 28, 10, 30, 0, 0, kgl),
+			tz:     kgl,
+			err:    "call window has unknown day of week: X",
+		},
+	}
+
+	for _, tc := range tcs {
+		actual, err := tc.window.NextAllowed(tc.now, tc.tz)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err)
+		} else {
+			assert.NoError(t, err)
+			assert.True(t, tc.expected.Equal(actual), "next allowed mismatch for now %s, expected %s, got %s", tc.now, tc.expected, actual)
+		}
+	}
+}
+
+func TestCallRetryScheduleRetryWait(t *testing.T) {
+	schedule := &models.CallRetrySchedule{Busy: []int{10, 30}, NoAnswer: []int{60}}
+
+	wait := func(d time.Duration) *time.Duration { return &d }
+
+	assert.Equal(t, wait(10*time.Minute), schedule.RetryWait(models.CallErrorBusy, 0))
+	assert.Equal(t, wait(30*time.Minute), schedule.RetryWait(models.CallErrorBusy, 1))
+	assert.Nil(t, schedule.RetryWait(models.CallErrorBusy, 2))
+	assert.Equal(t, wait(60*time.Minute), schedule.RetryWait(models.CallErrorNoAnswer, 0))
+	assert.Nil(t, schedule.RetryWait(models.CallErrorNoAnswer, 1))
+	assert.Nil(t, schedule.RetryWait(models.CallErrorMachine, 0))
+	assert.Nil(t, schedule.RetryWait(models.CallErrorProvider, 0))
+}
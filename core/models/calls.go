@@ -41,6 +41,7 @@ const (
 	CallStatusCompleted  = CallStatus("D") // call was completed successfully
 	CallStatusErrored    = CallStatus("E") // temporary failure (will be retried)
 	CallStatusFailed     = CallStatus("F") // permanent failure
+	CallStatusVoicemail  = CallStatus("V") // call was answered by a machine and a voicemail was left
 
 	CallErrorProvider = CallError("P")
 	CallErrorBusy     = CallError("B")
@@ -322,16 +323,15 @@ func (c *Call) MarkStarted(ctx context.Context, db Queryer, now time.Time) error
 	return nil
 }
 
-// MarkErrored updates the status for this call to errored and schedules a retry if appropriate
-func (c *Call) MarkErrored(ctx context.Context, db Queryer, now time.Time, retryWait *time.Duration, errorReason CallError) error {
+// MarkErrored updates the status for this call to errored and schedules a retry at the given time if there is one
+func (c *Call) MarkErrored(ctx context.Context, db Queryer, now time.Time, nextAttempt *time.Time, errorReason CallError) error {
 	c.c.Status = CallStatusErrored
 	c.c.ErrorReason = null.String(errorReason)
 	c.c.EndedOn = &now
 
-	if c.c.ErrorCount < CallMaxRetries && retryWait != nil {
+	if nextAttempt != nil {
 		c.c.ErrorCount++
-		next := now.Add(*retryWait)
-		c.c.NextAttempt = &next
+		c.c.NextAttempt = nextAttempt
 	} else {
 		c.c.Status = CallStatusFailed
 		c.c.NextAttempt = nil
@@ -366,6 +366,24 @@ func (c *Call) MarkFailed(ctx context.Context, db Queryer, now time.Time) error
 	return nil
 }
 
+// MarkVoicemail updates the status for this call to record that it was answered by a machine and a voicemail was left
+func (c *Call) MarkVoicemail(ctx context.Context, db Queryer, now time.Time) error {
+	c.c.Status = CallStatusVoicemail
+	c.c.ErrorReason = null.String(CallErrorMachine)
+	c.c.EndedOn = &now
+	c.c.NextAttempt = nil
+
+	_, err := db.ExecContext(ctx,
+		`UPDATE ivr_call SET status = $2, error_reason = $3, ended_on = $4, next_attempt = NULL, modified_on = NOW() WHERE id = $1`,
+		c.c.ID, c.c.Status, c.c.ErrorReason, now,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "error marking call as voicemail")
+	}
+
+	return nil
+}
+
 // MarkThrottled updates the status for this call to be queued, to be retried in a minute
 func (c *Call) MarkThrottled(ctx context.Context, db Queryer, now time.Time) error {
 	c.c.Status = CallStatusQueued
@@ -380,6 +398,19 @@ func (c *Call) MarkThrottled(ctx context.Context, db Queryer, now time.Time) err
 	return nil
 }
 
+// MarkDeferred updates the status for this call to be queued, to be requested at the given time
+func (c *Call) MarkDeferred(ctx context.Context, db Queryer, until time.Time) error {
+	c.c.Status = CallStatusQueued
+	c.c.NextAttempt = &until
+
+	_, err := db.ExecContext(ctx, `UPDATE ivr_call SET status = $2, next_attempt = $3, modified_on = NOW() WHERE id = $1`, c.c.ID, c.c.Status, c.c.NextAttempt)
+	if err != nil {
+		return errors.Wrapf(err, "error marking call as deferred")
+	}
+
+	return nil
+}
+
 // UpdateStatus updates the status for this call
 func (c *Call) UpdateStatus(ctx context.Context, db Queryer, status CallStatus, duration int, now time.Time) error {
 	c.c.Status = status
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	configCampaignThrottle = "campaign_throttle"
+
+	campaignBacklogKey = "campaign_fire_backlog:%d"
+	campaignBacklogTTL = 60 * 15
+)
+
+// CampaignThrottle limits how many campaign event fires are started per minute for an org, and optionally for
+// individual events, e.g.
+//
+//	{"fires_per_minute": 1000, "events": {"f2a3f8c5-e831-4df3-b046-8d8cdb90f178": 200}}
+//
+// Due fires over the limit are left to be started in later minutes, in the order they were scheduled.
+type CampaignThrottle struct {
+	FiresPerMinute int                       `json:"fires_per_minute"`
+	Events         map[CampaignEventUUID]int `json:"events,omitempty"`
+}
+
+// EventRate returns the fires per minute limit for the given event, or zero if it has no limit of its own
+func (t *CampaignThrottle) EventRate(eventUUID CampaignEventUUID) int {
+	return t.Events[eventUUID]
+}
+
+// CampaignThrottle returns the campaign fire throttle for this org if it has one
+func (o *Org) CampaignThrottle() *CampaignThrottle {
+	throttle := &CampaignThrottle{}
+	if readConfigValue(&o.o.Config, configCampaignThrottle, throttle) && (throttle.FiresPerMinute > 0 || len(throttle.Events) > 0) {
+		return throttle
+	}
+	return nil
+}
+
+// SetCampaignFireBacklog records the number of due fires which have been held back by throttling for each event
+func SetCampaignFireBacklog(rc redis.Conn, orgID OrgID, backlog map[CampaignEventUUID]int) error {
+	key := fmt.Sprintf(campaignBacklogKey, orgID)
+
+	rc.Send("multi")
+	rc.Send("del", key)
+	for eventUUID, count := range backlog {
+		rc.Send("hset", key, string(eventUUID), count)
+	}
+	rc.Send("expire", key, campaignBacklogTTL)
+	_, err := rc.Do("exec")
+
+	return errors.Wrapf(err, "error setting campaign fire backlog for org: %d", orgID)
+}
+
+// GetCampaignFireBacklog gets the number of due fires which are being held back by throttling for each event
+func GetCampaignFireBacklog(rc redis.Conn, orgID OrgID) (map[CampaignEventUUID]int, error) {
+	counts, err := redis.IntMap(rc.Do("hgetall", fmt.Sprintf(campaignBacklogKey, orgID)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting campaign fire backlog for org: %d", orgID)
+	}
+
+	backlog := make(map[CampaignEventUUID]int, len(counts))
+	for eventUUID, count := range counts {
+		backlog[CampaignEventUUID(eventUUID)] = count
+	}
+	return backlog, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"sort"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -120,6 +121,16 @@ type CampaignEvent struct {
 	campaign *Campaign
 }
 
+// NewCampaignEvent creates a new campaign event which isn't saved to the database, e.g. to preview its fires
+func NewCampaignEvent(campaign *Campaign, relativeToKey string, offset int, unit OffsetUnit, deliveryHour int) *CampaignEvent {
+	e := &CampaignEvent{campaign: campaign}
+	e.e.RelativeToKey = relativeToKey
+	e.e.Offset = offset
+	e.e.Unit = unit
+	e.e.DeliveryHour = deliveryHour
+	return e
+}
+
 // UnmarshalJSON is our unmarshaller for json data
 func (e *CampaignEvent) UnmarshalJSON(data []byte) error {
 	return json.Unmarshal(data, &e.e)
@@ -621,6 +632,93 @@ func ScheduleCampaignEvent(ctx context.Context, rt *runtime.Runtime, orgID OrgID
 	return AddEventFires(ctx, rt.DB, fas)
 }
 
+// CampaignPreviewInterval is the size of the buckets in a campaign event preview histogram
+type CampaignPreviewInterval string
+
+const (
+	CampaignPreviewHour = CampaignPreviewInterval("hour")
+	CampaignPreviewDay  = CampaignPreviewInterval("day")
+)
+
+// CampaignEventPreview is a summary of the fires that scheduling a campaign event would create
+type CampaignEventPreview struct {
+	Total     int `json:"total"`
+	Scheduled int `json:"scheduled"`
+	Skipped   struct {
+		NoValue int `json:"no_value"`
+		InPast  int `json:"in_past"`
+	} `json:"skipped"`
+	FirstFire *time.Time               `json:"first_fire"`
+	LastFire  *time.Time               `json:"last_fire"`
+	Histogram []*CampaignPreviewBucket `json:"histogram"`
+}
+
+// CampaignPreviewBucket is the number of fires scheduled in the interval starting at the given time
+type CampaignPreviewBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+// PreviewCampaignEvent calculates the fires the passed in event would have for the contacts in the given group,
+// without creating any of them
+func PreviewCampaignEvent(ctx context.Context, db Queryer, oa *OrgAssets, groupID GroupID, event *CampaignEvent, interval CampaignPreviewInterval, now time.Time) (*CampaignEventPreview, error) {
+	field := oa.FieldByKey(event.RelativeToKey())
+	if field == nil {
+		return nil, errors.Errorf("can't find field with key %s", event.RelativeToKey())
+	}
+
+	eligible, err := campaignEventEligibleContacts(ctx, db, groupID, field)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to calculate eligible contacts for group %d", groupID)
+	}
+
+	tz := oa.Env().Timezone()
+	preview := &CampaignEventPreview{Total: len(eligible), Histogram: []*CampaignPreviewBucket{}}
+	buckets := make(map[time.Time]*CampaignPreviewBucket)
+
+	for _, el := range eligible {
+		if el.RelToValue == nil {
+			preview.Skipped.NoValue++
+			continue
+		}
+
+		scheduled, err := event.ScheduleForTime(tz, now, *el.RelToValue)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error calculating offset for start: %s", *el.RelToValue)
+		}
+		if scheduled == nil {
+			preview.Skipped.InPast++
+			continue
+		}
+
+		preview.Scheduled++
+		if preview.FirstFire == nil || scheduled.Before(*preview.FirstFire) {
+			preview.FirstFire = scheduled
+		}
+		if preview.LastFire == nil || scheduled.After(*preview.LastFire) {
+			preview.LastFire = scheduled
+		}
+
+		local := scheduled.In(tz)
+		start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, tz)
+		if interval == CampaignPreviewHour {
+			start = time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), 0, 0, 0, tz)
+		}
+
+		bucket := buckets[start]
+		if bucket == nil {
+			bucket = &CampaignPreviewBucket{Start: start}
+			buckets[start] = bucket
+			preview.Histogram = append(preview.Histogram, bucket)
+		}
+		bucket.Count++
+	}
+
+	sort.Slice(preview.Histogram, func(i, j int) bool { return preview.Histogram[i].Start.Before(preview.Histogram[j].Start) })
+
+	return preview, nil
+}
+
 type eligibleContact struct {
 	ContactID  ContactID  `db:"contact_id"`
 	RelToValue *time.Time `db:"rel_to_value"`
@@ -35,6 +35,7 @@ const (
 	ChannelConfigCallbackDomain      = "callback_domain"
 	ChannelConfigMaxConcurrentEvents = "max_concurrent_events"
 	ChannelConfigFCMID               = "FCM_ID"
+	ChannelConfigTTSVoice            = "tts_voice"
 )
 
 // Channel is the mailroom struct that represents channels
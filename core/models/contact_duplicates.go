@@ -0,0 +1,391 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/lib/pq"
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/pkg/errors"
+)
+
+const (
+	duplicateReportKey        = "contact_duplicates:%d"
+	duplicateReportStagingKey = "contact_duplicates_staging:%d"
+	duplicateReportMetaKey    = "contact_duplicates_meta:%d"
+	duplicateReportTTL        = 60 * 60 * 24 * 7
+	duplicateReportPageSize   = 500
+
+	// how similar two normalized names must be, as a fraction of the longer name, to be considered duplicates
+	duplicateNameSimilarity = 0.8
+)
+
+// the URN schemes whose paths are phone numbers and so are compared by their digits
+var phoneSchemes = []string{"tel", "whatsapp"}
+
+// DuplicateReason is why a set of contacts are considered likely duplicates
+type DuplicateReason string
+
+// possible duplicate reasons
+const (
+	DuplicateReasonPhone = DuplicateReason("phone")
+	DuplicateReasonEmail = DuplicateReason("email")
+	DuplicateReasonName  = DuplicateReason("name")
+)
+
+// DuplicateReportStatus is the status of an org's duplicates report
+type DuplicateReportStatus string
+
+// possible duplicate report statuses
+const (
+	DuplicateReportStatusPending  = DuplicateReportStatus("pending")
+	DuplicateReportStatusComplete = DuplicateReportStatus("complete")
+)
+
+// DuplicateCandidate is a set of contacts which are likely to be the same person
+type DuplicateCandidate struct {
+	Reason     DuplicateReason `json:"reason"`
+	Key        string          `json:"key"`
+	ContactIDs []ContactID     `json:"contact_ids"`
+}
+
+// DuplicateReport is the summary of the last duplicates report generated for an org
+type DuplicateReport struct {
+	Status      DuplicateReportStatus `json:"status"`
+	Total       int                   `json:"total"`
+	GeneratedOn *time.Time            `json:"generated_on"`
+}
+
+const sqlSelectDuplicatePhones = `
+  SELECT 'phone' AS reason, digits AS key, array_agg(DISTINCT contact_id ORDER BY contact_id) AS contact_ids
+    FROM (
+        SELECT u.contact_id, regexp_replace(u.path, '[^0-9]', '', 'g') AS digits
+          FROM contacts_contacturn u
+    INNER JOIN contacts_contact c ON c.id = u.contact_id
+         WHERE u.org_id = $1 AND u.scheme = ANY($2) AND c.is_active = TRUE
+    ) u
+   WHERE digits != ''
+GROUP BY digits
+  HAVING count(DISTINCT contact_id) > 1`
+
+const sqlSelectDuplicateEmails = `
+  SELECT 'email' AS reason, lower(u.path) AS key, array_agg(DISTINCT u.contact_id ORDER BY u.contact_id) AS contact_ids
+    FROM contacts_contacturn u
+INNER JOIN contacts_contact c ON c.id = u.contact_id
+   WHERE u.org_id = $1 AND u.scheme = 'mailto' AND c.is_active = TRUE
+GROUP BY lower(u.path)
+  HAVING count(DISTINCT u.contact_id) > 1`
+
+// names are normalized by ignoring case, whitespace and punctuation, and the values of the given fields are selected
+// in the same order as the given field UUIDs so that contacts can be bucketed by them
+const sqlSelectDuplicateNameContacts = `
+  SELECT id, lower(regexp_replace(name, '[^[:alnum:]]+', '', 'g')) AS name,
+         ARRAY(SELECT COALESCE(lower(fields->f.uuid->>'text'), '') FROM unnest($2::text[]) WITH ORDINALITY AS f(uuid, n) ORDER BY f.n) AS field_values
+    FROM contacts_contact
+   WHERE org_id = $1 AND is_active = TRUE AND name IS NOT NULL
+ORDER BY id`
+
+// FindDuplicateContacts finds sets of contacts in the given org which are likely to be duplicates, largest sets first.
+// Contacts are considered duplicates by name if they have similar names and the same values for the given fields.
+func FindDuplicateContacts(ctx context.Context, db Queryer, orgID OrgID, fields []*Field) ([]*DuplicateCandidate, error) {
+	candidates := make([]*DuplicateCandidate, 0, 10)
+
+	queries := []struct {
+		sql  string
+		args []interface{}
+	}{
+		{sqlSelectDuplicatePhones, []interface{}{orgID, pq.Array(phoneSchemes)}},
+		{sqlSelectDuplicateEmails, []interface{}{orgID}},
+	}
+
+	for _, q := range queries {
+		rows, err := db.QueryxContext(ctx, q.sql, q.args...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error querying duplicate contacts")
+		}
+
+		for rows.Next() {
+			var reason, key string
+			var ids pq.Int64Array
+			if err := rows.Scan(&reason, &key, &ids); err != nil {
+				rows.Close()
+				return nil, errors.Wrapf(err, "error scanning duplicate contacts")
+			}
+
+			contactIDs := make([]ContactID, len(ids))
+			for i := range ids {
+				contactIDs[i] = ContactID(ids[i])
+			}
+
+			candidates = append(candidates, &DuplicateCandidate{Reason: DuplicateReason(reason), Key: key, ContactIDs: contactIDs})
+		}
+		rows.Close()
+	}
+
+	byName, err := findDuplicateNames(ctx, db, orgID, fields)
+	if err != nil {
+		return nil, err
+	}
+	candidates = append(candidates, byName...)
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if len(candidates[i].ContactIDs) != len(candidates[j].ContactIDs) {
+			return len(candidates[i].ContactIDs) > len(candidates[j].ContactIDs)
+		}
+		return candidates[i].Key < candidates[j].Key
+	})
+
+	return candidates, nil
+}
+
+type duplicateNameContact struct {
+	id   ContactID
+	name string
+}
+
+// finds sets of contacts with similar names and the same values for the given fields. To avoid comparing every pair of
+// contacts in the org, contacts are only compared with others which have the same field values and the same first
+// character of their normalized name.
+func findDuplicateNames(ctx context.Context, db Queryer, orgID OrgID, fields []*Field) ([]*DuplicateCandidate, error) {
+	fieldUUIDs := make([]string, len(fields))
+	for i := range fields {
+		fieldUUIDs[i] = string(fields[i].UUID())
+	}
+
+	rows, err := db.QueryxContext(ctx, sqlSelectDuplicateNameContacts, orgID, pq.Array(fieldUUIDs))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error querying contact names")
+	}
+	defer rows.Close()
+
+	buckets := make(map[string][]*duplicateNameContact)
+	bucketKeys := make([]string, 0, 100)
+
+	for rows.Next() {
+		c := &duplicateNameContact{}
+		var fieldValues pq.StringArray
+		if err := rows.Scan(&c.id, &c.name, &fieldValues); err != nil {
+			return nil, errors.Wrapf(err, "error scanning contact name")
+		}
+		if c.name == "" {
+			continue
+		}
+
+		first, _ := utf8.DecodeRuneInString(c.name)
+		key := strings.Join(append(fieldValues, string(first)), "\x00")
+
+		if _, exists := buckets[key]; !exists {
+			bucketKeys = append(bucketKeys, key)
+		}
+		buckets[key] = append(buckets[key], c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "error reading contact names")
+	}
+
+	candidates := make([]*DuplicateCandidate, 0, 10)
+	for _, key := range bucketKeys {
+		for _, group := range groupSimilarNames(buckets[key]) {
+			contactIDs := make([]ContactID, len(group))
+			for i, c := range group {
+				contactIDs[i] = c.id
+			}
+
+			// contacts are read in ID order so the first contact's name is the key
+			candidates = append(candidates, &DuplicateCandidate{Reason: DuplicateReasonName, Key: group[0].name, ContactIDs: contactIDs})
+		}
+	}
+
+	return candidates, nil
+}
+
+// groups the given contacts so that each contact is in the same group as any other contact with a similar name,
+// returning only the groups with more than one contact
+func groupSimilarNames(contacts []*duplicateNameContact) [][]*duplicateNameContact {
+	if len(contacts) < 2 {
+		return nil
+	}
+
+	// union-find where each contact starts in its own group
+	parents := make([]int, len(contacts))
+	for i := range parents {
+		parents[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+
+	for i := range contacts {
+		for j := i + 1; j < len(contacts); j++ {
+			if namesSimilar(contacts[i].name, contacts[j].name) {
+				// the root of each group is always its first contact
+				if ri, rj := find(i), find(j); ri < rj {
+					parents[rj] = ri
+				} else if rj < ri {
+					parents[ri] = rj
+				}
+			}
+		}
+	}
+
+	members := make(map[int][]*duplicateNameContact)
+	for i, c := range contacts {
+		root := find(i)
+		members[root] = append(members[root], c)
+	}
+
+	groups := make([][]*duplicateNameContact, 0, len(members))
+	for i := range contacts {
+		if group := members[i]; len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// whether the edit distance between the two names is small enough relative to the longer of them
+func namesSimilar(a, b string) bool {
+	if a == b {
+		return true
+	}
+
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+
+	return float64(longest-levenshtein(ra, rb))/float64(longest) >= duplicateNameSimilarity
+}
+
+// calculates the number of single character insertions, deletions or substitutions to change a into b
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j-1] + cost
+			if prev[j]+1 < curr[j] {
+				curr[j] = prev[j] + 1
+			}
+			if curr[j-1]+1 < curr[j] {
+				curr[j] = curr[j-1] + 1
+			}
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+// MarkDuplicateReportPending marks the duplicates report for the given org as being generated, keeping any previous results
+func MarkDuplicateReportPending(rc redis.Conn, orgID OrgID) error {
+	key := fmt.Sprintf(duplicateReportMetaKey, orgID)
+
+	rc.Send("multi")
+	rc.Send("hset", key, "status", DuplicateReportStatusPending)
+	rc.Send("expire", key, duplicateReportTTL)
+	_, err := rc.Do("exec")
+
+	return errors.Wrapf(err, "error marking duplicates report as pending for org: %d", orgID)
+}
+
+// SaveDuplicateReport saves the given candidates as the duplicates report for the given org. Candidates are written a
+// page at a time to a staging list which then replaces the previous report, so that large reports don't have to be
+// written in a single transaction.
+func SaveDuplicateReport(rc redis.Conn, orgID OrgID, candidates []*DuplicateCandidate, generatedOn time.Time) error {
+	key := fmt.Sprintf(duplicateReportKey, orgID)
+	stagingKey := fmt.Sprintf(duplicateReportStagingKey, orgID)
+	metaKey := fmt.Sprintf(duplicateReportMetaKey, orgID)
+
+	if _, err := rc.Do("del", stagingKey); err != nil {
+		return errors.Wrapf(err, "error clearing staged duplicates report for org: %d", orgID)
+	}
+
+	for start := 0; start < len(candidates); start += duplicateReportPageSize {
+		end := start + duplicateReportPageSize
+		if end > len(candidates) {
+			end = len(candidates)
+		}
+
+		args := redis.Args{}.Add(stagingKey)
+		for _, c := range candidates[start:end] {
+			args = args.Add(jsonx.MustMarshal(c))
+		}
+
+		rc.Send("rpush", args...)
+		rc.Send("expire", stagingKey, duplicateReportTTL)
+		if _, err := rc.Do(""); err != nil {
+			return errors.Wrapf(err, "error staging duplicates report for org: %d", orgID)
+		}
+	}
+
+	rc.Send("multi")
+	if len(candidates) > 0 {
+		rc.Send("rename", stagingKey, key)
+		rc.Send("expire", key, duplicateReportTTL)
+	} else {
+		rc.Send("del", key)
+	}
+	rc.Send("hset", metaKey, "status", DuplicateReportStatusComplete, "total", len(candidates), "generated_on", generatedOn.Format(time.RFC3339Nano))
+	rc.Send("expire", metaKey, duplicateReportTTL)
+	_, err := rc.Do("exec")
+
+	return errors.Wrapf(err, "error saving duplicates report for org: %d", orgID)
+}
+
+// GetDuplicateReport gets the duplicates report for the given org and a page of its candidates, returning nil if
+// the org has no report
+func GetDuplicateReport(rc redis.Conn, orgID OrgID, offset, limit int) (*DuplicateReport, []*DuplicateCandidate, error) {
+	meta, err := redis.StringMap(rc.Do("hgetall", fmt.Sprintf(duplicateReportMetaKey, orgID)))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error getting duplicates report for org: %d", orgID)
+	}
+	if len(meta) == 0 {
+		return nil, nil, nil
+	}
+
+	total, _ := strconv.Atoi(meta["total"])
+	report := &DuplicateReport{Status: DuplicateReportStatus(meta["status"]), Total: total}
+	if meta["generated_on"] != "" {
+		generatedOn, err := time.Parse(time.RFC3339Nano, meta["generated_on"])
+		if err == nil {
+			report.GeneratedOn = &generatedOn
+		}
+	}
+
+	values, err := redis.ByteSlices(rc.Do("lrange", fmt.Sprintf(duplicateReportKey, orgID), offset, offset+limit-1))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error getting duplicates report page for org: %d", orgID)
+	}
+
+	candidates := make([]*DuplicateCandidate, len(values))
+	for i, v := range values {
+		candidates[i] = &DuplicateCandidate{}
+		if err := json.Unmarshal(v, candidates[i]); err != nil {
+			return nil, nil, errors.Wrapf(err, "error unmarshalling duplicate candidate")
+		}
+	}
+
+	return report, candidates, nil
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"path"
+
+	"github.com/lib/pq"
+	"github.com/nyaruka/null"
+	"github.com/pkg/errors"
+)
+
+// ContactExportID is the type for contact export IDs
+type ContactExportID null.Int
+
+func (i ContactExportID) MarshalJSON() ([]byte, error)  { return null.Int(i).MarshalJSON() }
+func (i *ContactExportID) UnmarshalJSON(b []byte) error { return null.UnmarshalInt(b, (*null.Int)(i)) }
+func (i ContactExportID) Value() (driver.Value, error)  { return null.Int(i).Value() }
+func (i *ContactExportID) Scan(value interface{}) error { return null.ScanInt(value, (*null.Int)(i)) }
+
+// ContactExportStatus is the status of an export
+type ContactExportStatus string
+
+// export status constants
+const (
+	ContactExportStatusPending    ContactExportStatus = "P"
+	ContactExportStatusProcessing ContactExportStatus = "O"
+	ContactExportStatusComplete   ContactExportStatus = "C"
+	ContactExportStatusFailed     ContactExportStatus = "F"
+)
+
+// ContactExport is an export of the contacts in a group or matching a search
+type ContactExport struct {
+	ID          ContactExportID     `db:"id"`
+	UUID        string              `db:"uuid"`
+	OrgID       OrgID               `db:"org_id"`
+	Status      ContactExportStatus `db:"status"`
+	GroupID     GroupID             `db:"group_id"`
+	Search      string              `db:"search"`
+	CreatedByID UserID              `db:"created_by_id"`
+
+	// groups whose membership is included as columns in the export
+	GroupMemberships pq.Int64Array `db:"group_memberships"`
+}
+
+const sqlLoadContactExport = `
+         SELECT e.id, e.uuid, e.org_id, e.status, COALESCE(e.group_id, 0) AS group_id, COALESCE(e.search, '') AS search, e.created_by_id,
+                array_remove(array_agg(m.contactgroup_id ORDER BY m.id), NULL) AS group_memberships
+           FROM contacts_exportcontactstask e
+LEFT OUTER JOIN contacts_exportcontactstask_group_memberships m ON m.exportcontactstask_id = e.id
+          WHERE e.id = $1 AND e.is_active = TRUE
+       GROUP BY e.id`
+
+// LoadContactExport loads a contact export by ID
+func LoadContactExport(ctx context.Context, db Queryer, id ContactExportID) (*ContactExport, error) {
+	e := &ContactExport{}
+	err := db.GetContext(ctx, e, sqlLoadContactExport, id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading contact export id=%d", id)
+	}
+	return e, nil
+}
+
+const sqlUpdateContactExportStatus = `
+UPDATE contacts_exportcontactstask
+   SET status = $2, modified_on = NOW()
+ WHERE id = $1`
+
+// SetStatus updates the status of this export
+func (e *ContactExport) SetStatus(ctx context.Context, db Queryer, status ContactExportStatus) error {
+	e.Status = status
+
+	_, err := db.ExecContext(ctx, sqlUpdateContactExportStatus, e.ID, e.Status)
+	return errors.Wrap(err, "error updating export status")
+}
+
+// StoragePath returns the path in export storage of the file of this export with the given extension. Export storage
+// isn't public so files are downloaded through RapidPro which finds them by the export's org and UUID.
+func (e *ContactExport) StoragePath(prefix string, ext string) string {
+	return path.Join(prefix, fmt.Sprintf("%d", e.OrgID), "contact_exports", e.UUID+"."+ext)
+}
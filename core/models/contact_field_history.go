@@ -0,0 +1,242 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/null"
+	"github.com/pkg/errors"
+)
+
+const configContactFieldHistory = "contact_field_history"
+
+// how long we keep field history for if an org doesn't specify
+const defaultFieldHistoryRetentionDays = 365
+
+// FieldHistoryConfig is the org configuration for recording the history of contact field values, e.g.
+//
+//	{"fields": ["age", "district"], "retention_days": 90}
+//
+// If fields is empty, changes to all fields are recorded.
+type FieldHistoryConfig struct {
+	Fields        []string `json:"fields"`
+	RetentionDays int      `json:"retention_days"`
+}
+
+// Records returns whether changes to the field with the given key should be recorded
+func (c *FieldHistoryConfig) Records(key string) bool {
+	if len(c.Fields) == 0 {
+		return true
+	}
+	for _, f := range c.Fields {
+		if f == key {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldHistoryConfig returns the field history configuration for this org if it has one
+func (o *Org) FieldHistoryConfig() *FieldHistoryConfig {
+	config := &FieldHistoryConfig{}
+	if readConfigValue(&o.o.Config, configContactFieldHistory, config) {
+		return config
+	}
+	return nil
+}
+
+const sqlFieldHistoryTableExists = `SELECT to_regclass('contacts_contactfieldhistory') IS NOT NULL`
+
+// GetFieldHistoryConfig returns the field history configuration of the given org if it records field history. Field
+// history is only recorded where RapidPro has created the table for it, so without that, no org records it.
+func GetFieldHistoryConfig(ctx context.Context, db Queryer, org *Org) (*FieldHistoryConfig, error) {
+	config := org.FieldHistoryConfig()
+	if config == nil {
+		return nil, nil
+	}
+
+	var exists bool
+	if err := db.GetContext(ctx, &exists, sqlFieldHistoryTableExists); err != nil {
+		return nil, errors.Wrapf(err, "error checking for field history table")
+	}
+	if !exists {
+		return nil, nil
+	}
+	return config, nil
+}
+
+// FieldChange is a change to the value of a contact field
+type FieldChange struct {
+	OrgID       OrgID       `db:"org_id"`
+	ContactID   ContactID   `db:"contact_id"`
+	FieldID     FieldID     `db:"field_id"`
+	OldValue    null.String `db:"old_value"`
+	NewValue    null.String `db:"new_value"`
+	ChangedByID UserID      `db:"changed_by_id"`
+	FlowID      FlowID      `db:"flow_id"`
+	ChangedOn   time.Time   `db:"changed_on"`
+}
+
+const sqlInsertFieldChanges = `
+INSERT INTO contacts_contactfieldhistory(org_id, contact_id, field_id, old_value, new_value, changed_by_id, flow_id, changed_on)
+                                 VALUES(:org_id, :contact_id, :field_id, :old_value, :new_value, :changed_by_id, :flow_id, :changed_on)`
+
+// InsertFieldChanges inserts the given field changes into the field history
+func InsertFieldChanges(ctx context.Context, db Queryer, changes []*FieldChange) error {
+	return BulkQuery(ctx, "inserting field changes", db, sqlInsertFieldChanges, changes)
+}
+
+const sqlSelectContactFieldTexts = `
+SELECT c.id AS contact_id, f.key AS field_uuid, f.value->>'text' AS text
+  FROM contacts_contact c, jsonb_each(c.fields) f
+ WHERE c.id = ANY($1) AND f.value->>'text' IS NOT NULL`
+
+// LoadContactFieldTexts loads the current text values of the fields of the given contacts
+func LoadContactFieldTexts(ctx context.Context, db Queryer, contactIDs []ContactID) (map[ContactID]map[assets.FieldUUID]string, error) {
+	rows, err := db.QueryxContext(ctx, sqlSelectContactFieldTexts, pq.Array(contactIDs))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error selecting contact field values")
+	}
+	defer rows.Close()
+
+	values := make(map[ContactID]map[assets.FieldUUID]string, len(contactIDs))
+	for rows.Next() {
+		var contactID ContactID
+		var fieldUUID assets.FieldUUID
+		var text string
+		if err := rows.Scan(&contactID, &fieldUUID, &text); err != nil {
+			return nil, errors.Wrapf(err, "error scanning contact field value")
+		}
+		if values[contactID] == nil {
+			values[contactID] = make(map[assets.FieldUUID]string)
+		}
+		values[contactID][fieldUUID] = text
+	}
+
+	return values, nil
+}
+
+// FieldHistoryEntry is an entry in the timeline of a contact's field values
+type FieldHistoryEntry struct {
+	Field     *FieldHistoryField `json:"field"`
+	OldValue  null.String        `json:"old_value"`
+	NewValue  null.String        `json:"new_value"`
+	ChangedBy *FieldHistoryUser  `json:"changed_by,omitempty"`
+	Flow      *FieldHistoryFlow  `json:"flow,omitempty"`
+	ChangedOn time.Time          `json:"changed_on"`
+}
+
+// FieldHistoryField is a reference to a field in a field history entry
+type FieldHistoryField struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+}
+
+// FieldHistoryUser is a reference to a user in a field history entry
+type FieldHistoryUser struct {
+	ID    UserID `json:"id"`
+	Email string `json:"email"`
+}
+
+// FieldHistoryFlow is a reference to a flow in a field history entry
+type FieldHistoryFlow struct {
+	UUID assets.FlowUUID `json:"uuid"`
+	Name string          `json:"name"`
+}
+
+const sqlSelectContactFieldHistory = `
+   SELECT f.key AS field_key, f.name AS field_name, h.old_value, h.new_value, h.changed_by_id, COALESCE(u.email, '') AS user_email,
+          fl.uuid AS flow_uuid, COALESCE(fl.name, '') AS flow_name, h.changed_on
+     FROM contacts_contactfieldhistory h
+     JOIN contacts_contactfield f ON f.id = h.field_id
+LEFT JOIN auth_user u ON u.id = h.changed_by_id
+LEFT JOIN flows_flow fl ON fl.id = h.flow_id
+    WHERE h.contact_id = $1 AND (cardinality($2::text[]) = 0 OR f.key = ANY($2))
+ ORDER BY h.changed_on DESC, h.id DESC
+    LIMIT NULLIF($3, 0)`
+
+// LoadContactFieldHistory loads the history of the given contact's field values, most recent first, optionally limited
+// to the fields with the given keys. A limit of zero loads the entire history.
+func LoadContactFieldHistory(ctx context.Context, db Queryer, contactID ContactID, fieldKeys []string, limit int) ([]*FieldHistoryEntry, error) {
+	if fieldKeys == nil {
+		fieldKeys = []string{}
+	}
+
+	rows, err := db.QueryxContext(ctx, sqlSelectContactFieldHistory, contactID, pq.Array(fieldKeys), limit)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error selecting field history for contact")
+	}
+	defer rows.Close()
+
+	entries := make([]*FieldHistoryEntry, 0, 10)
+	for rows.Next() {
+		e := &FieldHistoryEntry{Field: &FieldHistoryField{}}
+		var userID UserID
+		var userEmail, flowName string
+		var flowUUID null.String
+
+		if err := rows.Scan(&e.Field.Key, &e.Field.Name, &e.OldValue, &e.NewValue, &userID, &userEmail, &flowUUID, &flowName, &e.ChangedOn); err != nil {
+			return nil, errors.Wrapf(err, "error scanning field history entry")
+		}
+		if userID != NilUserID {
+			e.ChangedBy = &FieldHistoryUser{ID: userID, Email: userEmail}
+		}
+		if flowUUID != "" {
+			e.Flow = &FieldHistoryFlow{UUID: assets.FlowUUID(flowUUID), Name: flowName}
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+const sqlSelectFieldHistoryRetentions = `
+SELECT id, COALESCE(NULLIF((COALESCE(NULLIF(config, ''), '{}')::jsonb->'contact_field_history'->>'retention_days')::int, 0), $1) AS retention_days
+  FROM orgs_org
+ WHERE is_active = TRUE AND COALESCE(NULLIF(config, ''), '{}')::jsonb ? 'contact_field_history'
+ORDER BY id`
+
+const sqlDeleteExpiredFieldHistory = `
+DELETE FROM contacts_contactfieldhistory WHERE id IN (
+    SELECT id FROM contacts_contactfieldhistory WHERE org_id = $1 AND changed_on < NOW() - make_interval(days => $2) LIMIT $3
+)`
+
+// DeleteExpiredFieldHistory deletes the field history of each org which records it that is older than the org's
+// retention period, in batches of the given size. Returns the number of entries deleted.
+func DeleteExpiredFieldHistory(ctx context.Context, db Queryer, batchSize int) (int, error) {
+	var exists bool
+	if err := db.GetContext(ctx, &exists, sqlFieldHistoryTableExists); err != nil {
+		return 0, errors.Wrapf(err, "error checking for field history table")
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	var retentions []struct {
+		OrgID         OrgID `db:"id"`
+		RetentionDays int   `db:"retention_days"`
+	}
+	if err := db.SelectContext(ctx, &retentions, sqlSelectFieldHistoryRetentions, defaultFieldHistoryRetentionDays); err != nil {
+		return 0, errors.Wrapf(err, "error selecting field history retentions")
+	}
+
+	total := 0
+	for _, r := range retentions {
+		for {
+			res, err := db.ExecContext(ctx, sqlDeleteExpiredFieldHistory, r.OrgID, r.RetentionDays, batchSize)
+			if err != nil {
+				return total, errors.Wrapf(err, "error deleting expired field history for org: %d", r.OrgID)
+			}
+			n, _ := res.RowsAffected()
+			total += int(n)
+
+			if int(n) < batchSize {
+				break
+			}
+		}
+	}
+
+	return total, nil
+}
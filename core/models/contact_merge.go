@@ -0,0 +1,196 @@
+package models
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/null"
+	"github.com/pkg/errors"
+)
+
+// ContactMergePrecedence is which contact's values are kept when both contacts being merged have a value
+type ContactMergePrecedence string
+
+// contact merge precedences
+const (
+	ContactMergePrecedenceTarget = ContactMergePrecedence("target")
+	ContactMergePrecedenceSource = ContactMergePrecedence("source")
+)
+
+// the tables whose rows are reassigned from the source contact to the target contact when merging
+var contactMergeReassignments = []struct {
+	name  string
+	table string
+}{
+	{"messages", "msgs_msg"},
+	{"sessions", "flows_flowsession"},
+	{"runs", "flows_flowrun"},
+	{"tickets", "tickets_ticket"},
+	{"ticket_events", "tickets_ticketevent"},
+	{"channel_events", "channels_channelevent"},
+	{"calls", "ivr_call"},
+}
+
+// MergeContacts merges the source contact into the target contact inside the given transaction. Waiting sessions of
+// the source contact are interrupted and those of the target contact are kept. Field values, name and language are
+// combined according to the given precedence, manual groups are combined and smart groups and campaign events are
+// recalculated. The source contact is then released. Callers should hold the locks for both contacts. Returns the
+// number of each type of item moved from the source to the target.
+func MergeContacts(ctx context.Context, tx *sqlx.Tx, oa *OrgAssets, userID UserID, source, target *Contact, precedence ContactMergePrecedence) (map[string]interface{}, error) {
+	counts := make(map[string]interface{}, len(contactMergeReassignments)+3)
+
+	// interrupt any waiting sessions for our source, we keep our target's
+	interrupted, err := getWaitingSessionsForContacts(ctx, tx, []ContactID{source.ID()})
+	if err != nil {
+		return nil, err
+	}
+	if err := exitSessionBatch(ctx, tx, interrupted, SessionStatusInterrupted); err != nil {
+		return nil, errors.Wrapf(err, "error interrupting sessions for source contact")
+	}
+	counts["interrupted_sessions"] = len(interrupted)
+
+	// reassign the history of our source to our target
+	for _, r := range contactMergeReassignments {
+		res, err := tx.ExecContext(ctx, `UPDATE `+r.table+` SET contact_id = $2 WHERE contact_id = $1`, source.ID(), target.ID())
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reassigning %s to target contact", r.name)
+		}
+		n, _ := res.RowsAffected()
+		counts[r.name] = n
+	}
+
+	// fired campaign events are history so are reassigned, unfired ones are recalculated below
+	res, err := tx.ExecContext(ctx, `UPDATE campaigns_eventfire SET contact_id = $2 WHERE contact_id = $1 AND fired IS NOT NULL`, source.ID(), target.ID())
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reassigning event fires to target contact")
+	}
+	n, _ := res.RowsAffected()
+	counts["event_fires"] = n
+
+	if err := DeleteUnfiredContactEvents(ctx, tx, []ContactID{source.ID()}); err != nil {
+		return nil, err
+	}
+
+	// combine our name, language and field values, giving precedence to one contact
+	first, second := target, source
+	if precedence == ContactMergePrecedenceSource {
+		first, second = source, target
+	}
+
+	name := first.Name()
+	if name == "" {
+		name = second.Name()
+	}
+	language := first.Language()
+	if language == "" {
+		language = second.Language()
+	}
+
+	_, err = tx.ExecContext(ctx, sqlMergeContactValues, target.ID(), source.ID(), null.String(name), null.String(string(language)), precedence == ContactMergePrecedenceSource)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error merging contact values")
+	}
+
+	// move the source URNs to our target, ordered by precedence
+	mergedURNs := make([]urns.URN, 0, len(target.URNs())+len(source.URNs()))
+	for _, c := range []*Contact{first, second} {
+		for _, u := range c.URNs() {
+			if c == source {
+				// without an id, the source URN is treated as new and moved to the target
+				u, err = urns.NewURNFromParts(u.Scheme(), u.Path(), "", u.Display())
+				if err != nil {
+					return nil, errors.Wrapf(err, "error parsing source URN")
+				}
+			}
+			mergedURNs = append(mergedURNs, u)
+		}
+	}
+
+	err = UpdateContactURNs(ctx, tx, oa, []*ContactURNsChanged{{ContactID: target.ID(), OrgID: oa.OrgID(), URNs: mergedURNs}})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error moving URNs to target contact")
+	}
+	counts["urns"] = len(source.URNs())
+
+	// add our target to any manual groups of our source, smart groups are recalculated below
+	groupAdds := make([]*GroupAdd, 0, len(source.Groups()))
+	for _, g := range source.Groups() {
+		if g.Type() == GroupTypeManual {
+			groupAdds = append(groupAdds, &GroupAdd{ContactID: target.ID(), GroupID: g.ID()})
+		}
+	}
+	if err := AddContactsToGroups(ctx, tx, groupAdds); err != nil {
+		return nil, errors.Wrapf(err, "error adding target contact to groups")
+	}
+
+	// release our source contact
+	if _, err := tx.ExecContext(ctx, sqlDeleteAllContactGroups, oa.OrgID(), source.ID()); err != nil {
+		return nil, errors.Wrapf(err, "error removing source contact from groups")
+	}
+	if _, err := tx.ExecContext(ctx, sqlDeleteAllContactTriggers, source.ID()); err != nil {
+		return nil, errors.Wrapf(err, "error removing source contact from triggers")
+	}
+	if _, err := tx.ExecContext(ctx, sqlReleaseMergedContact, source.ID(), userID); err != nil {
+		return nil, errors.Wrapf(err, "error releasing source contact")
+	}
+
+	// ticket counts have to be recalculated for both contacts
+	if _, err := tx.ExecContext(ctx, sqlUpdateContactTicketCounts, pq.Array([]ContactID{source.ID(), target.ID()})); err != nil {
+		return nil, errors.Wrapf(err, "error updating contact ticket counts")
+	}
+
+	// reload our merged target and recalculate its smart groups
+	merged, err := LoadContact(ctx, tx, oa, target.ID())
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading merged contact")
+	}
+	flowContact, err := merged.FlowContact(oa)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating flow contact for merged contact")
+	}
+	if err := CalculateDynamicGroups(ctx, tx, oa, []*flows.Contact{flowContact}); err != nil {
+		return nil, errors.Wrapf(err, "error calculating smart groups for merged contact")
+	}
+
+	// and then campaign events for all the groups it's now in
+	merged, err = LoadContact(ctx, tx, oa, target.ID())
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading merged contact")
+	}
+	flowContact, err = merged.FlowContact(oa)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating flow contact for merged contact")
+	}
+	for _, g := range merged.Groups() {
+		if len(oa.CampaignByGroupID(g.ID())) > 0 {
+			if err := AddCampaignEventsForGroupAddition(ctx, tx, oa, []*flows.Contact{flowContact}, g.ID()); err != nil {
+				return nil, errors.Wrapf(err, "error scheduling campaign events for merged contact")
+			}
+		}
+	}
+
+	return counts, nil
+}
+
+const sqlMergeContactValues = `
+UPDATE contacts_contact t
+   SET name = $3,
+       language = $4,
+       fields = CASE WHEN $5 THEN COALESCE(t.fields, '{}') || COALESCE(s.fields, '{}') ELSE COALESCE(s.fields, '{}') || COALESCE(t.fields, '{}') END,
+       last_seen_on = GREATEST(t.last_seen_on, s.last_seen_on),
+       modified_on = NOW()
+  FROM contacts_contact s
+ WHERE t.id = $1 AND s.id = $2`
+
+const sqlReleaseMergedContact = `
+UPDATE contacts_contact
+   SET is_active = FALSE, current_flow_id = NULL, modified_on = NOW(), modified_by_id = $2
+ WHERE id = $1`
+
+const sqlUpdateContactTicketCounts = `
+UPDATE contacts_contact c
+   SET ticket_count = (SELECT count(*) FROM tickets_ticket t WHERE t.contact_id = c.id AND t.status = 'O')
+ WHERE c.id = ANY($1)`
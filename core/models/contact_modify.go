@@ -0,0 +1,60 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	contactModifyProgressKey = "contact_modify:%d:%s"
+	contactModifyProgressTTL = 60 * 60 * 24
+)
+
+// ContactModifyStatus is the status of a bulk contact modification
+type ContactModifyStatus string
+
+// possible bulk contact modification statuses
+const (
+	ContactModifyStatusProcessing = ContactModifyStatus("processing")
+	ContactModifyStatusComplete   = ContactModifyStatus("complete")
+	ContactModifyStatusFailed     = ContactModifyStatus("failed")
+)
+
+// ContactModifyProgress is the progress of a bulk contact modification
+type ContactModifyProgress struct {
+	Status    ContactModifyStatus `json:"status"`
+	Total     int                 `json:"total"`
+	Processed int                 `json:"processed"`
+}
+
+// SetContactModifyProgress records the progress of the bulk contact modification with the given UUID
+func SetContactModifyProgress(rc redis.Conn, orgID OrgID, uuid string, progress *ContactModifyProgress) error {
+	key := fmt.Sprintf(contactModifyProgressKey, orgID, uuid)
+
+	rc.Send("multi")
+	rc.Send("hset", key, "status", progress.Status, "total", progress.Total, "processed", progress.Processed)
+	rc.Send("expire", key, contactModifyProgressTTL)
+	_, err := rc.Do("exec")
+
+	return errors.Wrapf(err, "error setting progress for contact modify: %s", uuid)
+}
+
+// GetContactModifyProgress gets the progress of the bulk contact modification with the given UUID, returning nil
+// if there is no such modification
+func GetContactModifyProgress(rc redis.Conn, orgID OrgID, uuid string) (*ContactModifyProgress, error) {
+	values, err := redis.StringMap(rc.Do("hgetall", fmt.Sprintf(contactModifyProgressKey, orgID, uuid)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting progress for contact modify: %s", uuid)
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	total, _ := strconv.Atoi(values["total"])
+	processed, _ := strconv.Atoi(values["processed"])
+
+	return &ContactModifyProgress{Status: ContactModifyStatus(values["status"]), Total: total, Processed: processed}, nil
+}
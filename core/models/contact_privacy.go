@@ -0,0 +1,333 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// EraseContact honours a right-to-erasure request for the given contact. Waiting sessions are interrupted, open tickets
+// are closed, message text and attachments, channel logs, session outputs, run results and field history are purged,
+// the contact's name and field values are cleared and its URNs detached. The contact itself is kept so that org level
+// counts remain consistent.
+// Callers should hold the lock for the contact. Returns the number of each type of item affected.
+func EraseContact(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, userID UserID, contact *Contact, logger *HTTPLogger) (map[string]int, error) {
+	counts := make(map[string]int, 10)
+
+	interrupted, err := InterruptSessionsForContacts(ctx, rt.DB, []ContactID{contact.ID()})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error interrupting sessions for contact")
+	}
+	counts["interrupted_sessions"] = interrupted
+
+	// close tickets on their ticketing services where possible, but locally regardless
+	tickets, err := LoadOpenTicketsForContact(ctx, rt.DB, contact)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading open tickets for contact")
+	}
+	if len(tickets) > 0 {
+		if _, err := CloseTickets(ctx, rt, oa, userID, tickets, true, true, logger); err != nil {
+			return nil, errors.Wrapf(err, "error closing tickets for contact")
+		}
+	}
+	counts["closed_tickets"] = len(tickets)
+
+	// purge anything stored in our attachment and session storage before we lose track of it
+	purgedAttachments, err := purgeContactAttachments(ctx, rt, oa, contact)
+	if err != nil {
+		return nil, err
+	}
+	counts["attachments"] = purgedAttachments
+
+	purgedOutputs, err := purgeContactSessionOutputs(ctx, rt, oa, contact)
+	if err != nil {
+		return nil, err
+	}
+	counts["session_outputs"] = purgedOutputs
+
+	tx, err := rt.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error starting transaction")
+	}
+
+	type erasure struct {
+		name string
+		sql  string
+	}
+
+	erasures := []erasure{
+		{"messages", sqlEraseContactMsgs},
+		{"runs", sqlEraseContactRuns},
+		{"tickets", sqlEraseContactTickets},
+		{"channel_logs", sqlEraseContactChannelLogs},
+		{"urns", sqlEraseContactURNs},
+	}
+
+	// field history is only recorded for orgs which have it configured
+	historyConfig, err := GetFieldHistoryConfig(ctx, tx, oa.Org())
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	counts["field_history"] = 0
+	if historyConfig != nil {
+		erasures = append(erasures, erasure{"field_history", sqlEraseContactFieldHistory})
+	}
+
+	for _, e := range erasures {
+		res, err := tx.ExecContext(ctx, e.sql, contact.ID())
+		if err != nil {
+			tx.Rollback()
+			return nil, errors.Wrapf(err, "error erasing %s for contact", e.name)
+		}
+		n, _ := res.RowsAffected()
+		counts[e.name] = int(n)
+	}
+
+	if _, err := tx.ExecContext(ctx, sqlEraseContactSessions, contact.ID()); err != nil {
+		tx.Rollback()
+		return nil, errors.Wrapf(err, "error erasing sessions for contact")
+	}
+	if _, err := tx.ExecContext(ctx, sqlEraseContact, contact.ID(), userID); err != nil {
+		tx.Rollback()
+		return nil, errors.Wrapf(err, "error erasing contact")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrapf(err, "error committing contact erasure")
+	}
+
+	// with no name or field values, the contact may no longer belong in some smart groups
+	erased, err := LoadContact(ctx, rt.DB, oa, contact.ID())
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading erased contact")
+	}
+	flowContact, err := erased.FlowContact(oa)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating flow contact for erased contact")
+	}
+	if err := CalculateDynamicGroups(ctx, rt.DB, oa, []*flows.Contact{flowContact}); err != nil {
+		return nil, errors.Wrapf(err, "error calculating smart groups for erased contact")
+	}
+
+	return counts, nil
+}
+
+const sqlSelectContactAttachments = `
+SELECT unnest(attachments) FROM msgs_msg WHERE contact_id = $1 AND attachments IS NOT NULL`
+
+// deletes any attachments of the contact's messages which are in our attachment storage, returning the number purged
+func purgeContactAttachments(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, contact *Contact) (int, error) {
+	var attachments []utils.Attachment
+	if err := rt.DB.SelectContext(ctx, &attachments, sqlSelectContactAttachments, contact.ID()); err != nil {
+		return 0, errors.Wrapf(err, "error selecting attachments for contact")
+	}
+
+	// only attachments under this org's path in our storage are ours to purge
+	orgPrefix := path.Join("/", rt.Config.S3AttachmentsPrefix, fmt.Sprintf("%d", oa.OrgID())) + "/"
+
+	purged := 0
+	for _, a := range attachments {
+		u, err := url.Parse(a.URL())
+		if err != nil {
+			logrus.WithError(err).WithField("attachment", a).Warn("unable to parse attachment URL for purging")
+			continue
+		}
+
+		idx := strings.Index(u.Path, orgPrefix)
+		if idx < 0 {
+			continue
+		}
+
+		if err := runtime.DeleteFromStorage(ctx, rt.AttachmentStorage, u.Path[idx:]); err != nil {
+			return purged, errors.Wrapf(err, "error purging attachment: %s", a.URL())
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+const sqlSelectContactSessionOutputURLs = `
+SELECT output_url FROM flows_flowsession WHERE contact_id = $1 AND output_url IS NOT NULL`
+
+// deletes the outputs of the contact's sessions which are in our session storage, returning the number purged
+func purgeContactSessionOutputs(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, contact *Contact) (int, error) {
+	var outputURLs []string
+	if err := rt.DB.SelectContext(ctx, &outputURLs, sqlSelectContactSessionOutputURLs, contact.ID()); err != nil {
+		return 0, errors.Wrapf(err, "error selecting session output URLs for contact")
+	}
+
+	// session outputs are stored under the org's path, see Session.StoragePath
+	orgPrefix := path.Join("/", rt.Config.S3SessionPrefix, "orgs", fmt.Sprintf("%d", oa.OrgID())) + "/"
+
+	purged := 0
+	for _, outputURL := range outputURLs {
+		u, err := url.Parse(outputURL)
+		if err != nil {
+			return purged, errors.Wrapf(err, "error parsing output URL: %s", outputURL)
+		}
+
+		idx := strings.Index(u.Path, orgPrefix)
+		if idx < 0 {
+			logrus.WithField("output_url", outputURL).Warn("session output not in org's session storage, not purging")
+			continue
+		}
+
+		if err := runtime.DeleteFromStorage(ctx, rt.SessionStorage, u.Path[idx:]); err != nil {
+			return purged, errors.Wrapf(err, "error purging session output: %s", outputURL)
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+const sqlEraseContactMsgs = `
+UPDATE msgs_msg SET text = '', attachments = NULL, metadata = NULL, log_uuids = NULL, modified_on = NOW() WHERE contact_id = $1`
+
+const sqlEraseContactRuns = `
+UPDATE flows_flowrun SET results = '{}', modified_on = NOW() WHERE contact_id = $1`
+
+const sqlEraseContactTickets = `
+UPDATE tickets_ticket SET body = '', modified_on = NOW() WHERE contact_id = $1`
+
+const sqlEraseContactChannelLogs = `
+DELETE FROM channels_channellog
+ WHERE msg_id IN (SELECT id FROM msgs_msg WHERE contact_id = $1) OR call_id IN (SELECT id FROM ivr_call WHERE contact_id = $1)`
+
+// URNs are kept as messages and calls still reference them, but they're detached and their identity is replaced by a
+// random one, so that the original address is gone and can't be picked up again by a new contact
+const sqlEraseContactURNs = `
+UPDATE contacts_contacturn u
+   SET contact_id = NULL, scheme = 'deleted', path = r.path, identity = 'deleted:' || r.path, display = NULL, auth = NULL
+  FROM (SELECT id, md5(random()::text || id::text) AS path FROM contacts_contacturn WHERE contact_id = $1) r
+ WHERE u.id = r.id`
+
+const sqlEraseContactFieldHistory = `
+DELETE FROM contacts_contactfieldhistory WHERE contact_id = $1`
+
+const sqlEraseContactSessions = `
+UPDATE flows_flowsession SET output = '{}', output_url = NULL WHERE contact_id = $1`
+
+const sqlEraseContact = `
+UPDATE contacts_contact
+   SET name = NULL, fields = '{}', modified_on = NOW(), modified_by_id = $2
+ WHERE id = $1`
+
+// ContactData is everything we hold about a contact, as returned for a subject-access request
+type ContactData struct {
+	Contact      *flows.Contact       `json:"contact"`
+	Messages     []*ContactDataMsg    `json:"messages"`
+	Runs         []*ContactDataRun    `json:"runs"`
+	Tickets      []*ContactDataTicket `json:"tickets"`
+	FieldHistory []*FieldHistoryEntry `json:"field_history"`
+}
+
+// ContactDataMsg is a message in a contact's data
+type ContactDataMsg struct {
+	UUID        flows.MsgUUID      `json:"uuid"        db:"uuid"`
+	Direction   MsgDirection       `json:"direction"   db:"direction"`
+	Text        string             `json:"text"        db:"text"`
+	Attachments pq.StringArray     `json:"attachments" db:"attachments"`
+	Status      MsgStatus          `json:"status"      db:"status"`
+	URN         *string            `json:"urn"         db:"urn"`
+	ChannelUUID assets.ChannelUUID `json:"channel"     db:"channel_uuid"`
+	CreatedOn   time.Time          `json:"created_on"  db:"created_on"`
+	SentOn      *time.Time         `json:"sent_on"     db:"sent_on"`
+}
+
+// ContactDataRun is a flow run in a contact's data
+type ContactDataRun struct {
+	UUID      flows.RunUUID   `json:"uuid"       db:"uuid"`
+	FlowUUID  assets.FlowUUID `json:"flow_uuid"  db:"flow_uuid"`
+	FlowName  string          `json:"flow_name"  db:"flow_name"`
+	Status    RunStatus       `json:"status"     db:"status"`
+	Results   json.RawMessage `json:"results"    db:"results"`
+	CreatedOn time.Time       `json:"created_on" db:"created_on"`
+	ExitedOn  *time.Time      `json:"exited_on"  db:"exited_on"`
+}
+
+// ContactDataTicket is a ticket in a contact's data
+type ContactDataTicket struct {
+	UUID     flows.TicketUUID `json:"uuid"      db:"uuid"`
+	Status   TicketStatus     `json:"status"    db:"status"`
+	Topic    *string          `json:"topic"     db:"topic"`
+	Body     string           `json:"body"      db:"body"`
+	OpenedOn time.Time        `json:"opened_on" db:"opened_on"`
+	ClosedOn *time.Time       `json:"closed_on" db:"closed_on"`
+}
+
+const sqlSelectContactDataMsgs = `
+  SELECT m.uuid, m.direction, m.text, m.attachments, m.status, u.identity AS urn, ch.uuid AS channel_uuid, m.created_on, m.sent_on
+    FROM msgs_msg m
+LEFT JOIN contacts_contacturn u ON u.id = m.contact_urn_id
+LEFT JOIN channels_channel ch ON ch.id = m.channel_id
+   WHERE m.contact_id = $1
+ORDER BY m.created_on, m.id`
+
+const sqlSelectContactDataRuns = `
+  SELECT r.uuid, f.uuid AS flow_uuid, f.name AS flow_name, r.status, COALESCE(r.results, '{}') AS results, r.created_on, r.exited_on
+    FROM flows_flowrun r
+    JOIN flows_flow f ON f.id = r.flow_id
+   WHERE r.contact_id = $1
+ORDER BY r.created_on, r.id`
+
+const sqlSelectContactDataTickets = `
+  SELECT t.uuid, t.status, tp.name AS topic, t.body, t.opened_on, t.closed_on
+    FROM tickets_ticket t
+LEFT JOIN tickets_topic tp ON tp.id = t.topic_id
+   WHERE t.contact_id = $1
+ORDER BY t.opened_on, t.id`
+
+// LoadContactData loads everything we hold about the given contact
+func LoadContactData(ctx context.Context, db Queryer, oa *OrgAssets, contact *Contact) (*ContactData, error) {
+	flowContact, err := contact.FlowContact(oa)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating flow contact")
+	}
+
+	data := &ContactData{
+		Contact:      flowContact,
+		Messages:     make([]*ContactDataMsg, 0),
+		Runs:         make([]*ContactDataRun, 0),
+		Tickets:      make([]*ContactDataTicket, 0),
+		FieldHistory: make([]*FieldHistoryEntry, 0),
+	}
+
+	if err := db.SelectContext(ctx, &data.Messages, sqlSelectContactDataMsgs, contact.ID()); err != nil {
+		return nil, errors.Wrapf(err, "error selecting messages for contact")
+	}
+	if err := db.SelectContext(ctx, &data.Runs, sqlSelectContactDataRuns, contact.ID()); err != nil {
+		return nil, errors.Wrapf(err, "error selecting runs for contact")
+	}
+	if err := db.SelectContext(ctx, &data.Tickets, sqlSelectContactDataTickets, contact.ID()); err != nil {
+		return nil, errors.Wrapf(err, "error selecting tickets for contact")
+	}
+
+	historyConfig, err := GetFieldHistoryConfig(ctx, db, oa.Org())
+	if err != nil {
+		return nil, err
+	}
+	if historyConfig != nil {
+		data.FieldHistory, err = LoadContactFieldHistory(ctx, db, contact.ID(), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
@@ -0,0 +1,103 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/goflow/utils"
+	"github.com/nyaruka/mailroom/core/models"
+	_ "github.com/nyaruka/mailroom/services/tickets/intern"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEraseContact(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	// org records field history
+	db.MustExec(`UPDATE orgs_org SET config = '{"contact_field_history": {}}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshOrg|models.RefreshTicketers)
+	require.NoError(t, err)
+
+	// give Cathy a waiting session, a ticket, a message with a stored attachment and a field value
+	sessionID := testdata.InsertWaitingSession(db, testdata.Org1, testdata.Cathy, models.FlowTypeMessaging, testdata.Favorites, models.NilCallID, time.Now(), time.Now().Add(time.Hour), true, nil)
+	testdata.InsertFlowRun(db, testdata.Org1, sessionID, testdata.Cathy, testdata.Favorites, models.RunStatusWaiting)
+	testdata.InsertOpenTicket(db, testdata.Org1, testdata.Cathy, testdata.Internal, testdata.DefaultTopic, "My address is...", "", time.Now(), nil)
+
+	attachmentURL, err := rt.AttachmentStorage.Put(ctx, "/attachments/1/6e3c/b1da/6e3cb1da.jpg", "image/jpeg", []byte("selfie"))
+	require.NoError(t, err)
+	testdata.InsertOutgoingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.Cathy, "Nice photo", []utils.Attachment{utils.Attachment("image/jpeg:" + attachmentURL)}, models.MsgStatusSent, false)
+	msgIn := testdata.InsertIncomingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.Cathy, "I live at 123 Main St", models.MsgStatusHandled)
+	db.MustExec(`INSERT INTO channels_channellog(uuid, log_type, channel_id, msg_id, http_logs, errors, is_error, elapsed_ms, created_on) VALUES($1, 'msg_receive', $2, $3, '[]', '[]', FALSE, 12, NOW())`, "c20d3bbf-5d7c-4e8d-a07b-2e6c5e4e0d1a", testdata.TwilioChannel.ID, msgIn.ID())
+
+	// and an ended session with its output in session storage
+	outputURL, err := rt.SessionStorage.Put(ctx, "/orgs/1/c/6393/6393abc0-283d-4c9b-a1b3-641a035c34bf/20221128T100000.000Z_session_1.json", "application/json", []byte(`{"contact": "Cathy"}`))
+	require.NoError(t, err)
+	endedID := testdata.InsertFlowSession(db, testdata.Org1, testdata.Cathy, models.FlowTypeMessaging, models.SessionStatusCompleted, testdata.Favorites, models.NilCallID)
+	db.MustExec(`UPDATE flows_flowsession SET output = NULL, output_url = $2 WHERE id = $1`, endedID, outputURL)
+
+	db.MustExec(`UPDATE contacts_contact SET fields = '{"903f51da-2717-47c7-a0d3-f2f32877013d": {"text": "30", "number": 30}}' WHERE id = $1`, testdata.Cathy.ID)
+	db.MustExec(`INSERT INTO contacts_contactfieldhistory(org_id, contact_id, field_id, old_value, new_value, changed_on) VALUES($1, $2, $3, NULL, '30', NOW())`, testdata.Org1.ID, testdata.Cathy.ID, testdata.AgeField.ID)
+
+	cathy, _ := testdata.Cathy.Load(db, oa)
+
+	// check what we export before erasing
+	data, err := models.LoadContactData(ctx, db, oa, cathy)
+	require.NoError(t, err)
+	assert.Equal(t, "Cathy", data.Contact.Name())
+	assert.Equal(t, 2, len(data.Messages))
+	assert.Equal(t, "Nice photo", data.Messages[0].Text)
+	assert.Equal(t, []string{"image/jpeg:" + attachmentURL}, []string(data.Messages[0].Attachments))
+	assert.Equal(t, "tel:+16055741111", *data.Messages[0].URN)
+	assert.Equal(t, testdata.TwilioChannel.UUID, data.Messages[0].ChannelUUID)
+	assert.Equal(t, 1, len(data.Runs))
+	assert.Equal(t, testdata.Favorites.UUID, data.Runs[0].FlowUUID)
+	assert.Equal(t, 1, len(data.Tickets))
+	assert.Equal(t, "My address is...", data.Tickets[0].Body)
+	assert.Equal(t, "General", *data.Tickets[0].Topic)
+	assert.Equal(t, 1, len(data.FieldHistory))
+	assert.Equal(t, "age", data.FieldHistory[0].Field.Key)
+
+	counts, err := models.EraseContact(ctx, rt, oa, testdata.Admin.ID, cathy, &models.HTTPLogger{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{
+		"interrupted_sessions": 1,
+		"closed_tickets":       1,
+		"attachments":          1,
+		"session_outputs":      1,
+		"messages":             2,
+		"runs":                 1,
+		"tickets":              1,
+		"channel_logs":         1,
+		"urns":                 1,
+		"field_history":        1,
+	}, counts)
+
+	// attachment and session output have been deleted from storage
+	_, _, err = rt.AttachmentStorage.Get(ctx, "/attachments/1/6e3c/b1da/6e3cb1da.jpg")
+	assert.Error(t, err)
+	_, _, err = rt.SessionStorage.Get(ctx, "/orgs/1/c/6393/6393abc0-283d-4c9b-a1b3-641a035c34bf/20221128T100000.000Z_session_1.json")
+	assert.Error(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE contact_id = $1 AND (text != '' OR attachments IS NOT NULL)`, testdata.Cathy.ID).Returns(0)
+	assertdb.Query(t, db, `SELECT count(*) FROM flows_flowsession WHERE contact_id = $1 AND status = 'W'`, testdata.Cathy.ID).Returns(0)
+	assertdb.Query(t, db, `SELECT count(*) FROM flows_flowsession WHERE contact_id = $1 AND (output != '{}' OR output_url IS NOT NULL)`, testdata.Cathy.ID).Returns(0)
+	assertdb.Query(t, db, `SELECT count(*) FROM channels_channellog WHERE msg_id = $1`, msgIn.ID()).Returns(0)
+	assertdb.Query(t, db, `SELECT count(*) FROM flows_flowrun WHERE contact_id = $1 AND results != '{}'`, testdata.Cathy.ID).Returns(0)
+	assertdb.Query(t, db, `SELECT count(*) FROM tickets_ticket WHERE contact_id = $1 AND (status = 'O' OR body != '')`, testdata.Cathy.ID).Returns(0)
+	assertdb.Query(t, db, `SELECT count(*) FROM contacts_contacturn WHERE contact_id = $1`, testdata.Cathy.ID).Returns(0)
+	assertdb.Query(t, db, `SELECT contact_id FROM contacts_contacturn WHERE id = $1`, testdata.Cathy.URNID).Returns(nil)
+	assertdb.Query(t, db, `SELECT scheme FROM contacts_contacturn WHERE id = $1`, testdata.Cathy.URNID).Returns("deleted")
+	assertdb.Query(t, db, `SELECT count(*) FROM contacts_contacturn WHERE identity = 'tel:+16055741111' OR path = '+16055741111'`).Returns(0)
+	assertdb.Query(t, db, `SELECT count(*) FROM contacts_contact WHERE id = $1 AND name IS NULL AND fields = '{}' AND is_active`, testdata.Cathy.ID).Returns(1)
+
+	// other contacts are untouched
+	assertdb.Query(t, db, `SELECT name FROM contacts_contact WHERE id = $1`, testdata.Bob.ID).Returns("Bob")
+	assertdb.Query(t, db, `SELECT count(*) FROM contacts_contacturn WHERE contact_id = $1`, testdata.Bob.ID).Returns(1)
+}
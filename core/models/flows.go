@@ -104,6 +104,41 @@ func (f *Flow) IVRRetryWait() *time.Duration {
 	return &wait
 }
 
+// IVRRetryWaitFor returns the wait before retrying an IVR call which has errored the given number of times for the given
+// reason, using the flow's retry schedule if it has one, otherwise the org's, otherwise the flow's fixed retry wait
+func (f *Flow) IVRRetryWaitFor(org *Org, reason CallError, errorCount int) *time.Duration {
+	schedule := f.IVRRetrySchedule()
+	if schedule == nil {
+		schedule = org.IVRRetrySchedule()
+	}
+	if schedule != nil {
+		return schedule.RetryWait(reason, errorCount)
+	}
+
+	if errorCount >= CallMaxRetries {
+		return nil
+	}
+	return f.IVRRetryWait()
+}
+
+// IVRRetrySchedule returns the retry schedule for IVR calls in this flow if it has one
+func (f *Flow) IVRRetrySchedule() *CallRetrySchedule {
+	schedule := &CallRetrySchedule{}
+	if readConfigValue(&f.f.Config, configIVRRetrySchedule, schedule) {
+		return schedule
+	}
+	return nil
+}
+
+// IVRCallWindow returns the window in which calls for this flow can be made, falling back to the org's window
+func (f *Flow) IVRCallWindow(org *Org) *CallWindow {
+	window := &CallWindow{}
+	if readConfigValue(&f.f.Config, configIVRCallWindow, window) {
+		return window
+	}
+	return org.IVRCallWindow()
+}
+
 // IgnoreTriggers returns whether this flow ignores triggers
 func (f *Flow) IgnoreTriggers() bool { return f.f.IgnoreTriggers }
 
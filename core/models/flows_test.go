@@ -164,3 +164,35 @@ func TestFlowIDForUUID(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, testdata.Favorites.ID, id)
 }
+
+func TestFlowIVRRetryWaitFor(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	db.MustExec(`UPDATE flows_flow SET metadata = '{"ivr_retry_schedule": {"busy": [5, 15]}, "ivr_call_window": {"start": "08:00", "end": "19:00"}}'::json WHERE id = $1`, testdata.IVRFlow.ID)
+	db.MustExec(`UPDATE flows_flow SET metadata = '{"ivr_retry": 30}'::json WHERE id = $1`, testdata.Favorites.ID)
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	assert.NoError(t, err)
+
+	fiveMinutes := 5 * time.Minute
+	fifteenMinutes := 15 * time.Minute
+	thirtyMinutes := 30 * time.Minute
+
+	// flow with a retry schedule
+	flow, err := models.LoadFlowByID(ctx, db, testdata.Org1.ID, testdata.IVRFlow.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, &fiveMinutes, flow.IVRRetryWaitFor(oa.Org(), models.CallErrorBusy, 0))
+	assert.Equal(t, &fifteenMinutes, flow.IVRRetryWaitFor(oa.Org(), models.CallErrorBusy, 1))
+	assert.Nil(t, flow.IVRRetryWaitFor(oa.Org(), models.CallErrorBusy, 2))
+	assert.Nil(t, flow.IVRRetryWaitFor(oa.Org(), models.CallErrorNoAnswer, 0))
+	assert.Equal(t, &models.CallWindow{Start: "08:00", End: "19:00"}, flow.IVRCallWindow(oa.Org()))
+
+	// flow with just a fixed retry wait
+	flow, err = models.LoadFlowByID(ctx, db, testdata.Org1.ID, testdata.Favorites.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, &thirtyMinutes, flow.IVRRetryWaitFor(oa.Org(), models.CallErrorNoAnswer, 2))
+	assert.Nil(t, flow.IVRRetryWaitFor(oa.Org(), models.CallErrorNoAnswer, 3))
+	assert.Nil(t, flow.IVRCallWindow(oa.Org()))
+}
@@ -167,6 +167,21 @@ func ContactIDsForGroupIDs(ctx context.Context, tx Queryer, groupIDs []GroupID)
 	return contactIDs, nil
 }
 
+const sqlSelectContactIDsForGroupIDsWithStatus = `
+SELECT DISTINCT(gc.contact_id)
+  FROM contacts_contactgroup_contacts gc
+  JOIN contacts_contact c ON c.id = gc.contact_id
+ WHERE gc.contactgroup_id = ANY($1) AND c.status = $2`
+
+// ContactIDsForGroupIDsWithStatus returns the unique contacts with the given status in the passed in groups
+func ContactIDsForGroupIDsWithStatus(ctx context.Context, db Queryer, groupIDs []GroupID, status ContactStatus) ([]ContactID, error) {
+	var contactIDs []ContactID
+	if err := db.SelectContext(ctx, &contactIDs, sqlSelectContactIDsForGroupIDsWithStatus, pq.Array(groupIDs), status); err != nil {
+		return nil, errors.Wrapf(err, "error selecting contacts for groups")
+	}
+	return contactIDs, nil
+}
+
 const updateGroupStatusSQL = `UPDATE contacts_contactgroup SET status = $2 WHERE id = $1`
 
 // UpdateGroupStatus updates the group status for the passed in group
@@ -5,6 +5,7 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/goflow/excellent/types"
 	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/goflow/flows/modifiers"
 	"github.com/nyaruka/mailroom/runtime"
@@ -113,6 +115,20 @@ func (b *ContactImportBatch) Import(ctx context.Context, rt *runtime.Runtime, or
 	return nil
 }
 
+// ImportErrorType is the type of a problem found with an import record
+type ImportErrorType string
+
+// import error types
+const (
+	ImportErrorTypeContactNotFound   ImportErrorType = "contact_not_found"
+	ImportErrorTypeInvalidURN        ImportErrorType = "invalid_urn"
+	ImportErrorTypeURNConflict       ImportErrorType = "urn_conflict"
+	ImportErrorTypeInvalidLanguage   ImportErrorType = "invalid_language"
+	ImportErrorTypeInvalidField      ImportErrorType = "invalid_field"
+	ImportErrorTypeInvalidFieldValue ImportErrorType = "invalid_field_value"
+	ImportErrorTypeInvalidGroup      ImportErrorType = "invalid_group"
+)
+
 // holds work data for import of a single contact
 type importContact struct {
 	record      int
@@ -121,7 +137,92 @@ type importContact struct {
 	created     bool
 	flowContact *flows.Contact
 	mods        []flows.Modifier
-	errors      []string
+	errors      []*importContactError
+}
+
+// a problem found with a single contact being imported
+type importContactError struct {
+	typ     ImportErrorType
+	message string
+}
+
+func (i *importContact) addError(t ImportErrorType, s string, args ...interface{}) {
+	i.errors = append(i.errors, &importContactError{typ: t, message: fmt.Sprintf(s, args...)})
+}
+
+// normalizes and validates the URNs of this import, returning false if any are invalid and so it can't be imported. Real
+// imports leave this to GetOrCreateContact which reports them as a URN conflict.
+func (i *importContact) validateURNs(oa *OrgAssets) bool {
+	valid := true
+	for j, urn := range i.spec.URNs {
+		urn = urn.Normalize(string(oa.Env().DefaultCountry()))
+		i.spec.URNs[j] = urn
+
+		if err := urn.Validate(); err != nil {
+			i.addError(ImportErrorTypeInvalidURN, "'%s' is not a valid URN", urn)
+			valid = false
+		}
+	}
+	return valid
+}
+
+// validates everything about this import besides which contact it is, and creates the modifiers needed to apply it to
+// that contact, skipping anything invalid. Field values which don't match their field's type are still saved as text,
+// but if checkValues is set, they're also reported as errors.
+func (i *importContact) prepareModifiers(oa *OrgAssets, checkValues bool) {
+	sa := oa.SessionAssets()
+	spec := i.spec
+	addModifier := func(m flows.Modifier) { i.mods = append(i.mods, m) }
+
+	addModifier(modifiers.NewURNs(spec.URNs, modifiers.URNsAppend))
+
+	if spec.Name != nil {
+		addModifier(modifiers.NewName(*spec.Name))
+	}
+	if spec.Language != nil {
+		lang, err := envs.ParseLanguage(*spec.Language)
+		if err != nil {
+			i.addError(ImportErrorTypeInvalidLanguage, "'%s' is not a valid language code", *spec.Language)
+		} else {
+			addModifier(modifiers.NewLanguage(lang))
+		}
+	}
+
+	for _, key := range sortedFieldKeys(spec.Fields) {
+		value := spec.Fields[key]
+		field := sa.Fields().Get(key)
+		if field == nil {
+			i.addError(ImportErrorTypeInvalidField, "'%s' is not a valid contact field key", key)
+		} else {
+			if checkValues && !isValidFieldValue(oa.Env(), field, value) {
+				i.addError(ImportErrorTypeInvalidFieldValue, "'%s' is not a valid %s value for field '%s'", value, field.Type(), key)
+			}
+			addModifier(modifiers.NewField(field, value))
+		}
+	}
+
+	if len(spec.Groups) > 0 {
+		groups := make([]*flows.Group, 0, len(spec.Groups))
+		for _, uuid := range spec.Groups {
+			group := sa.Groups().Get(uuid)
+			if group == nil {
+				i.addError(ImportErrorTypeInvalidGroup, "'%s' is not a valid contact group UUID", uuid)
+			} else {
+				groups = append(groups, group)
+			}
+		}
+		addModifier(modifiers.NewGroups(groups, modifiers.GroupsAdd))
+	}
+}
+
+// the error added when we can't match an import's URNs to a single contact
+func (i *importContact) addURNConflictError() {
+	urnStrs := make([]string, len(i.spec.URNs))
+	for j := range i.spec.URNs {
+		urnStrs[j] = string(i.spec.URNs[j].Identity())
+	}
+
+	i.addError(ImportErrorTypeURNConflict, "Unable to find or create contact with URNs %s", strings.Join(urnStrs, ", "))
 }
 
 func (b *ContactImportBatch) tryImport(ctx context.Context, rt *runtime.Runtime, orgID OrgID) error {
@@ -176,24 +277,18 @@ func (b *ContactImportBatch) tryImport(ctx context.Context, rt *runtime.Runtime,
 
 // for each import, fetches or creates the contact, creates the modifiers needed to set fields etc
 func (b *ContactImportBatch) getOrCreateContacts(ctx context.Context, db QueryerWithTx, oa *OrgAssets, imports []*importContact) error {
-	sa := oa.SessionAssets()
-
 	// build map of UUIDs to contacts
-	contactsByUUID, err := b.loadContactsByUUID(ctx, db, oa, imports)
+	contactsByUUID, err := loadImportContactsByUUID(ctx, db, oa, imports)
 	if err != nil {
 		return errors.Wrap(err, "error loading contacts by UUID")
 	}
 
 	for _, imp := range imports {
-		addModifier := func(m flows.Modifier) { imp.mods = append(imp.mods, m) }
-		addError := func(s string, args ...interface{}) { imp.errors = append(imp.errors, fmt.Sprintf(s, args...)) }
-		spec := imp.spec
-
-		uuid := spec.UUID
+		uuid := imp.spec.UUID
 		if uuid != "" {
 			imp.contact = contactsByUUID[uuid]
 			if imp.contact == nil {
-				addError("Unable to find contact with UUID '%s'", uuid)
+				imp.addError(ImportErrorTypeContactNotFound, "Unable to find contact with UUID '%s'", uuid)
 				continue
 			}
 
@@ -203,60 +298,21 @@ func (b *ContactImportBatch) getOrCreateContacts(ctx context.Context, db Queryer
 			}
 
 		} else {
-			imp.contact, imp.flowContact, imp.created, err = GetOrCreateContact(ctx, db, oa, spec.URNs, NilChannelID)
+			imp.contact, imp.flowContact, imp.created, err = GetOrCreateContact(ctx, db, oa, imp.spec.URNs, NilChannelID)
 			if err != nil {
-				urnStrs := make([]string, len(spec.URNs))
-				for i := range spec.URNs {
-					urnStrs[i] = string(spec.URNs[i].Identity())
-				}
-
-				addError("Unable to find or create contact with URNs %s", strings.Join(urnStrs, ", "))
+				imp.addURNConflictError()
 				continue
 			}
 		}
 
-		addModifier(modifiers.NewURNs(spec.URNs, modifiers.URNsAppend))
-
-		if spec.Name != nil {
-			addModifier(modifiers.NewName(*spec.Name))
-		}
-		if spec.Language != nil {
-			lang, err := envs.ParseLanguage(*spec.Language)
-			if err != nil {
-				addError("'%s' is not a valid language code", *spec.Language)
-			} else {
-				addModifier(modifiers.NewLanguage(lang))
-			}
-		}
-
-		for key, value := range spec.Fields {
-			field := sa.Fields().Get(key)
-			if field == nil {
-				addError("'%s' is not a valid contact field key", key)
-			} else {
-				addModifier(modifiers.NewField(field, value))
-			}
-		}
-
-		if len(spec.Groups) > 0 {
-			groups := make([]*flows.Group, 0, len(spec.Groups))
-			for _, uuid := range spec.Groups {
-				group := sa.Groups().Get(uuid)
-				if group == nil {
-					addError("'%s' is not a valid contact group UUID", uuid)
-				} else {
-					groups = append(groups, group)
-				}
-			}
-			addModifier(modifiers.NewGroups(groups, modifiers.GroupsAdd))
-		}
+		imp.prepareModifiers(oa, false)
 	}
 
 	return nil
 }
 
 // loads any import contacts for which we have UUIDs
-func (b *ContactImportBatch) loadContactsByUUID(ctx context.Context, db Queryer, oa *OrgAssets, imports []*importContact) (map[flows.ContactUUID]*Contact, error) {
+func loadImportContactsByUUID(ctx context.Context, db Queryer, oa *OrgAssets, imports []*importContact) (map[flows.ContactUUID]*Contact, error) {
 	uuids := make([]flows.ContactUUID, 0, 50)
 	for _, imp := range imports {
 		if imp.spec.UUID != "" {
@@ -297,7 +353,7 @@ func (b *ContactImportBatch) markComplete(ctx context.Context, db Queryer, impor
 			numUpdated++
 		}
 		for _, e := range imp.errors {
-			importErrors = append(importErrors, importError{Record: imp.record, Row: imp.spec.ImportRow, Message: e})
+			importErrors = append(importErrors, importError{Record: imp.record, Row: imp.spec.ImportRow, Message: e.message})
 		}
 	}
 
@@ -379,3 +435,30 @@ type importError struct {
 	Row     int    `json:"row"`
 	Message string `json:"message"`
 }
+
+// checks whether the given value can be stored as the type of the given field, empty values being used to clear fields
+func isValidFieldValue(env envs.Environment, field assets.Field, value string) bool {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return true
+	}
+
+	switch field.Type() {
+	case assets.FieldTypeNumber:
+		_, xerr := types.ToXNumber(env, types.NewXText(value))
+		return xerr == nil
+	case assets.FieldTypeDatetime:
+		_, err := envs.DateTimeFromString(env, value, false)
+		return err == nil
+	}
+	return true
+}
+
+func sortedFieldKeys(fields map[string]string) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
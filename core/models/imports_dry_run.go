@@ -0,0 +1,142 @@
+package models
+
+import (
+	"context"
+
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/pkg/errors"
+)
+
+// ImportDryRunError is a problem found with a particular import record
+type ImportDryRunError struct {
+	Record  int             `json:"record"`
+	Row     int             `json:"row"`
+	Type    ImportErrorType `json:"type"`
+	Message string          `json:"message"`
+}
+
+// ImportDryRun is the result of validating an import without writing anything
+type ImportDryRun struct {
+	NumRecords  int                     `json:"num_records"`
+	NumCreated  int                     `json:"num_created"`
+	NumUpdated  int                     `json:"num_updated"`
+	NumErrored  int                     `json:"num_errored"`
+	ErrorCounts map[ImportErrorType]int `json:"error_counts"`
+	Errors      []*ImportDryRunError    `json:"errors"`
+}
+
+const sqlSelectContactImportBatches = `
+  SELECT id, contact_import_id, status, specs, record_start, record_end
+    FROM contacts_contactimportbatch
+   WHERE contact_import_id = $1
+ORDER BY record_start`
+
+// DryRunContactImport validates all the batches of the given import in the same way they would be processed, but
+// without creating or updating any contacts, and returns the problems found and projected counts
+func DryRunContactImport(ctx context.Context, db Queryer, oa *OrgAssets, importID ContactImportID) (*ImportDryRun, error) {
+	var batches []*ContactImportBatch
+	if err := db.SelectContext(ctx, &batches, sqlSelectContactImportBatches, importID); err != nil {
+		return nil, errors.Wrapf(err, "error loading batches for import id=%d", importID)
+	}
+
+	imports := make([]*importContact, 0, 100)
+	for _, b := range batches {
+		var specs []*ContactSpec
+		if err := jsonx.Unmarshal(b.Specs, &specs); err != nil {
+			return nil, errors.Wrapf(err, "error unmarshaling specs for batch id=%d", b.ID)
+		}
+		for i, spec := range specs {
+			imports = append(imports, &importContact{record: b.RecordStart + i, spec: spec})
+		}
+	}
+
+	return dryRunImports(ctx, db, oa, imports)
+}
+
+// runs through the given imports like ContactImportBatch.getOrCreateContacts, but only looks up which contacts would
+// be matched rather than creating them
+func dryRunImports(ctx context.Context, db Queryer, oa *OrgAssets, imports []*importContact) (*ImportDryRun, error) {
+	result := &ImportDryRun{
+		NumRecords:  len(imports),
+		ErrorCounts: make(map[ImportErrorType]int),
+		Errors:      make([]*ImportDryRunError, 0, 10),
+	}
+
+	contactsByUUID, err := loadImportContactsByUUID(ctx, db, oa, imports)
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading contacts by UUID")
+	}
+
+	validURNs := make([]bool, len(imports))
+	allURNs := make([]urns.URN, 0, len(imports))
+	for i, imp := range imports {
+		validURNs[i] = imp.validateURNs(oa)
+		if validURNs[i] {
+			allURNs = append(allURNs, imp.spec.URNs...)
+		}
+	}
+
+	urnOwners, err := contactIDsFromURNs(ctx, db, oa.OrgID(), allURNs)
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading contacts by URN")
+	}
+
+	// URNs taken by earlier records will match later records to the same contact
+	takenURNs := make(map[urns.URN]bool)
+
+	for i, imp := range imports {
+		matched := false
+
+		if !validURNs[i] {
+			// can't be imported
+		} else if imp.spec.UUID != "" {
+			if contactsByUUID[imp.spec.UUID] == nil {
+				imp.addError(ImportErrorTypeContactNotFound, "Unable to find contact with UUID '%s'", imp.spec.UUID)
+			} else {
+				matched = true
+			}
+		} else {
+			owners := make(map[ContactID]bool)
+			ownedByCreated := false
+			for _, urn := range imp.spec.URNs {
+				if owner := urnOwners[urn]; owner != NilContactID {
+					owners[owner] = true
+				} else if takenURNs[urn.Identity()] {
+					ownedByCreated = true
+				}
+			}
+
+			if len(owners) > 1 || (len(owners) == 1 && ownedByCreated) {
+				imp.addURNConflictError()
+			} else {
+				matched = true
+				imp.created = len(owners) == 0 && !ownedByCreated
+			}
+		}
+
+		if matched {
+			// URNs are appended to matched contacts so all of them are now taken
+			for _, urn := range imp.spec.URNs {
+				takenURNs[urn.Identity()] = true
+			}
+
+			imp.prepareModifiers(oa, true)
+		}
+
+		for _, e := range imp.errors {
+			result.Errors = append(result.Errors, &ImportDryRunError{Record: imp.record, Row: imp.spec.ImportRow, Type: e.typ, Message: e.message})
+			result.ErrorCounts[e.typ]++
+		}
+
+		if !matched {
+			result.NumErrored++
+		} else if imp.created {
+			result.NumCreated++
+		} else {
+			result.NumUpdated++
+		}
+	}
+
+	return result, nil
+}
@@ -246,3 +246,51 @@ func loadAllContacts(t *testing.T, db *sqlx.DB, oa *models.OrgAssets) []*flows.C
 
 	return flowContacts
 }
+
+func TestDryRunContactImport(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	importID := testdata.InsertContactImport(db, testdata.Org1, testdata.Admin)
+	testdata.InsertContactImportBatch(db, importID, []byte(`[
+		{"name": "Cathy 2", "urns": ["tel:+16055741111"], "_import_row": 2},
+		{"name": "Norbert", "language": "xxx", "urns": ["tel:+16055740001"], "_import_row": 3},
+		{"urns": ["tel:+16055740001"], "_import_row": 4},
+		{"uuid": "f2a6d1a7-3c29-4e3a-8d6b-4e2a1c9d0b5f", "name": "Ghost", "_import_row": 5},
+		{"urns": ["tel:+16055741111", "tel:+16055742222"], "_import_row": 6},
+		{"urns": ["tel:+16055740009"], "fields": {"age": "old", "gender": "M", "goats": "3"}, "groups": ["c153e265-f7c9-4539-9dbc-9b358714b638", "e8c8e5a1-0b8e-4a6e-9d43-1d39f7b2a0c4"], "_import_row": 7},
+		{"uuid": "b699a406-7e44-49be-9f01-1a82893e8a10", "fields": {"age": "34"}, "_import_row": 8}
+	]`))
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	result, err := models.DryRunContactImport(ctx, db, oa, importID)
+	require.NoError(t, err)
+
+	assert.Equal(t, 7, result.NumRecords)
+	assert.Equal(t, 2, result.NumCreated)
+	assert.Equal(t, 3, result.NumUpdated)
+	assert.Equal(t, 2, result.NumErrored)
+	assert.Equal(t, map[models.ImportErrorType]int{
+		models.ImportErrorTypeInvalidLanguage:   1,
+		models.ImportErrorTypeContactNotFound:   1,
+		models.ImportErrorTypeURNConflict:       1,
+		models.ImportErrorTypeInvalidFieldValue: 1,
+		models.ImportErrorTypeInvalidField:      1,
+		models.ImportErrorTypeInvalidGroup:      1,
+	}, result.ErrorCounts)
+	assert.Equal(t, []*models.ImportDryRunError{
+		{Record: 1, Row: 3, Type: models.ImportErrorTypeInvalidLanguage, Message: "'xxx' is not a valid language code"},
+		{Record: 3, Row: 5, Type: models.ImportErrorTypeContactNotFound, Message: "Unable to find contact with UUID 'f2a6d1a7-3c29-4e3a-8d6b-4e2a1c9d0b5f'"},
+		{Record: 4, Row: 6, Type: models.ImportErrorTypeURNConflict, Message: "Unable to find or create contact with URNs tel:+16055741111, tel:+16055742222"},
+		{Record: 5, Row: 7, Type: models.ImportErrorTypeInvalidFieldValue, Message: "'old' is not a valid number value for field 'age'"},
+		{Record: 5, Row: 7, Type: models.ImportErrorTypeInvalidField, Message: "'goats' is not a valid contact field key"},
+		{Record: 5, Row: 7, Type: models.ImportErrorTypeInvalidGroup, Message: "'e8c8e5a1-0b8e-4a6e-9d43-1d39f7b2a0c4' is not a valid contact group UUID"},
+	}, result.Errors)
+
+	// nothing has been written
+	assertdb.Query(t, db, `SELECT count(*) FROM contacts_contact WHERE id >= 30000`).Returns(0)
+	assertdb.Query(t, db, `SELECT name FROM contacts_contact WHERE id = $1`, testdata.Cathy.ID).Returns("Cathy")
+}
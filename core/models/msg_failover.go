@@ -0,0 +1,332 @@
+package models
+
+import (
+	"context"
+
+	"github.com/lib/pq"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/gocommon/gsm7"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/null"
+	"github.com/pkg/errors"
+)
+
+const configMsgFailover = "msg_failover"
+
+// metadata keys used to link failed messages and their replacements
+const (
+	msgMetadataFailoverOf   = "failover_of"
+	msgMetadataFailoverURNs = "failover_urns"
+	msgMetadataFailedOverTo = "failed_over_to"
+)
+
+// MsgFailover is a channel's policy for failing over outgoing messages which fail permanently on that channel to the
+// contact's next best URN and channel, e.g.
+//
+//	{"schemes": ["tel", "whatsapp"]}
+//
+// If schemes are set, only URNs with those schemes are considered.
+type MsgFailover struct {
+	Schemes []string `json:"schemes"`
+}
+
+func (f *MsgFailover) allowsScheme(scheme string) bool {
+	if len(f.Schemes) == 0 {
+		return true
+	}
+	for _, s := range f.Schemes {
+		if s == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+// MsgFailover returns the failover policy for this channel if it has one
+func (c *Channel) MsgFailover() *MsgFailover {
+	config := null.NewMap(c.c.Config)
+	failover := &MsgFailover{}
+	if readConfigValue(&config, configMsgFailover, failover) {
+		return failover
+	}
+	return nil
+}
+
+// FailedOverTo returns the UUID of the message which replaced this failed message, if it was failed over
+func (m *Msg) FailedOverTo() flows.MsgUUID {
+	uuid, _ := m.m.Metadata.Map()[msgMetadataFailedOverTo].(string)
+	return flows.MsgUUID(uuid)
+}
+
+// FailoverOf returns the UUID of the failed message which this message replaced, if it's a failover
+func (m *Msg) FailoverOf() flows.MsgUUID {
+	uuid, _ := m.m.Metadata.Map()[msgMetadataFailoverOf].(string)
+	return flows.MsgUUID(uuid)
+}
+
+// selects the active channels which have a failover policy
+const sqlSelectChannelIDsForFailover = `
+SELECT id FROM channels_channel WHERE is_active = TRUE AND NULLIF(config, '')::jsonb ? 'msg_failover'`
+
+// selects messages which were failed by courier (rather than by mailroom for reasons that another channel won't fix)
+// in the last day, on the given channels, which haven't yet been checked for failover
+const sqlSelectMessagesForFailover = `
+SELECT
+	m.id,
+	m.broadcast_id,
+	m.uuid,
+	m.text,
+	m.created_on,
+	m.direction,
+	m.status,
+	m.visibility,
+	m.msg_type,
+	m.msg_count,
+	m.error_count,
+	m.next_attempt,
+	m.failed_reason,
+	m.high_priority,
+	m.external_id,
+	m.attachments,
+	m.metadata,
+	m.channel_id,
+	m.contact_id,
+	m.contact_urn_id,
+	m.flow_id,
+	m.org_id,
+	u.identity AS "urn_urn",
+	u.auth AS "urn_auth"
+FROM
+	msgs_msg m
+INNER JOIN
+	contacts_contacturn u ON u.id = m.contact_urn_id
+WHERE
+	m.channel_id = ANY($1) AND m.direction = 'O' AND m.status = 'F' AND (m.failed_reason IS NULL OR m.failed_reason = 'E') AND
+	m.modified_on > NOW() - INTERVAL '1 day' AND
+	NOT COALESCE(NULLIF(m.metadata, '')::jsonb ? 'failed_over_to', FALSE)
+ORDER BY
+    m.modified_on ASC
+LIMIT 1000`
+
+// GetMessagesForFailover gets recently failed outgoing messages on channels with a failover policy, which haven't yet
+// been failed over. Channels with a policy are looked up first so that only their messages are read.
+func GetMessagesForFailover(ctx context.Context, db Queryer) ([]*Msg, error) {
+	rows, err := db.QueryxContext(ctx, sqlSelectChannelIDsForFailover)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying channels with failover policies")
+	}
+	defer rows.Close()
+
+	channelIDs := make([]ChannelID, 0, 5)
+	for rows.Next() {
+		var channelID ChannelID
+		if err := rows.Scan(&channelID); err != nil {
+			return nil, errors.Wrap(err, "error scanning channel id")
+		}
+		channelIDs = append(channelIDs, channelID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error reading channels with failover policies")
+	}
+
+	if len(channelIDs) == 0 {
+		return nil, nil
+	}
+
+	return loadMessages(ctx, db, sqlSelectMessagesForFailover, pq.Array(channelIDs))
+}
+
+const sqlUpdateMsgFailedOver = `
+UPDATE msgs_msg m
+   SET metadata = r.metadata, modified_on = NOW()
+  FROM (VALUES(:id, :metadata)) AS r(id, metadata)
+ WHERE m.id = r.id::bigint`
+
+// FailoverMessages creates a replacement for each of the given failed messages on the contact's next best URN and
+// channel, linking each failed message and its replacement so they can be counted as a single logical send. Failed
+// messages for which there is no other destination are marked so that they aren't considered again. Returns the
+// replacements which should be sent.
+//
+// Replacements are intentionally not created via newOutgoingMsg and so aren't subject to the org's send window or
+// frequency cap. The failed message already passed both checks and its replacement is the same logical send, so
+// holding it back or counting it again would penalize the contact for a channel failure.
+func FailoverMessages(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets, msgs []*Msg) ([]*Msg, error) {
+	contactIDs := make([]ContactID, 0, len(msgs))
+	for _, msg := range msgs {
+		contactIDs = append(contactIDs, msg.ContactID())
+	}
+
+	contacts, err := LoadContacts(ctx, rt.DB, oa, contactIDs)
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading contacts for failover")
+	}
+
+	contactsByID := make(map[ContactID]*Contact, len(contacts))
+	for _, c := range contacts {
+		contactsByID[c.ID()] = c
+	}
+
+	replacements := make([]*Msg, 0, len(msgs))
+	updates := make([]interface{}, len(msgs))
+
+	for i, msg := range msgs {
+		var replacement *Msg
+
+		contact := contactsByID[msg.ContactID()]
+		if contact != nil && contact.Status() == ContactStatusActive && !oa.Org().Suspended() {
+			replacement = newFailoverMsg(oa, contact, msg)
+		}
+
+		// a nil value records that we tried to fail over this message but there was nowhere to send it
+		if replacement != nil {
+			msg.m.Metadata.Map()[msgMetadataFailedOverTo] = string(replacement.UUID())
+			replacements = append(replacements, replacement)
+		} else {
+			msg.m.Metadata.Map()[msgMetadataFailedOverTo] = nil
+		}
+		updates[i] = &msg.m
+	}
+
+	tx, err := rt.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error starting transaction")
+	}
+
+	if len(replacements) > 0 {
+		if err := InsertMessages(ctx, tx, replacements); err != nil {
+			tx.Rollback()
+			return nil, errors.Wrap(err, "error inserting failover messages")
+		}
+	}
+
+	if err := BulkQuery(ctx, "updating failed over messages", tx, sqlUpdateMsgFailedOver, updates); err != nil {
+		tx.Rollback()
+		return nil, errors.Wrap(err, "error updating failed over messages")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "error committing failover messages")
+	}
+
+	return replacements, nil
+}
+
+// creates a replacement for the given failed message on the contact's next best URN and channel, or returns nil if
+// the contact has no other URN that we can send to
+func newFailoverMsg(oa *OrgAssets, contact *Contact, failed *Msg) *Msg {
+	policy := failed.Channel().MsgFailover()
+	if policy == nil || failed.ContactURNID() == nil {
+		return nil
+	}
+
+	// URNs already tried by previous messages in this chain of failovers, and the URN this message failed on
+	tried := failoverURNs(failed)
+	tried = append(tried, *failed.ContactURNID())
+
+	isTried := func(id URNID) bool {
+		for _, t := range tried {
+			if t == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	// contact URNs are in priority order so walk them from the URN that failed onwards
+	contactURNs := contact.URNs()
+	for i, u := range contactURNs {
+		if GetURNID(u) == *failed.ContactURNID() {
+			contactURNs = contactURNs[i+1:]
+			break
+		}
+	}
+
+	channels := oa.SessionAssets().Channels()
+
+	for _, u := range contactURNs {
+		if isTried(GetURNID(u)) || !policy.allowsScheme(u.Scheme()) {
+			continue
+		}
+
+		contactURN, err := flows.ParseRawURN(channels, u, assets.IgnoreMissing)
+		if err != nil {
+			continue
+		}
+
+		ch := channels.GetForURN(contactURN, assets.ChannelRoleSend)
+		if ch == nil {
+			continue
+		}
+
+		channel := oa.ChannelByUUID(ch.UUID())
+		if channel == nil || channel.ID() == failed.ChannelID() {
+			continue
+		}
+
+		return newFailoverMsgOn(failed, u, channel, tried)
+	}
+
+	return nil
+}
+
+func newFailoverMsgOn(failed *Msg, urn urns.URN, channel *Channel, tried []URNID) *Msg {
+	f := &failed.m
+
+	msg := &Msg{}
+	m := &msg.m
+	m.UUID = flows.MsgUUID(uuids.New())
+	m.OrgID = f.OrgID
+	m.ContactID = f.ContactID
+	m.BroadcastID = f.BroadcastID
+	m.FlowID = f.FlowID
+	m.Text = f.Text
+	m.Attachments = f.Attachments
+	m.HighPriority = f.HighPriority
+	m.Direction = DirectionOut
+	m.Status = MsgStatusQueued
+	m.Visibility = f.Visibility
+	m.MsgType = f.MsgType
+	m.MsgCount = 1
+	m.CreatedOn = dates.Now()
+
+	// copy things like quick replies, templating and variant from the failed message
+	metadata := make(map[string]interface{}, len(f.Metadata.Map())+2)
+	for k, v := range f.Metadata.Map() {
+		if k != msgMetadataFailedOverTo {
+			metadata[k] = v
+		}
+	}
+	metadata[msgMetadataFailoverOf] = string(f.UUID)
+	metadata[msgMetadataFailoverURNs] = tried
+	m.Metadata = null.NewMap(metadata)
+
+	msg.SetChannel(channel)
+	msg.SetURN(urn)
+
+	if m.URN.Scheme() == urns.TelScheme {
+		m.MsgCount = gsm7.Segments(m.Text) + len(m.Attachments)
+	}
+
+	return msg
+}
+
+// gets the URNs tried by previous messages in the chain of failovers that led to the given message
+func failoverURNs(msg *Msg) []URNID {
+	urnIDs := make([]URNID, 0, 2)
+
+	switch ids := msg.m.Metadata.Map()[msgMetadataFailoverURNs].(type) {
+	case []URNID:
+		urnIDs = append(urnIDs, ids...)
+	case []interface{}:
+		for _, id := range ids {
+			if f, ok := id.(float64); ok {
+				urnIDs = append(urnIDs, URNID(f))
+			}
+		}
+	}
+	return urnIDs
+}
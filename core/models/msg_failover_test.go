@@ -0,0 +1,79 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMsgFailover(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	db.MustExec(`UPDATE channels_channel SET config = '{"msg_failover": {"schemes": ["twitterid"]}}' WHERE id = $1`, testdata.TwilioChannel.ID)
+
+	// Cathy has a lower priority twitter URN, Bob has a lower priority tel URN which isn't allowed by the policy
+	twitterURNID := testdata.InsertContactURN(db, testdata.Org1, testdata.Cathy, urns.URN("twitterid:123456"), 999)
+	testdata.InsertContactURN(db, testdata.Org1, testdata.Bob, urns.URN("tel:+250788123123"), 999)
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshChannels)
+	require.NoError(t, err)
+
+	channel := oa.ChannelByUUID(testdata.TwilioChannel.UUID)
+	assert.Equal(t, &models.MsgFailover{Schemes: []string{"twitterid"}}, channel.MsgFailover())
+	assert.Nil(t, oa.ChannelByUUID(testdata.VonageChannel.UUID).MsgFailover())
+
+	cathyMsg := testdata.InsertOutgoingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.Cathy, "Hi", nil, models.MsgStatusFailed, false)
+	bobMsg := testdata.InsertOutgoingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.Bob, "Hi", nil, models.MsgStatusFailed, false)
+	testdata.InsertOutgoingMsg(db, testdata.Org1, testdata.VonageChannel, testdata.George, "Hi", nil, models.MsgStatusFailed, false)
+	testdata.InsertOutgoingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.George, "Hi", nil, models.MsgStatusDelivered, false)
+	georgeMsg := testdata.InsertOutgoingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.George, "Hi", nil, models.MsgStatusFailed, false)
+
+	// messages failed by mailroom for reasons other channels won't fix are ignored
+	db.MustExec(`UPDATE msgs_msg SET failed_reason = 'C' WHERE id = $1`, georgeMsg.ID())
+
+	msgs, err := models.GetMessagesForFailover(ctx, db)
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, cathyMsg.ID(), msgs[0].ID())
+	assert.Equal(t, bobMsg.ID(), msgs[1].ID())
+
+	replacements, err := models.FailoverMessages(ctx, rt, oa, msgs)
+	require.NoError(t, err)
+	require.Len(t, replacements, 1)
+
+	replacement := replacements[0]
+	assert.Equal(t, testdata.TwitterChannel.ID, replacement.ChannelID())
+	assert.Equal(t, twitterURNID, *replacement.ContactURNID())
+	assert.Equal(t, models.MsgStatusQueued, replacement.Status())
+	assert.Equal(t, "Hi", replacement.Text())
+	assert.Equal(t, cathyMsg.UUID(), replacement.FailoverOf())
+	assert.Equal(t, replacement.UUID(), msgs[0].FailedOverTo())
+
+	// Bob had nowhere else to go
+	assert.Equal(t, "", string(msgs[1].FailedOverTo()))
+
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE uuid = $1 AND status = 'Q' AND channel_id = $2`, replacement.UUID(), testdata.TwitterChannel.ID).Returns(1)
+	assertdb.Query(t, db, `SELECT metadata::jsonb->>'failed_over_to' FROM msgs_msg WHERE id = $1`, cathyMsg.ID()).Returns(string(replacement.UUID()))
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE id = $1 AND metadata::jsonb ? 'failed_over_to'`, bobMsg.ID()).Returns(1)
+
+	// and neither is considered again
+	msgs, err = models.GetMessagesForFailover(ctx, db)
+	require.NoError(t, err)
+	assert.Len(t, msgs, 0)
+
+	// messages aren't considered at all if no channel has a failover policy
+	testdata.InsertOutgoingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.Cathy, "Hi", nil, models.MsgStatusFailed, false)
+	db.MustExec(`UPDATE channels_channel SET config = '{}' WHERE id = $1`, testdata.TwilioChannel.ID)
+
+	msgs, err = models.GetMessagesForFailover(ctx, db)
+	require.NoError(t, err)
+	assert.Len(t, msgs, 0)
+}
@@ -0,0 +1,184 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	configMsgFrequencyCap = "msg_frequency_cap"
+
+	msgFrequencyKey        = "msg_frequency:%d"
+	msgFrequencyPendingKey = "msg_frequency_pending:%d"
+	msgFrequencyPendingTTL = 5 * time.Minute
+	msgFrequencyCappedKey  = "msg_frequency_capped:%d:%s"
+	msgFrequencyCappedTTL  = 60 * 60 * 24 * 7
+)
+
+// FrequencyCapPolicy is what happens to messages over a frequency cap
+type FrequencyCapPolicy string
+
+// frequency cap policies
+const (
+	FrequencyCapPolicyFail  = FrequencyCapPolicy("fail")
+	FrequencyCapPolicyDefer = FrequencyCapPolicy("defer")
+)
+
+// MsgFrequencyCap limits how many non-urgent messages a contact can be sent in a sliding window, e.g.
+//
+//	{"limit": 3, "window_hours": 24, "policy": "defer"}
+//
+// Messages over the cap are failed, or if the policy is defer, held until the contact is back under the cap. Replies
+// to incoming messages are neither limited nor counted.
+type MsgFrequencyCap struct {
+	Limit       int                `json:"limit"`
+	WindowHours int                `json:"window_hours"`
+	Policy      FrequencyCapPolicy `json:"policy"`
+}
+
+// Window returns the duration of the sliding window of this cap
+func (c *MsgFrequencyCap) Window() time.Duration {
+	return time.Duration(c.WindowHours) * time.Hour
+}
+
+// MsgFrequencyCap returns the outgoing message frequency cap for this org if it has one
+func (o *Org) MsgFrequencyCap() *MsgFrequencyCap {
+	fc := &MsgFrequencyCap{}
+	if readConfigValue(&o.o.Config, configMsgFrequencyCap, fc) && fc.Limit > 0 && fc.WindowHours > 0 {
+		return fc
+	}
+	return nil
+}
+
+// times are in milliseconds, and a negative result means the message is over the cap and wasn't deferred. Slots taken
+// here are pending until confirmed, and are released if they aren't confirmed before their pending TTL, e.g. because the
+// message was never committed.
+var msgFrequencyScript = redis.NewScript(2, `
+local key, pendingKey = KEYS[1], KEYS[2]
+local now, target, window, limit, member, policy, pendingTTL = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4]), ARGV[5], ARGV[6], tonumber(ARGV[7])
+
+-- release any slots whose messages were never committed
+local abandoned = redis.call("ZRANGEBYSCORE", pendingKey, "-inf", now)
+for _, m in ipairs(abandoned) do
+	redis.call("ZREM", key, m)
+end
+redis.call("ZREMRANGEBYSCORE", pendingKey, "-inf", now)
+
+-- drop anything which has really left the window, then count what would be in the window at the target time,
+-- including anything deferred until later
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now - window)
+local scores = redis.call("ZRANGEBYSCORE", key, "(" .. (target - window), "+inf", "WITHSCORES")
+local count = #scores / 2
+
+local at = target
+if count >= limit then
+	if policy ~= "defer" then
+		return -1
+	end
+
+	-- we can send once enough of the earlier messages have left the window
+	local freeing = tonumber(scores[(count - limit + 1) * 2])
+	at = math.max(target, freeing + window)
+end
+
+redis.call("ZADD", key, at, member)
+redis.call("PEXPIRE", key, at - now + window)
+redis.call("ZADD", pendingKey, now + pendingTTL, member)
+redis.call("PEXPIRE", pendingKey, pendingTTL)
+return at
+`)
+
+// checks the given message against the org's frequency cap, failing or deferring it if it's over the cap
+func applyFrequencyCap(rp *redis.Pool, msg *Msg, org *Org) error {
+	fc := org.MsgFrequencyCap()
+	if fc == nil {
+		return nil
+	}
+
+	m := &msg.m
+
+	// messages held for their send window are counted from when they'll be sent
+	sendOn := m.CreatedOn
+	if m.NextAttempt != nil {
+		sendOn = *m.NextAttempt
+	}
+
+	rc := rp.Get()
+	defer rc.Close()
+
+	at, err := redis.Int64(msgFrequencyScript.Do(rc,
+		fmt.Sprintf(msgFrequencyKey, m.ContactID), fmt.Sprintf(msgFrequencyPendingKey, m.ContactID),
+		m.CreatedOn.UnixMilli(), sendOn.UnixMilli(), fc.Window().Milliseconds(), fc.Limit, string(m.UUID), string(fc.Policy), msgFrequencyPendingTTL.Milliseconds(),
+	))
+	if err != nil {
+		return errors.Wrapf(err, "error checking msg frequency for contact: %d", m.ContactID)
+	}
+
+	if at < 0 {
+		m.Status = MsgStatusFailed
+		m.FailedReason = MsgFailedFrequencyCap
+		m.NextAttempt = nil
+
+		logrus.WithFields(logrus.Fields{"contact_id": m.ContactID, "limit": fc.Limit, "window_hours": fc.WindowHours}).Debug("msg over frequency cap, failing")
+
+		return recordFrequencyCapped(rc, org.ID(), m.CreatedOn, "failed")
+	}
+
+	msg.frequencySlotPending = true
+
+	if at > sendOn.UnixMilli() {
+		next := time.UnixMilli(at).In(sendOn.Location())
+		m.Status = MsgStatusPending
+		m.NextAttempt = &next
+
+		return recordFrequencyCapped(rc, org.ID(), m.CreatedOn, "deferred")
+	}
+
+	return nil
+}
+
+// ConfirmFrequencyCapSlots confirms the frequency cap slots taken by the given messages once they have been committed,
+// so that they are no longer released as abandoned
+func ConfirmFrequencyCapSlots(rp *redis.Pool, msgs []*Msg) error {
+	rc := rp.Get()
+	defer rc.Close()
+
+	for _, msg := range msgs {
+		if msg.frequencySlotPending {
+			rc.Send("zrem", fmt.Sprintf(msgFrequencyPendingKey, msg.m.ContactID), string(msg.m.UUID))
+		}
+	}
+	if _, err := rc.Do(""); err != nil {
+		return errors.Wrapf(err, "error confirming msg frequency cap slots")
+	}
+
+	for _, msg := range msgs {
+		msg.frequencySlotPending = false
+	}
+	return nil
+}
+
+func recordFrequencyCapped(rc redis.Conn, orgID OrgID, on time.Time, action string) error {
+	key := fmt.Sprintf(msgFrequencyCappedKey, orgID, on.UTC().Format("2006-01-02"))
+
+	rc.Send("multi")
+	rc.Send("hincrby", key, action, 1)
+	rc.Send("expire", key, msgFrequencyCappedTTL)
+	_, err := rc.Do("exec")
+
+	return errors.Wrapf(err, "error recording frequency capped msg for org: %d", orgID)
+}
+
+// GetMsgFrequencyCappedCounts gets the number of messages which were failed or deferred by the frequency cap for the
+// given org on the given (UTC) day, keyed by action
+func GetMsgFrequencyCappedCounts(rc redis.Conn, orgID OrgID, day time.Time) (map[string]int, error) {
+	counts, err := redis.IntMap(rc.Do("hgetall", fmt.Sprintf(msgFrequencyCappedKey, orgID, day.UTC().Format("2006-01-02"))))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting frequency capped counts for org: %d", orgID)
+	}
+	return counts, nil
+}
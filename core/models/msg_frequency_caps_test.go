@@ -0,0 +1,135 @@
+package models_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/nyaruka/null"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMsgFrequencyCap(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	db.MustExec(`UPDATE orgs_org SET config = '{"msg_frequency_cap": {"limit": 2, "window_hours": 24, "policy": "fail"}}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshOrg)
+	require.NoError(t, err)
+
+	assert.Equal(t, &models.MsgFrequencyCap{Limit: 2, WindowHours: 24, Policy: models.FrequencyCapPolicyFail}, oa.Org().MsgFrequencyCap())
+
+	channel := oa.ChannelByUUID(testdata.TwilioChannel.UUID)
+	flow, _ := oa.FlowByID(testdata.Favorites.ID)
+	session := insertTestSession(t, ctx, rt, testdata.Org1, testdata.Cathy, testdata.Favorites)
+
+	newUncommitted := func(contact *testdata.Contact, session *models.Session, createdOn time.Time) *models.Msg {
+		flowMsg := flows.NewMsgOut(urns.URN(fmt.Sprintf("tel:+16055741111?id=%d", contact.URNID)), assets.NewChannelReference(testdata.TwilioChannel.UUID, "Twilio"), "Hi", nil, nil, nil, flows.NilMsgTopic, envs.NilLocale, flows.NilUnsendableReason)
+		msg, err := models.NewOutgoingFlowMsg(rt, oa.Org(), channel, session, flow, flowMsg, createdOn)
+		require.NoError(t, err)
+		return msg
+	}
+	newOutgoing := func(createdOn time.Time) *models.Msg {
+		msg := newUncommitted(testdata.Cathy, session, createdOn)
+		require.NoError(t, models.ConfirmFrequencyCapSlots(rp, []*models.Msg{msg}))
+		return msg
+	}
+
+	t1 := time.Date(2022, 11, 28, 10, 0, 0, 0, time.UTC)
+
+	// first two messages are under the cap
+	assert.Equal(t, models.MsgStatusQueued, newOutgoing(t1).Status())
+	assert.Equal(t, models.MsgStatusQueued, newOutgoing(t1.Add(time.Hour)).Status())
+
+	// third is over it so fails
+	msg := newOutgoing(t1.Add(2 * time.Hour))
+	assert.Equal(t, models.MsgStatusFailed, msg.Status())
+	assert.Equal(t, models.MsgFailedFrequencyCap, msg.FailedReason())
+
+	// replies to incoming messages aren't capped
+	session.SetIncomingMsg(models.MsgID(123425), null.NullString)
+	assert.Equal(t, models.MsgStatusQueued, newOutgoing(t1.Add(2*time.Hour)).Status())
+	session.SetIncomingMsg(models.NilMsgID, null.NullString)
+
+	// once the first message has left the window, we can send again
+	assert.Equal(t, models.MsgStatusQueued, newOutgoing(t1.Add(25*time.Hour)).Status())
+
+	// switch to deferring messages over the cap
+	db.MustExec(`UPDATE orgs_org SET config = '{"msg_frequency_cap": {"limit": 2, "window_hours": 24, "policy": "defer"}}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+	oa, err = models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshOrg)
+	require.NoError(t, err)
+
+	// window now has a message at t1+25h so we can send one more
+	assert.Equal(t, models.MsgStatusQueued, newOutgoing(t1.Add(26*time.Hour)).Status())
+
+	// but the next is deferred until the first of those leaves the window
+	msg = newOutgoing(t1.Add(26 * time.Hour))
+	assert.Equal(t, models.MsgStatusPending, msg.Status())
+	assert.Equal(t, t1.Add(49*time.Hour), msg.NextAttempt().UTC())
+	assert.True(t, msg.IsHeld())
+
+	// and the one after that until the second one does
+	msg = newOutgoing(t1.Add(26 * time.Hour))
+	assert.Equal(t, models.MsgStatusPending, msg.Status())
+	assert.Equal(t, t1.Add(50*time.Hour), msg.NextAttempt().UTC())
+
+	// slots taken by messages which are never committed are released once they're no longer pending
+	bobSession := insertTestSession(t, ctx, rt, testdata.Org1, testdata.Bob, testdata.Favorites)
+	t2 := t1.Add(26 * time.Hour)
+
+	assert.Equal(t, models.MsgStatusQueued, newUncommitted(testdata.Bob, bobSession, t2).Status())
+	assert.Equal(t, models.MsgStatusQueued, newUncommitted(testdata.Bob, bobSession, t2).Status())
+	assert.Equal(t, models.MsgStatusPending, newUncommitted(testdata.Bob, bobSession, t2.Add(time.Minute)).Status())
+	assert.Equal(t, models.MsgStatusQueued, newUncommitted(testdata.Bob, bobSession, t2.Add(10*time.Minute)).Status())
+
+	counts, err := models.GetMsgFrequencyCappedCounts(rc, testdata.Org1.ID, t1)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"failed": 1}, counts)
+
+	counts, err = models.GetMsgFrequencyCappedCounts(rc, testdata.Org1.ID, t1.Add(26*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"deferred": 3}, counts)
+}
+
+func TestMsgFrequencyCapBroadcasts(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	db.MustExec(`UPDATE orgs_org SET config = '{"msg_frequency_cap": {"limit": 1, "window_hours": 24, "policy": "fail"}}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshOrg)
+	require.NoError(t, err)
+
+	bcastID := testdata.InsertBroadcast(db, testdata.Org1, "eng", map[envs.Language]string{"eng": "Blast"}, models.NilScheduleID, []*testdata.Contact{testdata.Cathy}, nil)
+	bcast := models.NewBroadcast(testdata.Org1.ID, bcastID, map[envs.Language]*models.BroadcastTranslation{"eng": {Text: "Blast"}}, models.TemplateStateEvaluated, "eng", nil, []models.ContactID{testdata.Cathy.ID}, nil, models.NilTicketID, models.NilUserID)
+
+	msgs, err := bcast.CreateBatch([]models.ContactID{testdata.Cathy.ID}).CreateMessages(ctx, rt, oa)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(msgs))
+	assert.Equal(t, models.MsgStatusQueued, msgs[0].Status())
+
+	// the broadcast message's slot was confirmed so it isn't released once the pending TTL has passed
+	channel := oa.ChannelByUUID(testdata.TwilioChannel.UUID)
+	flow, _ := oa.FlowByID(testdata.Favorites.ID)
+	session := insertTestSession(t, ctx, rt, testdata.Org1, testdata.Cathy, testdata.Favorites)
+
+	flowMsg := flows.NewMsgOut(urns.URN(fmt.Sprintf("tel:+16055741111?id=%d", testdata.Cathy.URNID)), assets.NewChannelReference(testdata.TwilioChannel.UUID, "Twilio"), "Hi", nil, nil, nil, flows.NilMsgTopic, envs.NilLocale, flows.NilUnsendableReason)
+	msg, err := models.NewOutgoingFlowMsg(rt, oa.Org(), channel, session, flow, flowMsg, time.Now().Add(10*time.Minute))
+	require.NoError(t, err)
+
+	assert.Equal(t, models.MsgStatusFailed, msg.Status())
+	assert.Equal(t, models.MsgFailedFrequencyCap, msg.FailedReason())
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"unicode/utf8"
+
+	"github.com/nyaruka/gocommon/gsm7"
+	"github.com/nyaruka/null"
+)
+
+const configMsgSegmentPrice = "msg_segment_price"
+
+// MsgEncoding is the encoding that an SMS will be sent with
+type MsgEncoding string
+
+// encodings of SMS messages
+const (
+	MsgEncodingGSM7 = MsgEncoding("gsm7")
+	MsgEncodingUCS2 = MsgEncoding("ucs2")
+)
+
+// UnicodeChar is a character which forces a message to be sent as UCS-2, and its position in the message's text
+type UnicodeChar struct {
+	Char     string `json:"char"`
+	Position int    `json:"position"`
+}
+
+// SegmentEstimate is an estimate of how a message's text will be split into segments when sent as SMS. If the text
+// requires UCS-2 but substituting look-alike characters (e.g. curly quotes) would allow GSM-7, the substituted text
+// and its segment count are included.
+type SegmentEstimate struct {
+	Text         string        `json:"text"`
+	Encoding     MsgEncoding   `json:"encoding"`
+	Characters   int           `json:"characters"`
+	Segments     int           `json:"segments"`
+	UnicodeChars []UnicodeChar `json:"unicode_chars"`
+	GSM7Text     string        `json:"gsm7_text,omitempty"`
+	GSM7Segments int           `json:"gsm7_segments,omitempty"`
+}
+
+// EstimateSegments estimates how the given text will be segmented when sent as SMS
+func EstimateSegments(text string) *SegmentEstimate {
+	e := &SegmentEstimate{
+		Text:         text,
+		Encoding:     MsgEncodingGSM7,
+		Characters:   utf8.RuneCountInString(text),
+		Segments:     gsm7.Segments(text),
+		UnicodeChars: []UnicodeChar{},
+	}
+
+	if gsm7.IsValid(text) {
+		return e
+	}
+
+	e.Encoding = MsgEncodingUCS2
+
+	position := 0
+	for _, r := range text {
+		if !gsm7.IsValid(string(r)) {
+			e.UnicodeChars = append(e.UnicodeChars, UnicodeChar{Char: string(r), Position: position})
+		}
+		position++
+	}
+
+	if substituted := gsm7.ReplaceSubstitutions(text); gsm7.IsValid(substituted) {
+		e.GSM7Text = substituted
+		e.GSM7Segments = gsm7.Segments(substituted)
+	}
+
+	return e
+}
+
+// SegmentPrice is the price of sending a single SMS segment on a channel, e.g.
+//
+//	{"amount": 0.0075, "currency": "USD"}
+type SegmentPrice struct {
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+// MsgSegmentPrice returns the price per SMS segment on this channel if it has one
+func (c *Channel) MsgSegmentPrice() *SegmentPrice {
+	config := null.NewMap(c.c.Config)
+	price := &SegmentPrice{}
+	if readConfigValue(&config, configMsgSegmentPrice, price) {
+		return price
+	}
+	return nil
+}
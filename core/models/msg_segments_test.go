@@ -0,0 +1,69 @@
+package models_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateSegments(t *testing.T) {
+	tcs := []struct {
+		text     string
+		expected *models.SegmentEstimate
+	}{
+		{
+			text:     "",
+			expected: &models.SegmentEstimate{Encoding: models.MsgEncodingGSM7, Characters: 0, Segments: 1, UnicodeChars: []models.UnicodeChar{}},
+		},
+		{
+			text:     "Hi there",
+			expected: &models.SegmentEstimate{Text: "Hi there", Encoding: models.MsgEncodingGSM7, Characters: 8, Segments: 1, UnicodeChars: []models.UnicodeChar{}},
+		},
+		{
+			text:     strings.Repeat("x", 161),
+			expected: &models.SegmentEstimate{Text: strings.Repeat("x", 161), Encoding: models.MsgEncodingGSM7, Characters: 161, Segments: 2, UnicodeChars: []models.UnicodeChar{}},
+		},
+		{
+			// a stray curly quote forces UCS-2, but can be substituted
+			text: "Don’t " + strings.Repeat("x", 64),
+			expected: &models.SegmentEstimate{
+				Text:         "Don’t " + strings.Repeat("x", 64),
+				Encoding:     models.MsgEncodingUCS2,
+				Characters:   70,
+				Segments:     1,
+				UnicodeChars: []models.UnicodeChar{{Char: "’", Position: 3}},
+				GSM7Text:     "Don't " + strings.Repeat("x", 64),
+				GSM7Segments: 1,
+			},
+		},
+		{
+			text: "Don’t " + strings.Repeat("x", 65),
+			expected: &models.SegmentEstimate{
+				Text:         "Don’t " + strings.Repeat("x", 65),
+				Encoding:     models.MsgEncodingUCS2,
+				Characters:   71,
+				Segments:     2,
+				UnicodeChars: []models.UnicodeChar{{Char: "’", Position: 3}},
+				GSM7Text:     "Don't " + strings.Repeat("x", 65),
+				GSM7Segments: 1,
+			},
+		},
+		{
+			// but emoji can't be
+			text: "Hi 😀 there 😀",
+			expected: &models.SegmentEstimate{
+				Text:         "Hi 😀 there 😀",
+				Encoding:     models.MsgEncodingUCS2,
+				Characters:   12,
+				Segments:     1,
+				UnicodeChars: []models.UnicodeChar{{Char: "😀", Position: 3}, {Char: "😀", Position: 11}},
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.expected, models.EstimateSegments(tc.text), "estimate mismatch for text: %s", tc.text)
+	}
+}
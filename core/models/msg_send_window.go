@@ -0,0 +1,88 @@
+package models
+
+import (
+	"time"
+
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/null"
+	"github.com/sirupsen/logrus"
+)
+
+const configMsgSendWindow = "msg_send_window"
+
+// SendWindow is a window of local time in which non-urgent outgoing messages can be sent, e.g.
+//
+//	{"start": "08:00", "end": "20:00", "days_of_week": "MTWRF", "timezone_field": "timezone"}
+//
+// If a timezone field is set and a contact has a valid timezone name as the value of that field, the window is applied
+// in the contact's timezone rather than the org's.
+type SendWindow struct {
+	CallWindow
+	TimezoneField string `json:"timezone_field"`
+}
+
+// Timezone returns the timezone in which this window applies for the given contact
+func (w *SendWindow) Timezone(contact *flows.Contact, def *time.Location) *time.Location {
+	if w.TimezoneField != "" {
+		if value := contact.Fields()[w.TimezoneField]; value != nil {
+			if tz, err := time.LoadLocation(value.Text.Native()); err == nil {
+				return tz
+			}
+		}
+	}
+	if contact.Timezone() != nil {
+		return contact.Timezone()
+	}
+	return def
+}
+
+// MsgSendWindow returns the window in which non-urgent messages can be sent for this org if it has one
+func (o *Org) MsgSendWindow() *SendWindow {
+	window := &SendWindow{}
+	if readConfigValue(&o.o.Config, configMsgSendWindow, window) {
+		return window
+	}
+	return nil
+}
+
+// MsgSendWindow returns the window in which non-urgent messages can be sent on this channel, falling back to the org's
+// window
+func (c *Channel) MsgSendWindow(org *Org) *SendWindow {
+	config := null.NewMap(c.c.Config)
+	window := &SendWindow{}
+	if readConfigValue(&config, configMsgSendWindow, window) {
+		return window
+	}
+	return org.MsgSendWindow()
+}
+
+// holds the given non-urgent message as pending until the next time it can be sent, if it's being created outside of
+// the send window for its channel or org
+func holdOutsideSendWindow(msg *Msg, org *Org, channel *Channel, contact *flows.Contact) {
+	// messages without a channel can't be sent anyway
+	if channel == nil {
+		return
+	}
+
+	window := channel.MsgSendWindow(org)
+	if window == nil {
+		return
+	}
+
+	m := &msg.m
+	next, err := window.NextAllowed(m.CreatedOn, window.Timezone(contact, org.Timezone()))
+	if err != nil {
+		logrus.WithError(err).WithField("org_id", org.ID()).Error("invalid message send window, ignoring")
+		return
+	}
+
+	if next.After(m.CreatedOn) {
+		m.Status = MsgStatusPending
+		m.NextAttempt = &next
+	}
+}
+
+// IsHeld returns whether this is an outgoing message being held until its next attempt
+func (m *Msg) IsHeld() bool {
+	return m.m.Direction == DirectionOut && m.m.Status == MsgStatusPending && m.m.NextAttempt != nil
+}
@@ -0,0 +1,74 @@
+package models_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/nyaruka/null"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMsgSendWindow(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	kgl, _ := time.LoadLocation("Africa/Kigali")
+
+	// Cathy's gender field is (ab)used to hold her timezone
+	db.MustExec(`UPDATE orgs_org SET config = '{"msg_send_window": {"start": "08:00", "end": "19:00", "timezone_field": "gender"}}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+	db.MustExec(`UPDATE contacts_contact SET fields = '{"3a5891e4-756e-4dc9-8e12-b7a766168824": {"text": "Africa/Kigali"}}' WHERE id = $1`, testdata.Cathy.ID)
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshOrg)
+	require.NoError(t, err)
+
+	window := oa.Org().MsgSendWindow()
+	require.NotNil(t, window)
+	assert.Equal(t, "08:00", window.Start)
+	assert.Equal(t, "gender", window.TimezoneField)
+
+	_, cathy := testdata.Cathy.Load(db, oa)
+	_, bob := testdata.Bob.Load(db, oa)
+	assert.Equal(t, kgl, window.Timezone(cathy, time.UTC))
+	assert.Equal(t, time.UTC, window.Timezone(bob, time.UTC))
+
+	channel := oa.ChannelByUUID(testdata.TwilioChannel.UUID)
+	assert.Equal(t, window, channel.MsgSendWindow(oa.Org()))
+
+	flow, _ := oa.FlowByID(testdata.Favorites.ID)
+	session := insertTestSession(t, ctx, rt, testdata.Org1, testdata.Cathy, testdata.Favorites)
+
+	newOutgoing := func(createdOn time.Time) *models.Msg {
+		flowMsg := flows.NewMsgOut(urns.URN(fmt.Sprintf("tel:+16055741111?id=%d", testdata.Cathy.URNID)), assets.NewChannelReference(testdata.TwilioChannel.UUID, "Twilio"), "Hi", nil, nil, nil, flows.NilMsgTopic, envs.NilLocale, flows.NilUnsendableReason)
+		msg, err := models.NewOutgoingFlowMsg(rt, oa.Org(), channel, session, flow, flowMsg, createdOn)
+		require.NoError(t, err)
+		return msg
+	}
+
+	// message created within the window is queued
+	msg := newOutgoing(time.Date(2022, 11, 28, 10, 30, 0, 0, kgl))
+	assert.Equal(t, models.MsgStatusQueued, msg.Status())
+	assert.Nil(t, msg.NextAttempt())
+	assert.False(t, msg.IsHeld())
+
+	// message created before the window opens in Cathy's timezone is held until it does
+	msg = newOutgoing(time.Date(2022, 11, 28, 3, 0, 0, 0, kgl))
+	assert.Equal(t, models.MsgStatusPending, msg.Status())
+	assert.Equal(t, time.Date(2022, 11, 28, 8, 0, 0, 0, kgl), msg.NextAttempt().In(kgl))
+	assert.True(t, msg.IsHeld())
+
+	// but replies to incoming messages are sent regardless
+	session.SetIncomingMsg(models.MsgID(123425), null.NullString)
+	msg = newOutgoing(time.Date(2022, 11, 28, 3, 0, 0, 0, kgl))
+	assert.Equal(t, models.MsgStatusQueued, msg.Status())
+	assert.True(t, msg.HighPriority())
+}
@@ -84,6 +84,7 @@ const (
 	MsgFailedTooOld         = MsgFailedReason("O")
 	MsgFailedNoDestination  = MsgFailedReason("D")
 	MsgFailedChannelRemoved = MsgFailedReason("R")
+	MsgFailedFrequencyCap   = MsgFailedReason("F") // contact has been sent too many messages recently
 )
 
 var unsendableToFailedReason = map[flows.UnsendableReason]MsgFailedReason{
@@ -153,7 +154,8 @@ type Msg struct {
 		SessionTimeout       int        `json:"session_timeout,omitempty"`
 	}
 
-	channel *Channel
+	channel              *Channel
+	frequencySlotPending bool
 }
 
 func (m *Msg) ID() flows.MsgID                  { return m.m.ID }
@@ -397,6 +399,16 @@ func newOutgoingMsg(rt *runtime.Runtime, org *Org, channel *Channel, contact *fl
 		}
 	}
 
+	// hold non-urgent messages which are being created outside of the send window, and then check they don't take the
+	// contact over their frequency cap
+	if m.Status == MsgStatusQueued && !m.HighPriority {
+		holdOutsideSendWindow(msg, org, channel, contact)
+
+		if err := applyFrequencyCap(rt.RP, msg, org); err != nil {
+			return nil, errors.Wrap(err, "error applying msg frequency cap")
+		}
+	}
+
 	// if we have attachments, add them
 	if len(out.Attachments()) > 0 {
 		for _, a := range out.Attachments() {
@@ -660,6 +672,27 @@ func UpdateMessage(ctx context.Context, tx Queryer, msgID MsgID, status MsgStatu
 	return nil
 }
 
+// UpdateIVRRecording updates an incoming IVR message with the stored version of its recording, and if the message has
+// no text, the transcript of that recording
+func UpdateIVRRecording(ctx context.Context, db Queryer, msgID MsgID, recording utils.Attachment, transcript string) error {
+	_, err := db.ExecContext(ctx,
+		`UPDATE 
+			msgs_msg 
+		SET 
+			attachments = $2,
+			text = COALESCE(NULLIF(text, ''), $3),
+			modified_on = NOW()
+		WHERE
+			id = $1`,
+		msgID, pq.Array([]utils.Attachment{recording}), transcript)
+
+	if err != nil {
+		return errors.Wrapf(err, "error updating recording for msg: %d", msgID)
+	}
+
+	return nil
+}
+
 // MarkMessagesForRequeuing marks the passed in messages as pending(P) with a next attempt value
 // so that the retry messages task will pick them up.
 func MarkMessagesForRequeuing(ctx context.Context, db Queryer, msgs []*Msg) error {
@@ -708,10 +741,13 @@ type Broadcast struct {
 		URNs          []urns.URN                              `json:"urns,omitempty"`
 		ContactIDs    []ContactID                             `json:"contact_ids,omitempty"`
 		GroupIDs      []GroupID                               `json:"group_ids,omitempty"`
+		Query         null.String                             `json:"query,omitempty"`
 		OrgID         OrgID                                   `json:"org_id"                  db:"org_id"`
 		CreatedByID   UserID                                  `json:"created_by_id,omitempty" db:"created_by_id"`
 		ParentID      BroadcastID                             `json:"parent_id,omitempty"     db:"parent_id"`
 		TicketID      TicketID                                `json:"ticket_id,omitempty"     db:"ticket_id"`
+		Variants      []*BroadcastVariant                     `json:"variants,omitempty"`
+		HoldoutPct    int                                     `json:"holdout_pct,omitempty"`
 	}
 }
 
@@ -721,10 +757,26 @@ func (b *Broadcast) CreatedByID() UserID                                   { ret
 func (b *Broadcast) ContactIDs() []ContactID                               { return b.b.ContactIDs }
 func (b *Broadcast) GroupIDs() []GroupID                                   { return b.b.GroupIDs }
 func (b *Broadcast) URNs() []urns.URN                                      { return b.b.URNs }
+func (b *Broadcast) Query() string                                         { return string(b.b.Query) }
 func (b *Broadcast) BaseLanguage() envs.Language                           { return b.b.BaseLanguage }
 func (b *Broadcast) Translations() map[envs.Language]*BroadcastTranslation { return b.b.Translations }
 func (b *Broadcast) TemplateState() TemplateState                          { return b.b.TemplateState }
 func (b *Broadcast) TicketID() TicketID                                    { return b.b.TicketID }
+func (b *Broadcast) Variants() []*BroadcastVariant                         { return b.b.Variants }
+func (b *Broadcast) HoldoutPct() int                                       { return b.b.HoldoutPct }
+
+// WithQuery sets the query whose matching contacts should receive this broadcast. The query is built from the
+// broadcast's groups and contacts along with any exclusions, so when set, it replaces the explicit groups and contacts.
+func (b *Broadcast) WithQuery(query string) *Broadcast {
+	b.b.Query = null.String(query)
+	return b
+}
+
+// SetVariants sets the weighted variants of this broadcast and the percentage of contacts to hold out of it
+func (b *Broadcast) SetVariants(variants []*BroadcastVariant, holdoutPct int) {
+	b.b.Variants = variants
+	b.b.HoldoutPct = holdoutPct
+}
 
 func (b *Broadcast) MarshalJSON() ([]byte, error)    { return json.Marshal(b.b) }
 func (b *Broadcast) UnmarshalJSON(data []byte) error { return json.Unmarshal(data, &b.b) }
@@ -764,6 +816,8 @@ func InsertChildBroadcast(ctx context.Context, db Queryer, parent *Broadcast) (*
 		parent.b.CreatedByID,
 	)
 	child.b.ParentID = parent.ID()
+	child.b.Query = parent.b.Query
+	child.SetVariants(parent.b.Variants, parent.b.HoldoutPct)
 
 	// populate text from our translations
 	child.b.Text.Map = make(map[string]sql.NullString)
@@ -913,6 +967,8 @@ func (b *Broadcast) CreateBatch(contactIDs []ContactID) *BroadcastBatch {
 		CreatedByID:   b.b.CreatedByID,
 		TicketID:      b.b.TicketID,
 		ContactIDs:    contactIDs,
+		Variants:      b.b.Variants,
+		HoldoutPct:    b.b.HoldoutPct,
 	}
 }
 
@@ -928,6 +984,8 @@ type BroadcastBatch struct {
 	OrgID         OrgID                                   `json:"org_id"`
 	CreatedByID   UserID                                  `json:"created_by_id"`
 	TicketID      TicketID                                `json:"ticket_id"`
+	Variants      []*BroadcastVariant                     `json:"variants,omitempty"`
+	HoldoutPct    int                                     `json:"holdout_pct,omitempty"`
 }
 
 func (b *BroadcastBatch) CreateMessages(ctx context.Context, rt *runtime.Runtime, oa *OrgAssets) ([]*Msg, error) {
@@ -966,7 +1024,7 @@ func (b *BroadcastBatch) CreateMessages(ctx context.Context, rt *runtime.Runtime
 	msgs := make([]*Msg, 0, len(contacts))
 
 	// utility method to build up our message
-	buildMessage := func(c *Contact, forceURN urns.URN) (*Msg, error) {
+	buildMessage := func(c *Contact, forceURN urns.URN, variant *BroadcastVariant) (*Msg, error) {
 		if c.Status() != ContactStatusActive {
 			return nil, nil
 		}
@@ -1029,6 +1087,9 @@ func (b *BroadcastBatch) CreateMessages(ctx context.Context, rt *runtime.Runtime
 
 		// have a valid contact language, try that
 		trans := b.Translations
+		if variant != nil {
+			trans = variant.Translations
+		}
 		t := trans[lang]
 
 		// not found? try org default language
@@ -1090,14 +1151,35 @@ func (b *BroadcastBatch) CreateMessages(ctx context.Context, rt *runtime.Runtime
 			return nil, errors.Wrapf(err, "error creating outgoing message")
 		}
 
+		// record which variant this contact was assigned to
+		if variant != nil {
+			msg.m.Metadata.Map()["variant"] = variant.Key
+		}
+
 		return msg, nil
 	}
 
+	variantCounts := make(map[string]int, len(b.Variants)+1)
+
 	// run through all our contacts to create our messages
 	for _, c := range contacts {
+		// if this broadcast has variants, work out which one this contact gets, or whether they're held out
+		var variant *BroadcastVariant
+		if len(b.Variants) > 0 || b.HoldoutPct > 0 {
+			var heldOut bool
+			variant, heldOut = AssignBroadcastVariant(b.Variants, b.HoldoutPct, b.BroadcastID, c.UUID())
+			if heldOut {
+				variantCounts[BroadcastHoldout]++
+				continue
+			}
+			if variant != nil {
+				variantCounts[variant.Key]++
+			}
+		}
+
 		// use the preferred URN if present
 		urn := broadcastURNs[c.ID()]
-		msg, err := buildMessage(c, urn)
+		msg, err := buildMessage(c, urn, variant)
 		if err != nil {
 			return nil, errors.Wrapf(err, "error creating broadcast message")
 		}
@@ -1107,7 +1189,7 @@ func (b *BroadcastBatch) CreateMessages(ctx context.Context, rt *runtime.Runtime
 
 		// if this is a contact that will receive two messages, calculate that one as well
 		if repeatedContacts[c.ID()] {
-			m2, err := buildMessage(c, urns.NilURN)
+			m2, err := buildMessage(c, urns.NilURN, variant)
 			if err != nil {
 				return nil, errors.Wrapf(err, "error creating broadcast message")
 			}
@@ -1125,6 +1207,15 @@ func (b *BroadcastBatch) CreateMessages(ctx context.Context, rt *runtime.Runtime
 		return nil, errors.Wrapf(err, "error inserting broadcast messages")
 	}
 
+	// now that they're committed, confirm the frequency cap slots taken by these messages
+	if err := ConfirmFrequencyCapSlots(rt.RP, msgs); err != nil {
+		return nil, err
+	}
+
+	if err := recordBroadcastVariantAssignments(rt.RP, b.OrgID, b.BroadcastID, variantCounts); err != nil {
+		return nil, errors.Wrapf(err, "error recording broadcast variant assignments")
+	}
+
 	// if the broadcast was a ticket reply, update the ticket
 	if b.TicketID != NilTicketID {
 		if err := b.updateTicket(ctx, rt.DB, oa); err != nil {
@@ -1281,20 +1372,44 @@ func FailChannelMessages(ctx context.Context, db Queryer, orgID OrgID, channelID
 	return nil
 }
 
-// MarkBroadcastSent marks the passed in broadcast as sent
+// MarkBroadcastSent marks the passed in broadcast as sent, unless it has been interrupted
 func MarkBroadcastSent(ctx context.Context, db Queryer, id BroadcastID) error {
 	// noop if it is a nil id
 	if id == NilBroadcastID {
 		return nil
 	}
 
-	_, err := db.ExecContext(ctx, `UPDATE msgs_broadcast SET status = 'S', modified_on = now() WHERE id = $1`, id)
+	_, err := db.ExecContext(ctx, `UPDATE msgs_broadcast SET status = 'S', modified_on = now() WHERE id = $1 AND status != $2`, id, MsgStatusFailed)
 	if err != nil {
 		return errors.Wrapf(err, "error setting broadcast with id %d as sent", id)
 	}
 	return nil
 }
 
+// MarkBroadcastInterrupted marks the given broadcast as interrupted because it was cancelled before all its batches
+// were sent. Broadcasts share the status choices of messages which don't include interrupted, and where I means
+// initializing, so interrupted broadcasts are marked as failed.
+func MarkBroadcastInterrupted(ctx context.Context, db Queryer, id BroadcastID) error {
+	_, err := db.ExecContext(ctx, `UPDATE msgs_broadcast SET status = $2, modified_on = NOW() WHERE id = $1`, id, MsgStatusFailed)
+	if err != nil {
+		return errors.Wrapf(err, "error setting broadcast with id %d as interrupted", id)
+	}
+	return nil
+}
+
+// GetBroadcastStatus gets the status of the given broadcast, returning ErrNotFound if it doesn't exist in the given org
+func GetBroadcastStatus(ctx context.Context, db Queryer, orgID OrgID, id BroadcastID) (string, error) {
+	var status string
+	err := db.GetContext(ctx, &status, `SELECT status FROM msgs_broadcast WHERE id = $1 AND org_id = $2`, id, orgID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrNotFound
+		}
+		return "", errors.Wrapf(err, "error loading status of broadcast")
+	}
+	return status, nil
+}
+
 // NilID implementations
 
 // MarshalJSON marshals into JSON. 0 values will become null
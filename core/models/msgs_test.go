@@ -11,6 +11,7 @@ import (
 	"github.com/nyaruka/gocommon/dbutil/assertdb"
 	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/gocommon/uuids"
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/goflow/envs"
 	"github.com/nyaruka/goflow/flows"
@@ -710,6 +711,34 @@ func TestNewOutgoingIVR(t *testing.T) {
 	assertdb.Query(t, db, `SELECT text, created_on, sent_on FROM msgs_msg WHERE uuid = $1`, dbMsg.UUID()).Columns(map[string]interface{}{"text": "Hello", "created_on": createdOn, "sent_on": createdOn})
 }
 
+func TestUpdateIVRRecording(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	conn, err := models.InsertCall(ctx, db, testdata.Org1.ID, testdata.VonageChannel.ID, models.NilStartID, testdata.Cathy.ID, testdata.Cathy.URNID, models.CallDirectionOut, models.CallStatusInProgress, "")
+	require.NoError(t, err)
+
+	msgIn1 := flows.NewMsgIn(flows.MsgUUID(uuids.New()), testdata.Cathy.URN, nil, "", []utils.Attachment{"audio:http://provider.com/rec1.mp3"})
+	msgIn2 := flows.NewMsgIn(flows.MsgUUID(uuids.New()), testdata.Cathy.URN, nil, "1234", []utils.Attachment{"audio:http://provider.com/rec2.mp3"})
+	msg1 := models.NewIncomingIVR(rt.Config, testdata.Org1.ID, conn, msgIn1, time.Now())
+	msg2 := models.NewIncomingIVR(rt.Config, testdata.Org1.ID, conn, msgIn2, time.Now())
+
+	err = models.InsertMessages(ctx, db, []*models.Msg{msg1, msg2})
+	require.NoError(t, err)
+
+	// transcript is written as the text of a message with none
+	err = models.UpdateIVRRecording(ctx, db, models.MsgID(msg1.ID()), "audio/mp3:https://mailroom.io/rec1.mp3", "yes please")
+	assert.NoError(t, err)
+
+	// but doesn't replace existing text
+	err = models.UpdateIVRRecording(ctx, db, models.MsgID(msg2.ID()), "audio/mp3:https://mailroom.io/rec2.mp3", "one two three four")
+	assert.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT text, attachments[1] AS attachment FROM msgs_msg WHERE id = $1`, msg1.ID()).Columns(map[string]interface{}{"text": "yes please", "attachment": "audio/mp3:https://mailroom.io/rec1.mp3"})
+	assertdb.Query(t, db, `SELECT text, attachments[1] AS attachment FROM msgs_msg WHERE id = $1`, msg2.ID()).Columns(map[string]interface{}{"text": "1234", "attachment": "audio/mp3:https://mailroom.io/rec2.mp3"})
+}
+
 func insertTestSession(t *testing.T, ctx context.Context, rt *runtime.Runtime, org *testdata.Org, contact *testdata.Contact, flow *testdata.Flow) *models.Session {
 	testdata.InsertWaitingSession(rt.DB, org, contact, models.FlowTypeMessaging, testdata.Favorites, models.NilCallID, time.Now(), time.Now(), false, nil)
 
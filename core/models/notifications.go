@@ -18,6 +18,7 @@ type NotificationType string
 const (
 	NotificationTypeExportFinished  NotificationType = "export:finished"
 	NotificationTypeImportFinished  NotificationType = "import:finished"
+	NotificationTypeModifyFinished  NotificationType = "modify:finished"
 	NotificationTypeIncidentStarted NotificationType = "incident:started"
 	NotificationTypeTicketsOpened   NotificationType = "tickets:opened"
 	NotificationTypeTicketsActivity NotificationType = "tickets:activity"
@@ -41,10 +42,24 @@ type Notification struct {
 	EmailStatus EmailStatus      `db:"email_status"`
 	CreatedOn   time.Time        `db:"created_on"`
 
+	ContactExportID ContactExportID `db:"contact_export_id"`
 	ContactImportID ContactImportID `db:"contact_import_id"`
 	IncidentID      IncidentID      `db:"incident_id"`
 }
 
+// NotifyExportFinished notifies the user who created an export that it has finished
+func NotifyExportFinished(ctx context.Context, db Queryer, export *ContactExport) error {
+	n := &Notification{
+		OrgID:           export.OrgID,
+		Type:            NotificationTypeExportFinished,
+		Scope:           fmt.Sprintf("contact:%d", export.ID),
+		UserID:          export.CreatedByID,
+		ContactExportID: export.ID,
+	}
+
+	return insertNotifications(ctx, db, []*Notification{n})
+}
+
 // NotifyImportFinished notifies the user who created an import that it has finished
 func NotifyImportFinished(ctx context.Context, db Queryer, imp *ContactImport) error {
 	n := &Notification{
@@ -58,6 +73,18 @@ func NotifyImportFinished(ctx context.Context, db Queryer, imp *ContactImport) e
 	return insertNotifications(ctx, db, []*Notification{n})
 }
 
+// NotifyContactModifyFinished notifies the user who requested a bulk contact modification that it has finished
+func NotifyContactModifyFinished(ctx context.Context, db Queryer, orgID OrgID, userID UserID, uuid string) error {
+	n := &Notification{
+		OrgID:  orgID,
+		Type:   NotificationTypeModifyFinished,
+		Scope:  fmt.Sprintf("modify:%s", uuid),
+		UserID: userID,
+	}
+
+	return insertNotifications(ctx, db, []*Notification{n})
+}
+
 // NotifyIncidentStarted notifies administrators that an incident has started
 func NotifyIncidentStarted(ctx context.Context, db Queryer, oa *OrgAssets, incident *Incident) error {
 	admins := usersWithRoles(oa, []UserRole{UserRoleAdministrator})
@@ -135,8 +162,8 @@ func NotificationsFromTicketEvents(ctx context.Context, db Queryer, oa *OrgAsset
 }
 
 const insertNotificationSQL = `
-INSERT INTO notifications_notification(org_id,  notification_type,  scope,  user_id, is_seen, email_status, created_on,  contact_import_id,  incident_id) 
-                               VALUES(:org_id, :notification_type, :scope, :user_id,   FALSE,          'N',      NOW(), :contact_import_id, :incident_id) 
+INSERT INTO notifications_notification(org_id,  notification_type,  scope,  user_id, is_seen, email_status, created_on,  contact_export_id,  contact_import_id,  incident_id) 
+                               VALUES(:org_id, :notification_type, :scope, :user_id,   FALSE,          'N',      NOW(), :contact_export_id, :contact_import_id, :incident_id) 
 							   ON CONFLICT DO NOTHING`
 
 func insertNotifications(ctx context.Context, db Queryer, notifications []*Notification) error {
@@ -144,6 +144,24 @@ func (o *Org) ConfigValue(key string, def string) string {
 	return o.o.Config.GetString(key, def)
 }
 
+// IVRCallWindow returns the window in which outgoing calls can be made for this org if it has one
+func (o *Org) IVRCallWindow() *CallWindow {
+	window := &CallWindow{}
+	if readConfigValue(&o.o.Config, configIVRCallWindow, window) {
+		return window
+	}
+	return nil
+}
+
+// IVRRetrySchedule returns the retry schedule for outgoing calls for this org if it has one
+func (o *Org) IVRRetrySchedule() *CallRetrySchedule {
+	schedule := &CallRetrySchedule{}
+	if readConfigValue(&o.o.Config, configIVRRetrySchedule, schedule) {
+		return schedule
+	}
+	return nil
+}
+
 // EmailService returns the email service for this org
 func (o *Org) EmailService(c *runtime.Config, retries *smtpx.RetryConfig) (flows.EmailService, error) {
 	connectionURL := o.ConfigValue(configSMTPServer, c.SMTPServer)
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/nyaruka/null"
+	"github.com/pkg/errors"
+)
+
+// ScheduleFireResult is the result of a schedule being due to fire
+type ScheduleFireResult string
+
+const (
+	ScheduleFireResultFired   = ScheduleFireResult("F")
+	ScheduleFireResultSkipped = ScheduleFireResult("S")
+	ScheduleFireResultErrored = ScheduleFireResult("E")
+)
+
+// ScheduleFire is a record of a schedule being due to fire, and what happened as a result
+type ScheduleFire struct {
+	ScheduleID  ScheduleID         `db:"schedule_id"`
+	Scheduled   time.Time          `db:"scheduled"`
+	FiredOn     time.Time          `db:"fired_on"`
+	Result      ScheduleFireResult `db:"result"`
+	BroadcastID BroadcastID        `db:"broadcast_id"`
+	StartID     StartID            `db:"start_id"`
+	Error       null.String        `db:"error"`
+}
+
+// NewScheduleFire creates a new fire record for the given schedule
+func NewScheduleFire(scheduleID ScheduleID, scheduled, firedOn time.Time, result ScheduleFireResult) *ScheduleFire {
+	return &ScheduleFire{ScheduleID: scheduleID, Scheduled: scheduled, FiredOn: firedOn, Result: result}
+}
+
+const sqlScheduleFireHistoryEnabled = `SELECT to_regclass('schedules_schedulefire') IS NOT NULL`
+
+// ScheduleFireHistoryEnabled returns whether the database has a table for schedule fire history. Fire history is
+// optional and is only recorded where RapidPro has created that table.
+func ScheduleFireHistoryEnabled(ctx context.Context, db Queryer) (bool, error) {
+	var enabled bool
+	err := db.GetContext(ctx, &enabled, sqlScheduleFireHistoryEnabled)
+	return enabled, errors.Wrapf(err, "error checking for schedule fire history table")
+}
+
+const sqlInsertScheduleFires = `
+INSERT INTO schedules_schedulefire(schedule_id,  scheduled,  fired_on,  result,  broadcast_id,  start_id,  error)
+                            VALUES(:schedule_id, :scheduled, :fired_on, :result, :broadcast_id, :start_id, :error)`
+
+// InsertScheduleFires inserts the passed in schedule fires
+func InsertScheduleFires(ctx context.Context, db Queryer, fires []*ScheduleFire) error {
+	return BulkQuery(ctx, "inserting schedule fires", db, sqlInsertScheduleFires, fires)
+}
+
+const sqlRecordScheduleFireError = `
+WITH updated AS (
+    UPDATE schedules_schedulefire SET fired_on = $3, error = $4
+     WHERE schedule_id = $1 AND scheduled = $2 AND result = 'E'
+ RETURNING id
+)
+INSERT INTO schedules_schedulefire(schedule_id, scheduled, fired_on, result, error)
+     SELECT $1, $2, $3, 'E', $4 WHERE NOT EXISTS (SELECT 1 FROM updated)`
+
+// RecordScheduleFireError records that firing the given schedule errored. A schedule which errors stays due and so
+// is retried every minute, so rather than adding a new record each time, we update the existing errored fire.
+func RecordScheduleFireError(ctx context.Context, db Queryer, scheduleID ScheduleID, scheduled, firedOn time.Time, fireErr error) error {
+	_, err := db.ExecContext(ctx, sqlRecordScheduleFireError, scheduleID, scheduled, firedOn, fireErr.Error())
+	return errors.Wrapf(err, "error recording fire error for schedule: %d", scheduleID)
+}
@@ -0,0 +1,46 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordScheduleFireError(t *testing.T) {
+	ctx, _, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	var scheduleID models.ScheduleID
+	err := db.Get(
+		&scheduleID,
+		`INSERT INTO schedules_schedule(is_active, repeat_period, created_on, modified_on, next_fire, created_by_id, modified_by_id, org_id)
+			VALUES(TRUE, 'O', NOW(), NOW(), NOW() - INTERVAL '1 DAY', 1, 1, $1) RETURNING id`,
+		testdata.Org1.ID,
+	)
+	assert.NoError(t, err)
+
+	scheduled := time.Date(2022, 11, 28, 10, 0, 0, 0, time.UTC)
+
+	err = models.RecordScheduleFireError(ctx, db, scheduleID, scheduled, time.Date(2022, 11, 28, 10, 1, 0, 0, time.UTC), errors.New("boom"))
+	assert.NoError(t, err)
+
+	// the same fire erroring again updates the existing record
+	err = models.RecordScheduleFireError(ctx, db, scheduleID, scheduled, time.Date(2022, 11, 28, 10, 2, 0, 0, time.UTC), errors.New("bang"))
+	assert.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM schedules_schedulefire WHERE schedule_id = $1 AND result = 'E'`, scheduleID).Returns(1)
+	assertdb.Query(t, db, `SELECT error FROM schedules_schedulefire WHERE schedule_id = $1`, scheduleID).Returns("bang")
+
+	// but a later fire erroring gets its own record
+	err = models.RecordScheduleFireError(ctx, db, scheduleID, scheduled.Add(24*time.Hour), time.Date(2022, 11, 29, 10, 1, 0, 0, time.UTC), errors.New("boom"))
+	assert.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM schedules_schedulefire WHERE schedule_id = $1 AND result = 'E'`, scheduleID).Returns(2)
+}
@@ -0,0 +1,386 @@
+package models
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RepeatFrequency is the frequency of a repeat rule
+type RepeatFrequency string
+
+const (
+	RepeatFrequencyDaily   = RepeatFrequency("DAILY")
+	RepeatFrequencyWeekly  = RepeatFrequency("WEEKLY")
+	RepeatFrequencyMonthly = RepeatFrequency("MONTHLY")
+)
+
+// the maximum number of periods we'll step through looking for the next occurrence of a rule
+const maxRepeatRulePeriods = 50000
+
+var ruleDayToWeekday = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// RuleWeekday is a BYDAY value of a repeat rule, e.g. MO, 1MO for the first Monday or -1FR for the last Friday
+type RuleWeekday struct {
+	N   int
+	Day time.Weekday
+}
+
+// RepeatRule is a subset of an RFC 5545 recurrence rule, e.g.
+//
+//	DTSTART;TZID=Africa/Kigali:20221201T090000
+//	RRULE:FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1;COUNT=12
+//
+// Supported parts are FREQ (DAILY, WEEKLY or MONTHLY), INTERVAL, BYDAY, BYMONTHDAY, BYSETPOS, BYHOUR, BYMINUTE, UNTIL
+// and COUNT. Rules with an INTERVAL or a COUNT must have a DTSTART to count from. Weeks start on Mondays.
+type RepeatRule struct {
+	Freq       RepeatFrequency
+	Interval   int
+	ByDay      []RuleWeekday
+	ByMonthDay []int
+	BySetPos   []int
+	ByHour     *int
+	ByMinute   *int
+	Until      *time.Time
+	Count      int
+	Start      *time.Time
+}
+
+// ParseRepeatRule parses the given repeat rule, using the given timezone for any times without one
+func ParseRepeatRule(rule string, tz *time.Location) (*RepeatRule, error) {
+	r := &RepeatRule{Interval: 1}
+
+	for _, line := range strings.Split(strings.TrimSpace(rule), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "DTSTART") {
+			start, err := parseRuleStart(line, tz)
+			if err != nil {
+				return nil, err
+			}
+			r.Start = &start
+			continue
+		}
+
+		if err := r.parseParts(strings.TrimPrefix(line, "RRULE:"), tz); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.Freq == "" {
+		return nil, errors.New("repeat rule has no FREQ")
+	}
+	if (r.Interval > 1 || r.Count > 0) && r.Start == nil {
+		return nil, errors.New("repeat rule with INTERVAL or COUNT must have a DTSTART")
+	}
+	if r.Freq == RepeatFrequencyWeekly && len(r.ByDay) == 0 && r.Start == nil {
+		return nil, errors.New("weekly repeat rule must have BYDAY or DTSTART")
+	}
+	if r.Freq == RepeatFrequencyMonthly && len(r.ByDay) == 0 && len(r.ByMonthDay) == 0 && r.Start == nil {
+		return nil, errors.New("monthly repeat rule must have BYDAY, BYMONTHDAY or DTSTART")
+	}
+
+	return r, nil
+}
+
+func (r *RepeatRule) parseParts(s string, tz *time.Location) error {
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return errors.Errorf("invalid repeat rule part: %s", part)
+		}
+		key, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+		var err error
+
+		switch key {
+		case "FREQ":
+			r.Freq = RepeatFrequency(value)
+			if r.Freq != RepeatFrequencyDaily && r.Freq != RepeatFrequencyWeekly && r.Freq != RepeatFrequencyMonthly {
+				return errors.Errorf("unsupported repeat rule FREQ: %s", value)
+			}
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(value)
+			if err == nil && r.Interval < 1 {
+				err = errors.New("must be positive")
+			}
+		case "BYDAY":
+			for _, d := range strings.Split(value, ",") {
+				wd, ok := parseRuleWeekday(d)
+				if !ok {
+					return errors.Errorf("invalid repeat rule BYDAY: %s", d)
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = parseRuleInts(value, 31)
+		case "BYSETPOS":
+			r.BySetPos, err = parseRuleInts(value, 366)
+		case "BYHOUR":
+			var h int
+			h, err = strconv.Atoi(value)
+			if err == nil && (h < 0 || h > 23) {
+				err = errors.New("out of range")
+			}
+			r.ByHour = &h
+		case "BYMINUTE":
+			var m int
+			m, err = strconv.Atoi(value)
+			if err == nil && (m < 0 || m > 59) {
+				err = errors.New("out of range")
+			}
+			r.ByMinute = &m
+		case "UNTIL":
+			var until time.Time
+			until, err = parseRuleTime(value, tz, true)
+			r.Until = &until
+		case "COUNT":
+			r.Count, err = strconv.Atoi(value)
+			if err == nil && r.Count < 1 {
+				err = errors.New("must be positive")
+			}
+		case "WKST":
+			if value != "MO" {
+				err = errors.New("only MO is supported")
+			}
+		default:
+			return errors.Errorf("unsupported repeat rule part: %s", key)
+		}
+
+		if err != nil {
+			return errors.Errorf("invalid repeat rule %s: %s (%s)", key, value, err)
+		}
+	}
+	return nil
+}
+
+// Next returns the first occurrence of this rule after the given time at the given time of day, or nil if there are
+// no more occurrences. Occurrences are wall clock times in the given timezone so keep their time of day across DST.
+func (r *RepeatRule) Next(tz *time.Location, after time.Time, hour, minute int) *time.Time {
+	anchor := after.In(tz)
+	if r.Start != nil {
+		anchor = r.Start.In(tz)
+	}
+
+	period := r.periodStart(anchor)
+	count := 0
+
+	for i := 0; i < maxRepeatRulePeriods; i++ {
+		for _, day := range r.days(period, anchor) {
+			occurrence := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, tz)
+
+			if r.Start != nil && occurrence.Before(*r.Start) {
+				continue
+			}
+			if r.Until != nil && occurrence.After(*r.Until) {
+				return nil
+			}
+			count++
+			if r.Count > 0 && count > r.Count {
+				return nil
+			}
+			if occurrence.After(after) {
+				return &occurrence
+			}
+		}
+
+		switch r.Freq {
+		case RepeatFrequencyDaily:
+			period = period.AddDate(0, 0, r.Interval)
+		case RepeatFrequencyWeekly:
+			period = period.AddDate(0, 0, 7*r.Interval)
+		case RepeatFrequencyMonthly:
+			period = period.AddDate(0, r.Interval, 0)
+		}
+	}
+
+	return nil
+}
+
+// returns the midnight starting the day, week or month containing the given time
+func (r *RepeatRule) periodStart(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+
+	switch r.Freq {
+	case RepeatFrequencyWeekly:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case RepeatFrequencyMonthly:
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+// returns the days in the period starting at the given time which match this rule, in order
+func (r *RepeatRule) days(period, anchor time.Time) []time.Time {
+	var candidates []time.Time
+
+	switch r.Freq {
+	case RepeatFrequencyDaily:
+		candidates = []time.Time{period}
+	case RepeatFrequencyWeekly:
+		for i := 0; i < 7; i++ {
+			candidates = append(candidates, period.AddDate(0, 0, i))
+		}
+	case RepeatFrequencyMonthly:
+		for i := 0; i < daysInMonth(period); i++ {
+			candidates = append(candidates, period.AddDate(0, 0, i))
+		}
+	}
+
+	days := make([]time.Time, 0, len(candidates))
+	for _, day := range candidates {
+		if r.matches(day, anchor) {
+			days = append(days, day)
+		}
+	}
+
+	if len(r.BySetPos) == 0 {
+		return days
+	}
+
+	selected := make([]time.Time, 0, len(r.BySetPos))
+	for _, pos := range r.BySetPos {
+		if pos > 0 && pos <= len(days) {
+			selected = append(selected, days[pos-1])
+		} else if pos < 0 && -pos <= len(days) {
+			selected = append(selected, days[len(days)+pos])
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Before(selected[j]) })
+	return selected
+}
+
+// returns whether the given day matches the BYDAY and BYMONTHDAY parts of this rule
+func (r *RepeatRule) matches(day, anchor time.Time) bool {
+	monthDays := daysInMonth(day)
+
+	if len(r.ByMonthDay) > 0 {
+		found := false
+		for _, md := range r.ByMonthDay {
+			if md == day.Day() || (md < 0 && monthDays+md+1 == day.Day()) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(r.ByDay) > 0 {
+		found := false
+		for _, wd := range r.ByDay {
+			if wd.Day != day.Weekday() {
+				continue
+			}
+			if wd.N == 0 || r.Freq != RepeatFrequencyMonthly ||
+				(wd.N > 0 && (day.Day()-1)/7+1 == wd.N) ||
+				(wd.N < 0 && (monthDays-day.Day())/7+1 == -wd.N) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	// with no BY parts, weekly rules repeat on the weekday and monthly rules on the day of their start
+	if len(r.ByDay) == 0 && len(r.ByMonthDay) == 0 {
+		switch r.Freq {
+		case RepeatFrequencyWeekly:
+			return day.Weekday() == anchor.Weekday()
+		case RepeatFrequencyMonthly:
+			return day.Day() == anchor.Day()
+		}
+	}
+
+	return true
+}
+
+func parseRuleWeekday(s string) (RuleWeekday, bool) {
+	if len(s) < 2 {
+		return RuleWeekday{}, false
+	}
+	day, found := ruleDayToWeekday[s[len(s)-2:]]
+	if !found {
+		return RuleWeekday{}, false
+	}
+	n := 0
+	if len(s) > 2 {
+		var err error
+		n, err = strconv.Atoi(s[:len(s)-2])
+		if err != nil || n == 0 || n > 5 || n < -5 {
+			return RuleWeekday{}, false
+		}
+	}
+	return RuleWeekday{N: n, Day: day}, true
+}
+
+func parseRuleInts(s string, max int) ([]int, error) {
+	vals := make([]int, 0, 2)
+	for _, v := range strings.Split(s, ",") {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 || i > max || i < -max {
+			return nil, errors.New("out of range")
+		}
+		vals = append(vals, i)
+	}
+	return vals, nil
+}
+
+// parses a DTSTART line, e.g. DTSTART:20221201T090000Z or DTSTART;TZID=Africa/Kigali:20221201T090000
+func parseRuleStart(line string, tz *time.Location) (time.Time, error) {
+	parts := strings.SplitN(line, ":", 2)
+	if len(parts) != 2 {
+		return time.Time{}, errors.Errorf("invalid repeat rule DTSTART: %s", line)
+	}
+
+	for _, param := range strings.Split(parts[0], ";")[1:] {
+		if strings.HasPrefix(param, "TZID=") {
+			var err error
+			tz, err = time.LoadLocation(strings.TrimPrefix(param, "TZID="))
+			if err != nil {
+				return time.Time{}, errors.Errorf("invalid repeat rule DTSTART timezone: %s", param)
+			}
+		}
+	}
+
+	start, err := parseRuleTime(parts[1], tz, false)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid repeat rule DTSTART: %s", parts[1])
+	}
+	return start, nil
+}
+
+// parses a date or datetime value, which are in the given timezone unless they are UTC. Dates are the start of the
+// day unless endOfDay is set in which case they are the end of the day.
+func parseRuleTime(value string, tz *time.Location, endOfDay bool) (time.Time, error) {
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse("20060102T150405Z", value)
+	}
+	if len(value) == 8 {
+		d, err := time.ParseInLocation("20060102", value, tz)
+		if err == nil && endOfDay {
+			d = time.Date(d.Year(), d.Month(), d.Day(), 23, 59, 59, 0, tz)
+		}
+		return d, err
+	}
+	return time.ParseInLocation("20060102T150405", value, tz)
+}
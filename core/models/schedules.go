@@ -25,6 +25,13 @@ const RepeatPeriodDaily = RepeatPeriod("D")
 const RepeatPeriodWeekly = RepeatPeriod("W")
 const RepeatPeriodMonthly = RepeatPeriod("M")
 
+// ScheduleCatchUp is how a schedule which is found overdue, e.g. because mailroom was down, catches up on missed fires
+type ScheduleCatchUp string
+
+const ScheduleCatchUpOnce = ScheduleCatchUp("O")
+const ScheduleCatchUpAll = ScheduleCatchUp("A")
+const ScheduleCatchUpSkip = ScheduleCatchUp("S")
+
 const Monday = 'M'
 const Tuesday = 'T'
 const Wednesday = 'W'
@@ -52,6 +59,8 @@ type Schedule struct {
 		MinuteOfHour *int         `json:"repeat_minute_of_hour"`
 		DayOfMonth   *int         `json:"repeat_day_of_month"`
 		DaysOfWeek   null.String  `json:"repeat_days_of_week"`
+		RepeatRule   null.String  `json:"repeat_rule"`
+		CatchUp      null.String  `json:"catch_up"`
 		NextFire     *time.Time   `json:"next_fire"`
 		LastFire     *time.Time   `json:"last_fire"`
 		OrgID        OrgID        `json:"org_id"`
@@ -78,19 +87,36 @@ func NewSchedule(period RepeatPeriod, hourOfDay, minuteOfHour, dayOfMonth *int,
 	return sched
 }
 
+// NewRuleSchedule creates a new schedule which repeats according to the given RRULE style rule
+func NewRuleSchedule(rule string, hourOfDay, minuteOfHour *int) *Schedule {
+	sched := &Schedule{}
+	s := &sched.s
+	s.RepeatRule = null.String(rule)
+	s.HourOfDay = hourOfDay
+	s.MinuteOfHour = minuteOfHour
+	return sched
+}
+
 func (s *Schedule) ID() ScheduleID             { return s.s.ID }
 func (s *Schedule) OrgID() OrgID               { return s.s.OrgID }
 func (s *Schedule) Broadcast() *Broadcast      { return s.s.Broadcast }
 func (s *Schedule) FlowStart() *FlowStart      { return s.s.FlowStart }
 func (s *Schedule) RepeatPeriod() RepeatPeriod { return s.s.RepeatPeriod }
+func (s *Schedule) RepeatRule() string         { return string(s.s.RepeatRule) }
 func (s *Schedule) NextFire() *time.Time       { return s.s.NextFire }
 func (s *Schedule) LastFire() *time.Time       { return s.s.LastFire }
+func (s *Schedule) CatchUp() ScheduleCatchUp {
+	if s.s.CatchUp == "" {
+		return ScheduleCatchUpOnce
+	}
+	return ScheduleCatchUp(s.s.CatchUp)
+}
 func (s *Schedule) Timezone() (*time.Location, error) {
 	return time.LoadLocation(s.s.Timezone)
 }
 
 // UpdateFires updates the next and last fire for a shedule on the db
-func (s *Schedule) UpdateFires(ctx context.Context, tx Queryer, last time.Time, next *time.Time) error {
+func (s *Schedule) UpdateFires(ctx context.Context, tx Queryer, last *time.Time, next *time.Time) error {
 	_, err := tx.ExecContext(ctx, `UPDATE schedules_schedule SET last_fire = $2, next_fire = $3 WHERE id = $1`,
 		s.s.ID, last, next,
 	)
@@ -100,8 +126,40 @@ func (s *Schedule) UpdateFires(ctx context.Context, tx Queryer, last time.Time,
 	return nil
 }
 
+// GetDueFires returns the times this schedule was due to fire at up to now, starting with its next fire, and the next
+// fire after now. At most max due fires are returned, any more are considered lost.
+func (s *Schedule) GetDueFires(tz *time.Location, now time.Time, max int) ([]time.Time, *time.Time, error) {
+	due := make([]time.Time, 0, 1)
+	if s.s.NextFire != nil {
+		due = append(due, *s.s.NextFire)
+
+		for len(due) < max {
+			next, err := s.GetNextFire(tz, due[len(due)-1])
+			if err != nil {
+				return nil, nil, err
+			}
+			if next == nil || next.After(now) {
+				break
+			}
+			due = append(due, *next)
+		}
+	}
+
+	next, err := s.GetNextFire(tz, now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return due, next, nil
+}
+
 // GetNextFire returns the next fire for this schedule (if any)
 func (s *Schedule) GetNextFire(tz *time.Location, now time.Time) (*time.Time, error) {
+	// repeat rules take precedence over repeat periods
+	if s.s.RepeatRule != "" {
+		return s.getNextRuleFire(tz, now)
+	}
+
 	// Never repeats? no next fire
 	if s.s.RepeatPeriod == RepeatPeriodNever {
 		return nil, nil
@@ -187,6 +245,37 @@ func (s *Schedule) GetNextFire(tz *time.Location, now time.Time) (*time.Time, er
 	}
 }
 
+// returns the next fire for a schedule with a repeat rule
+func (s *Schedule) getNextRuleFire(tz *time.Location, now time.Time) (*time.Time, error) {
+	rule, err := ParseRepeatRule(string(s.s.RepeatRule), tz)
+	if err != nil {
+		return nil, errors.Wrapf(err, "schedule %d has invalid repeat_rule", s.s.ID)
+	}
+
+	// time of day comes from the rule, then its start, then the schedule
+	hour, minute := s.s.HourOfDay, s.s.MinuteOfHour
+	if rule.Start != nil {
+		start := rule.Start.In(tz)
+		h, m := start.Hour(), start.Minute()
+		hour, minute = &h, &m
+	}
+	if rule.ByHour != nil {
+		hour = rule.ByHour
+	}
+	if rule.ByMinute != nil {
+		minute = rule.ByMinute
+	}
+	if hour == nil {
+		return nil, errors.Errorf("schedule %d has no repeat_hour_of_day set", s.s.ID)
+	}
+	if minute == nil {
+		return nil, errors.Errorf("schedule %d has no repeat_minute_of_hour set", s.s.ID)
+	}
+
+	// increment now by a minute as above
+	return rule.Next(tz, now.Add(time.Minute), *hour, *minute), nil
+}
+
 // returns number of days in the month for the passed in date using crazy golang date magic
 func daysInMonth(t time.Time) int {
 	// day 0 of a month is previous day of previous month, months can be > 12 and roll years
@@ -194,6 +283,8 @@ func daysInMonth(t time.Time) int {
 	return lastDay.Day()
 }
 
+// repeat_rule and catch_up are read through to_jsonb so that schedules still load from databases where RapidPro hasn't
+// yet added those columns, in which case they're null
 const selectUnfiredSchedules = `
 SELECT ROW_TO_JSON(s) FROM (SELECT
 	s.id as id,
@@ -202,6 +293,8 @@ SELECT ROW_TO_JSON(s) FROM (SELECT
 	s.repeat_day_of_month as repeat_day_of_month,
 	s.repeat_days_of_week as repeat_days_of_week,
 	s.repeat_period as repeat_period,
+	to_jsonb(s) ->> 'repeat_rule' as repeat_rule,
+	to_jsonb(s) ->> 'catch_up' as catch_up,
 	s.next_fire as next_fire,
 	s.last_fire as last_fire,
 	s.org_id as org_id,
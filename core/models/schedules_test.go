@@ -16,6 +16,8 @@ import (
 func TestGetExpired(t *testing.T) {
 	ctx, _, db, _ := testsuite.Get()
 
+	defer testsuite.Reset(testsuite.ResetAll)
+
 	// add a schedule and tie a broadcast to it
 	var s1 models.ScheduleID
 	err := db.Get(
@@ -66,6 +68,9 @@ func TestGetExpired(t *testing.T) {
 	)
 	assert.NoError(t, err)
 
+	// give the first a repeat rule
+	db.MustExec(`UPDATE schedules_schedule SET repeat_rule = 'FREQ=WEEKLY;BYDAY=MO' WHERE id = $1`, s1)
+
 	// get expired schedules
 	schedules, err := models.GetUnfiredSchedules(ctx, db)
 	assert.NoError(t, err)
@@ -74,6 +79,7 @@ func TestGetExpired(t *testing.T) {
 	assert.Equal(t, s3, schedules[0].ID())
 	assert.Nil(t, schedules[0].Broadcast())
 	assert.Equal(t, models.RepeatPeriodNever, schedules[0].RepeatPeriod())
+	assert.Equal(t, "", schedules[0].RepeatRule())
 	assert.NotNil(t, schedules[0].NextFire())
 	assert.Nil(t, schedules[0].LastFire())
 
@@ -88,6 +94,7 @@ func TestGetExpired(t *testing.T) {
 	assert.Equal(t, []models.GroupID{testdata.DoctorsGroup.ID}, start.GroupIDs())
 
 	assert.Equal(t, s1, schedules[2].ID())
+	assert.Equal(t, "FREQ=WEEKLY;BYDAY=MO", schedules[2].RepeatRule())
 	bcast := schedules[2].Broadcast()
 	assert.NotNil(t, bcast)
 	assert.Equal(t, envs.Language("eng"), bcast.BaseLanguage())
@@ -98,6 +105,16 @@ func TestGetExpired(t *testing.T) {
 	assert.Equal(t, []models.ContactID{testdata.Cathy.ID, testdata.George.ID}, bcast.ContactIDs())
 	assert.Equal(t, []models.GroupID{testdata.DoctorsGroup.ID}, bcast.GroupIDs())
 	assert.Equal(t, []urns.URN{urns.URN("tel:+16055741111?id=10000")}, bcast.URNs())
+
+	// schedules still load from databases without repeat rule or catch up columns
+	db.MustExec(`ALTER TABLE schedules_schedule DROP COLUMN repeat_rule, DROP COLUMN catch_up`)
+	defer db.MustExec(`ALTER TABLE schedules_schedule ADD COLUMN repeat_rule character varying(255), ADD COLUMN catch_up character varying(1)`)
+
+	schedules, err = models.GetUnfiredSchedules(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(schedules))
+	assert.Equal(t, "", schedules[2].RepeatRule())
+	assert.Equal(t, models.ScheduleCatchUpOnce, schedules[2].CatchUp())
 }
 
 func TestNextFire(t *testing.T) {
@@ -379,3 +396,148 @@ tests:
 		}
 	}
 }
+
+func TestNextFireWithRule(t *testing.T) {
+	la, err := time.LoadLocation("America/Los_Angeles")
+	assert.NoError(t, err)
+
+	dp := func(year int, month int, day int, hour int, minute int) *time.Time {
+		d := time.Date(year, time.Month(month), day, hour, minute, 0, 0, la)
+		return &d
+	}
+
+	ip := func(i int) *int {
+		return &i
+	}
+
+	tcs := []struct {
+		Label        string
+		Now          time.Time
+		Rule         string
+		HourOfDay    *int
+		MinuteOfHour *int
+		Next         []*time.Time
+		Error        string
+	}{
+		{
+			Label:        "daily",
+			Now:          time.Date(2019, 8, 20, 10, 57, 0, 0, la),
+			Rule:         "FREQ=DAILY",
+			HourOfDay:    ip(12),
+			MinuteOfHour: ip(35),
+			Next:         []*time.Time{dp(2019, 8, 20, 12, 35), dp(2019, 8, 21, 12, 35)},
+		},
+		{
+			Label:        "every weekday across DST start",
+			Now:          time.Date(2019, 3, 8, 12, 30, 0, 0, la),
+			Rule:         "RRULE:FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR",
+			HourOfDay:    ip(2),
+			MinuteOfHour: ip(30),
+			Next:         []*time.Time{dp(2019, 3, 11, 2, 30), dp(2019, 3, 12, 2, 30)},
+		},
+		{
+			Label: "every 2 weeks on tuesday and thursday",
+			Now:   time.Date(2019, 8, 20, 10, 57, 0, 0, la),
+			Rule:  "DTSTART;TZID=America/Los_Angeles:20190805T090000\nRRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH",
+			Next:  []*time.Time{dp(2019, 8, 22, 9, 0), dp(2019, 9, 3, 9, 0), dp(2019, 9, 5, 9, 0)},
+		},
+		{
+			Label: "every 2 weeks defaulting to weekday of start",
+			Now:   time.Date(2019, 8, 20, 10, 57, 0, 0, la),
+			Rule:  "DTSTART:20190805T160000Z\nRRULE:FREQ=WEEKLY;INTERVAL=2",
+			Next:  []*time.Time{dp(2019, 9, 2, 9, 0), dp(2019, 9, 16, 9, 0)},
+		},
+		{
+			Label:        "last weekday of the month",
+			Now:          time.Date(2019, 8, 20, 10, 57, 0, 0, la),
+			Rule:         "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1",
+			HourOfDay:    ip(17),
+			MinuteOfHour: ip(0),
+			Next:         []*time.Time{dp(2019, 8, 30, 17, 0), dp(2019, 9, 30, 17, 0), dp(2019, 10, 31, 17, 0), dp(2019, 11, 29, 17, 0)},
+		},
+		{
+			Label:        "1st and 15th",
+			Now:          time.Date(2019, 8, 20, 10, 57, 0, 0, la),
+			Rule:         "FREQ=MONTHLY;BYMONTHDAY=1,15",
+			HourOfDay:    ip(8),
+			MinuteOfHour: ip(0),
+			Next:         []*time.Time{dp(2019, 9, 1, 8, 0), dp(2019, 9, 15, 8, 0), dp(2019, 10, 1, 8, 0)},
+		},
+		{
+			Label: "second tuesday with time from rule",
+			Now:   time.Date(2019, 8, 20, 10, 57, 0, 0, la),
+			Rule:  "FREQ=MONTHLY;BYDAY=2TU;BYHOUR=10;BYMINUTE=15",
+			Next:  []*time.Time{dp(2019, 9, 10, 10, 15), dp(2019, 10, 8, 10, 15)},
+		},
+		{
+			Label:        "last day of month",
+			Now:          time.Date(2019, 1, 31, 13, 0, 0, 0, la),
+			Rule:         "FREQ=MONTHLY;BYMONTHDAY=-1",
+			HourOfDay:    ip(12),
+			MinuteOfHour: ip(0),
+			Next:         []*time.Time{dp(2019, 2, 28, 12, 0), dp(2019, 3, 31, 12, 0)},
+		},
+		{
+			Label: "count",
+			Now:   time.Date(2019, 8, 20, 10, 57, 0, 0, la),
+			Rule:  "DTSTART;TZID=America/Los_Angeles:20190819T090000\nRRULE:FREQ=DAILY;COUNT=3",
+			Next:  []*time.Time{dp(2019, 8, 21, 9, 0), nil},
+		},
+		{
+			Label:        "until",
+			Now:          time.Date(2019, 8, 20, 10, 57, 0, 0, la),
+			Rule:         "FREQ=DAILY;UNTIL=20190821",
+			HourOfDay:    ip(12),
+			MinuteOfHour: ip(0),
+			Next:         []*time.Time{dp(2019, 8, 20, 12, 0), dp(2019, 8, 21, 12, 0), nil},
+		},
+		{
+			Label: "no time of day",
+			Now:   time.Date(2019, 8, 20, 10, 57, 0, 0, la),
+			Rule:  "FREQ=DAILY",
+			Error: "schedule 0 has no repeat_hour_of_day set",
+		},
+		{
+			Label: "interval without start",
+			Now:   time.Date(2019, 8, 20, 10, 57, 0, 0, la),
+			Rule:  "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO",
+			Error: "schedule 0 has invalid repeat_rule: repeat rule with INTERVAL or COUNT must have a DTSTART",
+		},
+		{
+			Label: "unsupported frequency",
+			Now:   time.Date(2019, 8, 20, 10, 57, 0, 0, la),
+			Rule:  "FREQ=YEARLY",
+			Error: "schedule 0 has invalid repeat_rule: unsupported repeat rule FREQ: YEARLY",
+		},
+		{
+			Label: "invalid day",
+			Now:   time.Date(2019, 8, 20, 10, 57, 0, 0, la),
+			Rule:  "FREQ=WEEKLY;BYDAY=XX",
+			Error: "schedule 0 has invalid repeat_rule: invalid repeat rule BYDAY: XX",
+		},
+	}
+
+tests:
+	for _, tc := range tcs {
+		sched := models.NewRuleSchedule(tc.Rule, tc.HourOfDay, tc.MinuteOfHour)
+		now := tc.Now
+
+		if tc.Error != "" {
+			_, err := sched.GetNextFire(la, now)
+			assert.EqualError(t, err, tc.Error, "%s: error did not match", tc.Label)
+			continue
+		}
+
+		for _, n := range tc.Next {
+			next, err := sched.GetNextFire(la, now)
+			if !assert.NoError(t, err, "%s: received unexpected error", tc.Label) {
+				continue tests
+			}
+			assert.Equal(t, n, next, "%s: next fire did not match", tc.Label)
+
+			if n != nil {
+				now = *n
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"context"
+
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/contactql"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/pkg/errors"
+)
+
+// ContactChanges describes what has changed about a set of contacts
+type ContactChanges struct {
+	Fields     []string `json:"fields,omitempty"`     // keys of fields whose values changed
+	Schemes    []string `json:"schemes,omitempty"`    // schemes of URNs which were added or removed
+	Attributes []string `json:"attributes,omitempty"` // other changed attributes, e.g. name, language, status
+
+	Groups []assets.GroupUUID `json:"groups,omitempty"` // groups which contacts were added to or removed from
+}
+
+// SmartGroupsAffectedBy returns the smart groups whose queries reference anything in the given changes, and so which
+// may have gained or lost the changed contacts
+func SmartGroupsAffectedBy(oa *OrgAssets, changes *ContactChanges) []*flows.Group {
+	fields := stringSet(changes.Fields)
+	schemes := stringSet(changes.Schemes)
+	attributes := stringSet(changes.Attributes)
+	groups := make(map[assets.GroupUUID]bool, len(changes.Groups))
+	for _, g := range changes.Groups {
+		groups[g] = true
+	}
+
+	// only active contacts can be in smart groups so a status change affects all of them
+	allAffected := attributes[contactql.AttributeStatus]
+
+	affected := make([]*flows.Group, 0, 5)
+
+	queries := oa.SmartGroupQueries()
+
+	for _, group := range oa.SessionAssets().Groups().All() {
+		inspection := queries[group.UUID()]
+		if inspection == nil {
+			continue
+		}
+
+		if allAffected || queryReferencesChanges(inspection, fields, schemes, attributes, groups) {
+			affected = append(affected, group)
+		}
+	}
+
+	return affected
+}
+
+func queryReferencesChanges(inspection *contactql.Inspection, fields, schemes, attributes map[string]bool, groups map[assets.GroupUUID]bool) bool {
+	for _, f := range inspection.Fields {
+		if fields[f.Key] {
+			return true
+		}
+	}
+	for _, s := range inspection.Schemes {
+		if schemes[s] {
+			return true
+		}
+	}
+	for _, g := range inspection.Groups {
+		if groups[g.UUID] {
+			return true
+		}
+	}
+	for _, a := range inspection.Attributes {
+		// a query on any URN is affected by changes to URNs of any scheme
+		if attributes[a] || (a == contactql.AttributeURN && len(schemes) > 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReevaluateSmartGroups re-evaluates membership of only the given smart groups for only the given contacts, updating
+// memberships and campaign events for any changes. Returns the number of contacts added to and removed from groups.
+func ReevaluateSmartGroups(ctx context.Context, db Queryer, oa *OrgAssets, contacts []*flows.Contact, groups []*flows.Group) (int, int, error) {
+	numAdded, numRemoved := 0, 0
+
+	for _, fg := range groups {
+		group := oa.GroupByUUID(fg.UUID())
+		if group == nil {
+			continue
+		}
+
+		added := make([]*flows.Contact, 0, 10)
+		groupAdds := make([]*GroupAdd, 0, 10)
+		groupRemoves := make([]*GroupRemove, 0, 10)
+		removedIDs := make([]ContactID, 0, 10)
+
+		for _, contact := range contacts {
+			if fg.CheckQueryBasedMembership(oa.Env(), contact) {
+				if contact.Groups().Add(fg) {
+					added = append(added, contact)
+					groupAdds = append(groupAdds, &GroupAdd{ContactID: ContactID(contact.ID()), GroupID: group.ID()})
+				}
+			} else if contact.Groups().Remove(fg) {
+				removedIDs = append(removedIDs, ContactID(contact.ID()))
+				groupRemoves = append(groupRemoves, &GroupRemove{ContactID: ContactID(contact.ID()), GroupID: group.ID()})
+			}
+		}
+
+		if err := AddContactsToGroups(ctx, db, groupAdds); err != nil {
+			return 0, 0, errors.Wrapf(err, "error adding contacts to group: %d", group.ID())
+		}
+		if err := RemoveContactsFromGroups(ctx, db, groupRemoves); err != nil {
+			return 0, 0, errors.Wrapf(err, "error removing contacts from group: %d", group.ID())
+		}
+
+		if len(oa.CampaignByGroupID(group.ID())) > 0 {
+			if len(added) > 0 {
+				if err := AddCampaignEventsForGroupAddition(ctx, db, oa, added, group.ID()); err != nil {
+					return 0, 0, errors.Wrapf(err, "error scheduling campaign events for group: %d", group.ID())
+				}
+			}
+			if len(removedIDs) > 0 {
+				if err := DeleteUnfiredEventsForGroupRemoval(ctx, db, oa, removedIDs, group.ID()); err != nil {
+					return 0, 0, errors.Wrapf(err, "error removing campaign events for group: %d", group.ID())
+				}
+			}
+		}
+
+		numAdded += len(groupAdds)
+		numRemoved += len(groupRemoves)
+	}
+
+	return numAdded, numRemoved, nil
+}
+
+func stringSet(vals []string) map[string]bool {
+	set := make(map[string]bool, len(vals))
+	for _, v := range vals {
+		set[v] = true
+	}
+	return set
+}
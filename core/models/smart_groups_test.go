@@ -0,0 +1,122 @@
+package models_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmartGroupsAffectedBy(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	testdata.InsertContactGroup(db, testdata.Org1, "4e5b8f77-0f59-4c8b-a7a4-8f1a1a9f2c01", "Adults", "age > 30")
+	testdata.InsertContactGroup(db, testdata.Org1, "4e5b8f77-0f59-4c8b-a7a4-8f1a1a9f2c02", "Bobs", `name ~ bob`)
+	testdata.InsertContactGroup(db, testdata.Org1, "4e5b8f77-0f59-4c8b-a7a4-8f1a1a9f2c03", "Tweeters", `twitter != ""`)
+	testdata.InsertContactGroup(db, testdata.Org1, "4e5b8f77-0f59-4c8b-a7a4-8f1a1a9f2c04", "Reachable", `urn != ""`)
+	testdata.InsertContactGroup(db, testdata.Org1, "4e5b8f77-0f59-4c8b-a7a4-8f1a1a9f2c05", "Patients", `group != "Doctors"`)
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshGroups)
+	require.NoError(t, err)
+
+	// smart group queries are parsed once and cached on the assets
+	queries := oa.SmartGroupQueries()
+	assert.Contains(t, queries, assets.GroupUUID("4e5b8f77-0f59-4c8b-a7a4-8f1a1a9f2c01"))
+	assert.NotContains(t, queries, testdata.DoctorsGroup.UUID)
+	assert.Equal(t, fmt.Sprintf("%p", queries), fmt.Sprintf("%p", oa.SmartGroupQueries()))
+
+	groupNames := func(changes *models.ContactChanges) []string {
+		names := make([]string, 0)
+		for _, g := range models.SmartGroupsAffectedBy(oa, changes) {
+			names = append(names, g.Name())
+		}
+		return names
+	}
+
+	names := groupNames(&models.ContactChanges{Fields: []string{"age"}})
+	assert.Contains(t, names, "Adults")
+	assert.NotContains(t, names, "Bobs")
+	assert.NotContains(t, names, "Tweeters")
+	assert.NotContains(t, names, "Reachable")
+
+	names = groupNames(&models.ContactChanges{Attributes: []string{"name"}})
+	assert.NotContains(t, names, "Adults")
+	assert.Contains(t, names, "Bobs")
+
+	names = groupNames(&models.ContactChanges{Schemes: []string{"twitter"}})
+	assert.NotContains(t, names, "Adults")
+	assert.Contains(t, names, "Tweeters")
+	assert.Contains(t, names, "Reachable")
+
+	names = groupNames(&models.ContactChanges{Schemes: []string{"tel"}})
+	assert.NotContains(t, names, "Tweeters")
+	assert.Contains(t, names, "Reachable")
+
+	names = groupNames(&models.ContactChanges{Fields: []string{"gender"}})
+	assert.NotContains(t, names, "Adults")
+	assert.NotContains(t, names, "Bobs")
+
+	// a change to the membership of a group affects smart groups which query it
+	names = groupNames(&models.ContactChanges{Groups: []assets.GroupUUID{testdata.DoctorsGroup.UUID}})
+	assert.Contains(t, names, "Patients")
+	assert.NotContains(t, names, "Adults")
+
+	names = groupNames(&models.ContactChanges{Groups: []assets.GroupUUID{testdata.TestersGroup.UUID}})
+	assert.NotContains(t, names, "Patients")
+
+	// a status change affects every smart group
+	names = groupNames(&models.ContactChanges{Attributes: []string{"status"}})
+	assert.Contains(t, names, "Adults")
+	assert.Contains(t, names, "Bobs")
+	assert.Contains(t, names, "Tweeters")
+	assert.Contains(t, names, "Reachable")
+}
+
+func TestReevaluateSmartGroups(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	adults := testdata.InsertContactGroup(db, testdata.Org1, "4e5b8f77-0f59-4c8b-a7a4-8f1a1a9f2c01", "Adults", "age > 30")
+	bobs := testdata.InsertContactGroup(db, testdata.Org1, "4e5b8f77-0f59-4c8b-a7a4-8f1a1a9f2c02", "Bobs", `name ~ bob`)
+
+	// Bob is already in the Adults group but is now too young
+	adults.Add(db, testdata.Bob)
+
+	db.MustExec(`UPDATE contacts_contact SET fields = '{"903f51da-2717-47c7-a0d3-f2f32877013d": {"text": "40", "number": 40}}' WHERE id = $1`, testdata.Cathy.ID)
+	db.MustExec(`UPDATE contacts_contact SET fields = '{"903f51da-2717-47c7-a0d3-f2f32877013d": {"text": "20", "number": 20}}' WHERE id = $1`, testdata.Bob.ID)
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, testdata.Org1.ID, models.RefreshGroups)
+	require.NoError(t, err)
+
+	_, cathy := testdata.Cathy.Load(db, oa)
+	_, bob := testdata.Bob.Load(db, oa)
+
+	// only consider our new groups which are affected by the change
+	groups := make([]*flows.Group, 0, 2)
+	for _, g := range models.SmartGroupsAffectedBy(oa, &models.ContactChanges{Fields: []string{"age"}}) {
+		if g.UUID() == adults.UUID || g.UUID() == bobs.UUID {
+			groups = append(groups, g)
+		}
+	}
+	assert.Equal(t, 1, len(groups))
+
+	added, removed, err := models.ReevaluateSmartGroups(ctx, db, oa, []*flows.Contact{cathy, bob}, groups)
+	require.NoError(t, err)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, 1, removed)
+
+	assertdb.Query(t, db, `SELECT contact_id FROM contacts_contactgroup_contacts WHERE contactgroup_id = $1`, adults.ID).Returns(int64(testdata.Cathy.ID))
+
+	// the Bobs group wasn't re-evaluated even though Bob qualifies for it
+	assertdb.Query(t, db, `SELECT count(*) FROM contacts_contactgroup_contacts WHERE contactgroup_id = $1`, bobs.ID).Returns(0)
+}
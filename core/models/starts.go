@@ -2,9 +2,11 @@ package models
 
 import (
 	"context"
+	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
 
+	"github.com/lib/pq"
 	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/gocommon/uuids"
@@ -36,15 +38,16 @@ type StartStatus string
 
 // start status constants
 const (
-	StartStatusPending  = StartStatus("P")
-	StartStatusStarting = StartStatus("S")
-	StartStatusComplete = StartStatus("C")
-	StartStatusFailed   = StartStatus("F")
+	StartStatusPending     = StartStatus("P")
+	StartStatusStarting    = StartStatus("S")
+	StartStatusComplete    = StartStatus("C")
+	StartStatusFailed      = StartStatus("F")
+	StartStatusInterrupted = StartStatus("I")
 )
 
-// MarkStartComplete sets the status for the passed in flow start
+// MarkStartComplete sets the status for the passed in flow start, unless it has been interrupted
 func MarkStartComplete(ctx context.Context, db Queryer, startID StartID) error {
-	_, err := db.ExecContext(ctx, "UPDATE flows_flowstart SET status = 'C', modified_on = NOW() WHERE id = $1", startID)
+	_, err := db.ExecContext(ctx, "UPDATE flows_flowstart SET status = 'C', modified_on = NOW() WHERE id = $1 AND status != 'I'", startID)
 	if err != nil {
 		return errors.Wrapf(err, "error setting start as complete")
 	}
@@ -87,6 +90,29 @@ func MarkStartFailed(ctx context.Context, db Queryer, startID StartID) error {
 	return nil
 }
 
+// MarkStartInterrupted sets the status for the passed in flow start to I because it was cancelled before all its batches
+// were started
+func MarkStartInterrupted(ctx context.Context, db Queryer, startID StartID) error {
+	_, err := db.ExecContext(ctx, "UPDATE flows_flowstart SET status = 'I', modified_on = NOW() WHERE id = $1", startID)
+	if err != nil {
+		return errors.Wrapf(err, "error setting start as interrupted")
+	}
+	return nil
+}
+
+// GetStartStatus gets the status of the given flow start, returning ErrNotFound if it doesn't exist in the given org
+func GetStartStatus(ctx context.Context, db Queryer, orgID OrgID, startID StartID) (StartStatus, error) {
+	var status StartStatus
+	err := db.GetContext(ctx, &status, "SELECT status FROM flows_flowstart WHERE id = $1 AND org_id = $2", startID, orgID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrNotFound
+		}
+		return "", errors.Wrapf(err, "error loading status of start")
+	}
+	return status, nil
+}
+
 // FlowStartBatch represents a single flow batch that needs to be started
 type FlowStartBatch struct {
 	b struct {
@@ -242,6 +268,26 @@ func GetFlowStartAttributes(ctx context.Context, db Queryer, startID StartID) (*
 	return start, nil
 }
 
+// GetFlowIDsForStarts gets the flow ids of the passed in starts
+func GetFlowIDsForStarts(ctx context.Context, db Queryer, startIDs []StartID) (map[StartID]FlowID, error) {
+	rows, err := db.QueryxContext(ctx, `SELECT id, flow_id FROM flows_flowstart WHERE id = ANY($1)`, pq.Array(startIDs))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error selecting flow ids for starts")
+	}
+	defer rows.Close()
+
+	flowIDs := make(map[StartID]FlowID, len(startIDs))
+	for rows.Next() {
+		var startID StartID
+		var flowID FlowID
+		if err := rows.Scan(&startID, &flowID); err != nil {
+			return nil, errors.Wrapf(err, "error scanning flow id for start")
+		}
+		flowIDs[startID] = flowID
+	}
+	return flowIDs, rows.Err()
+}
+
 // NewFlowStart creates a new flow start objects for the passed in parameters
 func NewFlowStart(orgID OrgID, startType StartType, flowType FlowType, flowID FlowID) *FlowStart {
 	s := &FlowStart{}
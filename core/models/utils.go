@@ -3,11 +3,13 @@ package models
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/gocommon/dbutil"
+	"github.com/nyaruka/null"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -91,3 +93,22 @@ func chunkSlice[T any](slice []T, size int) [][]T {
 	}
 	return chunks
 }
+
+// reads the structured config value with the passed in key into v, returning whether it was found and valid
+func readConfigValue(config *null.Map, key string, v interface{}) bool {
+	value := config.Get(key, nil)
+	if value == nil {
+		return false
+	}
+
+	// config values are decoded generically so round trip through JSON to get the typed value
+	b, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		logrus.WithError(err).WithField("key", key).Error("invalid config value")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"github.com/nyaruka/goflow/envs"
+)
+
+const configIVRVoicemail = "ivr_voicemail"
+
+// CallVoicemail is the message to leave after the beep when an outgoing call is answered by a machine, by language, e.g.
+//
+//	{"eng": {"text": "Hi, this is a reminder of your appointment tomorrow"}, "fra": {"audio_url": "https://..."}}
+//
+// Messages with only text are rendered as audio by the TTS service if there is one, otherwise spoken by the provider.
+type CallVoicemail map[envs.Language]*VoicemailMessage
+
+// VoicemailMessage is a single translation of a voicemail
+type VoicemailMessage struct {
+	Text     string `json:"text,omitempty"`
+	AudioURL string `json:"audio_url,omitempty"`
+}
+
+// MessageFor returns the voicemail message in the first of the given languages it has a translation for
+func (v CallVoicemail) MessageFor(languages ...envs.Language) (*VoicemailMessage, envs.Language) {
+	for _, lang := range languages {
+		msg := v[lang]
+		if msg != nil && (msg.Text != "" || msg.AudioURL != "") {
+			return msg, lang
+		}
+	}
+	return nil, envs.NilLanguage
+}
+
+// IVRVoicemail returns the voicemail to leave when calls for this flow are answered by a machine if it has one
+func (f *Flow) IVRVoicemail() CallVoicemail {
+	voicemail := make(CallVoicemail)
+	if readConfigValue(&f.f.Config, configIVRVoicemail, &voicemail) && len(voicemail) > 0 {
+		return voicemail
+	}
+	return nil
+}
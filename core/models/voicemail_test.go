@@ -0,0 +1,33 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallVoicemail(t *testing.T) {
+	voicemail := models.CallVoicemail{
+		"eng": {Text: "Hi, this is a reminder of your appointment"},
+		"fra": {AudioURL: "http://example.com/rappel.mp3"},
+		"kin": {},
+	}
+
+	msg, lang := voicemail.MessageFor("fra", "eng")
+	assert.Equal(t, &models.VoicemailMessage{AudioURL: "http://example.com/rappel.mp3"}, msg)
+	assert.Equal(t, envs.Language("fra"), lang)
+
+	// no translation or empty translation falls back to next language
+	msg, lang = voicemail.MessageFor("spa", "eng")
+	assert.Equal(t, "Hi, this is a reminder of your appointment", msg.Text)
+	assert.Equal(t, envs.Language("eng"), lang)
+
+	msg, lang = voicemail.MessageFor("kin", "eng")
+	assert.Equal(t, envs.Language("eng"), lang)
+
+	msg, lang = voicemail.MessageFor("kin", "spa")
+	assert.Nil(t, msg)
+	assert.Equal(t, envs.NilLanguage, lang)
+}
@@ -23,8 +23,8 @@ func SendMessages(ctx context.Context, rt *runtime.Runtime, tx models.Queryer, f
 
 	// walk through our messages, separate by whether they have a channel and if it's Android
 	for _, msg := range msgs {
-		// ignore any message already marked as failed (maybe org is suspended)
-		if msg.Status() == models.MsgStatusFailed {
+		// ignore any message already marked as failed (maybe org is suspended) or being held until later
+		if msg.Status() == models.MsgStatusFailed || msg.IsHeld() {
 			continue
 		}
 
@@ -19,6 +19,7 @@ type msgSpec struct {
 	Channel      *testdata.Channel
 	Contact      *testdata.Contact
 	Failed       bool
+	Held         bool
 	HighPriority bool
 }
 
@@ -29,6 +30,9 @@ func (m *msgSpec) createMsg(t *testing.T, rt *runtime.Runtime, oa *models.OrgAss
 	}
 
 	flowMsg := testdata.InsertOutgoingMsg(rt.DB, testdata.Org1, m.Channel, m.Contact, "Hello", nil, status, m.HighPriority)
+	if m.Held {
+		rt.DB.MustExec(`UPDATE msgs_msg SET status = 'P', next_attempt = NOW() + INTERVAL '1 hour' WHERE id = $1`, flowMsg.ID())
+	}
 	msgs, err := models.GetMessagesByID(context.Background(), rt.DB, testdata.Org1.ID, models.DirectionOut, []models.MsgID{models.MsgID(flowMsg.ID())})
 	require.NoError(t, err)
 
@@ -149,6 +153,19 @@ func TestSendMessages(t *testing.T) {
 			FCMTokensSynced: []string{},
 			PendingMsgs:     1,
 		},
+		{
+			Description: "messages held until their send window opens ignored",
+			Msgs: []msgSpec{
+				{
+					Channel: testdata.TwilioChannel,
+					Contact: testdata.Cathy,
+					Held:    true,
+				},
+			},
+			QueueSizes:      map[string][]int{},
+			FCMTokensSynced: []string{},
+			PendingMsgs:     2,
+		},
 	}
 
 	for _, tc := range tests {
@@ -58,6 +58,9 @@ const (
 
 	// StartIVRFlowBatch is our task for starting an ivr batch
 	StartIVRFlowBatch = "start_ivr_flow_batch"
+
+	// ProcessIVRRecording is our task for storing and transcribing an ivr recording
+	ProcessIVRRecording = "process_ivr_recording"
 )
 
 // Size returns the number of tasks for the passed in queue
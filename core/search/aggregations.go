@@ -0,0 +1,220 @@
+package search
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/contactql"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// AggregationOptions controls how contacts are bucketed when aggregating
+type AggregationOptions struct {
+	Fields         []string // keys of the fields to aggregate over
+	TermsLimit     int      // maximum number of buckets for text and location fields
+	NumberInterval float64  // interval of buckets for number fields
+	DateInterval   string   // calendar interval of buckets for datetime fields, e.g. day, week, month
+}
+
+// AggregateBucket is a single bucket in an aggregation. Keys are strings for text, location and datetime fields, and
+// the lower bound of the bucket for number fields.
+type AggregateBucket struct {
+	Key   interface{} `json:"key"`
+	Count int64       `json:"count"`
+}
+
+// FieldAggregation is the aggregation of the values of a contact field
+type FieldAggregation struct {
+	Type    assets.FieldType   `json:"type"`
+	Buckets []*AggregateBucket `json:"buckets"`
+}
+
+// GroupCount is the number of matching contacts in a group
+type GroupCount struct {
+	UUID  assets.GroupUUID `json:"uuid"`
+	Name  string           `json:"name"`
+	Count int64            `json:"count"`
+}
+
+// ContactAggregations are the aggregations over the contacts matching a query
+type ContactAggregations struct {
+	Total   int64                        `json:"total"`
+	Fields  map[string]*FieldAggregation `json:"fields"`
+	Groups  []*GroupCount                `json:"groups"`
+	Schemes map[string]int64             `json:"schemes"`
+}
+
+// BuildElasticAggregations builds the aggregations for the given fields, group memberships and URN schemes
+func BuildElasticAggregations(oa *models.OrgAssets, fields []*models.Field, opts *AggregationOptions) (map[string]elastic.Aggregation, error) {
+	aggs := make(map[string]elastic.Aggregation, len(fields)+2)
+
+	for _, field := range fields {
+		var values elastic.Aggregation
+
+		switch field.Type() {
+		case assets.FieldTypeText, assets.FieldTypeState, assets.FieldTypeDistrict, assets.FieldTypeWard:
+			name := "fields.text"
+			if field.Type() != assets.FieldTypeText {
+				name = "fields." + string(field.Type()) + "_keyword"
+			}
+			values = elastic.NewTermsAggregation().Field(name).Size(opts.TermsLimit)
+		case assets.FieldTypeNumber:
+			values = elastic.NewHistogramAggregation().Field("fields.number").Interval(opts.NumberInterval).MinDocCount(1)
+		case assets.FieldTypeDatetime:
+			values = elastic.NewDateHistogramAggregation().Field("fields.datetime").CalendarInterval(opts.DateInterval).TimeZone(oa.Env().Timezone().String()).MinDocCount(1)
+		default:
+			return nil, errors.Errorf("unable to aggregate field of type: %s", field.Type())
+		}
+
+		aggs["field_"+field.Key()] = elastic.NewNestedAggregation().Path("fields").SubAggregation("field",
+			elastic.NewFilterAggregation().Filter(elastic.NewTermQuery("fields.field", field.UUID())).SubAggregation("values", values),
+		)
+	}
+
+	numGroups := len(oa.SessionAssets().Groups().All())
+	if numGroups == 0 {
+		numGroups = 1
+	}
+	aggs["groups"] = elastic.NewTermsAggregation().Field("group_ids").Size(numGroups)
+
+	// count contacts rather than URNs for each scheme
+	aggs["schemes"] = elastic.NewNestedAggregation().Path("urns").SubAggregation("schemes",
+		elastic.NewTermsAggregation().Field("urns.scheme").Size(100).SubAggregation("contacts", elastic.NewReverseNestedAggregation()),
+	)
+
+	return aggs, nil
+}
+
+// GetContactAggregations returns aggregations over the contacts in the given group matching the given query, or if no
+// group is given, over the active contacts matching the query
+func GetContactAggregations(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, group *models.Group, query string, opts *AggregationOptions) (*contactql.ContactQuery, *ContactAggregations, error) {
+	if !useElastic(rt) {
+		return nil, nil, errors.New("contact aggregations require elastic")
+	}
+
+	start := time.Now()
+	var parsed *contactql.ContactQuery
+	var err error
+
+	if query != "" {
+		parsed, err = contactql.ParseQuery(oa.Env(), query, oa.SessionAssets())
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "error parsing query: %s", query)
+		}
+	}
+
+	fields := make([]*models.Field, 0, len(opts.Fields))
+	for _, key := range opts.Fields {
+		field := oa.FieldByKey(key)
+		if field == nil {
+			return nil, nil, errors.Errorf("no such field with key: %s", key)
+		}
+		fields = append(fields, field)
+	}
+
+	aggs, err := BuildElasticAggregations(oa, fields, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// without a group, only count active contacts rather than including blocked, stopped and archived contacts
+	status := models.NilContactStatus
+	if group == nil {
+		status = models.ContactStatusActive
+	}
+
+	eq := BuildElasticQuery(oa, group, status, nil, parsed)
+
+	s := rt.ES.Search("contacts").TrackTotalHits(true).Routing(strconv.FormatInt(int64(oa.OrgID()), 10)).Size(0).Query(eq)
+	for name, agg := range aggs {
+		s = s.Aggregation(name, agg)
+	}
+
+	results, err := s.Do(ctx)
+	if err != nil {
+		ee, ok := err.(*elastic.Error)
+		if !ok || ee.Details == nil {
+			return nil, nil, errors.Wrapf(err, "error performing aggregation query")
+		}
+		return nil, nil, errors.Wrapf(err, "error performing aggregation query: %s", ee.Details.Reason)
+	}
+
+	result := &ContactAggregations{
+		Total:   results.Hits.TotalHits.Value,
+		Fields:  make(map[string]*FieldAggregation, len(fields)),
+		Groups:  make([]*GroupCount, 0),
+		Schemes: make(map[string]int64),
+	}
+
+	for _, field := range fields {
+		result.Fields[field.Key()] = readFieldAggregation(results.Aggregations, field)
+	}
+
+	if terms, ok := results.Aggregations.Terms("groups"); ok {
+		for _, b := range terms.Buckets {
+			id, _ := b.KeyNumber.Int64()
+			if group := oa.GroupByID(models.GroupID(id)); group != nil {
+				result.Groups = append(result.Groups, &GroupCount{UUID: group.UUID(), Name: group.Name(), Count: b.DocCount})
+			}
+		}
+	}
+
+	if nested, ok := results.Aggregations.Nested("schemes"); ok {
+		if terms, ok := nested.Terms("schemes"); ok {
+			for _, b := range terms.Buckets {
+				scheme, _ := b.Key.(string)
+				count := b.DocCount
+				if contacts, ok := b.ReverseNested("contacts"); ok {
+					count = contacts.DocCount
+				}
+				result.Schemes[scheme] = count
+			}
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{"org_id": oa.OrgID(), "query": query, "elapsed": time.Since(start), "total_count": result.Total}).Debug("contact aggregation complete")
+
+	return parsed, result, nil
+}
+
+func readFieldAggregation(aggs elastic.Aggregations, field *models.Field) *FieldAggregation {
+	agg := &FieldAggregation{Type: field.Type(), Buckets: make([]*AggregateBucket, 0)}
+
+	nested, ok := aggs.Nested("field_" + field.Key())
+	if !ok {
+		return agg
+	}
+	filtered, ok := nested.Filter("field")
+	if !ok {
+		return agg
+	}
+
+	switch field.Type() {
+	case assets.FieldTypeNumber, assets.FieldTypeDatetime:
+		histogram, ok := filtered.Histogram("values")
+		if ok {
+			for _, b := range histogram.Buckets {
+				var key interface{} = b.Key
+				if field.Type() == assets.FieldTypeDatetime && b.KeyAsString != nil {
+					key = *b.KeyAsString
+				}
+				agg.Buckets = append(agg.Buckets, &AggregateBucket{Key: key, Count: b.DocCount})
+			}
+		}
+	default:
+		terms, ok := filtered.Terms("values")
+		if ok {
+			for _, b := range terms.Buckets {
+				agg.Buckets = append(agg.Buckets, &AggregateBucket{Key: b.Key, Count: b.DocCount})
+			}
+		}
+	}
+
+	return agg
+}
@@ -0,0 +1,150 @@
+package search_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nyaruka/gocommon/jsonx"
+	"github.com/nyaruka/goflow/test"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/search"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildElasticAggregations(t *testing.T) {
+	ctx, rt, _, _ := testsuite.Get()
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	opts := &search.AggregationOptions{TermsLimit: 20, NumberInterval: 10, DateInterval: "month"}
+	fields := []*models.Field{oa.FieldByKey("gender"), oa.FieldByKey("age"), oa.FieldByKey("joined")}
+
+	aggs, err := search.BuildElasticAggregations(oa, fields, opts)
+	require.NoError(t, err)
+	assert.Len(t, aggs, 5)
+
+	source := make(map[string]interface{}, len(aggs))
+	for name, agg := range aggs {
+		source[name], err = agg.Source()
+		require.NoError(t, err)
+	}
+
+	test.AssertEqualJSON(t, []byte(fmt.Sprintf(`{
+		"field_age": {
+			"nested": {"path": "fields"},
+			"aggregations": {
+				"field": {
+					"filter": {"term": {"fields.field": "903f51da-2717-47c7-a0d3-f2f32877013d"}},
+					"aggregations": {
+						"values": {"histogram": {"field": "fields.number", "interval": 10, "min_doc_count": 1}}
+					}
+				}
+			}
+		},
+		"field_gender": {
+			"nested": {"path": "fields"},
+			"aggregations": {
+				"field": {
+					"filter": {"term": {"fields.field": "3a5891e4-756e-4dc9-8e12-b7a766168824"}},
+					"aggregations": {
+						"values": {"terms": {"field": "fields.text", "size": 20}}
+					}
+				}
+			}
+		},
+		"field_joined": {
+			"nested": {"path": "fields"},
+			"aggregations": {
+				"field": {
+					"filter": {"term": {"fields.field": "d83aae24-4bbf-49d0-ab85-6bfd201eac6d"}},
+					"aggregations": {
+						"values": {"date_histogram": {"field": "fields.datetime", "calendar_interval": "month", "min_doc_count": 1, "time_zone": "%s"}}
+					}
+				}
+			}
+		},
+		"groups": {"terms": {"field": "group_ids", "size": %d}},
+		"schemes": {
+			"nested": {"path": "urns"},
+			"aggregations": {
+				"schemes": {
+					"terms": {"field": "urns.scheme", "size": 100},
+					"aggregations": {
+						"contacts": {"reverse_nested": {}}
+					}
+				}
+			}
+		}
+	}`, oa.Env().Timezone().String(), len(oa.SessionAssets().Groups().All()))), jsonx.MustMarshal(source), "aggregations mismatch")
+}
+
+func TestGetContactAggregations(t *testing.T) {
+	ctx, rt, _, _ := testsuite.Get()
+
+	mockES := testsuite.NewMockElasticServer()
+	defer mockES.Close()
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	opts := &search.AggregationOptions{Fields: []string{"gender", "age"}, TermsLimit: 20, NumberInterval: 10, DateInterval: "month"}
+
+	// without elastic we can't aggregate
+	rt.ES = nil
+	_, _, err = search.GetContactAggregations(ctx, rt, oa, nil, "age > 10", opts)
+	assert.EqualError(t, err, "contact aggregations require elastic")
+
+	rt.ES = mockES.Client()
+	defer func() { rt.ES = nil }()
+
+	mockES.Responses = append(mockES.Responses, []byte(`{
+		"took": 2,
+		"timed_out": false,
+		"hits": {"total": {"value": 3, "relation": "eq"}, "hits": []},
+		"aggregations": {
+			"field_gender": {
+				"doc_count": 3,
+				"field": {
+					"doc_count": 2,
+					"values": {"buckets": [{"key": "female", "doc_count": 2}]}
+				}
+			},
+			"field_age": {
+				"doc_count": 3,
+				"field": {
+					"doc_count": 3,
+					"values": {"buckets": [{"key": 10.0, "doc_count": 1}, {"key": 30.0, "doc_count": 2}]}
+				}
+			},
+			"groups": {"buckets": [{"key": 10000, "doc_count": 2}, {"key": 99999, "doc_count": 1}]},
+			"schemes": {
+				"doc_count": 4,
+				"schemes": {"buckets": [{"key": "tel", "doc_count": 4, "contacts": {"doc_count": 3}}]}
+			}
+		}
+	}`))
+
+	parsed, aggs, err := search.GetContactAggregations(ctx, rt, oa, nil, "age > 10", opts)
+	require.NoError(t, err)
+	assert.Equal(t, "age > 10", parsed.String())
+	assert.Contains(t, mockES.LastRequestBody, `"size":0`)
+	assert.Contains(t, mockES.LastRequestBody, `{"term":{"status":"A"}}`) // no group so only active contacts
+
+	test.AssertEqualJSON(t, []byte(`{
+		"total": 3,
+		"fields": {
+			"age": {"type": "number", "buckets": [{"key": 10, "count": 1}, {"key": 30, "count": 2}]},
+			"gender": {"type": "text", "buckets": [{"key": "female", "count": 2}]}
+		},
+		"groups": [{"uuid": "c153e265-f7c9-4539-9dbc-9b358714b638", "name": "Doctors", "count": 2}],
+		"schemes": {"tel": 3}
+	}`), jsonx.MustMarshal(aggs), "aggregations result mismatch")
+
+	// unknown fields are an error
+	_, _, err = search.GetContactAggregations(ctx, rt, oa, nil, "", &search.AggregationOptions{Fields: []string{"goats"}})
+	assert.EqualError(t, err, "no such field with key: goats")
+}
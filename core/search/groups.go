@@ -4,16 +4,17 @@ import (
 	"context"
 	"time"
 
-	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/mailroom/core/models"
-	"github.com/olivere/elastic/v7"
+	"github.com/nyaruka/mailroom/runtime"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
 // PopulateSmartGroup calculates which members should be part of a group and populates the contacts
 // for that group by performing the minimum number of inserts / deletes.
-func PopulateSmartGroup(ctx context.Context, db *sqlx.DB, es *elastic.Client, oa *models.OrgAssets, groupID models.GroupID, query string) (int, error) {
+func PopulateSmartGroup(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, groupID models.GroupID, query string) (int, error) {
+	db := rt.DB
+
 	err := models.UpdateGroupStatus(ctx, db, groupID, models.GroupStatusEvaluating)
 	if err != nil {
 		return 0, errors.Wrapf(err, "error marking dynamic group as evaluating")
@@ -21,21 +22,23 @@ func PopulateSmartGroup(ctx context.Context, db *sqlx.DB, es *elastic.Client, oa
 
 	start := time.Now()
 
-	// we have a bit of a race with the indexer process.. we want to make sure that any contacts that changed
-	// before this group was updated but after the last index are included, so if a contact was modified
-	// more recently than 10 seconds ago, we wait that long before starting in populating our group
-	newest, err := models.GetNewestContactModifiedOn(ctx, db, oa)
-	if err != nil {
-		return 0, errors.Wrapf(err, "error getting most recent contact modified_on for org: %d", oa.OrgID())
-	}
-	if newest != nil {
-		n := *newest
-
-		// if it was more recent than 10 seconds ago, sleep until it has been 10 seconds
-		if n.Add(time.Second * 10).After(start) {
-			sleep := n.Add(time.Second * 10).Sub(start)
-			logrus.WithField("sleep", sleep).Info("sleeping before evaluating dynamic group")
-			time.Sleep(sleep)
+	// if we're querying elastic, we have a bit of a race with the indexer process.. we want to make sure that any
+	// contacts that changed before this group was updated but after the last index are included, so if a contact was
+	// modified more recently than 10 seconds ago, we wait that long before starting in populating our group
+	if useElastic(rt) {
+		newest, err := models.GetNewestContactModifiedOn(ctx, db, oa)
+		if err != nil {
+			return 0, errors.Wrapf(err, "error getting most recent contact modified_on for org: %d", oa.OrgID())
+		}
+		if newest != nil {
+			n := *newest
+
+			// if it was more recent than 10 seconds ago, sleep until it has been 10 seconds
+			if n.Add(time.Second * 10).After(start) {
+				sleep := n.Add(time.Second * 10).Sub(start)
+				logrus.WithField("sleep", sleep).Info("sleeping before evaluating dynamic group")
+				time.Sleep(sleep)
+			}
 		}
 	}
 
@@ -49,8 +52,9 @@ func PopulateSmartGroup(ctx context.Context, db *sqlx.DB, es *elastic.Client, oa
 		present[i] = true
 	}
 
-	// calculate new set of ids
-	new, err := GetContactIDsForQuery(ctx, es, oa, query, -1)
+	// calculate new set of ids, and if that's done against the database rather than elastic, use the primary so that
+	// like above we don't miss recent changes to contacts, which here would be due to replication lag
+	new, err := getContactIDsForQuery(ctx, rt, db, oa, query, -1)
 	if err != nil {
 		return 0, errors.Wrapf(err, "error performing query: %s for group: %d", query, groupID)
 	}
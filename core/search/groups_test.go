@@ -38,7 +38,7 @@ func TestSmartGroups(t *testing.T) {
 	mockES := testsuite.NewMockElasticServer()
 	defer mockES.Close()
 
-	es := mockES.Client()
+	rt.ES = mockES.Client()
 
 	mockES.AddResponse(testdata.Cathy.ID)
 	mockES.AddResponse(testdata.Bob.ID)
@@ -70,7 +70,7 @@ func TestSmartGroups(t *testing.T) {
 		err := models.UpdateGroupStatus(ctx, db, testdata.DoctorsGroup.ID, models.GroupStatusInitializing)
 		assert.NoError(t, err)
 
-		count, err := search.PopulateSmartGroup(ctx, db, es, oa, testdata.DoctorsGroup.ID, tc.Query)
+		count, err := search.PopulateSmartGroup(ctx, rt, oa, testdata.DoctorsGroup.ID, tc.Query)
 		assert.NoError(t, err, "error populating smart group for: %s", tc.Query)
 
 		assert.Equal(t, count, len(tc.ContactIDs))
@@ -32,10 +32,18 @@ func BuildStartQuery(oa *models.OrgAssets, flow *models.Flow, groups []*models.G
 		}
 	}
 
-	return contactql.Stringify(buildStartQuery(oa.Env(), flow, groups, contactUUIDs, urnz, parsedQuery, excs)), nil
+	return contactql.Stringify(buildRecipientsQuery(oa.Env(), flow, groups, contactUUIDs, urnz, parsedQuery, excs)), nil
 }
 
-func buildStartQuery(env envs.Environment, flow *models.Flow, groups []*models.Group, contactUUIDs []flows.ContactUUID, urnz []urns.URN, userQuery *contactql.ContactQuery, excs Exclusions) contactql.QueryNode {
+// BuildBroadcastQuery builds a query for the recipients of a broadcast. Broadcasts don't have a flow, so the started
+// previously exclusion is ignored.
+func BuildBroadcastQuery(oa *models.OrgAssets, groups []*models.Group, contactUUIDs []flows.ContactUUID, urnz []urns.URN, userQuery string, excs Exclusions) (string, error) {
+	excs.StartedPreviously = false
+
+	return BuildStartQuery(oa, nil, groups, contactUUIDs, urnz, userQuery, excs)
+}
+
+func buildRecipientsQuery(env envs.Environment, flow *models.Flow, groups []*models.Group, contactUUIDs []flows.ContactUUID, urnz []urns.URN, userQuery *contactql.ContactQuery, excs Exclusions) contactql.QueryNode {
 	inclusions := make([]contactql.QueryNode, 0, 10)
 
 	for _, group := range groups {
@@ -59,7 +67,7 @@ func buildStartQuery(env envs.Environment, flow *models.Flow, groups []*models.G
 	if excs.InAFlow {
 		exclusions = append(exclusions, contactql.NewCondition("flow", contactql.PropertyTypeAttribute, contactql.OpEqual, ""))
 	}
-	if excs.StartedPreviously {
+	if excs.StartedPreviously && flow != nil {
 		exclusions = append(exclusions, contactql.NewCondition("history", contactql.PropertyTypeAttribute, contactql.OpNotEqual, flow.Name()))
 	}
 	if excs.NotSeenSinceDays > 0 {
@@ -121,3 +121,30 @@ func TestBuildStartQuery(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildBroadcastQuery(t *testing.T) {
+	_, rt, _, _ := testsuite.Get()
+
+	dates.SetNowSource(dates.NewFixedNowSource(time.Date(2022, 4, 20, 15, 30, 45, 0, time.UTC)))
+	defer dates.SetNowSource(dates.DefaultNowSource)
+
+	oa := testdata.Org1.Load(rt)
+	doctors := oa.GroupByID(testdata.DoctorsGroup.ID)
+
+	// started previously exclusion doesn't apply to broadcasts
+	query, err := search.BuildBroadcastQuery(oa, []*models.Group{doctors}, []flows.ContactUUID{testdata.Cathy.UUID}, nil, "", search.Exclusions{
+		NonActive:         true,
+		InAFlow:           true,
+		StartedPreviously: true,
+		NotSeenSinceDays:  90,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `(group = "Doctors" OR uuid = "6393abc0-283d-4c9b-a1b3-641a035c34bf") AND status = "active" AND flow = "" AND last_seen_on > "20-01-2022"`, query)
+
+	query, err = search.BuildBroadcastQuery(oa, nil, nil, nil, `gender = "M"`, search.Exclusions{InAFlow: true})
+	assert.NoError(t, err)
+	assert.Equal(t, `gender = "M" AND flow = ""`, query)
+
+	_, err = search.BuildBroadcastQuery(oa, nil, nil, nil, `goats > 14`, search.Exclusions{})
+	assert.EqualError(t, err, "invalid user query: can't resolve 'goats' to attribute, scheme or field")
+}
@@ -4,13 +4,17 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/goflow/contactql"
 	"github.com/nyaruka/goflow/contactql/es"
 	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
 	"github.com/olivere/elastic/v7"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -67,16 +71,12 @@ func BuildElasticQuery(oa *models.OrgAssets, group *models.Group, status models.
 }
 
 // GetContactIDsForQueryPage returns a page of contact ids for the given query and sort
-func GetContactIDsForQueryPage(ctx context.Context, client *elastic.Client, oa *models.OrgAssets, group *models.Group, excludeIDs []models.ContactID, query string, sort string, offset int, pageSize int) (*contactql.ContactQuery, []models.ContactID, int64, error) {
+func GetContactIDsForQueryPage(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, group *models.Group, excludeIDs []models.ContactID, query string, sort string, offset int, pageSize int) (*contactql.ContactQuery, []models.ContactID, int64, error) {
 	env := oa.Env()
 	start := time.Now()
 	var parsed *contactql.ContactQuery
 	var err error
 
-	if client == nil {
-		return nil, nil, 0, errors.Errorf("no elastic client available, check your configuration")
-	}
-
 	if query != "" {
 		parsed, err = contactql.ParseQuery(env, query, oa.SessionAssets())
 		if err != nil {
@@ -84,11 +84,33 @@ func GetContactIDsForQueryPage(ctx context.Context, client *elastic.Client, oa *
 		}
 	}
 
+	var ids []models.ContactID
+	var total int64
+
+	if useElastic(rt) {
+		ids, total, err = getContactIDsPageFromElastic(ctx, rt.ES, oa, group, excludeIDs, parsed, sort, offset, pageSize)
+		if isElasticUnavailable(err) {
+			logrus.WithError(err).WithField("org_id", oa.OrgID()).Warn("elastic unavailable, falling back to database for contact query")
+			ids, total, err = getContactIDsPageFromDB(ctx, rt.ReadonlyDB, oa, group, excludeIDs, parsed, sort, offset, pageSize)
+		}
+	} else {
+		ids, total, err = getContactIDsPageFromDB(ctx, rt.ReadonlyDB, oa, group, excludeIDs, parsed, sort, offset, pageSize)
+	}
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	logrus.WithFields(logrus.Fields{"org_id": oa.OrgID(), "query": query, "elapsed": time.Since(start), "page_count": len(ids), "total_count": total}).Debug("paged contact query complete")
+
+	return parsed, ids, total, nil
+}
+
+func getContactIDsPageFromElastic(ctx context.Context, client *elastic.Client, oa *models.OrgAssets, group *models.Group, excludeIDs []models.ContactID, parsed *contactql.ContactQuery, sort string, offset int, pageSize int) ([]models.ContactID, int64, error) {
 	eq := BuildElasticQuery(oa, group, models.NilContactStatus, excludeIDs, parsed)
 
 	fieldSort, err := es.ToElasticFieldSort(sort, oa.SessionAssets())
 	if err != nil {
-		return nil, nil, 0, errors.Wrapf(err, "error parsing sort")
+		return nil, 0, errors.Wrapf(err, "error parsing sort")
 	}
 
 	s := client.Search("contacts").TrackTotalHits(true).Routing(strconv.FormatInt(int64(oa.OrgID()), 10))
@@ -99,38 +121,134 @@ func GetContactIDsForQueryPage(ctx context.Context, client *elastic.Client, oa *
 		// Get *elastic.Error which contains additional information
 		ee, ok := err.(*elastic.Error)
 		if !ok {
-			return nil, nil, 0, errors.Wrapf(err, "error performing query")
+			return nil, 0, errors.Wrapf(err, "error performing query")
 		}
 
-		return nil, nil, 0, errors.Wrapf(err, "error performing query: %s", ee.Details.Reason)
+		return nil, 0, errors.Wrapf(err, "error performing query: %s", ee.Details.Reason)
 	}
 
 	ids := make([]models.ContactID, 0, pageSize)
 	ids, err = appendIDsFromHits(ids, results.Hits.Hits)
 	if err != nil {
-		return nil, nil, 0, err
+		return nil, 0, err
+	}
+
+	return ids, results.Hits.TotalHits.Value, nil
+}
+
+func getContactIDsPageFromDB(ctx context.Context, db *sqlx.DB, oa *models.OrgAssets, group *models.Group, excludeIDs []models.ContactID, parsed *contactql.ContactQuery, sort string, offset int, pageSize int) ([]models.ContactID, int64, error) {
+	where, params := BuildSQLQuery(oa, group, models.NilContactStatus, excludeIDs, parsed)
+
+	var total int64
+	if err := db.GetContext(ctx, &total, fmt.Sprintf(`SELECT count(*) FROM contacts_contact c WHERE %s`, where), params...); err != nil {
+		return nil, 0, errors.Wrapf(err, "error counting query results")
+	}
+
+	orderBy, params, err := ToSQLSort(sort, oa.SessionAssets(), params)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "error parsing sort")
 	}
 
-	logrus.WithFields(logrus.Fields{"org_id": oa.OrgID(), "query": query, "elapsed": time.Since(start), "page_count": len(ids), "total_count": results.Hits.TotalHits.Value}).Debug("paged contact query complete")
+	ids := make([]models.ContactID, 0, pageSize)
+	sql := fmt.Sprintf(`SELECT c.id FROM contacts_contact c WHERE %s ORDER BY %s LIMIT %d OFFSET %d`, where, orderBy, pageSize, offset)
 
-	return parsed, ids, results.Hits.TotalHits.Value, nil
+	if err := db.SelectContext(ctx, &ids, sql, params...); err != nil {
+		return nil, 0, errors.Wrapf(err, "error performing query")
+	}
+
+	return ids, total, nil
 }
 
-// GetContactIDsForQuery returns up to limit the contact ids that match the given query without sorting. Limit of -1 means return all.
-func GetContactIDsForQuery(ctx context.Context, client *elastic.Client, oa *models.OrgAssets, query string, limit int) ([]models.ContactID, error) {
-	env := oa.Env()
+// GetContactTotal returns the total count of active contacts matching the given query
+func GetContactTotal(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, query string) (*contactql.ContactQuery, int64, error) {
 	start := time.Now()
+	var parsed *contactql.ContactQuery
+	var err error
+
+	if query != "" {
+		parsed, err = contactql.ParseQuery(oa.Env(), query, oa.SessionAssets())
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "error parsing query: %s", query)
+		}
+	}
 
-	if client == nil {
-		return nil, errors.Errorf("no elastic client available, check your configuration")
+	var total int64
+
+	if useElastic(rt) {
+		total, err = getContactTotalFromElastic(ctx, rt.ES, oa, parsed)
+		if isElasticUnavailable(err) {
+			logrus.WithError(err).WithField("org_id", oa.OrgID()).Warn("elastic unavailable, falling back to database for contact count")
+			total, err = getContactTotalFromDB(ctx, rt.ReadonlyDB, oa, parsed)
+		}
+	} else {
+		total, err = getContactTotalFromDB(ctx, rt.ReadonlyDB, oa, parsed)
 	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	logrus.WithFields(logrus.Fields{"org_id": oa.OrgID(), "query": query, "elapsed": time.Since(start), "total_count": total}).Debug("contact count complete")
+
+	return parsed, total, nil
+}
+
+func getContactTotalFromElastic(ctx context.Context, client *elastic.Client, oa *models.OrgAssets, parsed *contactql.ContactQuery) (int64, error) {
+	eq := BuildElasticQuery(oa, nil, models.ContactStatusActive, nil, parsed)
+
+	return client.Count("contacts").Routing(strconv.FormatInt(int64(oa.OrgID()), 10)).Query(eq).Do(ctx)
+}
+
+func getContactTotalFromDB(ctx context.Context, db *sqlx.DB, oa *models.OrgAssets, parsed *contactql.ContactQuery) (int64, error) {
+	where, params := BuildSQLQuery(oa, nil, models.ContactStatusActive, nil, parsed)
+
+	var total int64
+	if err := db.GetContext(ctx, &total, fmt.Sprintf(`SELECT count(*) FROM contacts_contact c WHERE %s`, where), params...); err != nil {
+		return 0, errors.Wrapf(err, "error counting query results")
+	}
+	return total, nil
+}
+
+// GetContactIDsForQuery returns up to limit the contact ids that match the given query without sorting. Limit of -1 means return all.
+func GetContactIDsForQuery(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, query string, limit int) ([]models.ContactID, error) {
+	return getContactIDsForQuery(ctx, rt, rt.ReadonlyDB, oa, query, limit)
+}
+
+// gets the contact ids that match the given query, using the given database if elastic isn't being used or can't be reached
+func getContactIDsForQuery(ctx context.Context, rt *runtime.Runtime, db *sqlx.DB, oa *models.OrgAssets, query string, limit int) ([]models.ContactID, error) {
+	env := oa.Env()
+	start := time.Now()
 
-	// turn into elastic query
 	parsed, err := contactql.ParseQuery(env, query, oa.SessionAssets())
 	if err != nil {
 		return nil, errors.Wrapf(err, "error parsing query: %s", query)
 	}
 
+	var ids []models.ContactID
+
+	if useElastic(rt) {
+		ids, err = getContactIDsFromElastic(ctx, rt.ES, oa, parsed, limit)
+		if isElasticUnavailable(err) {
+			logrus.WithError(err).WithField("org_id", oa.OrgID()).Warn("elastic unavailable, falling back to database for contact query")
+			ids, err = getContactIDsFromDB(ctx, db, oa, parsed, limit)
+		}
+	} else {
+		ids, err = getContactIDsFromDB(ctx, db, oa, parsed, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"org_id":      oa.OrgID(),
+		"query":       query,
+		"elapsed":     time.Since(start),
+		"match_count": len(ids),
+	}).Debug("contact query complete")
+
+	return ids, nil
+}
+
+func getContactIDsFromElastic(ctx context.Context, client *elastic.Client, oa *models.OrgAssets, parsed *contactql.ContactQuery, limit int) ([]models.ContactID, error) {
 	routing := strconv.FormatInt(int64(oa.OrgID()), 10)
 	eq := BuildElasticQuery(oa, nil, models.ContactStatusActive, nil, parsed)
 	ids := make([]models.ContactID, 0, 100)
@@ -150,17 +268,10 @@ func GetContactIDsForQuery(ctx context.Context, client *elastic.Client, oa *mode
 	for {
 		results, err := scroll.Do(ctx)
 		if err == io.EOF {
-			logrus.WithFields(logrus.Fields{
-				"org_id":      oa.OrgID(),
-				"query":       query,
-				"elapsed":     time.Since(start),
-				"match_count": len(ids),
-			}).Debug("contact query complete")
-
 			return ids, nil
 		}
 		if err != nil {
-			return nil, errors.Wrapf(err, "error scrolling through results for search: %s", query)
+			return nil, errors.Wrapf(err, "error scrolling through results")
 		}
 
 		ids, err = appendIDsFromHits(ids, results.Hits.Hits)
@@ -170,6 +281,38 @@ func GetContactIDsForQuery(ctx context.Context, client *elastic.Client, oa *mode
 	}
 }
 
+func getContactIDsFromDB(ctx context.Context, db *sqlx.DB, oa *models.OrgAssets, parsed *contactql.ContactQuery, limit int) ([]models.ContactID, error) {
+	where, params := BuildSQLQuery(oa, nil, models.ContactStatusActive, nil, parsed)
+
+	sql := fmt.Sprintf(`SELECT c.id FROM contacts_contact c WHERE %s ORDER BY c.id`, where)
+	if limit >= 0 {
+		sql += fmt.Sprintf(` LIMIT %d`, limit)
+	}
+
+	ids := make([]models.ContactID, 0, 100)
+	if err := db.SelectContext(ctx, &ids, sql, params...); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// whether contact queries should be performed against elastic rather than the database
+func useElastic(rt *runtime.Runtime) bool {
+	return rt.ES != nil && rt.Config.ContactSearch != "db"
+}
+
+// whether the given error means we couldn't reach elastic at all, as opposed to elastic rejecting our query
+func isElasticUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if elastic.IsConnErr(err) || elastic.IsStatusCode(err, http.StatusServiceUnavailable) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
 // utility to convert search hits to contact IDs and append them to the given slice
 func appendIDsFromHits(ids []models.ContactID, hits []*elastic.SearchHit) ([]models.ContactID, error) {
 	for _, hit := range hits {
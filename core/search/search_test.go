@@ -1,20 +1,33 @@
 package search_test
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/goflow/test"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/core/search"
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/nyaruka/mailroom/testsuite/testdata"
-	"github.com/olivere/elastic/v7"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// gives only Cathy, Bob and George ages so that the same queries give the same results against elastic or the database
+func setTestAges(db *sqlx.DB) {
+	db.MustExec(`UPDATE contacts_contact SET fields = fields - $1`, testdata.AgeField.UUID)
+	for id, age := range map[models.ContactID]int{testdata.Cathy.ID: 30, testdata.Bob.ID: 40, testdata.George.ID: 35} {
+		db.MustExec(fmt.Sprintf(`UPDATE contacts_contact SET fields = fields || '{"%s": {"text": "%d", "number": %d}}'::jsonb WHERE id = $1`, testdata.AgeField.UUID, age, age), id)
+	}
+}
+
 func TestGetContactIDsForQueryPage(t *testing.T) {
-	ctx, rt, _, _ := testsuite.Get()
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	setTestAges(db)
 
 	mockES := testsuite.NewMockElasticServer()
 	defer mockES.Close()
@@ -22,7 +35,7 @@ func TestGetContactIDsForQueryPage(t *testing.T) {
 	mockES.AddResponse(testdata.George.ID)
 	mockES.AddResponse(testdata.George.ID)
 
-	es := mockES.Client()
+	rt.ES = mockES.Client()
 
 	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
 	require.NoError(t, err)
@@ -85,7 +98,7 @@ func TestGetContactIDsForQueryPage(t *testing.T) {
 			ExpectedTotal:    1,
 		},
 		{
-			Group:      testdata.BlockedGroup,
+			Group:      testdata.ActiveGroup,
 			ExcludeIDs: []models.ContactID{testdata.Bob.ID, testdata.Cathy.ID},
 			Query:      "age > 32",
 			Sort:       "-age",
@@ -107,7 +120,7 @@ func TestGetContactIDsForQueryPage(t *testing.T) {
 							},
 							{
 								"term": {
-									"group_ids": 2
+									"group_ids": 1
 								}
 							},
 							{
@@ -179,7 +192,7 @@ func TestGetContactIDsForQueryPage(t *testing.T) {
 	for i, tc := range tcs {
 		group := oa.GroupByID(tc.Group.ID)
 
-		_, ids, total, err := search.GetContactIDsForQueryPage(ctx, es, oa, group, tc.ExcludeIDs, tc.Query, tc.Sort, 0, 50)
+		_, ids, total, err := search.GetContactIDsForQueryPage(ctx, rt, oa, group, tc.ExcludeIDs, tc.Query, tc.Sort, 0, 50)
 
 		if tc.ExpectedError != "" {
 			assert.EqualError(t, err, tc.ExpectedError)
@@ -191,10 +204,71 @@ func TestGetContactIDsForQueryPage(t *testing.T) {
 			test.AssertEqualJSON(t, []byte(tc.ExpectedESRequest), []byte(mockES.LastRequestBody), "%d: ES request mismatch", i)
 		}
 	}
+
+	// the same queries performed against the database should give the same results
+	rt.ES = nil
+
+	for i, tc := range tcs {
+		group := oa.GroupByID(tc.Group.ID)
+
+		_, ids, total, err := search.GetContactIDsForQueryPage(ctx, rt, oa, group, tc.ExcludeIDs, tc.Query, tc.Sort, 0, 50)
+
+		if tc.ExpectedError != "" {
+			assert.EqualError(t, err, tc.ExpectedError)
+		} else {
+			assert.NoError(t, err, "%d: error encountered performing query against database", i)
+			assert.Equal(t, tc.ExpectedContacts, ids, "%d: ids mismatch against database", i)
+			assert.Equal(t, tc.ExpectedTotal, total, "%d: total mismatch against database", i)
+		}
+	}
+}
+
+func TestGetContactTotal(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	setTestAges(db)
+
+	// stopped contacts aren't counted
+	db.MustExec(`UPDATE contacts_contact SET status = 'S' WHERE id = $1`, testdata.Bob.ID)
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	// without elastic, count is done against the database
+	rt.ES = nil
+
+	parsed, total, err := search.GetContactTotal(ctx, rt, oa, "age >= 30")
+	require.NoError(t, err)
+	assert.Equal(t, "age >= 30", parsed.String())
+	assert.Equal(t, int64(2), total)
+
+	mockES := testsuite.NewMockElasticServer()
+	defer mockES.Close()
+
+	rt.ES = mockES.Client()
+	defer func() { rt.ES = nil }()
+
+	mockES.Responses = append(mockES.Responses, []byte(`{"count": 2, "_shards": {"total": 1, "successful": 1, "skipped": 0, "failed": 0}}`))
+
+	_, total, err = search.GetContactTotal(ctx, rt, oa, "age >= 30")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Equal(t, "/contacts/_count?routing=1", mockES.LastRequestURL)
+	assert.Contains(t, mockES.LastRequestBody, `{"term":{"status":"A"}}`)
+
+	// invalid queries are an error
+	_, _, err = search.GetContactTotal(ctx, rt, oa, "goats > 2")
+	assert.EqualError(t, err, "error parsing query: goats > 2: can't resolve 'goats' to attribute, scheme or field")
 }
 
 func TestGetContactIDsForQuery(t *testing.T) {
-	ctx, rt, _, _ := testsuite.Get()
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	setTestAges(db)
 
 	mockES := testsuite.NewMockElasticServer()
 	defer mockES.Close()
@@ -203,8 +277,7 @@ func TestGetContactIDsForQuery(t *testing.T) {
 	mockES.AddResponse()
 	mockES.AddResponse(testdata.George.ID)
 
-	es, err := elastic.NewClient(elastic.SetURL(mockES.URL()), elastic.SetHealthcheck(false), elastic.SetSniff(false))
-	require.NoError(t, err)
+	rt.ES = mockES.Client()
 
 	oa, err := models.GetOrgAssets(ctx, rt, 1)
 	require.NoError(t, err)
@@ -340,7 +413,7 @@ func TestGetContactIDsForQuery(t *testing.T) {
 	}
 
 	for i, tc := range tcs {
-		ids, err := search.GetContactIDsForQuery(ctx, es, oa, tc.query, tc.limit)
+		ids, err := search.GetContactIDsForQuery(ctx, rt, oa, tc.query, tc.limit)
 
 		if tc.expectedError != "" {
 			assert.EqualError(t, err, tc.expectedError)
@@ -352,4 +425,89 @@ func TestGetContactIDsForQuery(t *testing.T) {
 			test.AssertEqualJSON(t, []byte(tc.expectedRequestBody), []byte(mockES.LastRequestBody), "%d: request body mismatch", i)
 		}
 	}
+
+	// the same queries performed against the database should give the same results
+	rt.ES = nil
+
+	for i, tc := range tcs {
+		ids, err := search.GetContactIDsForQuery(ctx, rt, oa, tc.query, tc.limit)
+
+		if tc.expectedError != "" {
+			assert.EqualError(t, err, tc.expectedError)
+		} else {
+			assert.NoError(t, err, "%d: error encountered performing query against database", i)
+			assert.Equal(t, tc.expectedContacts, ids, "%d: ids mismatch against database", i)
+		}
+	}
+}
+
+func TestGetContactIDsFromDB(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	setTestAges(db)
+
+	oa, err := models.GetOrgAssets(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	// these go beyond the cases shared with the ES tests above, and with no elastic client are performed against the database
+	tcs := []struct {
+		group            *testdata.Group
+		excludeIDs       []models.ContactID
+		query            string
+		sort             string
+		expectedContacts []models.ContactID
+		expectedTotal    int64
+	}{
+		{
+			group:            testdata.ActiveGroup,
+			query:            "age >= 30",
+			sort:             "age",
+			expectedContacts: []models.ContactID{testdata.Cathy.ID, testdata.George.ID, testdata.Bob.ID},
+			expectedTotal:    3,
+		},
+		{
+			group:            testdata.ActiveGroup,
+			query:            `age >= 30 AND tel = "+16055741111"`,
+			expectedContacts: []models.ContactID{testdata.Cathy.ID},
+			expectedTotal:    1,
+		},
+	}
+
+	for i, tc := range tcs {
+		group := oa.GroupByID(tc.group.ID)
+
+		_, ids, total, err := search.GetContactIDsForQueryPage(ctx, rt, oa, group, tc.excludeIDs, tc.query, tc.sort, 0, 50)
+		assert.NoError(t, err, "%d: error encountered performing query", i)
+		assert.Equal(t, tc.expectedContacts, ids, "%d: ids mismatch", i)
+		assert.Equal(t, tc.expectedTotal, total, "%d: total mismatch", i)
+	}
+
+	ids, err := search.GetContactIDsForQuery(ctx, rt, oa, "age < 40", -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.ContactID{testdata.Cathy.ID, testdata.George.ID}, ids)
+
+	ids, err = search.GetContactIDsForQuery(ctx, rt, oa, "age < 40", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.ContactID{testdata.Cathy.ID}, ids)
+
+	// if elastic is configured but can't be reached, we fall back to the database
+	mockES := testsuite.NewMockElasticServer()
+	rt.ES = mockES.Client()
+	mockES.Close()
+	defer func() { rt.ES = nil }()
+
+	ids, err = search.GetContactIDsForQuery(ctx, rt, oa, "age > 32", -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.ContactID{testdata.Bob.ID, testdata.George.ID}, ids)
+
+	// or if we're configured to always use the database
+	rt.Config.ContactSearch = "db"
+	defer func() { rt.Config.ContactSearch = "elastic" }()
+
+	_, ids, total, err := search.GetContactIDsForQueryPage(ctx, rt, oa, nil, nil, "age = 35", "", 0, 50)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.ContactID{testdata.George.ID}, ids)
+	assert.Equal(t, int64(1), total)
 }
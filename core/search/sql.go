@@ -0,0 +1,486 @@
+package search
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/contactql"
+	"github.com/nyaruka/goflow/contactql/es"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/pkg/errors"
+)
+
+// we store contact status in the database as single char codes
+var contactStatusCodes = map[string]string{
+	"active":   "A",
+	"blocked":  "B",
+	"stopped":  "S",
+	"archived": "V",
+}
+
+// BuildSQLQuery turns the passed in contact ql query into a Postgres condition on the contacts_contact table (aliased
+// as c) and its parameters. It's the database equivalent of BuildElasticQuery.
+func BuildSQLQuery(oa *models.OrgAssets, group *models.Group, status models.ContactStatus, excludeIDs []models.ContactID, query *contactql.ContactQuery) (string, []interface{}) {
+	b := &sqlBuilder{env: oa.Env(), mapper: assetMapper}
+
+	// filter by org and active contacts
+	conds := []string{
+		fmt.Sprintf("c.org_id = %s", b.param(oa.OrgID())),
+		"c.is_active = TRUE",
+	}
+
+	// our group if present
+	if group != nil {
+		conds = append(conds, fmt.Sprintf("EXISTS (SELECT 1 FROM contacts_contactgroup_contacts gc WHERE gc.contact_id = c.id AND gc.contactgroup_id = %s)", b.param(group.ID())))
+	}
+
+	// our status is present
+	if status != models.NilContactStatus {
+		conds = append(conds, fmt.Sprintf("c.status = %s", b.param(status)))
+	}
+
+	// exclude ids if present
+	if len(excludeIDs) > 0 {
+		conds = append(conds, fmt.Sprintf("c.id != ALL(%s)", b.param(pq.Array(excludeIDs))))
+	}
+
+	// and by our query if present
+	if query != nil {
+		conds = append(conds, b.node(query.Resolver(), query.Root()))
+	}
+
+	return strings.Join(conds, " AND "), b.params
+}
+
+// ToSQLQuery converts a contactql query to a Postgres condition on the contacts_contact table (aliased as c). Any
+// parameters are appended to the given parameters and numbered accordingly.
+func ToSQLQuery(env envs.Environment, mapper es.AssetMapper, query *contactql.ContactQuery, params []interface{}) (string, []interface{}) {
+	if query.Resolver() == nil {
+		panic("can only convert queries parsed with a resolver")
+	}
+
+	b := &sqlBuilder{env: env, mapper: mapper, params: params}
+	return b.node(query.Resolver(), query.Root()), b.params
+}
+
+// ToSQLSort returns the Postgres ORDER BY clause for the passed in sort by string. Any parameters are appended to the
+// given parameters and numbered accordingly.
+func ToSQLSort(sortBy string, resolver contactql.Resolver, params []interface{}) (string, []interface{}, error) {
+	// default to most recent first by id
+	if sortBy == "" {
+		return "c.id DESC", params, nil
+	}
+
+	// figure out if we are ascending or descending (default is ascending, can be changed with leading -)
+	property := sortBy
+	direction := "ASC"
+	if strings.HasPrefix(sortBy, "-") {
+		direction = "DESC"
+		property = sortBy[1:]
+	}
+
+	property = strings.ToLower(property)
+
+	// attributes are straight sorts on their columns
+	if property == contactql.AttributeID {
+		return fmt.Sprintf("c.id %s", direction), params, nil
+	}
+	if property == contactql.AttributeName || property == contactql.AttributeCreatedOn || property == contactql.AttributeLastSeenOn || property == contactql.AttributeLanguage {
+		return fmt.Sprintf("c.%s %s NULLS LAST, c.id DESC", property, direction), params, nil
+	}
+
+	// we are sorting by a custom field
+	field := resolver.ResolveField(property)
+	if field == nil {
+		return "", nil, errors.Errorf("no such field with key: %s", property)
+	}
+
+	b := &sqlBuilder{params: params}
+	return fmt.Sprintf("%s %s NULLS LAST, c.id DESC", b.fieldValue(field), direction), b.params, nil
+}
+
+// sqlBuilder builds SQL conditions from query nodes, keeping track of the parameters they use
+type sqlBuilder struct {
+	env    envs.Environment
+	mapper es.AssetMapper
+	params []interface{}
+}
+
+// adds a parameter, returning its placeholder
+func (b *sqlBuilder) param(v interface{}) string {
+	b.params = append(b.params, v)
+	return fmt.Sprintf("$%d", len(b.params))
+}
+
+func (b *sqlBuilder) node(resolver contactql.Resolver, node contactql.QueryNode) string {
+	switch n := node.(type) {
+	case *contactql.BoolCombination:
+		return b.boolCombination(resolver, n)
+	case *contactql.Condition:
+		return b.condition(resolver, n)
+	default:
+		panic(fmt.Sprintf("unsupported node type: %T", n))
+	}
+}
+
+func (b *sqlBuilder) boolCombination(resolver contactql.Resolver, combination *contactql.BoolCombination) string {
+	conds := make([]string, len(combination.Children()))
+	for i, child := range combination.Children() {
+		conds[i] = b.node(resolver, child)
+	}
+
+	if combination.Operator() == contactql.BoolOperatorAnd {
+		return "(" + strings.Join(conds, " AND ") + ")"
+	}
+
+	return "(" + strings.Join(conds, " OR ") + ")"
+}
+
+func (b *sqlBuilder) condition(resolver contactql.Resolver, c *contactql.Condition) string {
+	switch c.PropertyType() {
+	case contactql.PropertyTypeField:
+		return b.fieldCondition(resolver, c)
+	case contactql.PropertyTypeAttribute:
+		return b.attributeCondition(resolver, c)
+	case contactql.PropertyTypeScheme:
+		return b.schemeCondition(c)
+	default:
+		panic(fmt.Sprintf("unsupported property type: %s", c.PropertyType()))
+	}
+}
+
+// returns the expression for the value of the given field, which will be NULL if the contact doesn't have one
+func (b *sqlBuilder) fieldValue(field assets.Field) string {
+	key := b.param(string(field.UUID()))
+
+	switch field.Type() {
+	case assets.FieldTypeNumber:
+		return fmt.Sprintf("(c.fields->%s::text->>'number')::numeric", key)
+	case assets.FieldTypeDatetime:
+		return fmt.Sprintf("(c.fields->%s::text->>'datetime')::timestamptz", key)
+	case assets.FieldTypeState, assets.FieldTypeDistrict, assets.FieldTypeWard:
+		// locations are stored as paths, so match on the last part of the path, i.e. the location name
+		return fmt.Sprintf("lower(reverse(split_part(reverse(c.fields->%s::text->>'%s'), ' > ', 1)))", key, field.Type())
+	default:
+		return fmt.Sprintf("lower(c.fields->%s::text->>'text')", key)
+	}
+}
+
+func (b *sqlBuilder) fieldCondition(resolver contactql.Resolver, c *contactql.Condition) string {
+	field := resolver.ResolveField(c.PropertyKey())
+	fieldType := field.Type()
+
+	// special cases for set/unset
+	if (c.Operator() == contactql.OpEqual || c.Operator() == contactql.OpNotEqual) && c.Value() == "" {
+		cond := fmt.Sprintf("c.fields->%s::text->>'%s' IS NOT NULL", b.param(string(field.UUID())), fieldType)
+
+		// if we are looking for unset, inverse our condition
+		if c.Operator() == contactql.OpEqual {
+			cond = not(cond)
+		}
+		return cond
+	}
+
+	if fieldType == assets.FieldTypeText {
+		value := strings.ToLower(c.Value())
+
+		switch c.Operator() {
+		case contactql.OpEqual:
+			return fmt.Sprintf("%s = %s", b.fieldValue(field), b.param(value))
+		case contactql.OpNotEqual:
+			return not(fmt.Sprintf("%s = %s", b.fieldValue(field), b.param(value)))
+		default:
+			panic(fmt.Sprintf("unsupported text field operator: %s", c.Operator()))
+		}
+
+	} else if fieldType == assets.FieldTypeNumber {
+		value, _ := c.ValueAsNumber()
+
+		switch c.Operator() {
+		case contactql.OpEqual:
+			return fmt.Sprintf("%s = %s", b.fieldValue(field), b.param(value))
+		case contactql.OpNotEqual:
+			return not(fmt.Sprintf("%s = %s", b.fieldValue(field), b.param(value)))
+		case contactql.OpGreaterThan:
+			return fmt.Sprintf("%s > %s", b.fieldValue(field), b.param(value))
+		case contactql.OpGreaterThanOrEqual:
+			return fmt.Sprintf("%s >= %s", b.fieldValue(field), b.param(value))
+		case contactql.OpLessThan:
+			return fmt.Sprintf("%s < %s", b.fieldValue(field), b.param(value))
+		case contactql.OpLessThanOrEqual:
+			return fmt.Sprintf("%s <= %s", b.fieldValue(field), b.param(value))
+		default:
+			panic(fmt.Sprintf("unsupported number field operator: %s", c.Operator()))
+		}
+
+	} else if fieldType == assets.FieldTypeDatetime {
+		value, _ := c.ValueAsDate(b.env)
+
+		cond, ok := b.dateRange(b.fieldValue(field), c.Operator(), value)
+		if !ok {
+			panic(fmt.Sprintf("unsupported datetime field operator: %s", c.Operator()))
+		}
+		return cond
+
+	} else if fieldType == assets.FieldTypeState || fieldType == assets.FieldTypeDistrict || fieldType == assets.FieldTypeWard {
+		value := strings.ToLower(c.Value())
+
+		switch c.Operator() {
+		case contactql.OpEqual:
+			return fmt.Sprintf("%s = %s", b.fieldValue(field), b.param(value))
+		case contactql.OpNotEqual:
+			return not(fmt.Sprintf("%s = %s", b.fieldValue(field), b.param(value)))
+		default:
+			panic(fmt.Sprintf("unsupported location field operator: %s", c.Operator()))
+		}
+	}
+
+	panic(fmt.Sprintf("unsupported field type: %s", fieldType))
+}
+
+func (b *sqlBuilder) attributeCondition(resolver contactql.Resolver, c *contactql.Condition) string {
+	key := c.PropertyKey()
+	value := strings.ToLower(c.Value())
+
+	// special case for set/unset for name and language
+	if (c.Operator() == contactql.OpEqual || c.Operator() == contactql.OpNotEqual) && value == "" &&
+		(key == contactql.AttributeName || key == contactql.AttributeLanguage) {
+
+		return setOrUnset(c, fmt.Sprintf("(c.%s IS NOT NULL AND c.%s != '')", key, key))
+	}
+
+	switch key {
+	case contactql.AttributeUUID:
+		return b.textAttribute(c, "c.uuid", value)
+	case contactql.AttributeID:
+		id, err := strconv.Atoi(value)
+		cond := "FALSE"
+		if err == nil {
+			cond = fmt.Sprintf("c.id = %s", b.param(id))
+		}
+
+		switch c.Operator() {
+		case contactql.OpEqual:
+			return cond
+		case contactql.OpNotEqual:
+			return not(cond)
+		default:
+			panic(fmt.Sprintf("unsupported ID attribute operator: %s", c.Operator()))
+		}
+	case contactql.AttributeName:
+		switch c.Operator() {
+		case contactql.OpEqual:
+			return fmt.Sprintf("lower(c.name) = %s", b.param(value))
+		case contactql.OpNotEqual:
+			return not(fmt.Sprintf("lower(c.name) = %s", b.param(value)))
+		case contactql.OpContains:
+			return fmt.Sprintf("c.name ILIKE %s", b.param(containsPattern(value)))
+		default:
+			panic(fmt.Sprintf("unsupported name attribute operator: %s", c.Operator()))
+		}
+	case contactql.AttributeStatus:
+		return b.textAttribute(c, "c.status", contactStatusCodes[value])
+	case contactql.AttributeLanguage:
+		return b.textAttribute(c, "c.language", value)
+	case contactql.AttributeCreatedOn:
+		date, _ := c.ValueAsDate(b.env)
+
+		cond, ok := b.dateRange("c.created_on", c.Operator(), date)
+		if !ok {
+			panic(fmt.Sprintf("unsupported created_on attribute operator: %s", c.Operator()))
+		}
+		return cond
+	case contactql.AttributeLastSeenOn:
+		// special case for set/unset
+		if (c.Operator() == contactql.OpEqual || c.Operator() == contactql.OpNotEqual) && value == "" {
+			return setOrUnset(c, "c.last_seen_on IS NOT NULL")
+		}
+
+		date, _ := c.ValueAsDate(b.env)
+
+		cond, ok := b.dateRange("c.last_seen_on", c.Operator(), date)
+		if !ok {
+			panic(fmt.Sprintf("unsupported last_seen_on attribute operator: %s", c.Operator()))
+		}
+		return cond
+	case contactql.AttributeURN:
+		// special case for set/unset
+		if (c.Operator() == contactql.OpEqual || c.Operator() == contactql.OpNotEqual) && value == "" {
+			return setOrUnset(c, urnExists(""))
+		}
+
+		switch c.Operator() {
+		case contactql.OpEqual:
+			return urnExists(fmt.Sprintf("lower(u.path) = %s", b.param(value)))
+		case contactql.OpNotEqual:
+			return not(urnExists(fmt.Sprintf("lower(u.path) = %s", b.param(value))))
+		case contactql.OpContains:
+			return urnExists(fmt.Sprintf("u.path ILIKE %s", b.param(containsPattern(value))))
+		default:
+			panic(fmt.Sprintf("unsupported URN attribute operator: %s", c.Operator()))
+		}
+	case contactql.AttributeGroup:
+		// special case for set/unset, which only considers user groups
+		if (c.Operator() == contactql.OpEqual || c.Operator() == contactql.OpNotEqual) && value == "" {
+			return setOrUnset(c, fmt.Sprintf(
+				"EXISTS (SELECT 1 FROM contacts_contactgroup_contacts gc INNER JOIN contacts_contactgroup g ON g.id = gc.contactgroup_id WHERE gc.contact_id = c.id AND g.group_type IN ('%s', '%s'))",
+				models.GroupTypeManual, models.GroupTypeSmart,
+			))
+		}
+
+		group := c.ValueAsGroup(resolver)
+		cond := fmt.Sprintf("EXISTS (SELECT 1 FROM contacts_contactgroup_contacts gc WHERE gc.contact_id = c.id AND gc.contactgroup_id = %s)", b.param(b.mapper.Group(group)))
+
+		switch c.Operator() {
+		case contactql.OpEqual:
+			return cond
+		case contactql.OpNotEqual:
+			return not(cond)
+		default:
+			panic(fmt.Sprintf("unsupported group attribute operator: %s", c.Operator()))
+		}
+	case contactql.AttributeFlow:
+		// special case for set/unset
+		if (c.Operator() == contactql.OpEqual || c.Operator() == contactql.OpNotEqual) && value == "" {
+			return setOrUnset(c, "c.current_flow_id IS NOT NULL")
+		}
+
+		flow := c.ValueAsFlow(resolver)
+		cond := fmt.Sprintf("c.current_flow_id = %s", b.param(b.mapper.Flow(flow)))
+
+		switch c.Operator() {
+		case contactql.OpEqual:
+			return cond
+		case contactql.OpNotEqual:
+			return not(cond)
+		default:
+			panic(fmt.Sprintf("unsupported flow attribute operator: %s", c.Operator()))
+		}
+	case contactql.AttributeHistory:
+		// special case for set/unset
+		if (c.Operator() == contactql.OpEqual || c.Operator() == contactql.OpNotEqual) && value == "" {
+			return setOrUnset(c, "EXISTS (SELECT 1 FROM flows_flowrun r WHERE r.contact_id = c.id)")
+		}
+
+		flow := c.ValueAsFlow(resolver)
+		cond := fmt.Sprintf("EXISTS (SELECT 1 FROM flows_flowrun r WHERE r.contact_id = c.id AND r.flow_id = %s)", b.param(b.mapper.Flow(flow)))
+
+		switch c.Operator() {
+		case contactql.OpEqual:
+			return cond
+		case contactql.OpNotEqual:
+			return not(cond)
+		default:
+			panic(fmt.Sprintf("unsupported flow attribute operator: %s", c.Operator()))
+		}
+	case contactql.AttributeTickets:
+		number, _ := c.ValueAsNumber()
+
+		switch c.Operator() {
+		case contactql.OpEqual:
+			return fmt.Sprintf("c.ticket_count = %s", b.param(number))
+		case contactql.OpNotEqual:
+			return not(fmt.Sprintf("c.ticket_count = %s", b.param(number)))
+		case contactql.OpGreaterThan:
+			return fmt.Sprintf("c.ticket_count > %s", b.param(number))
+		case contactql.OpGreaterThanOrEqual:
+			return fmt.Sprintf("c.ticket_count >= %s", b.param(number))
+		case contactql.OpLessThan:
+			return fmt.Sprintf("c.ticket_count < %s", b.param(number))
+		case contactql.OpLessThanOrEqual:
+			return fmt.Sprintf("c.ticket_count <= %s", b.param(number))
+		default:
+			panic(fmt.Sprintf("unsupported tickets attribute operator: %s", c.Operator()))
+		}
+	default:
+		panic(fmt.Sprintf("unsupported contact attribute: %s", key))
+	}
+}
+
+func (b *sqlBuilder) schemeCondition(c *contactql.Condition) string {
+	value := strings.ToLower(c.Value())
+	scheme := fmt.Sprintf("u.scheme = %s", b.param(c.PropertyKey()))
+
+	// special case for set/unset
+	if (c.Operator() == contactql.OpEqual || c.Operator() == contactql.OpNotEqual) && value == "" {
+		return setOrUnset(c, urnExists(scheme))
+	}
+
+	switch c.Operator() {
+	case contactql.OpEqual:
+		return urnExists(fmt.Sprintf("%s AND lower(u.path) = %s", scheme, b.param(value)))
+	case contactql.OpNotEqual:
+		return not(urnExists(fmt.Sprintf("%s AND lower(u.path) = %s", scheme, b.param(value))))
+	case contactql.OpContains:
+		return urnExists(fmt.Sprintf("%s AND u.path ILIKE %s", scheme, b.param(containsPattern(value))))
+	default:
+		panic(fmt.Sprintf("unsupported scheme operator: %s", c.Operator()))
+	}
+}
+
+func (b *sqlBuilder) textAttribute(c *contactql.Condition, column string, value string) string {
+	cond := fmt.Sprintf("%s = %s", column, b.param(value))
+
+	switch c.Operator() {
+	case contactql.OpEqual:
+		return cond
+	case contactql.OpNotEqual:
+		return not(cond)
+	default:
+		panic(fmt.Sprintf("unsupported %s attribute operator: %s", c.PropertyKey(), c.Operator()))
+	}
+}
+
+// builds a condition comparing the given expression to the day of the given date, returning false if operator isn't supported
+func (b *sqlBuilder) dateRange(expr string, op contactql.Operator, value time.Time) (string, bool) {
+	start, end := dates.DayToUTCRange(value, value.Location())
+
+	switch op {
+	case contactql.OpEqual:
+		return fmt.Sprintf("(%s >= %s AND %s < %s)", expr, b.param(start), expr, b.param(end)), true
+	case contactql.OpNotEqual:
+		return not(fmt.Sprintf("%s >= %s AND %s < %s", expr, b.param(start), expr, b.param(end))), true
+	case contactql.OpGreaterThan:
+		return fmt.Sprintf("%s >= %s", expr, b.param(end)), true
+	case contactql.OpGreaterThanOrEqual:
+		return fmt.Sprintf("%s >= %s", expr, b.param(start)), true
+	case contactql.OpLessThan:
+		return fmt.Sprintf("%s < %s", expr, b.param(start)), true
+	case contactql.OpLessThanOrEqual:
+		return fmt.Sprintf("%s < %s", expr, b.param(end)), true
+	default:
+		return "", false
+	}
+}
+
+// returns the condition for a set/unset check given the condition for being set
+func setOrUnset(c *contactql.Condition, isSet string) string {
+	if c.Operator() == contactql.OpEqual {
+		return not(isSet)
+	}
+	return isSet
+}
+
+// returns a condition that the contact has a URN matching the given condition on the URN table (aliased as u)
+func urnExists(cond string) string {
+	if cond == "" {
+		return "EXISTS (SELECT 1 FROM contacts_contacturn u WHERE u.contact_id = c.id)"
+	}
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM contacts_contacturn u WHERE u.contact_id = c.id AND %s)", cond)
+}
+
+// returns an ILIKE pattern which matches values containing the given value
+func containsPattern(value string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value) + "%"
+}
+
+// negates a condition, treating NULL as false so that contacts without a value match
+func not(cond string) string {
+	return fmt.Sprintf("(%s) IS NOT TRUE", cond)
+}
@@ -0,0 +1,226 @@
+package search_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/assets/static"
+	"github.com/nyaruka/goflow/contactql"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/mailroom/core/search"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockMapper struct{}
+
+func (m *mockMapper) Flow(f assets.Flow) int64   { return 1234 }
+func (m *mockMapper) Group(g assets.Group) int64 { return 2345 }
+
+func TestToSQLQuery(t *testing.T) {
+	resolver := contactql.NewMockResolver(
+		[]assets.Field{
+			static.NewField("6b6a43fa-a26d-4017-bede-328bcdd5c93b", "age", "Age", assets.FieldTypeNumber),
+			static.NewField("ecc7b13b-c698-4f46-8a90-24a8fab6fe34", "color", "Color", assets.FieldTypeText),
+			static.NewField("cbd3fc0e-9b74-4207-a8c7-248082bb4572", "dob", "DOB", assets.FieldTypeDatetime),
+			static.NewField("67663ad1-3abc-42dd-a162-09df2dea66ec", "state", "State", assets.FieldTypeState),
+		},
+		[]assets.Flow{
+			static.NewFlow("c261165a-f5b0-40ba-b916-76fb49667a4f", "Registration", []byte(`{}`)),
+		},
+		[]assets.Group{
+			static.NewGroup("8de30b78-d9ef-4db2-b2e8-4f7b6aef64cf", "U-Reporters", ""),
+		},
+	)
+
+	tz, _ := time.LoadLocation("Africa/Kigali")
+	env := envs.NewBuilder().WithTimezone(tz).WithDateFormat(envs.DateFormatDayMonthYear).Build()
+
+	dayStart := time.Date(2020, 1, 2, 0, 0, 0, 0, tz)
+	dayEnd := time.Date(2020, 1, 3, 0, 0, 0, 0, tz)
+	ageUUID := "6b6a43fa-a26d-4017-bede-328bcdd5c93b"
+
+	tcs := []struct {
+		query          string
+		expectedSQL    string
+		expectedParams []interface{}
+	}{
+		// these mirror the cases in goflow's contactql/es tests
+		{
+			query:          `color != ""`,
+			expectedSQL:    `c.fields->$1::text->>'text' IS NOT NULL`,
+			expectedParams: []interface{}{"ecc7b13b-c698-4f46-8a90-24a8fab6fe34"},
+		},
+		{
+			query:          `color = ""`,
+			expectedSQL:    `(c.fields->$1::text->>'text' IS NOT NULL) IS NOT TRUE`,
+			expectedParams: []interface{}{"ecc7b13b-c698-4f46-8a90-24a8fab6fe34"},
+		},
+		{
+			query:          `color = RED`,
+			expectedSQL:    `lower(c.fields->$1::text->>'text') = $2`,
+			expectedParams: []interface{}{"ecc7b13b-c698-4f46-8a90-24a8fab6fe34", "red"},
+		},
+		{
+			query:          `color != red`,
+			expectedSQL:    `(lower(c.fields->$1::text->>'text') = $2) IS NOT TRUE`,
+			expectedParams: []interface{}{"ecc7b13b-c698-4f46-8a90-24a8fab6fe34", "red"},
+		},
+		{
+			query:          `age > 10`,
+			expectedSQL:    `(c.fields->$1::text->>'number')::numeric > $2`,
+			expectedParams: []interface{}{ageUUID, decimal.RequireFromString("10")},
+		},
+		{
+			query:          `age <= 10.5`,
+			expectedSQL:    `(c.fields->$1::text->>'number')::numeric <= $2`,
+			expectedParams: []interface{}{ageUUID, decimal.RequireFromString("10.5")},
+		},
+		{
+			query:          `dob = 2/1/2020`,
+			expectedSQL:    `((c.fields->$1::text->>'datetime')::timestamptz >= $2 AND (c.fields->$1::text->>'datetime')::timestamptz < $3)`,
+			expectedParams: []interface{}{"cbd3fc0e-9b74-4207-a8c7-248082bb4572", dayStart, dayEnd},
+		},
+		{
+			query:          `dob > 2/1/2020`,
+			expectedSQL:    `(c.fields->$1::text->>'datetime')::timestamptz >= $2`,
+			expectedParams: []interface{}{"cbd3fc0e-9b74-4207-a8c7-248082bb4572", dayEnd},
+		},
+		{
+			query:          `state = Kigali`,
+			expectedSQL:    `lower(reverse(split_part(reverse(c.fields->$1::text->>'state'), ' > ', 1))) = $2`,
+			expectedParams: []interface{}{"67663ad1-3abc-42dd-a162-09df2dea66ec", "kigali"},
+		},
+		{
+			query:          `name = ""`,
+			expectedSQL:    `((c.name IS NOT NULL AND c.name != '')) IS NOT TRUE`,
+			expectedParams: []interface{}{},
+		},
+		{
+			query:          `name ~ "Bob"`,
+			expectedSQL:    `c.name ILIKE $1`,
+			expectedParams: []interface{}{"%bob%"},
+		},
+		{
+			query:          `id = 123`,
+			expectedSQL:    `c.id = $1`,
+			expectedParams: []interface{}{123},
+		},
+		{
+			query:          `status = blocked`,
+			expectedSQL:    `c.status = $1`,
+			expectedParams: []interface{}{"B"},
+		},
+		{
+			query:          `language != eng`,
+			expectedSQL:    `(c.language = $1) IS NOT TRUE`,
+			expectedParams: []interface{}{"eng"},
+		},
+		{
+			query:          `created_on < 2/1/2020`,
+			expectedSQL:    `c.created_on < $1`,
+			expectedParams: []interface{}{dayStart},
+		},
+		{
+			query:          `last_seen_on = ""`,
+			expectedSQL:    `(c.last_seen_on IS NOT NULL) IS NOT TRUE`,
+			expectedParams: []interface{}{},
+		},
+		{
+			query:          `urn ~ 2507`,
+			expectedSQL:    `EXISTS (SELECT 1 FROM contacts_contacturn u WHERE u.contact_id = c.id AND u.path ILIKE $1)`,
+			expectedParams: []interface{}{"%2507%"},
+		},
+		{
+			query:          `tel = +250781234567`,
+			expectedSQL:    `EXISTS (SELECT 1 FROM contacts_contacturn u WHERE u.contact_id = c.id AND u.scheme = $1 AND lower(u.path) = $2)`,
+			expectedParams: []interface{}{"tel", "+250781234567"},
+		},
+		{
+			query:          `twitter != ""`,
+			expectedSQL:    `EXISTS (SELECT 1 FROM contacts_contacturn u WHERE u.contact_id = c.id AND u.scheme = $1)`,
+			expectedParams: []interface{}{"twitter"},
+		},
+		{
+			query:          `group = "U-Reporters"`,
+			expectedSQL:    `EXISTS (SELECT 1 FROM contacts_contactgroup_contacts gc WHERE gc.contact_id = c.id AND gc.contactgroup_id = $1)`,
+			expectedParams: []interface{}{int64(2345)},
+		},
+		{
+			query:          `flow = Registration`,
+			expectedSQL:    `c.current_flow_id = $1`,
+			expectedParams: []interface{}{int64(1234)},
+		},
+		{
+			query:          `history != Registration`,
+			expectedSQL:    `(EXISTS (SELECT 1 FROM flows_flowrun r WHERE r.contact_id = c.id AND r.flow_id = $1)) IS NOT TRUE`,
+			expectedParams: []interface{}{int64(1234)},
+		},
+		{
+			query:          `tickets > 0`,
+			expectedSQL:    `c.ticket_count > $1`,
+			expectedParams: []interface{}{decimal.RequireFromString("0")},
+		},
+		{
+			query:          `(age > 10 AND color = red) OR name = Bob`,
+			expectedSQL:    `(((c.fields->$1::text->>'number')::numeric > $2 AND lower(c.fields->$3::text->>'text') = $4) OR lower(c.name) = $5)`,
+			expectedParams: []interface{}{ageUUID, decimal.RequireFromString("10"), "ecc7b13b-c698-4f46-8a90-24a8fab6fe34", "red", "bob"},
+		},
+	}
+
+	for _, tc := range tcs {
+		parsed, err := contactql.ParseQuery(env, tc.query, resolver)
+		require.NoError(t, err, "error parsing query: %s", tc.query)
+
+		sql, params := search.ToSQLQuery(env, &mockMapper{}, parsed, []interface{}{})
+
+		assert.Equal(t, tc.expectedSQL, sql, "sql mismatch for query: %s", tc.query)
+		assert.Equal(t, tc.expectedParams, params, "params mismatch for query: %s", tc.query)
+	}
+
+	// parameters are numbered after any existing parameters
+	parsed, err := contactql.ParseQuery(env, `age = 10`, resolver)
+	require.NoError(t, err)
+
+	sql, params := search.ToSQLQuery(env, &mockMapper{}, parsed, []interface{}{1})
+	assert.Equal(t, `(c.fields->$2::text->>'number')::numeric = $3`, sql)
+	assert.Equal(t, []interface{}{1, ageUUID, decimal.RequireFromString("10")}, params)
+}
+
+func TestToSQLSort(t *testing.T) {
+	resolver := contactql.NewMockResolver(
+		[]assets.Field{
+			static.NewField("6b6a43fa-a26d-4017-bede-328bcdd5c93b", "age", "Age", assets.FieldTypeNumber),
+		},
+		nil, nil,
+	)
+
+	tcs := []struct {
+		sort           string
+		expectedSQL    string
+		expectedParams []interface{}
+		expectedError  string
+	}{
+		{sort: "", expectedSQL: "c.id DESC", expectedParams: []interface{}{}},
+		{sort: "id", expectedSQL: "c.id ASC", expectedParams: []interface{}{}},
+		{sort: "-created_on", expectedSQL: "c.created_on DESC NULLS LAST, c.id DESC", expectedParams: []interface{}{}},
+		{sort: "Name", expectedSQL: "c.name ASC NULLS LAST, c.id DESC", expectedParams: []interface{}{}},
+		{sort: "-age", expectedSQL: "(c.fields->$1::text->>'number')::numeric DESC NULLS LAST, c.id DESC", expectedParams: []interface{}{"6b6a43fa-a26d-4017-bede-328bcdd5c93b"}},
+		{sort: "goats", expectedError: "no such field with key: goats"},
+	}
+
+	for _, tc := range tcs {
+		sql, params, err := search.ToSQLSort(tc.sort, resolver, []interface{}{})
+
+		if tc.expectedError != "" {
+			assert.EqualError(t, err, tc.expectedError)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedSQL, sql, fmt.Sprintf("sql mismatch for sort: %s", tc.sort))
+			assert.Equal(t, tc.expectedParams, params, fmt.Sprintf("params mismatch for sort: %s", tc.sort))
+		}
+	}
+}
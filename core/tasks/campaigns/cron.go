@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/lib/pq"
 	"github.com/nyaruka/gocommon/analytics"
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/mailroom"
@@ -20,6 +21,15 @@ import (
 
 const (
 	maxBatchSize = 100
+
+	// fires are read in pages of this size until this many have been queued or read
+	expiredEventsPageSize = 25000
+	maxQueuedPerRun       = 25000
+	maxScannedPerRun      = 100000
+
+	// the backlog of throttled orgs is only recalculated this often
+	backlogRecordedKey = "campaign_fire_backlog_recorded"
+	backlogInterval    = 60 * 5
 )
 
 var campaignsMarker = redisx.NewIntervalSet("campaign_event", time.Hour*24, 2)
@@ -37,65 +47,108 @@ func QueueEventFires(ctx context.Context, rt *runtime.Runtime) error {
 	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
 	defer cancel()
 
-	rows, err := rt.DB.QueryxContext(ctx, expiredEventsQuery)
-	if err != nil {
-		return errors.Wrapf(err, "error loading expired campaign events")
-	}
-	defer rows.Close()
-
 	rc := rt.RP.Get()
 	defer rc.Close()
 
 	orgID := models.NilOrgID
 	var task *FireCampaignEventTask
-	numFires, numDupes, numTasks := 0, 0, 0
+	numFires, numDupes, numTasks, numThrottled, numQueued := 0, 0, 0, 0, 0
 
-	for rows.Next() {
-		row := &eventFireRow{}
-		err := rows.StructScan(row)
+	throttles := newFireThrottles()
+
+	// fires are read in pages so that fires held back by throttling or already queued don't use up the fires we
+	// can queue in this run, and orgs and events which have reached their throttle limit are excluded from later pages.
+	// The number of fires read is also limited so that a large backlog of throttled fires isn't read every minute.
+	after := &eventFireRow{}
+	for numQueued < maxQueuedPerRun && numFires < maxScannedPerRun {
+		rows, err := rt.DB.QueryxContext(ctx, expiredEventsQuery, after.ScheduledMinute, after.EventID, after.FireID, pq.Array(throttles.exhaustedOrgs()), pq.Array(throttles.exhaustedEvents()), expiredEventsPageSize)
 		if err != nil {
-			return errors.Wrapf(err, "error reading event fire row")
+			return errors.Wrapf(err, "error loading expired campaign events")
 		}
 
-		numFires++
+		pageFires := 0
 
-		// check whether this event has already been queued to fire
-		taskID := fmt.Sprintf("%d", row.FireID)
-		dupe, err := campaignsMarker.Contains(rc, taskID)
-		if err != nil {
-			return errors.Wrap(err, "error checking task lock")
-		}
+		for rows.Next() {
+			row := &eventFireRow{}
+			err := rows.StructScan(row)
+			if err != nil {
+				rows.Close()
+				return errors.Wrapf(err, "error reading event fire row")
+			}
 
-		// this has already been queued, skip
-		if dupe {
-			numDupes++
-			continue
-		}
+			numFires++
+			pageFires++
+			after = row
 
-		// if this is the same event as our current task, and we haven't reached the fire per task limit, add it there
-		if task != nil && row.EventID == task.EventID && len(task.FireIDs) < maxBatchSize {
-			task.FireIDs = append(task.FireIDs, row.FireID)
-			continue
-		}
+			// check whether this event has already been queued to fire
+			taskID := fmt.Sprintf("%d", row.FireID)
+			dupe, err := campaignsMarker.Contains(rc, taskID)
+			if err != nil {
+				rows.Close()
+				return errors.Wrap(err, "error checking task lock")
+			}
 
-		// if not, queue up current task...
-		if task != nil {
-			err = queueFiresTask(rt.RP, orgID, task)
+			// this has already been queued, skip
+			if dupe {
+				throttles.skipped(row.OrgID, models.CampaignEventUUID(row.EventUUID))
+				numDupes++
+				continue
+			}
+
+			// check whether this fire is over the throttle limit for its org or event this minute
+			allowed, err := throttles.allow(ctx, rt, row.OrgID, models.CampaignEventUUID(row.EventUUID))
 			if err != nil {
-				return errors.Wrapf(err, "error queueing task")
+				rows.Close()
+				return errors.Wrapf(err, "error checking throttle for org: %d", row.OrgID)
+			}
+			if !allowed {
+				numThrottled++
+				continue
+			}
+
+			numQueued++
+
+			// if this is the same event as our current task, and we haven't reached the fire per task limit, add it there
+			if task != nil && row.EventID == task.EventID && len(task.FireIDs) < maxBatchSize {
+				task.FireIDs = append(task.FireIDs, row.FireID)
+				continue
+			}
+
+			// if not, queue up current task...
+			if task != nil {
+				err = queueFiresTask(rt.RP, orgID, task)
+				if err != nil {
+					rows.Close()
+					return errors.Wrapf(err, "error queueing task")
+				}
+				numTasks++
+			}
+
+			// and create a new one based on this row
+			orgID = row.OrgID
+			task = &FireCampaignEventTask{
+				FireIDs:      []models.FireID{row.FireID},
+				EventID:      row.EventID,
+				EventUUID:    row.EventUUID,
+				FlowUUID:     row.FlowUUID,
+				CampaignUUID: row.CampaignUUID,
+				CampaignName: row.CampaignName,
+			}
+
+			if numQueued >= maxQueuedPerRun {
+				break
 			}
-			numTasks++
 		}
 
-		// and create a new one based on this row
-		orgID = row.OrgID
-		task = &FireCampaignEventTask{
-			FireIDs:      []models.FireID{row.FireID},
-			EventID:      row.EventID,
-			EventUUID:    row.EventUUID,
-			FlowUUID:     row.FlowUUID,
-			CampaignUUID: row.CampaignUUID,
-			CampaignName: row.CampaignName,
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return errors.Wrapf(err, "error reading expired campaign events")
+		}
+
+		// a partial page means there are no more due fires
+		if pageFires < expiredEventsPageSize {
+			break
 		}
 	}
 
@@ -107,17 +160,165 @@ func QueueEventFires(ctx context.Context, rt *runtime.Runtime) error {
 		numTasks++
 	}
 
+	// record what we've held back so it can be monitored, if it hasn't been recorded recently
+	recordBacklog, err := redis.String(rc.Do("SET", backlogRecordedKey, "1", "EX", backlogInterval, "NX"))
+	if err != nil && err != redis.ErrNil {
+		return errors.Wrapf(err, "error checking when throttled fires were last recorded")
+	}
+	if recordBacklog == "OK" {
+		if err := throttles.recordBacklog(ctx, rt.DB, rc); err != nil {
+			return errors.Wrapf(err, "error recording throttled fires")
+		}
+	}
+
 	analytics.Gauge("mr.campaign_event_cron_elapsed", float64(time.Since(start))/float64(time.Second))
 	analytics.Gauge("mr.campaign_event_cron_count", float64(numFires))
+	analytics.Gauge("mr.campaign_event_cron_throttled", float64(numThrottled))
 	log.WithFields(logrus.Fields{
-		"elapsed": time.Since(start),
-		"fires":   numFires,
-		"dupes":   numDupes,
-		"tasks":   numTasks,
+		"elapsed":   time.Since(start),
+		"fires":     numFires,
+		"dupes":     numDupes,
+		"tasks":     numTasks,
+		"throttled": numThrottled,
 	}).Info("campaign event fire queuing complete")
 	return nil
 }
 
+// tracks how many fires have been queued in this run of the cron for each throttled org and event
+type fireThrottles struct {
+	throttles  map[models.OrgID]*models.CampaignThrottle
+	orgFires   map[models.OrgID]int
+	eventFires map[models.CampaignEventUUID]int
+
+	// fires of throttled orgs which were queued or already queued, and so aren't part of the backlog
+	orgSkipped map[models.OrgID]map[models.CampaignEventUUID]int
+
+	// orgs and events which can't have any more fires queued in this run
+	exhaustedOrg   map[models.OrgID]bool
+	exhaustedEvent map[models.CampaignEventUUID]bool
+}
+
+func newFireThrottles() *fireThrottles {
+	return &fireThrottles{
+		throttles:      make(map[models.OrgID]*models.CampaignThrottle),
+		orgFires:       make(map[models.OrgID]int),
+		eventFires:     make(map[models.CampaignEventUUID]int),
+		orgSkipped:     make(map[models.OrgID]map[models.CampaignEventUUID]int),
+		exhaustedOrg:   make(map[models.OrgID]bool),
+		exhaustedEvent: make(map[models.CampaignEventUUID]bool),
+	}
+}
+
+// returns whether a fire for the given org and event can be queued, counting it if so
+func (t *fireThrottles) allow(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID, eventUUID models.CampaignEventUUID) (bool, error) {
+	throttle, loaded := t.throttles[orgID]
+	if !loaded {
+		oa, err := models.GetOrgAssets(ctx, rt, orgID)
+		if err != nil {
+			return false, errors.Wrapf(err, "error loading org assets")
+		}
+		throttle = oa.Org().CampaignThrottle()
+		t.throttles[orgID] = throttle
+
+		if throttle != nil {
+			t.orgSkipped[orgID] = make(map[models.CampaignEventUUID]int)
+		}
+	}
+
+	if throttle == nil {
+		return true, nil
+	}
+
+	orgRate, eventRate := throttle.FiresPerMinute, throttle.EventRate(eventUUID)
+	if orgRate > 0 && t.orgFires[orgID] >= orgRate {
+		t.exhaustedOrg[orgID] = true
+		return false, nil
+	}
+	if eventRate > 0 && t.eventFires[eventUUID] >= eventRate {
+		t.exhaustedEvent[eventUUID] = true
+		return false, nil
+	}
+
+	t.orgFires[orgID]++
+	t.eventFires[eventUUID]++
+	t.orgSkipped[orgID][eventUUID]++
+	return true, nil
+}
+
+// records that a fire for the given org and event was skipped because it has already been queued
+func (t *fireThrottles) skipped(orgID models.OrgID, eventUUID models.CampaignEventUUID) {
+	if skipped := t.orgSkipped[orgID]; skipped != nil {
+		skipped[eventUUID]++
+	}
+}
+
+func (t *fireThrottles) exhaustedOrgs() []models.OrgID {
+	orgIDs := make([]models.OrgID, 0, len(t.exhaustedOrg))
+	for orgID := range t.exhaustedOrg {
+		orgIDs = append(orgIDs, orgID)
+	}
+	return orgIDs
+}
+
+func (t *fireThrottles) exhaustedEvents() []models.CampaignEventUUID {
+	eventUUIDs := make([]models.CampaignEventUUID, 0, len(t.exhaustedEvent))
+	for eventUUID := range t.exhaustedEvent {
+		eventUUIDs = append(eventUUIDs, eventUUID)
+	}
+	return eventUUIDs
+}
+
+// records the backlog of every throttled org we've seen, including those which no longer have one. Because fires of
+// exhausted orgs and events aren't all read, the backlog is counted from all due fires rather than those we've seen.
+func (t *fireThrottles) recordBacklog(ctx context.Context, db models.Queryer, rc redis.Conn) error {
+	for orgID, skipped := range t.orgSkipped {
+		due, err := loadDueFireCounts(ctx, db, orgID)
+		if err != nil {
+			return err
+		}
+
+		backlog := make(map[models.CampaignEventUUID]int, len(due))
+		for eventUUID, count := range due {
+			if held := count - skipped[eventUUID]; held > 0 {
+				backlog[eventUUID] = held
+			}
+		}
+
+		if err := models.SetCampaignFireBacklog(rc, orgID, backlog); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const sqlSelectDueFireCounts = `
+SELECT ce.uuid AS event_uuid, COUNT(*) AS count
+  FROM campaigns_eventfire ef
+  JOIN campaigns_campaignevent ce ON ce.id = ef.event_id
+  JOIN campaigns_campaign c ON c.id = ce.campaign_id
+ WHERE c.org_id = $1 AND ef.fired IS NULL AND ef.scheduled <= NOW() AND ce.is_active = TRUE
+GROUP BY ce.uuid`
+
+// loads the number of due fires for each active event of the given org
+func loadDueFireCounts(ctx context.Context, db models.Queryer, orgID models.OrgID) (map[models.CampaignEventUUID]int, error) {
+	rows, err := db.QueryxContext(ctx, sqlSelectDueFireCounts, orgID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error counting due fires for org: %d", orgID)
+	}
+	defer rows.Close()
+
+	counts := make(map[models.CampaignEventUUID]int)
+	for rows.Next() {
+		var eventUUID models.CampaignEventUUID
+		var count int
+		if err := rows.Scan(&eventUUID, &count); err != nil {
+			return nil, errors.Wrapf(err, "error scanning due fire count")
+		}
+		counts[eventUUID] = count
+	}
+	return counts, rows.Err()
+}
+
 func queueFiresTask(rp *redis.Pool, orgID models.OrgID, task *FireCampaignEventTask) error {
 	rc := rp.Get()
 	defer rc.Close()
@@ -140,18 +341,20 @@ func queueFiresTask(rp *redis.Pool, orgID models.OrgID, task *FireCampaignEventT
 }
 
 type eventFireRow struct {
-	FireID       models.FireID   `db:"fire_id"`
-	EventID      int64           `db:"event_id"`
-	EventUUID    string          `db:"event_uuid"`
-	FlowUUID     assets.FlowUUID `db:"flow_uuid"`
-	CampaignUUID string          `db:"campaign_uuid"`
-	CampaignName string          `db:"campaign_name"`
-	OrgID        models.OrgID    `db:"org_id"`
+	FireID          models.FireID   `db:"fire_id"`
+	ScheduledMinute time.Time       `db:"scheduled_minute"`
+	EventID         int64           `db:"event_id"`
+	EventUUID       string          `db:"event_uuid"`
+	FlowUUID        assets.FlowUUID `db:"flow_uuid"`
+	CampaignUUID    string          `db:"campaign_uuid"`
+	CampaignName    string          `db:"campaign_name"`
+	OrgID           models.OrgID    `db:"org_id"`
 }
 
 const expiredEventsQuery = `
 SELECT
     ef.id as fire_id,
+    DATE_TRUNC('minute', ef.scheduled) as scheduled_minute,
     ef.event_id as event_id,
     ce.uuid as event_uuid,
 	f.uuid as flow_uuid,
@@ -168,10 +371,14 @@ WHERE
 	ce.id = ef.event_id AND
 	ce.is_active = TRUE AND
     f.id = ce.flow_id AND
-    ce.campaign_id = c.id
+    ce.campaign_id = c.id AND
+	(DATE_TRUNC('minute', ef.scheduled), ef.event_id, ef.id) > ($1, $2, $3) AND
+	NOT (f.org_id = ANY($4)) AND
+	NOT (ce.uuid = ANY($5::uuid[]))
 ORDER BY
-    DATE_TRUNC('minute', scheduled) ASC,
-    ef.event_id ASC
+    DATE_TRUNC('minute', ef.scheduled) ASC,
+    ef.event_id ASC,
+	ef.id ASC
 LIMIT
-    25000;
+    $6;
 `
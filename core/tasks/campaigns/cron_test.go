@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/lib/pq"
 	"github.com/nyaruka/gocommon/dbutil/assertdb"
 	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/gocommon/uuids"
@@ -93,6 +94,66 @@ func TestQueueEventFires(t *testing.T) {
 	assert.Equal(t, 100, len(tk1.FireIDs))
 	assert.Equal(t, 10, len(tk2.FireIDs))
 }
+
+func TestQueueEventFiresThrottled(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	// org can start 3 fires a minute, and the second reminders event only 1
+	db.MustExec(`UPDATE orgs_org SET config = $2 WHERE id = $1`, testdata.Org1.ID, fmt.Sprintf(`{"campaign_throttle": {"fires_per_minute": 3, "events": {"%s": 1}}}`, testdata.RemindersEvent2.UUID))
+	models.FlushCache()
+
+	jim := testdata.InsertContact(db, testdata.Org1, flows.ContactUUID(uuids.New()), "Jim", envs.NilLanguage, models.ContactStatusActive)
+
+	fire1ID := testdata.InsertEventFire(rt.DB, testdata.Cathy, testdata.RemindersEvent1, time.Now().Add(-5*time.Minute))
+	fire2ID := testdata.InsertEventFire(rt.DB, testdata.Alexandria, testdata.RemindersEvent2, time.Now().Add(-5*time.Minute))
+	fire3ID := testdata.InsertEventFire(rt.DB, testdata.Bob, testdata.RemindersEvent2, time.Now().Add(-4*time.Minute))
+	fire4ID := testdata.InsertEventFire(rt.DB, testdata.George, testdata.RemindersEvent1, time.Now().Add(-3*time.Minute))
+	fire5ID := testdata.InsertEventFire(rt.DB, jim, testdata.RemindersEvent1, time.Now().Add(-2*time.Minute))
+
+	err := campaigns.QueueEventFires(ctx, rt)
+	assert.NoError(t, err)
+
+	// third fire is over the event limit and the fifth over the org limit
+	assertFireTasks(t, rp, testdata.Org1, [][]models.FireID{{fire1ID}, {fire2ID}, {fire4ID}})
+
+	backlog, err := models.GetCampaignFireBacklog(rc, testdata.Org1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, map[models.CampaignEventUUID]int{testdata.RemindersEvent1.UUID: 1, testdata.RemindersEvent2.UUID: 1}, backlog)
+
+	// simulate the queued fires being fired
+	db.MustExec(`UPDATE campaigns_eventfire SET fired = NOW() WHERE id = ANY($1)`, pq.Array([]models.FireID{fire1ID, fire2ID, fire4ID}))
+	rc.Do("DEL", "batch:active")
+	rc.Do("DEL", "batch:1")
+
+	// next minute, the held back fires are queued
+	err = campaigns.QueueEventFires(ctx, rt)
+	assert.NoError(t, err)
+
+	assertFireTasks(t, rp, testdata.Org1, [][]models.FireID{{fire3ID}, {fire5ID}})
+
+	// but the backlog isn't recalculated until it's due to be
+	backlog, err = models.GetCampaignFireBacklog(rc, testdata.Org1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, map[models.CampaignEventUUID]int{testdata.RemindersEvent1.UUID: 1, testdata.RemindersEvent2.UUID: 1}, backlog)
+
+	rc.Do("DEL", "campaign_fire_backlog_recorded")
+	rc.Do("DEL", "batch:active")
+	rc.Do("DEL", "batch:1")
+
+	err = campaigns.QueueEventFires(ctx, rt)
+	assert.NoError(t, err)
+
+	assertFireTasks(t, rp, testdata.Org1, [][]models.FireID{})
+
+	backlog, err = models.GetCampaignFireBacklog(rc, testdata.Org1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, map[models.CampaignEventUUID]int{}, backlog)
+}
+
 func TestFireCampaignEvents(t *testing.T) {
 	ctx, rt, db, rp := testsuite.Get()
 	rc := rp.Get()
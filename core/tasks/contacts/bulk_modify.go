@@ -0,0 +1,142 @@
+package contacts
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/core/goflow"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/search"
+	"github.com/nyaruka/mailroom/core/tasks"
+	"github.com/nyaruka/mailroom/runtime"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// TypeBulkModifyContacts is the type of the bulk modify contacts task
+const TypeBulkModifyContacts = "bulk_modify_contacts"
+
+// the number of contacts we modify at a time
+const bulkModifyBatchSize = 100
+
+func init() {
+	tasks.RegisterType(TypeBulkModifyContacts, func() tasks.Task { return &BulkModifyContactsTask{} })
+}
+
+// BulkModifyContactsTask is our task to apply modifiers to all the active contacts matching a query or in a group
+type BulkModifyContactsTask struct {
+	UUID      string            `json:"uuid"`
+	UserID    models.UserID     `json:"user_id"`
+	GroupID   models.GroupID    `json:"group_id,omitempty"`
+	Query     string            `json:"query,omitempty"`
+	Modifiers []json.RawMessage `json:"modifiers"`
+}
+
+// Timeout is the maximum amount of time the task can run for
+func (t *BulkModifyContactsTask) Timeout() time.Duration {
+	return time.Hour * 2
+}
+
+// Perform resolves the contacts to be modified and applies the modifiers to them in batches, recording progress as it goes
+func (t *BulkModifyContactsTask) Perform(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID) error {
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	log := logrus.WithFields(logrus.Fields{"org_id": orgID, "uuid": t.UUID, "group_id": t.GroupID, "query": t.Query})
+	start := time.Now()
+
+	progress := &models.ContactModifyProgress{Status: models.ContactModifyStatusProcessing}
+	if err := models.SetContactModifyProgress(rc, orgID, t.UUID, progress); err != nil {
+		return err
+	}
+
+	if err := t.perform(ctx, rt, orgID, progress); err != nil {
+		progress.Status = models.ContactModifyStatusFailed
+		if err := models.SetContactModifyProgress(rc, orgID, t.UUID, progress); err != nil {
+			log.WithError(err).Error("error recording failed contact modify")
+		}
+		return err
+	}
+
+	progress.Status = models.ContactModifyStatusComplete
+	if err := models.SetContactModifyProgress(rc, orgID, t.UUID, progress); err != nil {
+		return err
+	}
+
+	if err := models.NotifyContactModifyFinished(ctx, rt.DB, orgID, t.UserID, t.UUID); err != nil {
+		return errors.Wrapf(err, "error notifying user of finished contact modify")
+	}
+
+	log.WithField("elapsed", time.Since(start)).WithField("count", progress.Processed).Info("completed bulk contact modify")
+
+	return nil
+}
+
+func (t *BulkModifyContactsTask) perform(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID, progress *models.ContactModifyProgress) error {
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	oa, err := models.GetOrgAssets(ctx, rt, orgID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to load org assets")
+	}
+
+	mods, err := goflow.ReadModifiers(oa.SessionAssets(), t.Modifiers, goflow.ErrorOnMissing)
+	if err != nil {
+		return errors.Wrapf(err, "error reading modifiers")
+	}
+
+	var contactIDs []models.ContactID
+	if t.Query != "" {
+		contactIDs, err = search.GetContactIDsForQuery(ctx, rt, oa, t.Query, -1)
+	} else if t.GroupID != 0 {
+		contactIDs, err = models.ContactIDsForGroupIDsWithStatus(ctx, rt.ReadonlyDB, []models.GroupID{t.GroupID}, models.ContactStatusActive)
+	} else {
+		return errors.New("bulk contact modify must have a query or group")
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error resolving contacts to modify")
+	}
+
+	progress.Total = len(contactIDs)
+	if err := models.SetContactModifyProgress(rc, orgID, t.UUID, progress); err != nil {
+		return err
+	}
+
+	for i := 0; i < len(contactIDs); i += bulkModifyBatchSize {
+		end := i + bulkModifyBatchSize
+		if end > len(contactIDs) {
+			end = len(contactIDs)
+		}
+		idBatch := contactIDs[i:end]
+
+		// load from the primary as we're about to modify these contacts
+		contacts, err := models.LoadContacts(ctx, rt.DB, oa, idBatch)
+		if err != nil {
+			return errors.Wrapf(err, "error loading contacts to modify")
+		}
+
+		modifiersByContact := make(map[*flows.Contact][]flows.Modifier, len(contacts))
+		for _, contact := range contacts {
+			flowContact, err := contact.FlowContact(oa)
+			if err != nil {
+				return errors.Wrapf(err, "error creating flow contact for contact: %d", contact.ID())
+			}
+			modifiersByContact[flowContact] = mods
+		}
+
+		if _, err := models.ApplyModifiers(ctx, rt, oa, t.UserID, modifiersByContact); err != nil {
+			return errors.Wrapf(err, "error applying modifiers to contacts")
+		}
+
+		progress.Processed += len(idBatch)
+		if err := models.SetContactModifyProgress(rc, orgID, t.UUID, progress); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package contacts_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	_ "github.com/nyaruka/mailroom/core/handlers"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/contacts"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkModifyTask(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	mockES := testsuite.NewMockElasticServer()
+	defer mockES.Close()
+
+	rt.ES = mockES.Client()
+
+	setGender := []json.RawMessage{json.RawMessage(`{"type": "field", "field": {"key": "gender", "name": "Gender"}, "value": "M"}`)}
+
+	// block one of the doctors, who shouldn't be modified as only active contacts are
+	var blockedID models.ContactID
+	require.NoError(t, db.Get(&blockedID, `SELECT contact_id FROM contacts_contactgroup_contacts WHERE contactgroup_id = $1 ORDER BY contact_id DESC LIMIT 1`, testdata.DoctorsGroup.ID))
+	db.MustExec(`UPDATE contacts_contact SET status = 'B' WHERE id = $1`, blockedID)
+
+	// modify all the active contacts in the doctors group, which takes more than one batch
+	task := &contacts.BulkModifyContactsTask{
+		UUID:      "5a8345c1-514a-4d1b-aee5-6f39b2f53cfa",
+		UserID:    testdata.Admin.ID,
+		GroupID:   testdata.DoctorsGroup.ID,
+		Modifiers: setGender,
+	}
+	err := task.Perform(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	progress, err := models.GetContactModifyProgress(rc, testdata.Org1.ID, "5a8345c1-514a-4d1b-aee5-6f39b2f53cfa")
+	require.NoError(t, err)
+	assert.Equal(t, &models.ContactModifyProgress{Status: models.ContactModifyStatusComplete, Total: 120, Processed: 120}, progress)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM contacts_contact c INNER JOIN contacts_contactgroup_contacts g ON g.contact_id = c.id WHERE g.contactgroup_id = $1 AND c.fields->$2->>'text' = 'M'`, testdata.DoctorsGroup.ID, testdata.GenderField.UUID).Returns(120)
+	assertdb.Query(t, db, `SELECT count(*) FROM contacts_contact WHERE id = $1 AND fields->$2->>'text' = 'M'`, blockedID, testdata.GenderField.UUID).Returns(0)
+	assertdb.Query(t, db, `SELECT org_id, notification_type, scope, user_id FROM notifications_notification WHERE scope = 'modify:5a8345c1-514a-4d1b-aee5-6f39b2f53cfa'`).
+		Columns(map[string]interface{}{"org_id": int64(testdata.Org1.ID), "notification_type": "modify:finished", "scope": "modify:5a8345c1-514a-4d1b-aee5-6f39b2f53cfa", "user_id": int64(testdata.Admin.ID)})
+
+	// modify the contacts matching a query
+	mockES.AddResponse(testdata.Bob.ID, testdata.George.ID)
+
+	task = &contacts.BulkModifyContactsTask{
+		UUID:      "e0b1a5e6-f6ea-4ed3-a0d4-6e1f2a0d2f63",
+		UserID:    testdata.Admin.ID,
+		Query:     "name ~ o",
+		Modifiers: []json.RawMessage{json.RawMessage(`{"type": "name", "name": "Anon"}`)},
+	}
+	err = task.Perform(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	progress, err = models.GetContactModifyProgress(rc, testdata.Org1.ID, "e0b1a5e6-f6ea-4ed3-a0d4-6e1f2a0d2f63")
+	require.NoError(t, err)
+	assert.Equal(t, &models.ContactModifyProgress{Status: models.ContactModifyStatusComplete, Total: 2, Processed: 2}, progress)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM contacts_contact WHERE name = 'Anon'`).Returns(2)
+
+	// a task without a query or group fails
+	task = &contacts.BulkModifyContactsTask{UUID: "b4d5a1f0-5b4e-4bde-92a1-7b0b6a1c9d8e", UserID: testdata.Admin.ID, Modifiers: setGender}
+	err = task.Perform(ctx, rt, testdata.Org1.ID)
+	assert.EqualError(t, err, "bulk contact modify must have a query or group")
+
+	progress, err = models.GetContactModifyProgress(rc, testdata.Org1.ID, "b4d5a1f0-5b4e-4bde-92a1-7b0b6a1c9d8e")
+	require.NoError(t, err)
+	assert.Equal(t, models.ContactModifyStatusFailed, progress.Status)
+
+	// and progress of unknown modifications is nil
+	progress, err = models.GetContactModifyProgress(rc, testdata.Org1.ID, "d3c2b1a0-0000-4000-8000-000000000000")
+	assert.NoError(t, err)
+	assert.Nil(t, progress)
+}
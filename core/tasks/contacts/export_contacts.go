@@ -0,0 +1,296 @@
+package contacts
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/search"
+	"github.com/nyaruka/mailroom/core/tasks"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/utils/xlsx"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// TypeExportContacts is the type of the export contacts task
+const TypeExportContacts = "export_contacts"
+
+// the number of contacts we load at a time when exporting
+const exportBatchSize = 500
+
+// ExportFormat is the file format of an export
+type ExportFormat string
+
+// possible export formats
+const (
+	ExportFormatCSV  = ExportFormat("csv")
+	ExportFormatXLSX = ExportFormat("xlsx")
+)
+
+var exportContentTypes = map[ExportFormat]string{
+	ExportFormatCSV:  "text/csv",
+	ExportFormatXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+func init() {
+	tasks.RegisterType(TypeExportContacts, func() tasks.Task { return &ExportContactsTask{} })
+}
+
+// ExportContactsTask is our task to export the contacts in a group or matching a search to a file in storage
+type ExportContactsTask struct {
+	ExportID models.ContactExportID `json:"export_id"`
+	Format   ExportFormat           `json:"format"`
+	Fields   []string               `json:"fields"`
+	Schemes  []string               `json:"schemes"`
+}
+
+// Timeout is the maximum amount of time the task can run for
+func (t *ExportContactsTask) Timeout() time.Duration {
+	return time.Hour
+}
+
+// Perform writes the export file and notifies the user who created the export
+func (t *ExportContactsTask) Perform(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID) error {
+	export, err := models.LoadContactExport(ctx, rt.DB, t.ExportID)
+	if err != nil {
+		return err
+	}
+
+	if err := export.SetStatus(ctx, rt.DB, models.ContactExportStatusProcessing); err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	path, count, err := t.export(ctx, rt, export)
+	if err != nil {
+		export.SetStatus(ctx, rt.DB, models.ContactExportStatusFailed)
+		return errors.Wrapf(err, "error exporting contacts for export: %d", t.ExportID)
+	}
+
+	if err := export.SetStatus(ctx, rt.DB, models.ContactExportStatusComplete); err != nil {
+		return err
+	}
+
+	if err := models.NotifyExportFinished(ctx, rt.DB, export); err != nil {
+		return errors.Wrapf(err, "error notifying user of finished export")
+	}
+
+	logrus.WithFields(logrus.Fields{"org_id": orgID, "export_id": t.ExportID, "path": path, "count": count, "elapsed": time.Since(start)}).Info("completed contact export")
+
+	return nil
+}
+
+// writes the export file to export storage, returning its path and the number of contacts exported
+func (t *ExportContactsTask) export(ctx context.Context, rt *runtime.Runtime, export *models.ContactExport) (string, int, error) {
+	contentType, ok := exportContentTypes[t.Format]
+	if !ok {
+		return "", 0, errors.Errorf("unsupported export format: %s", t.Format)
+	}
+
+	oa, err := models.GetOrgAssets(ctx, rt, export.OrgID)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "unable to load org assets")
+	}
+
+	contactIDs, err := t.resolveContacts(ctx, rt, oa, export)
+	if err != nil {
+		return "", 0, err
+	}
+
+	// figure out our columns
+	fields := make([]*models.Field, 0, len(t.Fields))
+	for _, key := range t.Fields {
+		field := oa.FieldByKey(key)
+		if field == nil {
+			return "", 0, errors.Errorf("no such field with key: %s", key)
+		}
+		fields = append(fields, field)
+	}
+	groups := make([]*models.Group, 0, len(export.GroupMemberships))
+	for _, id := range export.GroupMemberships {
+		if group := oa.GroupByID(models.GroupID(id)); group != nil {
+			groups = append(groups, group)
+		}
+	}
+
+	header := []string{"Contact UUID", "Name", "Language", "Created On", "Last Seen On"}
+	for _, scheme := range t.Schemes {
+		header = append(header, "URN:"+scheme)
+	}
+	for _, field := range fields {
+		header = append(header, "Field:"+field.Name())
+	}
+	for _, group := range groups {
+		header = append(header, "Group:"+group.Name())
+	}
+
+	// exports can be large so rather than build them in memory, we write them to a temporary file
+	file, err := os.CreateTemp("", "export-*."+string(t.Format))
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "error creating export file")
+	}
+	defer func() {
+		file.Close()
+		os.Remove(file.Name())
+	}()
+
+	writer, err := newExportWriter(t.Format, file)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "error creating export writer")
+	}
+
+	if err := writer.Write(header); err != nil {
+		return "", 0, errors.Wrapf(err, "error writing export header")
+	}
+
+	tz := oa.Env().Timezone()
+
+	for i := 0; i < len(contactIDs); i += exportBatchSize {
+		end := i + exportBatchSize
+		if end > len(contactIDs) {
+			end = len(contactIDs)
+		}
+
+		contacts, err := models.LoadContacts(ctx, rt.ReadonlyDB, oa, contactIDs[i:end])
+		if err != nil {
+			return "", 0, errors.Wrapf(err, "error loading contacts to export")
+		}
+
+		// loaded contacts aren't in any particular order
+		sort.Slice(contacts, func(i, j int) bool { return contacts[i].ID() < contacts[j].ID() })
+
+		for _, c := range contacts {
+			row := []string{string(c.UUID()), c.Name(), string(c.Language()), c.CreatedOn().In(tz).Format(time.RFC3339), ""}
+			if c.LastSeenOn() != nil {
+				row[4] = c.LastSeenOn().In(tz).Format(time.RFC3339)
+			}
+
+			for _, scheme := range t.Schemes {
+				row = append(row, firstURNPath(c.URNs(), scheme))
+			}
+			for _, field := range fields {
+				value := ""
+				if v := c.Fields()[field.Key()]; v != nil {
+					value = v.Text.Native()
+				}
+				row = append(row, value)
+			}
+			for _, group := range groups {
+				row = append(row, exportGroupMembership(c, group))
+			}
+
+			if err := writer.Write(row); err != nil {
+				return "", 0, errors.Wrapf(err, "error writing export row")
+			}
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return "", 0, errors.Wrapf(err, "error closing export writer")
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", 0, errors.Wrapf(err, "error rewinding export file")
+	}
+
+	path := export.StoragePath(rt.Config.S3ExportsPrefix, string(t.Format))
+
+	if _, err := runtime.PutStreamToStorage(ctx, rt.ExportStorage, path, contentType, file); err != nil {
+		return "", 0, errors.Wrapf(err, "error storing export file")
+	}
+
+	return path, len(contactIDs), nil
+}
+
+// resolves the ids of the contacts to export, in order of their ids
+func (t *ExportContactsTask) resolveContacts(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, export *models.ContactExport) ([]models.ContactID, error) {
+	if export.Search == "" && export.GroupID == 0 {
+		return nil, errors.New("export must have a search or group")
+	}
+
+	var groupIDs []models.ContactID
+	if export.GroupID != 0 {
+		var err error
+		groupIDs, err = models.ContactIDsForGroupIDs(ctx, rt.ReadonlyDB, []models.GroupID{export.GroupID})
+		if err != nil {
+			return nil, errors.Wrapf(err, "error loading group contacts to export")
+		}
+	}
+
+	contactIDs := groupIDs
+
+	if export.Search != "" {
+		searchIDs, err := search.GetContactIDsForQuery(ctx, rt, oa, export.Search, -1)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error searching contacts to export")
+		}
+
+		// if we also have a group, only export matches which are in that group
+		if export.GroupID != 0 {
+			inGroup := make(map[models.ContactID]bool, len(groupIDs))
+			for _, id := range groupIDs {
+				inGroup[id] = true
+			}
+			contactIDs = make([]models.ContactID, 0, len(searchIDs))
+			for _, id := range searchIDs {
+				if inGroup[id] {
+					contactIDs = append(contactIDs, id)
+				}
+			}
+		} else {
+			contactIDs = searchIDs
+		}
+	}
+
+	sort.Slice(contactIDs, func(i, j int) bool { return contactIDs[i] < contactIDs[j] })
+
+	return contactIDs, nil
+}
+
+func firstURNPath(contactURNs []urns.URN, scheme string) string {
+	for _, u := range contactURNs {
+		if u.Scheme() == scheme {
+			return u.Path()
+		}
+	}
+	return ""
+}
+
+func exportGroupMembership(c *models.Contact, group *models.Group) string {
+	for _, g := range c.Groups() {
+		if g.ID() == group.ID() {
+			return "true"
+		}
+	}
+	return "false"
+}
+
+// the writers we use for export files all look like CSV writers
+type exportWriter interface {
+	Write([]string) error
+	Close() error
+}
+
+type csvExportWriter struct {
+	*csv.Writer
+}
+
+func (w *csvExportWriter) Close() error {
+	w.Flush()
+	return w.Error()
+}
+
+func newExportWriter(format ExportFormat, w io.Writer) (exportWriter, error) {
+	if format == ExportFormatXLSX {
+		return xlsx.NewWriter(w)
+	}
+	return &csvExportWriter{csv.NewWriter(w)}, nil
+}
@@ -0,0 +1,103 @@
+package contacts_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"testing"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/contacts"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportContactsTask(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	mockES := testsuite.NewMockElasticServer()
+	defer mockES.Close()
+
+	rt.ES = mockES.Client()
+
+	insertExport := func(uuid string, group *testdata.Group, search string) models.ContactExportID {
+		var groupID interface{}
+		if group != nil {
+			groupID = group.ID
+		}
+
+		var id models.ContactExportID
+		must(db.Get(&id,
+			`INSERT INTO contacts_exportcontactstask(is_active, created_on, modified_on, uuid, status, search, created_by_id, group_id, modified_by_id, org_id)
+			 VALUES(TRUE, NOW(), NOW(), $1, 'P', $2, $3, $4, $3, $5) RETURNING id`, uuid, search, testdata.Admin.ID, groupID, testdata.Org1.ID,
+		))
+		db.MustExec(`INSERT INTO contacts_exportcontactstask_group_memberships(exportcontactstask_id, contactgroup_id) VALUES($1, $2)`, id, testdata.DoctorsGroup.ID)
+		return id
+	}
+
+	// export the doctors group as CSV
+	exportID := insertExport("7c1c3e5b-9f4f-4b9e-8a4e-3f6a1d2c5b7e", testdata.DoctorsGroup, "")
+
+	task := &contacts.ExportContactsTask{ExportID: exportID, Format: contacts.ExportFormatCSV, Fields: []string{"gender"}, Schemes: []string{"tel"}}
+	err := task.Perform(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	rows := readExportCSV(t, ctx, rt, "/exports/1/contact_exports/7c1c3e5b-9f4f-4b9e-8a4e-3f6a1d2c5b7e.csv")
+	assert.Equal(t, 122, len(rows))
+	assert.Equal(t, []string{"Contact UUID", "Name", "Language", "Created On", "Last Seen On", "URN:tel", "Field:Gender", "Group:Doctors"}, rows[0])
+	assert.Equal(t, string(testdata.Cathy.UUID), rows[1][0])
+	assert.Equal(t, "Cathy", rows[1][1])
+	assert.Equal(t, "+16055741111", rows[1][5])
+	assert.Equal(t, "F", rows[1][6])
+	assert.Equal(t, "true", rows[1][7])
+
+	// nothing is written to public attachment storage
+	_, _, err = rt.AttachmentStorage.Get(ctx, "/attachments/1/7c1c/3e5b/7c1c3e5b-9f4f-4b9e-8a4e-3f6a1d2c5b7e.csv")
+	assert.Error(t, err)
+
+	assertdb.Query(t, db, `SELECT status FROM contacts_exportcontactstask WHERE id = $1`, exportID).Returns("C")
+	assertdb.Query(t, db, `SELECT count(*) FROM notifications_notification WHERE contact_export_id = $1 AND notification_type = 'export:finished' AND user_id = $2`, exportID, testdata.Admin.ID).Returns(1)
+
+	// export contacts matching a search as XLSX
+	mockES.AddResponse(testdata.Bob.ID, testdata.Cathy.ID)
+	exportID = insertExport("9e0d4c3b-2a1f-4e5d-8c7b-6a5f4e3d2c1b", nil, "name ~ b")
+
+	task = &contacts.ExportContactsTask{ExportID: exportID, Format: contacts.ExportFormatXLSX}
+	err = task.Perform(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	_, content, err := rt.ExportStorage.Get(ctx, "/exports/1/contact_exports/9e0d4c3b-2a1f-4e5d-8c7b-6a5f4e3d2c1b.xlsx")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("PK"), content[:2])
+
+	// an export with an unsupported format fails
+	exportID = insertExport("1f2e3d4c-5b6a-4978-8695-a4b3c2d1e0f9", testdata.DoctorsGroup, "")
+
+	task = &contacts.ExportContactsTask{ExportID: exportID, Format: "pdf"}
+	err = task.Perform(ctx, rt, testdata.Org1.ID)
+	assert.EqualError(t, err, "error exporting contacts for export: "+fmt.Sprint(exportID)+": unsupported export format: pdf")
+
+	assertdb.Query(t, db, `SELECT status FROM contacts_exportcontactstask WHERE id = $1`, exportID).Returns("F")
+}
+
+func readExportCSV(t *testing.T, ctx context.Context, rt *runtime.Runtime, path string) [][]string {
+	_, content, err := rt.ExportStorage.Get(ctx, path)
+	require.NoError(t, err)
+
+	rows, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+	require.NoError(t, err)
+	return rows
+}
+
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
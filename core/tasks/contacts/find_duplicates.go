@@ -0,0 +1,70 @@
+package contacts
+
+import (
+	"context"
+	"time"
+
+	"github.com/nyaruka/gocommon/dates"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks"
+	"github.com/nyaruka/mailroom/runtime"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// TypeFindDuplicateContacts is the type of the find duplicate contacts task
+const TypeFindDuplicateContacts = "find_duplicate_contacts"
+
+func init() {
+	tasks.RegisterType(TypeFindDuplicateContacts, func() tasks.Task { return &FindDuplicateContactsTask{} })
+}
+
+// FindDuplicateContactsTask is our task to generate the report of likely duplicate contacts for an org
+type FindDuplicateContactsTask struct {
+	// contacts are only considered duplicates by name if they have the same values for these fields
+	FieldKeys []string `json:"field_keys"`
+}
+
+// Timeout is the maximum amount of time the task can run for
+func (t *FindDuplicateContactsTask) Timeout() time.Duration {
+	return time.Minute * 30
+}
+
+// Perform finds likely duplicate contacts in the org and saves them as the org's duplicates report
+func (t *FindDuplicateContactsTask) Perform(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID) error {
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	if err := models.MarkDuplicateReportPending(rc, orgID); err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	oa, err := models.GetOrgAssets(ctx, rt, orgID)
+	if err != nil {
+		return errors.Wrapf(err, "error loading org assets for org: %d", orgID)
+	}
+
+	fields := make([]*models.Field, len(t.FieldKeys))
+	for i, key := range t.FieldKeys {
+		fields[i] = oa.FieldByKey(key)
+		if fields[i] == nil {
+			return errors.Errorf("no such contact field with key: %s", key)
+		}
+	}
+
+	candidates, err := models.FindDuplicateContacts(ctx, rt.ReadonlyDB, orgID, fields)
+	if err != nil {
+		return errors.Wrapf(err, "error finding duplicate contacts for org: %d", orgID)
+	}
+
+	if err := models.SaveDuplicateReport(rc, orgID, candidates, dates.Now()); err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{"org_id": orgID, "elapsed": time.Since(start), "candidates": len(candidates)}).Info("completed finding duplicate contacts")
+
+	return nil
+}
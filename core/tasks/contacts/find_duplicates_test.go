@@ -0,0 +1,74 @@
+package contacts_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/contacts"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindDuplicatesTask(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	// no report yet
+	report, _, err := models.GetDuplicateReport(rc, testdata.Org1.ID, 0, 10)
+	assert.NoError(t, err)
+	assert.Nil(t, report)
+
+	// give Bob a WhatsApp URN with the same number as Cathy's phone
+	testdata.InsertContactURN(db, testdata.Org1, testdata.Bob, urns.URN("whatsapp:16055741111"), 1000)
+
+	// give George and Alexandria the same email address
+	testdata.InsertContactURN(db, testdata.Org1, testdata.George, urns.URN("mailto:george@nyaruka.com"), 1000)
+	testdata.InsertContactURN(db, testdata.Org1, testdata.Alexandria, urns.URN("mailto:George@Nyaruka.com"), 1000)
+
+	// give Cathy and George similar names and the same age, but different other field values
+	db.MustExec(`UPDATE contacts_contact SET name = 'Cathy-Jo', fields = '{"903f51da-2717-47c7-a0d3-f2f32877013d": {"text": "30", "number": 30}, "3a5891e4-756e-4dc9-8e12-b7a766168824": {"text": "F"}}' WHERE id = $1`, testdata.Cathy.ID)
+	db.MustExec(`UPDATE contacts_contact SET name = 'cathy joe', fields = '{"903f51da-2717-47c7-a0d3-f2f32877013d": {"text": "30", "number": 30}}' WHERE id = $1`, testdata.George.ID)
+
+	// and give Alexandria the same name as Cathy but a different age
+	db.MustExec(`UPDATE contacts_contact SET name = 'Cathy Jo', fields = '{"903f51da-2717-47c7-a0d3-f2f32877013d": {"text": "31", "number": 31}}' WHERE id = $1`, testdata.Alexandria.ID)
+
+	// can't use a field that doesn't exist
+	task := &contacts.FindDuplicateContactsTask{FieldKeys: []string{"xyz"}}
+	err = task.Perform(ctx, rt, testdata.Org1.ID)
+	assert.EqualError(t, err, "no such contact field with key: xyz")
+
+	task = &contacts.FindDuplicateContactsTask{FieldKeys: []string{"age"}}
+	err = task.Perform(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	report, candidates, err := models.GetDuplicateReport(rc, testdata.Org1.ID, 0, 100)
+	require.NoError(t, err)
+	require.NotNil(t, report)
+	assert.Equal(t, models.DuplicateReportStatusComplete, report.Status)
+	assert.Equal(t, len(candidates), report.Total)
+	assert.NotNil(t, report.GeneratedOn)
+
+	assert.Contains(t, candidates, &models.DuplicateCandidate{Reason: models.DuplicateReasonPhone, Key: "16055741111", ContactIDs: []models.ContactID{testdata.Cathy.ID, testdata.Bob.ID}})
+	assert.Contains(t, candidates, &models.DuplicateCandidate{Reason: models.DuplicateReasonEmail, Key: "george@nyaruka.com", ContactIDs: []models.ContactID{testdata.George.ID, testdata.Alexandria.ID}})
+	assert.Contains(t, candidates, &models.DuplicateCandidate{Reason: models.DuplicateReasonName, Key: "cathyjo", ContactIDs: []models.ContactID{testdata.Cathy.ID, testdata.George.ID}})
+
+	// candidates can be paged through
+	_, page, err := models.GetDuplicateReport(rc, testdata.Org1.ID, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, candidates[1:2], page)
+
+	// marking the report as pending keeps the previous results
+	err = models.MarkDuplicateReportPending(rc, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	report, page, err = models.GetDuplicateReport(rc, testdata.Org1.ID, 0, 100)
+	require.NoError(t, err)
+	assert.Equal(t, models.DuplicateReportStatusPending, report.Status)
+	assert.Equal(t, candidates, page)
+}
@@ -0,0 +1,88 @@
+package contacts
+
+import (
+	"context"
+	"time"
+
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks"
+	"github.com/nyaruka/mailroom/runtime"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// TypeReevaluateSmartGroups is the type of the re-evaluate smart groups task
+const TypeReevaluateSmartGroups = "reevaluate_smart_groups"
+
+// the number of contacts we re-evaluate at a time
+const reevaluateBatchSize = 100
+
+func init() {
+	tasks.RegisterType(TypeReevaluateSmartGroups, func() tasks.Task { return &ReevaluateSmartGroupsTask{} })
+}
+
+// ReevaluateSmartGroupsTask is our task to re-evaluate smart group membership for a set of contacts after they have
+// changed, which only considers groups whose queries reference what changed, rather than repopulating whole groups. It's
+// for changes made outside of the engine, e.g. bulk updates or field definition changes, as the engine already
+// re-evaluates smart groups for the changes it makes.
+type ReevaluateSmartGroupsTask struct {
+	ContactIDs []models.ContactID    `json:"contact_ids"`
+	Changes    models.ContactChanges `json:"changes"`
+}
+
+// Timeout is the maximum amount of time the task can run for
+func (t *ReevaluateSmartGroupsTask) Timeout() time.Duration {
+	return time.Minute * 30
+}
+
+// Perform re-evaluates the affected smart groups for the changed contacts
+func (t *ReevaluateSmartGroupsTask) Perform(ctx context.Context, rt *runtime.Runtime, orgID models.OrgID) error {
+	oa, err := models.GetOrgAssets(ctx, rt, orgID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to load org assets")
+	}
+
+	log := logrus.WithFields(logrus.Fields{"org_id": orgID, "contacts": len(t.ContactIDs)})
+	start := time.Now()
+
+	groups := models.SmartGroupsAffectedBy(oa, &t.Changes)
+	if len(groups) == 0 {
+		log.Debug("no smart groups affected by contact changes")
+		return nil
+	}
+
+	added, removed := 0, 0
+
+	for i := 0; i < len(t.ContactIDs); i += reevaluateBatchSize {
+		end := i + reevaluateBatchSize
+		if end > len(t.ContactIDs) {
+			end = len(t.ContactIDs)
+		}
+
+		contacts, err := models.LoadContacts(ctx, rt.DB, oa, t.ContactIDs[i:end])
+		if err != nil {
+			return errors.Wrapf(err, "error loading contacts")
+		}
+
+		flowContacts := make([]*flows.Contact, len(contacts))
+		for j, c := range contacts {
+			flowContacts[j], err = c.FlowContact(oa)
+			if err != nil {
+				return errors.Wrapf(err, "error creating flow contact for contact: %d", c.ID())
+			}
+		}
+
+		batchAdded, batchRemoved, err := models.ReevaluateSmartGroups(ctx, rt.DB, oa, flowContacts, groups)
+		if err != nil {
+			return errors.Wrapf(err, "error re-evaluating smart groups")
+		}
+		added += batchAdded
+		removed += batchRemoved
+	}
+
+	log.WithFields(logrus.Fields{"groups": len(groups), "added": added, "removed": removed, "elapsed": time.Since(start)}).Info("re-evaluated smart groups for changed contacts")
+
+	return nil
+}
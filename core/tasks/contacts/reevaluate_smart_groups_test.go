@@ -0,0 +1,42 @@
+package contacts_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/contacts"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReevaluateSmartGroupsTask(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	adults := testdata.InsertContactGroup(db, testdata.Org1, "4e5b8f77-0f59-4c8b-a7a4-8f1a1a9f2c01", "Adults", "age > 30")
+
+	db.MustExec(`UPDATE contacts_contact SET fields = '{"903f51da-2717-47c7-a0d3-f2f32877013d": {"text": "40", "number": 40}}' WHERE id = ANY(ARRAY[$1, $2]::int[])`, testdata.Cathy.ID, testdata.George.ID)
+
+	// a change which doesn't affect the group does nothing
+	task := &contacts.ReevaluateSmartGroupsTask{
+		ContactIDs: []models.ContactID{testdata.Cathy.ID, testdata.George.ID},
+		Changes:    models.ContactChanges{Attributes: []string{"language"}},
+	}
+	err := task.Perform(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM contacts_contactgroup_contacts WHERE contactgroup_id = $1`, adults.ID).Returns(0)
+
+	// but a change to age does
+	task = &contacts.ReevaluateSmartGroupsTask{
+		ContactIDs: []models.ContactID{testdata.Cathy.ID, testdata.George.ID},
+		Changes:    models.ContactChanges{Fields: []string{"age"}},
+	}
+	err = task.Perform(ctx, rt, testdata.Org1.ID)
+	require.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM contacts_contactgroup_contacts WHERE contactgroup_id = $1`, adults.ID).Returns(2)
+}
@@ -0,0 +1,35 @@
+package contacts
+
+import (
+	"context"
+	"time"
+
+	"github.com/nyaruka/mailroom"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// the number of field history entries we delete at a time
+const trimFieldHistoryBatchSize = 10000
+
+func init() {
+	mailroom.RegisterCron("trim_field_history", time.Hour, false, TrimFieldHistory)
+}
+
+// TrimFieldHistory deletes contact field history which is older than the retention period of its org
+func TrimFieldHistory(ctx context.Context, rt *runtime.Runtime) error {
+	start := time.Now()
+
+	deleted, err := models.DeleteExpiredFieldHistory(ctx, rt.DB, trimFieldHistoryBatchSize)
+	if err != nil {
+		return errors.Wrap(err, "error trimming field history")
+	}
+
+	if deleted > 0 {
+		logrus.WithField("count", deleted).WithField("elapsed", time.Since(start)).Info("trimmed expired field history")
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package contacts_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/mailroom/core/tasks/contacts"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrimFieldHistory(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	// nothing to do if there's no field history table
+	db.MustExec(`ALTER TABLE contacts_contactfieldhistory RENAME TO contacts_contactfieldhistory_tmp`)
+	err := contacts.TrimFieldHistory(ctx, rt)
+	require.NoError(t, err)
+	db.MustExec(`ALTER TABLE contacts_contactfieldhistory_tmp RENAME TO contacts_contactfieldhistory`)
+
+	// org 1 keeps history for 30 days, org 2 uses the default of a year
+	db.MustExec(`UPDATE orgs_org SET config = '{"contact_field_history": {"retention_days": 30}}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+	db.MustExec(`UPDATE orgs_org SET config = '{"contact_field_history": {}}'::jsonb WHERE id = $1`, testdata.Org2.ID)
+
+	insertHistory := func(org *testdata.Org, contact *testdata.Contact, field *testdata.Field, daysAgo int) {
+		db.MustExec(`INSERT INTO contacts_contactfieldhistory(org_id, contact_id, field_id, old_value, new_value, changed_on) VALUES($1, $2, $3, NULL, 'x', NOW() - make_interval(days => $4))`, org.ID, contact.ID, field.ID, daysAgo)
+	}
+
+	insertHistory(testdata.Org1, testdata.Cathy, testdata.AgeField, 10)
+	insertHistory(testdata.Org1, testdata.Cathy, testdata.AgeField, 40)
+	insertHistory(testdata.Org2, testdata.Org2Contact, testdata.AgeField, 40)
+	insertHistory(testdata.Org2, testdata.Org2Contact, testdata.AgeField, 400)
+
+	err = contacts.TrimFieldHistory(ctx, rt)
+	require.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM contacts_contactfieldhistory WHERE org_id = $1`, testdata.Org1.ID).Returns(1)
+	assertdb.Query(t, db, `SELECT count(*) FROM contacts_contactfieldhistory WHERE org_id = $1`, testdata.Org2.ID).Returns(1)
+}
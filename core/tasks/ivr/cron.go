@@ -30,6 +30,18 @@ func RetryCalls(ctx context.Context, rt *runtime.Runtime) error {
 		return errors.Wrapf(err, "error loading calls to retry")
 	}
 
+	// load the flows of any calls which are part of flow starts in a single query
+	startIDs := make([]models.StartID, 0, len(calls))
+	for _, call := range calls {
+		if call.StartID() != models.NilStartID {
+			startIDs = append(startIDs, call.StartID())
+		}
+	}
+	startFlowIDs, err := models.GetFlowIDsForStarts(ctx, rt.DB, startIDs)
+	if err != nil {
+		return errors.Wrapf(err, "error loading flow starts for calls to retry")
+	}
+
 	throttledChannels := make(map[models.ChannelID]bool)
 	clogs := make([]*models.ChannelLog, 0, len(calls))
 
@@ -62,6 +74,29 @@ func RetryCalls(ctx context.Context, rt *runtime.Runtime) error {
 			continue
 		}
 
+		// retries of calls for flow starts have to respect the calling window of the flow
+		var flow *models.Flow
+		if call.StartID() != models.NilStartID {
+			flowID, found := startFlowIDs[call.StartID()]
+			if !found {
+				log.WithField("start_id", call.StartID()).Error("unable to load flow start")
+				continue
+			}
+			flow, err = oa.FlowByID(flowID)
+			if err != nil {
+				log.WithError(err).WithField("flow_id", flowID).Error("unable to load flow")
+				continue
+			}
+			deferred, err := ivr.DeferCallToWindow(ctx, rt, oa, flow, call)
+			if err != nil {
+				log.WithError(err).Error("error checking call window")
+				continue
+			}
+			if deferred {
+				continue
+			}
+		}
+
 		// finally load the full URN
 		urn, err := models.URNForID(ctx, rt.DB, oa, call.ContactURNID())
 		if err != nil {
@@ -69,7 +104,7 @@ func RetryCalls(ctx context.Context, rt *runtime.Runtime) error {
 			continue
 		}
 
-		clog, err := ivr.RequestStartForCall(ctx, rt, channel, urn, call)
+		clog, err := ivr.RequestStartForCall(ctx, rt, channel, flow, urn, call)
 		if clog != nil {
 			clogs = append(clogs, clog)
 		}
@@ -3,7 +3,9 @@ package ivr_test
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
+	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/gocommon/dbutil/assertdb"
 	"github.com/nyaruka/mailroom/core/ivr"
 	"github.com/nyaruka/mailroom/core/models"
@@ -13,6 +15,7 @@ import (
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/nyaruka/mailroom/testsuite/testdata"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRetries(t *testing.T) {
@@ -73,3 +76,49 @@ func TestRetries(t *testing.T) {
 	assertdb.Query(t, db, `SELECT COUNT(*) FROM ivr_call WHERE contact_id = $1 AND status = $2 AND external_id = $3`,
 		testdata.Cathy.ID, models.CallStatusFailed, "call1").Returns(1)
 }
+
+func TestRetriesDeferredToCallWindow(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+	defer dates.SetNowSource(dates.DefaultNowSource)
+
+	ivr.RegisterServiceType(models.ChannelType("ZZ"), NewMockProvider)
+
+	db.MustExec(`UPDATE channels_channel SET channel_type = 'ZZ', config = '{"max_concurrent_events": 1}' WHERE id = $1`, testdata.TwilioChannel.ID)
+
+	start := models.NewFlowStart(testdata.Org1.ID, models.StartTypeTrigger, models.FlowTypeVoice, testdata.IVRFlow.ID).
+		WithContactIDs([]models.ContactID{testdata.Cathy.ID})
+
+	err := starts.CreateFlowBatches(ctx, rt, start)
+	require.NoError(t, err)
+
+	task, err := queue.PopNextTask(rc, queue.HandlerQueue)
+	require.NoError(t, err)
+	batch := &models.FlowStartBatch{}
+	err = json.Unmarshal(task.Task, batch)
+	require.NoError(t, err)
+
+	service.callError = nil
+	service.callID = ivr.CallID("call1")
+	err = ivrtasks.HandleFlowStartBatch(ctx, rt, batch)
+	require.NoError(t, err)
+
+	// give the flow a calling window and make it 3am in the org's timezone
+	db.MustExec(`UPDATE flows_flow SET metadata = '{"ivr_call_window": {"start": "08:00", "end": "19:00"}}' WHERE id = $1`, testdata.IVRFlow.ID)
+	tz, _ := time.LoadLocation("America/Los_Angeles")
+	dates.SetNowSource(dates.NewFixedNowSource(time.Date(2022, 11, 28, 3, 0, 0, 0, tz)))
+	models.FlushCache()
+
+	db.MustExec(`UPDATE ivr_call SET status = 'E', next_attempt = NOW() WHERE external_id = 'call1';`)
+	service.callID = ivr.CallID("call2")
+
+	err = ivrtasks.RetryCalls(ctx, rt)
+	assert.NoError(t, err)
+
+	// call should be queued until the window opens rather than retried
+	assertdb.Query(t, db, `SELECT COUNT(*) FROM ivr_call WHERE contact_id = $1 AND status = $2 AND external_id = $3 AND next_attempt = $4`,
+		testdata.Cathy.ID, models.CallStatusQueued, "call1", time.Date(2022, 11, 28, 8, 0, 0, 0, tz)).Returns(1)
+}
@@ -0,0 +1,33 @@
+package ivr
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/nyaruka/mailroom"
+	"github.com/nyaruka/mailroom/core/ivr"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	mailroom.AddTaskFunction(queue.ProcessIVRRecording, handleProcessRecordingTask)
+}
+
+func handleProcessRecordingTask(ctx context.Context, rt *runtime.Runtime, task *queue.Task) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+
+	recording := &ivr.Recording{}
+	if err := json.Unmarshal(task.Task, recording); err != nil {
+		return errors.Wrapf(err, "error unmarshalling recording: %s", string(task.Task))
+	}
+
+	if err := ivr.ProcessRecording(ctx, rt, models.OrgID(task.OrgID), recording); err != nil {
+		return errors.Wrapf(err, "error processing recording for msg: %d", recording.MsgID)
+	}
+	return nil
+}
@@ -29,7 +29,25 @@ func handleFlowStartTask(ctx context.Context, rt *runtime.Runtime, task *queue.T
 		return errors.Wrapf(err, "error unmarshalling flow start batch: %s", string(task.Task))
 	}
 
-	return HandleFlowStartBatch(ctx, rt, batch)
+	// check that our start hasn't been paused or cancelled
+	start, err := models.CheckStartBatch(ctx, rt.DB, rt.RP, queue.StartIVRFlowBatch, batch)
+	if err != nil {
+		return errors.Wrapf(err, "error checking start control state")
+	}
+	if !start {
+		return nil
+	}
+
+	if err := HandleFlowStartBatch(ctx, rt, batch); err != nil {
+		return err
+	}
+
+	if batch.StartID() != models.NilStartID {
+		if err := models.RecordStartBatch(rt.RP, batch.StartID(), len(batch.ContactIDs()), 0); err != nil {
+			logrus.WithError(err).Error("error recording start batch")
+		}
+	}
+	return nil
 }
 
 // HandleFlowStartBatch starts a batch of contacts in an IVR flow
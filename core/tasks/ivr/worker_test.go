@@ -9,6 +9,7 @@ import (
 	"github.com/nyaruka/gocommon/dbutil/assertdb"
 	"github.com/nyaruka/gocommon/httpx"
 	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/mailroom/core/ivr"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/core/queue"
@@ -80,7 +81,7 @@ type MockService struct {
 	callError error
 }
 
-func (s *MockService) RequestCall(number urns.URN, handleURL string, statusURL string, machineDetection bool) (ivr.CallID, *httpx.Trace, error) {
+func (s *MockService) RequestCall(number urns.URN, handleURL string, statusURL string, machineDetection ivr.MachineDetection) (ivr.CallID, *httpx.Trace, error) {
 	return s.callID, nil, s.callError
 }
 
@@ -104,6 +105,14 @@ func (s *MockService) WriteEmptyResponse(w http.ResponseWriter, msg string) erro
 	return nil
 }
 
+func (s *MockService) RedirectCall(callID string, url string) (*httpx.Trace, error) {
+	return nil, nil
+}
+
+func (s *MockService) WriteVoicemailResponse(ctx context.Context, rt *runtime.Runtime, channel *models.Channel, call *models.Call, number urns.URN, msg *flows.MsgOut, w http.ResponseWriter) error {
+	return nil
+}
+
 func (s *MockService) ResumeForRequest(r *http.Request) (ivr.Resume, error) {
 	return nil, nil
 }
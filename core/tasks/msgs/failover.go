@@ -0,0 +1,59 @@
+package msgs
+
+import (
+	"context"
+	"time"
+
+	"github.com/nyaruka/mailroom"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/msgio"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	mailroom.RegisterCron("failover_failed_messages", time.Second*60, false, FailoverFailedMessages)
+}
+
+// FailoverFailedMessages re-creates messages which failed permanently on channels with a failover policy, on the
+// contact's next best URN and channel
+func FailoverFailedMessages(ctx context.Context, rt *runtime.Runtime) error {
+	start := time.Now()
+
+	msgs, err := models.GetMessagesForFailover(ctx, rt.DB)
+	if err != nil {
+		return errors.Wrap(err, "error fetching failed messages to fail over")
+	}
+	if len(msgs) == 0 {
+		return nil // nothing to fail over
+	}
+
+	// organize messages by org
+	byOrg := make(map[models.OrgID][]*models.Msg)
+	for _, m := range msgs {
+		byOrg[m.OrgID()] = append(byOrg[m.OrgID()], m)
+	}
+
+	numFailedOver := 0
+
+	for orgID, orgMsgs := range byOrg {
+		oa, err := models.GetOrgAssets(ctx, rt, orgID)
+		if err != nil {
+			return errors.Wrapf(err, "error loading org assets for org %d", orgID)
+		}
+
+		replacements, err := models.FailoverMessages(ctx, rt, oa, orgMsgs)
+		if err != nil {
+			return errors.Wrapf(err, "error failing over messages for org %d", orgID)
+		}
+
+		msgio.SendMessages(ctx, rt, rt.DB, nil, replacements)
+
+		numFailedOver += len(replacements)
+	}
+
+	logrus.WithField("count", len(msgs)).WithField("failed_over", numFailedOver).WithField("elapsed", time.Since(start)).Info("failed over failed messages")
+
+	return nil
+}
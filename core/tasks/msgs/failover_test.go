@@ -0,0 +1,54 @@
+package msgs_test
+
+import (
+	"testing"
+
+	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/tasks/msgs"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailoverFailedMessages(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	// nothing to fail over
+	err := msgs.FailoverFailedMessages(ctx, rt)
+	require.NoError(t, err)
+
+	testsuite.AssertCourierQueues(t, map[string][]int{})
+
+	db.MustExec(`UPDATE channels_channel SET config = '{"msg_failover": {}}' WHERE id = $1`, testdata.TwilioChannel.ID)
+	testdata.InsertContactURN(db, testdata.Org1, testdata.Cathy, urns.URN("twitterid:123456"), 999)
+
+	// failed messages on a channel without a failover policy are ignored
+	testdata.InsertOutgoingMsg(db, testdata.Org1, testdata.VonageChannel, testdata.Cathy, "Hi", nil, models.MsgStatusFailed, false)
+
+	// as are failed messages for contacts with nowhere else to send to
+	testdata.InsertOutgoingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.Bob, "Hi", nil, models.MsgStatusFailed, false)
+
+	failed := testdata.InsertOutgoingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.Cathy, "Hi", nil, models.MsgStatusFailed, false)
+
+	models.FlushCache()
+
+	err = msgs.FailoverFailedMessages(ctx, rt)
+	require.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE direction = 'O' AND status = 'Q' AND channel_id = $1 AND metadata::jsonb->>'failover_of' = $2`, testdata.TwitterChannel.ID, failed.UUID()).Returns(1)
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE metadata::jsonb ? 'failed_over_to'`).Returns(2)
+
+	testsuite.AssertCourierQueues(t, map[string][]int{
+		"msgs:0f661e8b-ea9d-4bd3-9953-d368340acf91|10/0": {1}, // twitter, bulk priority
+	})
+
+	// failing over again does nothing
+	err = msgs.FailoverFailedMessages(ctx, rt)
+	require.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE metadata::jsonb ? 'failover_of'`).Returns(1)
+}
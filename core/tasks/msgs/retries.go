@@ -16,6 +16,8 @@ func init() {
 	mailroom.RegisterCron("retry_errored_messages", time.Second*60, false, RetryErroredMessages)
 }
 
+// RetryErroredMessages queues errored messages which are due a retry, and pending messages which were held until their
+// send window opened
 func RetryErroredMessages(ctx context.Context, rt *runtime.Runtime) error {
 	rc := rt.RP.Get()
 	defer rc.Close()
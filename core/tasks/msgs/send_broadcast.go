@@ -10,6 +10,7 @@ import (
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/core/msgio"
 	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/core/search"
 	"github.com/nyaruka/mailroom/runtime"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -44,26 +45,44 @@ func handleSendBroadcast(ctx context.Context, rt *runtime.Runtime, task *queue.T
 
 // CreateBroadcastBatches takes our master broadcast and creates batches of broadcast sends for all the unique contacts
 func CreateBroadcastBatches(ctx context.Context, rt *runtime.Runtime, bcast *models.Broadcast) error {
-	// we are building a set of contact ids, start with the explicit ones
-	contactIDs := make(map[models.ContactID]bool)
-	for _, id := range bcast.ContactIDs() {
-		contactIDs[id] = true
-	}
-
-	groupContactIDs, err := models.ContactIDsForGroupIDs(ctx, rt.DB, bcast.GroupIDs())
-	for _, id := range groupContactIDs {
-		contactIDs[id] = true
-	}
-
 	oa, err := models.GetOrgAssets(ctx, rt, bcast.OrgID())
 	if err != nil {
 		return errors.Wrapf(err, "error getting org assets")
 	}
 
-	// get the contact ids for our URNs
-	urnMap, err := models.GetOrCreateContactIDsFromURNs(ctx, rt.DB, oa, bcast.URNs())
-	if err != nil {
-		return errors.Wrapf(err, "error getting contact ids for urns")
+	// we are building a set of contact ids
+	contactIDs := make(map[models.ContactID]bool)
+
+	if bcast.Query() != "" {
+		// a query includes the broadcast's groups and contacts with exclusions applied to all of them, so it replaces them
+		matches, err := search.GetContactIDsForQuery(ctx, rt, oa, bcast.Query(), -1)
+		if err != nil {
+			return errors.Wrapf(err, "error performing search for broadcast: %d", bcast.ID())
+		}
+		for _, id := range matches {
+			contactIDs[id] = true
+		}
+	} else {
+		for _, id := range bcast.ContactIDs() {
+			contactIDs[id] = true
+		}
+
+		groupContactIDs, err := models.ContactIDsForGroupIDs(ctx, rt.DB, bcast.GroupIDs())
+		if err != nil {
+			return errors.Wrapf(err, "error getting contact ids for groups")
+		}
+		for _, id := range groupContactIDs {
+			contactIDs[id] = true
+		}
+	}
+
+	// get the contact ids for our URNs, unless we have a query which already includes them with exclusions applied
+	urnMap := make(map[urns.URN]models.ContactID)
+	if bcast.Query() == "" {
+		urnMap, err = models.GetOrCreateContactIDsFromURNs(ctx, rt.DB, oa, bcast.URNs())
+		if err != nil {
+			return errors.Wrapf(err, "error getting contact ids for urns")
+		}
 	}
 
 	urnContacts := make(map[models.ContactID]urns.URN)
@@ -85,6 +104,20 @@ func CreateBroadcastBatches(ctx context.Context, rt *runtime.Runtime, bcast *mod
 		urnContacts[id] = u
 	}
 
+	// if the broadcast was cancelled before we got here, don't queue any batches
+	if bcast.ID() != models.NilBroadcastID {
+		control, err := models.GetBroadcastControl(rt.RP, bcast.ID())
+		if err != nil {
+			return errors.Wrapf(err, "error getting broadcast control state")
+		}
+		if control.State == models.ControlStateCancelled {
+			if err := models.RecordBroadcastBatch(rt.RP, bcast.ID(), 0, len(contactIDs)+len(urnContacts)); err != nil {
+				return errors.Wrapf(err, "error recording skipped contacts for broadcast")
+			}
+			return models.MarkBroadcastInterrupted(ctx, rt.DB, bcast.ID())
+		}
+	}
+
 	rc := rt.RP.Get()
 	defer rc.Close()
 
@@ -149,6 +182,15 @@ func handleSendBroadcastBatch(ctx context.Context, rt *runtime.Runtime, task *qu
 
 // SendBroadcastBatch sends the passed in broadcast batch
 func SendBroadcastBatch(ctx context.Context, rt *runtime.Runtime, bcast *models.BroadcastBatch) error {
+	// check that our broadcast hasn't been paused or cancelled
+	send, err := models.CheckBroadcastBatch(ctx, rt.DB, rt.RP, queue.SendBroadcastBatch, bcast)
+	if err != nil {
+		return errors.Wrapf(err, "error checking broadcast control state")
+	}
+	if !send {
+		return nil
+	}
+
 	// always set our broadcast as sent if it is our last
 	defer func() {
 		if bcast.IsLast {
@@ -171,5 +213,11 @@ func SendBroadcastBatch(ctx context.Context, rt *runtime.Runtime, bcast *models.
 	}
 
 	msgio.SendMessages(ctx, rt, rt.DB, nil, msgs)
+
+	if bcast.BroadcastID != models.NilBroadcastID {
+		if err := models.RecordBroadcastBatch(rt.RP, bcast.BroadcastID, len(bcast.ContactIDs)+len(bcast.URNs), 0); err != nil {
+			logrus.WithError(err).Error("error recording broadcast batch")
+		}
+	}
 	return nil
 }
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/nyaruka/gocommon/dbutil/assertdb"
+	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/goflow/envs"
@@ -19,6 +20,7 @@ import (
 	"github.com/nyaruka/mailroom/testsuite/testdata"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBroadcastEvents(t *testing.T) {
@@ -289,3 +291,141 @@ func TestBroadcastTask(t *testing.T) {
 
 	assertdb.Query(t, db, `SELECT SUM(count) FROM tickets_ticketdailytiming WHERE count_type = 'R' AND scope = CONCAT('o:', $1::text)`, testdata.Org1.ID).Returns(1)
 }
+
+func TestBroadcastWithQuery(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	mockES := testsuite.NewMockElasticServer()
+	defer mockES.Close()
+
+	rt.ES = mockES.Client()
+
+	// query matches Bob and George, and Cathy who is also included explicitly is excluded by it, as is Alexandria
+	// who is included by URN
+	mockES.AddResponse(testdata.Bob.ID, testdata.George.ID)
+
+	eng := envs.Language("eng")
+	bcast := models.NewBroadcast(
+		testdata.Org1.ID,
+		models.NilBroadcastID,
+		map[envs.Language]*models.BroadcastTranslation{eng: {Text: "hello query"}},
+		models.TemplateStateEvaluated,
+		eng,
+		[]urns.URN{testdata.Alexandria.URN},
+		[]models.ContactID{testdata.Cathy.ID},
+		nil,
+		models.NilTicketID,
+		models.NilUserID,
+	).WithQuery(`gender = "M" AND flow = ""`)
+
+	err := msgs.CreateBroadcastBatches(ctx, rt, bcast)
+	require.NoError(t, err)
+
+	task, err := queue.PopNextTask(rc, queue.BatchQueue)
+	require.NoError(t, err)
+	require.NotNil(t, task)
+
+	batch := &models.BroadcastBatch{}
+	jsonx.MustUnmarshal(task.Task, batch)
+	assert.ElementsMatch(t, []models.ContactID{testdata.Bob.ID, testdata.George.ID}, batch.ContactIDs)
+	assert.Len(t, batch.URNs, 0)
+
+	err = msgs.SendBroadcastBatch(ctx, rt, batch)
+	require.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE text = 'hello query'`).Returns(2)
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE text = 'hello query' AND contact_id = $1`, testdata.Cathy.ID).Returns(0)
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE text = 'hello query' AND contact_id = $1`, testdata.Alexandria.ID).Returns(0)
+
+	// and no more batches were queued
+	task, err = queue.PopNextTask(rc, queue.BatchQueue)
+	require.NoError(t, err)
+	assert.Nil(t, task)
+}
+
+func TestBroadcastPauseAndCancel(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	eng := envs.Language("eng")
+	bcastID := testdata.InsertBroadcast(db, testdata.Org1, eng, map[envs.Language]string{eng: "hello doctors"}, models.NilScheduleID, nil, nil)
+	bcast := models.NewBroadcast(
+		testdata.Org1.ID,
+		bcastID,
+		map[envs.Language]*models.BroadcastTranslation{eng: {Text: "hello doctors"}},
+		models.TemplateStateEvaluated,
+		eng,
+		nil,
+		nil,
+		[]models.GroupID{testdata.DoctorsGroup.ID},
+		models.NilTicketID,
+		models.NilUserID,
+	)
+
+	// pause the broadcast before it starts sending
+	_, _, err := models.SetBroadcastControlState(rp, bcastID, models.ControlStatePaused)
+	require.NoError(t, err)
+
+	err = msgs.CreateBroadcastBatches(ctx, rt, bcast)
+	require.NoError(t, err)
+
+	// both batches are parked rather than sent
+	for {
+		task, err := queue.PopNextTask(rc, queue.BatchQueue)
+		require.NoError(t, err)
+		if task == nil {
+			break
+		}
+		batch := &models.BroadcastBatch{}
+		jsonx.MustUnmarshal(task.Task, batch)
+
+		err = msgs.SendBroadcastBatch(ctx, rt, batch)
+		require.NoError(t, err)
+	}
+
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE text = 'hello doctors'`).Returns(0)
+
+	// resume and send the first parked batch
+	_, parked, err := models.SetBroadcastControlState(rp, bcastID, models.ControlStateRunning)
+	require.NoError(t, err)
+	require.Len(t, parked, 2)
+
+	batch1 := &models.BroadcastBatch{}
+	jsonx.MustUnmarshal(parked[0].Task, batch1)
+	err = msgs.SendBroadcastBatch(ctx, rt, batch1)
+	require.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE text = 'hello doctors'`).Returns(100)
+
+	// cancel before the last batch is sent
+	_, _, err = models.SetBroadcastControlState(rp, bcastID, models.ControlStateCancelled)
+	require.NoError(t, err)
+
+	batch2 := &models.BroadcastBatch{}
+	jsonx.MustUnmarshal(parked[1].Task, batch2)
+	assert.True(t, batch2.IsLast)
+	err = msgs.SendBroadcastBatch(ctx, rt, batch2)
+	require.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE text = 'hello doctors'`).Returns(100)
+	assertdb.Query(t, db, `SELECT status FROM msgs_broadcast WHERE id = $1`, bcastID).Returns("F")
+
+	control, err := models.GetBroadcastControl(rp, bcastID)
+	require.NoError(t, err)
+	assert.Equal(t, &models.BatchControl{State: models.ControlStateCancelled, Processed: 100, Skipped: 21}, control)
+
+	// a cancelled broadcast won't be split into any batches
+	err = msgs.CreateBroadcastBatches(ctx, rt, bcast)
+	require.NoError(t, err)
+
+	task, err := queue.PopNextTask(rc, queue.BatchQueue)
+	require.NoError(t, err)
+	assert.Nil(t, task)
+}
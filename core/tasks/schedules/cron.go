@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/mailroom"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/core/queue"
@@ -12,6 +13,9 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// the maximum number of missed fires we'll catch up on for a single schedule
+const maxCatchUpFires = 100
+
 func init() {
 	mailroom.RegisterCron("fire_schedules", time.Minute*1, false, checkSchedules)
 }
@@ -34,10 +38,19 @@ func checkSchedules(ctx context.Context, rt *runtime.Runtime) error {
 		return errors.Wrapf(err, "error while getting unfired schedules")
 	}
 
+	// fire history is only recorded if there's somewhere to record it
+	recordHistory, err := models.ScheduleFireHistoryEnabled(ctx, rt.DB)
+	if err != nil {
+		return err
+	}
+
 	// for each unfired schedule
 	broadcasts := 0
 	triggers := 0
 	noops := 0
+	skipped := 0
+
+	overdueLimit := time.Minute * time.Duration(rt.Config.ScheduleOverdueLimit)
 
 	for _, s := range unfired {
 		log := log.WithField("schedule_id", s.ID())
@@ -50,13 +63,26 @@ func checkSchedules(ctx context.Context, rt *runtime.Runtime) error {
 			continue
 		}
 
-		// calculate our next fire
-		nextFire, err := s.GetNextFire(tz, now)
+		// calculate the fires we were due to make and our next fire
+		dueFires, nextFire, err := s.GetDueFires(tz, now, maxCatchUpFires)
 		if err != nil {
 			log.WithError(err).Error("error calculating next fire for schedule")
 			continue
 		}
 
+		// normally we fire once, but if we're overdue, apply our catch up policy
+		toFire, toSkip := dueFires[:1], dueFires[1:]
+		if now.Sub(dueFires[0]) > overdueLimit {
+			log.WithField("due_fires", len(dueFires)).WithField("catch_up", s.CatchUp()).Warn("schedule is overdue")
+
+			switch s.CatchUp() {
+			case models.ScheduleCatchUpAll:
+				toFire, toSkip = dueFires, nil
+			case models.ScheduleCatchUpSkip:
+				toFire, toSkip = nil, dueFires
+			}
+		}
+
 		// open a transaction for committing all the items for this fire
 		tx, err := rt.DB.BeginTxx(ctx, nil)
 		if err != nil {
@@ -64,65 +90,80 @@ func checkSchedules(ctx context.Context, rt *runtime.Runtime) error {
 			continue
 		}
 
-		var task interface{}
-		var taskName string
+		fires := make([]*models.ScheduleFire, 0, len(dueFires))
+		tasks := make([]*queue.Task, 0, len(toFire))
 
-		// if it is a broadcast
-		if s.Broadcast() != nil {
-			// clone our broadcast, our schedule broadcast is just a template
-			bcast, err := models.InsertChildBroadcast(ctx, tx, s.Broadcast())
+		for _, scheduled := range toFire {
+			fire := models.NewScheduleFire(s.ID(), scheduled, now, models.ScheduleFireResultFired)
+
+			var task *queue.Task
+			task, err = fireSchedule(ctx, tx, s, fire)
 			if err != nil {
-				log.WithError(err).Error("error inserting new broadcast for schedule")
-				tx.Rollback()
-				continue
+				break
 			}
 
-			// add our task to send this broadcast
-			task = bcast
-			taskName = queue.SendBroadcast
-			broadcasts++
-
-		} else if s.FlowStart() != nil {
-			start := s.FlowStart()
+			if task == nil {
+				log.Info("schedule found with no associated active broadcast or trigger, ignoring")
+				noops++
+			} else if task.Type == queue.SendBroadcast {
+				broadcasts++
+			} else {
+				triggers++
+			}
 
-			// insert our flow start
-			err := models.InsertFlowStarts(ctx, tx, []*models.FlowStart{start})
-			if err != nil {
-				log.WithError(err).Error("error inserting new flow start for schedule")
-				tx.Rollback()
-				continue
+			fires = append(fires, fire)
+			if task != nil {
+				tasks = append(tasks, task)
 			}
+		}
 
-			// add our flow start task
-			task = start
-			taskName = queue.StartFlow
-			triggers++
-		} else {
-			log.Info("schedule found with no associated active broadcast or trigger, ignoring")
-			noops++
+		if err == nil {
+			for _, scheduled := range toSkip {
+				fires = append(fires, models.NewScheduleFire(s.ID(), scheduled, now, models.ScheduleFireResultSkipped))
+				skipped++
+			}
 		}
 
-		// update our next fire for this schedule
-		err = s.UpdateFires(ctx, tx, now, nextFire)
-		if err != nil {
-			log.WithError(err).Error("error updating next fire for schedule")
-			tx.Rollback()
-			continue
+		// update our next fire for this schedule, last fire only changes if we actually fired
+		if err == nil {
+			lastFire := s.LastFire()
+			if len(toFire) > 0 {
+				lastFire = &now
+			}
+
+			err = s.UpdateFires(ctx, tx, lastFire, nextFire)
 		}
 
 		// commit our transaction
-		err = tx.Commit()
+		if err == nil {
+			err = tx.Commit()
+		}
+
 		if err != nil {
-			log.WithError(err).Error("error comitting schedule transaction")
+			log.WithError(err).Error("error firing schedule")
 			tx.Rollback()
+
+			// record the error in our fire history, schedule is still due so will be tried again
+			if recordHistory {
+				if err := models.RecordScheduleFireError(ctx, rt.DB, s.ID(), dueFires[0], now, err); err != nil {
+					log.WithError(err).Error("error recording schedule fire error")
+				}
+			}
 			continue
 		}
 
-		// add our task if we have one
-		if task != nil {
-			err = queue.AddTask(rc, queue.BatchQueue, taskName, int(s.OrgID()), task, queue.HighPriority)
+		// record our fire history outside of the fire's transaction so that a failure here can't stop a fire
+		if recordHistory {
+			if err := models.InsertScheduleFires(ctx, rt.DB, fires); err != nil {
+				log.WithError(err).Error("error recording schedule fire history")
+			}
+		}
+
+		// add our tasks
+		for _, task := range tasks {
+			err = queue.AddTask(rc, queue.BatchQueue, task.Type, int(s.OrgID()), task.Task, queue.HighPriority)
 			if err != nil {
-				log.WithError(err).Error("error firing task with name: ", taskName)
+				log.WithError(err).Error("error firing task with name: ", task.Type)
 			}
 		}
 	}
@@ -131,8 +172,44 @@ func checkSchedules(ctx context.Context, rt *runtime.Runtime) error {
 		"broadcasts": broadcasts,
 		"triggers":   triggers,
 		"noops":      noops,
+		"skipped":    skipped,
 		"elapsed":    time.Since(start),
 	}).Info("fired schedules")
 
 	return nil
 }
+
+// creates the broadcast or flow start for a single fire of the given schedule, returning the task to be queued
+func fireSchedule(ctx context.Context, tx models.Queryer, s *models.Schedule, fire *models.ScheduleFire) (*queue.Task, error) {
+	// if it is a broadcast
+	if s.Broadcast() != nil {
+		// clone our broadcast, our schedule broadcast is just a template
+		bcast, err := models.InsertChildBroadcast(ctx, tx, s.Broadcast())
+		if err != nil {
+			return nil, errors.Wrapf(err, "error inserting new broadcast for schedule")
+		}
+
+		fire.BroadcastID = bcast.ID()
+
+		// add our task to send this broadcast
+		return &queue.Task{Type: queue.SendBroadcast, Task: jsonx.MustMarshal(bcast)}, nil
+
+	} else if s.FlowStart() != nil {
+		// copy our flow start, our schedule flow start is also a template
+		start := &models.FlowStart{}
+		jsonx.MustUnmarshal(jsonx.MustMarshal(s.FlowStart()), start)
+
+		// insert our flow start
+		err := models.InsertFlowStarts(ctx, tx, []*models.FlowStart{start})
+		if err != nil {
+			return nil, errors.Wrapf(err, "error inserting new flow start for schedule")
+		}
+
+		fire.StartID = start.ID()
+
+		// add our flow start task
+		return &queue.Task{Type: queue.StartFlow, Task: jsonx.MustMarshal(start)}, nil
+	}
+
+	return nil, nil
+}
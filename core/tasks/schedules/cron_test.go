@@ -1,7 +1,9 @@
 package schedules
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/nyaruka/gocommon/dbutil/assertdb"
 	"github.com/nyaruka/goflow/envs"
@@ -93,6 +95,11 @@ func TestCheckSchedules(t *testing.T) {
 	// we shouldn't have any pending schedules since there were all one time fires, but all should have last fire
 	assertdb.Query(t, db, `SELECT count(*) FROM schedules_schedule WHERE next_fire IS NULL and last_fire < NOW();`).Returns(3)
 
+	// and each fire should be recorded in the schedule's history
+	assertdb.Query(t, db, `SELECT count(*) FROM schedules_schedulefire WHERE schedule_id = $1 AND result = 'F' AND broadcast_id = 2`, s1).Returns(1)
+	assertdb.Query(t, db, `SELECT count(*) FROM schedules_schedulefire WHERE schedule_id = $1 AND result = 'F' AND start_id = 1`, s2).Returns(1)
+	assertdb.Query(t, db, `SELECT count(*) FROM schedules_schedulefire WHERE schedule_id = $1 AND result = 'F' AND broadcast_id IS NULL AND start_id IS NULL`, s3).Returns(1)
+
 	// check the tasks created
 	task, err := queue.PopNextTask(rc, queue.BatchQueue)
 
@@ -112,3 +119,88 @@ func TestCheckSchedules(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Nil(t, task)
 }
+
+func TestCheckSchedulesCatchUp(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	// org 1 is in Los Angeles, make daily schedules which were due 50 hours ago so have missed 3 fires
+	la, _ := time.LoadLocation("America/Los_Angeles")
+	due := time.Now().In(la).Add(-50 * time.Hour).Truncate(time.Minute)
+
+	insertSchedule := func(name string) models.ScheduleID {
+		var id models.ScheduleID
+		err := db.Get(
+			&id,
+			`INSERT INTO schedules_schedule(is_active, repeat_period, repeat_hour_of_day, repeat_minute_of_hour, created_on, modified_on, next_fire, created_by_id, modified_by_id, org_id)
+				VALUES(TRUE, 'D', $1, $2, NOW(), NOW(), $3, 1, 1, $4) RETURNING id`,
+			due.Hour(), due.Minute(), due, testdata.Org1.ID,
+		)
+		assert.NoError(t, err)
+
+		testdata.InsertBroadcast(db, testdata.Org1, "eng", map[envs.Language]string{"eng": fmt.Sprintf("Catch up %s", name)}, id, []*testdata.Contact{testdata.Cathy}, nil)
+		return id
+	}
+
+	once := insertSchedule("once")
+	all := insertSchedule("all")
+	skip := insertSchedule("skip")
+
+	db.MustExec(`UPDATE schedules_schedule SET catch_up = 'A' WHERE id = $1`, all)
+	db.MustExec(`UPDATE schedules_schedule SET catch_up = 'S' WHERE id = $1`, skip)
+
+	err := checkSchedules(ctx, rt)
+	assert.NoError(t, err)
+
+	// by default we fire once and skip the rest
+	assertdb.Query(t, db, `SELECT count(*) FROM schedules_schedulefire WHERE schedule_id = $1 AND result = 'F'`, once).Returns(1)
+	assertdb.Query(t, db, `SELECT count(*) FROM schedules_schedulefire WHERE schedule_id = $1 AND result = 'S'`, once).Returns(2)
+
+	// or fire for every missed fire
+	assertdb.Query(t, db, `SELECT count(*) FROM schedules_schedulefire WHERE schedule_id = $1 AND result = 'F'`, all).Returns(3)
+
+	// or skip them all
+	assertdb.Query(t, db, `SELECT count(*) FROM schedules_schedulefire WHERE schedule_id = $1 AND result = 'S'`, skip).Returns(3)
+
+	// skipping schedules don't get a last fire, but all are now due in the future
+	assertdb.Query(t, db, `SELECT count(*) FROM schedules_schedule WHERE id = ANY(ARRAY[$1, $2]::int[]) AND last_fire IS NOT NULL`, once, all).Returns(2)
+	assertdb.Query(t, db, `SELECT count(*) FROM schedules_schedule WHERE id = $1 AND last_fire IS NULL`, skip).Returns(1)
+	assertdb.Query(t, db, `SELECT count(*) FROM schedules_schedule WHERE id = ANY(ARRAY[$1, $2, $3]::int[]) AND next_fire > NOW()`, once, all, skip).Returns(3)
+
+	// 4 broadcasts were created and queued
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_broadcast WHERE parent_id IS NOT NULL`).Returns(4)
+	assert.Equal(t, 4, len(testsuite.CurrentOrgTasks(t, rp)[testdata.Org1.ID]))
+}
+
+func TestCheckSchedulesWithoutHistory(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	// databases without the fire history table still fire schedules
+	db.MustExec(`ALTER TABLE schedules_schedulefire RENAME TO schedules_schedulefire_tmp`)
+	defer db.MustExec(`ALTER TABLE schedules_schedulefire_tmp RENAME TO schedules_schedulefire`)
+
+	var s1 models.ScheduleID
+	err := db.Get(
+		&s1,
+		`INSERT INTO schedules_schedule(is_active, repeat_period, created_on, modified_on, next_fire, created_by_id, modified_by_id, org_id)
+			VALUES(TRUE, 'O', NOW(), NOW(), NOW()- INTERVAL '1 DAY', 1, 1, $1) RETURNING id`,
+		testdata.Org1.ID,
+	)
+	assert.NoError(t, err)
+
+	testdata.InsertBroadcast(db, testdata.Org1, "eng", map[envs.Language]string{"eng": "Test message"}, s1, []*testdata.Contact{testdata.Cathy}, nil)
+
+	err = checkSchedules(ctx, rt)
+	assert.NoError(t, err)
+
+	assertdb.Query(t, db, `SELECT count(*) FROM schedules_schedule WHERE id = $1 AND next_fire IS NULL AND last_fire IS NOT NULL`, s1).Returns(1)
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_broadcast WHERE parent_id IS NOT NULL`).Returns(1)
+	assert.Equal(t, 1, len(testsuite.CurrentOrgTasks(t, rp)[testdata.Org1.ID]))
+}
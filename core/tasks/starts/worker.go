@@ -121,7 +121,7 @@ func CreateFlowBatches(ctx context.Context, rt *runtime.Runtime, start *models.F
 		if start.Type() == models.StartTypeFlowAction {
 			limit = 1
 		}
-		matches, err := search.GetContactIDsForQuery(ctx, rt.ES, oa, start.Query(), limit)
+		matches, err := search.GetContactIDsForQuery(ctx, rt, oa, start.Query(), limit)
 		if err != nil {
 			return errors.Wrapf(err, "error performing search for start: %d", start.ID())
 		}
@@ -158,6 +158,18 @@ func CreateFlowBatches(ctx context.Context, rt *runtime.Runtime, start *models.F
 		return errors.Wrapf(err, "error marking start as started")
 	}
 
+	// if the start was cancelled before we got here, don't queue any batches
+	control, err := models.GetStartControl(rt.RP, start.ID())
+	if err != nil {
+		return errors.Wrapf(err, "error getting start control state")
+	}
+	if control.State == models.ControlStateCancelled {
+		if err := models.RecordStartBatch(rt.RP, start.ID(), 0, len(contactIDs)); err != nil {
+			return errors.Wrapf(err, "error recording skipped contacts for start")
+		}
+		return models.MarkStartInterrupted(ctx, rt.DB, start.ID())
+	}
+
 	// if there are no contacts to start, mark our start as complete, we are done
 	if len(contactIDs) == 0 {
 		err = models.MarkStartComplete(ctx, rt.DB, start.ID())
@@ -221,11 +233,26 @@ func handleFlowStartBatch(ctx context.Context, rt *runtime.Runtime, task *queue.
 		return errors.Wrapf(err, "error unmarshalling flow start batch: %s", string(task.Task))
 	}
 
+	// check that our start hasn't been paused or cancelled
+	start, err := models.CheckStartBatch(ctx, rt.DB, rt.RP, queue.StartFlowBatch, startBatch)
+	if err != nil {
+		return errors.Wrapf(err, "error checking start control state")
+	}
+	if !start {
+		return nil
+	}
+
 	// start these contacts in our flow
 	_, err = runner.StartFlowBatch(ctx, rt, startBatch)
 	if err != nil {
 		return errors.Wrapf(err, "error starting flow batch: %s", string(task.Task))
 	}
 
-	return err
+	if startBatch.StartID() != models.NilStartID {
+		if err := models.RecordStartBatch(rt.RP, startBatch.StartID(), len(startBatch.ContactIDs()), 0); err != nil {
+			logrus.WithError(err).Error("error recording start batch")
+		}
+	}
+
+	return nil
 }
@@ -129,11 +129,13 @@ func (mr *Mailroom) Start() error {
 		if err != nil {
 			return err
 		}
-		mr.rt.AttachmentStorage = storage.NewS3(s3Client, mr.rt.Config.S3AttachmentsBucket, c.S3Region, s3.BucketCannedACLPublicRead, 32)
-		mr.rt.SessionStorage = storage.NewS3(s3Client, mr.rt.Config.S3SessionBucket, c.S3Region, s3.ObjectCannedACLPrivate, 32)
+		mr.rt.AttachmentStorage = runtime.NewS3Storage(s3Client, mr.rt.Config.S3AttachmentsBucket, c.S3Region, s3.BucketCannedACLPublicRead, 32)
+		mr.rt.SessionStorage = runtime.NewS3Storage(s3Client, mr.rt.Config.S3SessionBucket, c.S3Region, s3.ObjectCannedACLPrivate, 32)
+		mr.rt.ExportStorage = runtime.NewS3Storage(s3Client, mr.rt.Config.S3ExportsBucket, c.S3Region, s3.ObjectCannedACLPrivate, 32)
 	} else {
-		mr.rt.AttachmentStorage = storage.NewFS("_storage", 0766)
-		mr.rt.SessionStorage = storage.NewFS("_storage", 0766)
+		mr.rt.AttachmentStorage = runtime.NewFSStorage("_storage", 0766)
+		mr.rt.SessionStorage = runtime.NewFSStorage("_storage", 0766)
+		mr.rt.ExportStorage = runtime.NewFSStorage("_storage", 0766)
 	}
 
 	// test our attachment storage
@@ -157,6 +159,16 @@ func (mr *Mailroom) Start() error {
 		log.Info(mr.rt.SessionStorage.Name() + " session storage ok")
 	}
 
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second*10)
+	err = mr.rt.ExportStorage.Test(ctx)
+	cancel()
+
+	if err != nil {
+		log.WithError(err).Warn(mr.rt.ExportStorage.Name() + " export storage not available")
+	} else {
+		log.Info(mr.rt.ExportStorage.Name() + " export storage ok")
+	}
+
 	// initialize our elastic client
 	mr.rt.ES, err = newElasticClient(c.Elastic, c.ElasticUsername, c.ElasticPassword)
 	if err != nil {
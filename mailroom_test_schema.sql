@@ -0,0 +1,30 @@
+-- tables and columns used by mailroom which aren't yet part of mailroom_test.dump, applied after it's restored
+
+ALTER TABLE schedules_schedule ADD COLUMN IF NOT EXISTS repeat_rule character varying(255);
+ALTER TABLE schedules_schedule ADD COLUMN IF NOT EXISTS catch_up character varying(1);
+
+CREATE TABLE IF NOT EXISTS schedules_schedulefire (
+    id serial PRIMARY KEY,
+    schedule_id integer NOT NULL REFERENCES schedules_schedule(id) DEFERRABLE INITIALLY DEFERRED,
+    scheduled timestamp with time zone NOT NULL,
+    fired_on timestamp with time zone NOT NULL,
+    result character varying(1) NOT NULL,
+    broadcast_id integer REFERENCES msgs_broadcast(id) DEFERRABLE INITIALLY DEFERRED,
+    start_id integer REFERENCES flows_flowstart(id) DEFERRABLE INITIALLY DEFERRED,
+    error text
+);
+CREATE INDEX IF NOT EXISTS schedules_schedulefire_schedule_scheduled ON schedules_schedulefire(schedule_id, scheduled);
+
+CREATE TABLE IF NOT EXISTS contacts_contactfieldhistory (
+    id bigserial PRIMARY KEY,
+    org_id integer NOT NULL REFERENCES orgs_org(id) DEFERRABLE INITIALLY DEFERRED,
+    contact_id integer NOT NULL REFERENCES contacts_contact(id) DEFERRABLE INITIALLY DEFERRED,
+    field_id integer NOT NULL REFERENCES contacts_contactfield(id) DEFERRABLE INITIALLY DEFERRED,
+    old_value text,
+    new_value text,
+    changed_by_id integer REFERENCES auth_user(id) DEFERRABLE INITIALLY DEFERRED,
+    flow_id integer REFERENCES flows_flow(id) DEFERRABLE INITIALLY DEFERRED,
+    changed_on timestamp with time zone NOT NULL
+);
+CREATE INDEX IF NOT EXISTS contacts_contactfieldhistory_contact_changed ON contacts_contactfieldhistory(contact_id, changed_on);
+CREATE INDEX IF NOT EXISTS contacts_contactfieldhistory_org_changed ON contacts_contactfieldhistory(org_id, changed_on);
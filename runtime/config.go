@@ -14,6 +14,7 @@ import (
 
 func init() {
 	utils.RegisterValidatorAlias("session_storage", "eq=db|eq=s3", func(e validator.FieldError) string { return "is not a valid session storage mode" })
+	utils.RegisterValidatorAlias("contact_search", "eq=elastic|eq=db", func(e validator.FieldError) string { return "is not a valid contact search mode" })
 }
 
 // Config is our top level configuration object
@@ -33,6 +34,7 @@ type Config struct {
 	BatchWorkers         int  `help:"the number of go routines that will be used to handle batch events"`
 	HandlerWorkers       int  `help:"the number of go routines that will be used to handle messages"`
 	RetryPendingMessages bool `help:"whether to requeue pending messages older than five minutes to retry"`
+	ScheduleOverdueLimit int  `help:"the number of minutes a schedule can be overdue before its catch up policy is applied"`
 
 	WebhooksTimeout              int     `help:"the timeout in milliseconds for webhook calls from engine"`
 	WebhooksMaxRetries           int     `help:"the number of times to retry a failed webhook call"`
@@ -48,9 +50,15 @@ type Config struct {
 	MaxValueLength       int    `help:"the maximum size in characters for contact field values and run result values"`
 	SessionStorage       string `validate:"omitempty,session_storage"         help:"where to store session output (s3|db)"`
 
+	TTSService string `help:"the text-to-speech service used to render IVR prompts as audio (blank to disable)"`
+	TTSCommand string `help:"the command used by the command TTS service, {voice} and {locale} are replaced and text is written to stdin"`
+	STTService string `help:"the speech-to-text service used to transcribe IVR recordings (blank to disable)"`
+	STTCommand string `help:"the command used by the command STT service, {language} and {locale} are replaced and audio is written to stdin"`
+
 	Elastic         string `validate:"url" help:"the URL of your ElasticSearch instance"`
 	ElasticUsername string `help:"the username for ElasticSearch if using basic auth"`
 	ElasticPassword string `help:"the password for ElasticSearch if using basic auth"`
+	ContactSearch   string `validate:"omitempty,contact_search" help:"how contact queries are performed (elastic|db), elastic falls back to db if unavailable"`
 
 	S3Endpoint          string `help:"the S3 endpoint we will write attachments to"`
 	S3Region            string `help:"the S3 region we will write attachments to"`
@@ -58,6 +66,8 @@ type Config struct {
 	S3AttachmentsPrefix string `help:"the prefix that will be added to attachment filenames"`
 	S3SessionBucket     string `help:"the S3 bucket we will write attachments to"`
 	S3SessionPrefix     string `help:"the prefix that will be added to attachment filenames"`
+	S3ExportsBucket     string `help:"the S3 bucket we will write exports to, which should not be publicly readable"`
+	S3ExportsPrefix     string `help:"the prefix that will be added to export filenames"`
 	S3DisableSSL        bool   `help:"whether we disable SSL when accessing S3. Should always be set to False unless you're hosting an S3 compatible service within a secure internal network"`
 	S3ForcePathStyle    bool   `help:"whether we force S3 path style. Should generally need to default to False unless you're hosting an S3 compatible service"`
 
@@ -93,6 +103,7 @@ func NewDefaultConfig() *Config {
 		BatchWorkers:         4,
 		HandlerWorkers:       32,
 		RetryPendingMessages: true,
+		ScheduleOverdueLimit: 15,
 
 		WebhooksTimeout:              15000,
 		WebhooksMaxRetries:           2,
@@ -108,9 +119,15 @@ func NewDefaultConfig() *Config {
 		MaxValueLength:       640,
 		SessionStorage:       "db",
 
+		TTSService: "",
+		TTSCommand: "espeak-ng --stdout -v {voice}",
+		STTService: "",
+		STTCommand: "whisper-cli --language {language} -",
+
 		Elastic:         "http://localhost:9200",
 		ElasticUsername: "",
 		ElasticPassword: "",
+		ContactSearch:   "elastic",
 
 		S3Endpoint:          "https://s3.amazonaws.com",
 		S3Region:            "us-east-1",
@@ -118,6 +135,8 @@ func NewDefaultConfig() *Config {
 		S3AttachmentsPrefix: "/attachments/",
 		S3SessionBucket:     "mailroom-sessions",
 		S3SessionPrefix:     "/",
+		S3ExportsBucket:     "mailroom-exports",
+		S3ExportsPrefix:     "/exports/",
 		S3DisableSSL:        false,
 		S3ForcePathStyle:    false,
 
@@ -0,0 +1,129 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/nyaruka/gocommon/storage"
+	"github.com/pkg/errors"
+)
+
+// Deleter is implemented by storage which supports deleting what has been stored
+type Deleter interface {
+	Delete(ctx context.Context, path string) error
+}
+
+// Streamer is implemented by storage which can store content as it's read rather than needing all of it up front
+type Streamer interface {
+	PutStream(ctx context.Context, path string, contentType string, body io.Reader) (string, error)
+}
+
+// DeleteFromStorage deletes the file at the given path from the given storage, which must support deleting
+func DeleteFromStorage(ctx context.Context, s storage.Storage, path string) error {
+	d, ok := s.(Deleter)
+	if !ok {
+		return errors.Errorf("%s storage doesn't support deleting", s.Name())
+	}
+	return d.Delete(ctx, path)
+}
+
+// PutStreamToStorage stores the content read from body at the given path, streaming it if the storage supports that
+// and otherwise reading it all first
+func PutStreamToStorage(ctx context.Context, s storage.Storage, path string, contentType string, body io.Reader) (string, error) {
+	if st, ok := s.(Streamer); ok {
+		return st.PutStream(ctx, path, contentType, body)
+	}
+
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return "", errors.Wrapf(err, "error reading content")
+	}
+	return s.Put(ctx, path, contentType, content)
+}
+
+type s3Storage struct {
+	storage.Storage
+	client s3iface.S3API
+	bucket string
+	region string
+	acl    string
+}
+
+// NewS3Storage creates a new S3 storage which supports deleting and streaming if the given client is a full S3 client
+func NewS3Storage(client storage.S3Client, bucket, region, acl string, workersPerBatch int) storage.Storage {
+	s := storage.NewS3(client, bucket, region, acl, workersPerBatch)
+
+	if api, ok := client.(s3iface.S3API); ok {
+		return &s3Storage{Storage: s, client: api, bucket: bucket, region: region, acl: acl}
+	}
+	return s
+}
+
+func (s *s3Storage) Delete(ctx context.Context, path string) error {
+	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(path)})
+	return errors.Wrapf(err, "error deleting S3 object bucket=%s key=%s", s.bucket, path)
+}
+
+// PutStream uploads the content of body in parts so it never needs to be held in memory all at once
+func (s *s3Storage) PutStream(ctx context.Context, path string, contentType string, body io.Reader) (string, error) {
+	uploader := s3manager.NewUploaderWithClient(s.client)
+
+	_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(path),
+		Body:        body,
+		ContentType: aws.String(contentType),
+		ACL:         aws.String(s.acl),
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "error uploading S3 object bucket=%s key=%s", s.bucket, path)
+	}
+
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com%s", s.bucket, s.region, path), nil
+}
+
+type fsStorage struct {
+	storage.Storage
+	directory string
+	perms     os.FileMode
+}
+
+// NewFSStorage creates a new file system storage which supports deleting and streaming
+func NewFSStorage(directory string, perms os.FileMode) storage.Storage {
+	return &fsStorage{Storage: storage.NewFS(directory, perms), directory: directory, perms: perms}
+}
+
+func (s *fsStorage) Delete(ctx context.Context, path string) error {
+	err := os.Remove(filepath.Join(s.directory, path))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "error deleting file %s", path)
+	}
+	return nil
+}
+
+func (s *fsStorage) PutStream(ctx context.Context, path string, contentType string, body io.Reader) (string, error) {
+	fullPath := filepath.Join(s.directory, path)
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), s.perms); err != nil {
+		return "", err
+	}
+
+	f, err := os.OpenFile(fullPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, s.perms)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return "", err
+	}
+
+	return fullPath, f.Close()
+}
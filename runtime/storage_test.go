@@ -0,0 +1,47 @@
+package runtime_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/nyaruka/gocommon/storage"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFSStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s := runtime.NewFSStorage(dir, 0766)
+
+	url, err := runtime.PutStreamToStorage(ctx, s, "/exports/1/foo.csv", "text/csv", strings.NewReader("a,b,c\n1,2,3\n"))
+	require.NoError(t, err)
+	assert.Equal(t, dir+"/exports/1/foo.csv", url)
+
+	_, content, err := s.Get(ctx, "/exports/1/foo.csv")
+	require.NoError(t, err)
+	assert.Equal(t, "a,b,c\n1,2,3\n", string(content))
+
+	err = runtime.DeleteFromStorage(ctx, s, "/exports/1/foo.csv")
+	assert.NoError(t, err)
+
+	_, err = os.Stat(dir + "/exports/1/foo.csv")
+	assert.True(t, os.IsNotExist(err))
+
+	// deleting something which doesn't exist isn't an error
+	err = runtime.DeleteFromStorage(ctx, s, "/exports/1/foo.csv")
+	assert.NoError(t, err)
+
+	// plain storage can't delete but can still have content streamed to it
+	plain := storage.NewFS(dir, 0766)
+
+	_, err = runtime.PutStreamToStorage(ctx, plain, "/exports/1/bar.csv", "text/csv", strings.NewReader("x,y\n"))
+	assert.NoError(t, err)
+
+	err = runtime.DeleteFromStorage(ctx, plain, "/exports/1/bar.csv")
+	assert.EqualError(t, err, "file system storage doesn't support deleting")
+}
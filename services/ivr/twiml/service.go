@@ -142,7 +142,7 @@ func (s *service) DownloadMedia(url string) (*http.Response, error) {
 func (s *service) CheckStartRequest(r *http.Request) models.CallError {
 	r.ParseForm()
 	answeredBy := r.Form.Get("AnsweredBy")
-	if answeredBy == "machine_start" || answeredBy == "fax" {
+	if answeredBy == "fax" || strings.HasPrefix(answeredBy, "machine_") {
 		return models.CallErrorMachine
 	}
 	return ""
@@ -184,15 +184,23 @@ type CallResponse struct {
 }
 
 // RequestCall causes this client to request a new outgoing call for this provider
-func (s *service) RequestCall(number urns.URN, callbackURL string, statusURL string, machineDetection bool) (ivr.CallID, *httpx.Trace, error) {
+func (s *service) RequestCall(number urns.URN, callbackURL string, statusURL string, machineDetection ivr.MachineDetection) (ivr.CallID, *httpx.Trace, error) {
 	form := url.Values{}
 	form.Set("To", number.Path())
 	form.Set("From", s.channel.Address())
 	form.Set("Url", callbackURL)
 	form.Set("StatusCallback", statusURL)
 
-	if machineDetection {
+	switch machineDetection {
+	case ivr.MachineDetectionHangup:
 		form.Set("MachineDetection", "Enable")
+	case ivr.MachineDetectionVoicemail:
+		// start the flow as soon as the call is answered, and have Twilio call us back separately once it knows if a
+		// machine answered, which for machines is after the beep so that the voicemail can be left
+		form.Set("MachineDetection", "DetectMessageEnd")
+		form.Set("AsyncAmd", "true")
+		form.Set("AsyncAmdStatusCallback", statusURL)
+		form.Set("AsyncAmdStatusCallbackMethod", http.MethodPost)
 	}
 
 	sendURL := s.baseURL + strings.Replace(callPath, "{AccountSID}", s.accountSID, -1)
@@ -238,6 +246,27 @@ func (s *service) HangupCall(callID string) (*httpx.Trace, error) {
 	return trace, nil
 }
 
+// RedirectCall asks Twilio to fetch new TWIML for the passed in in-progress call from the passed in URL
+func (s *service) RedirectCall(callID string, redirectURL string) (*httpx.Trace, error) {
+	form := url.Values{}
+	form.Set("Url", redirectURL)
+	form.Set("Method", http.MethodPost)
+
+	sendURL := s.baseURL + strings.Replace(hangupPath, "{AccountSID}", s.accountSID, -1)
+	sendURL = strings.Replace(sendURL, "{SID}", callID, -1)
+
+	trace, err := s.postRequest(sendURL, form)
+	if err != nil {
+		return trace, errors.Wrapf(err, "error trying to redirect call")
+	}
+
+	if trace.Response.StatusCode != 200 {
+		return trace, errors.Errorf("received non 200 trying to redirect call: %d", trace.Response.StatusCode)
+	}
+
+	return trace, nil
+}
+
 // InputForRequest returns the input for the passed in request, if any
 func (s *service) ResumeForRequest(r *http.Request) (ivr.Resume, error) {
 	// this could be a timeout, in which case we return an empty input
@@ -265,6 +294,9 @@ func (s *service) ResumeForRequest(r *http.Request) (ivr.Resume, error) {
 		}
 		return ivr.InputResume{Attachment: utils.Attachment("audio/mp3:" + url + ".mp3")}, nil
 
+	case "machine":
+		return ivr.MachineResume{}, nil
+
 	case "dial":
 		twStatus := r.Form.Get("DialCallStatus")
 		status := dialStatusMap[twStatus]
@@ -292,6 +324,15 @@ func (s *service) ResumeForRequest(r *http.Request) (ivr.Resume, error) {
 // and if available, the current call duration
 func (s *service) StatusForRequest(r *http.Request) (models.CallStatus, models.CallError, int) {
 	status := r.Form.Get("CallStatus")
+
+	// answering machine detection callbacks tell us who answered but have no call status
+	if status == "" && r.Form.Get("AnsweredBy") != "" {
+		if s.CheckStartRequest(r) == models.CallErrorMachine {
+			return models.CallStatusErrored, models.CallErrorMachine, 0
+		}
+		return models.CallStatusInProgress, "", 0
+	}
+
 	switch status {
 
 	case "queued", "ringing":
@@ -362,8 +403,14 @@ func (s *service) WriteSessionResponse(ctx context.Context, rt *runtime.Runtime,
 		return errors.Errorf("cannot write IVR response for session with no sprint")
 	}
 
+	// render any prompts without audio using our TTS service if we have one
+	evts, err := ivr.SynthesizePrompts(ctx, rt, channel, call, sprint.Events())
+	if err != nil {
+		return errors.Wrap(err, "unable to synthesize prompts for IVR call")
+	}
+
 	// get our response
-	response, err := ResponseForSprint(rt.Config, number, resumeURL, sprint.Events(), true)
+	response, err := ResponseForSprint(rt.Config, number, resumeURL, evts, true)
 	if err != nil {
 		return errors.Wrap(err, "unable to build response for IVR call")
 	}
@@ -376,6 +423,23 @@ func (s *service) WriteSessionResponse(ctx context.Context, rt *runtime.Runtime,
 	return nil
 }
 
+// WriteVoicemailResponse writes a TWIML response which plays the passed in voicemail message and hangs up
+func (s *service) WriteVoicemailResponse(ctx context.Context, rt *runtime.Runtime, channel *models.Channel, call *models.Call, number urns.URN, msg *flows.MsgOut, w http.ResponseWriter) error {
+	evts, err := ivr.SynthesizePrompts(ctx, rt, channel, call, []flows.Event{events.NewIVRCreated(msg)})
+	if err != nil {
+		return errors.Wrap(err, "unable to synthesize voicemail for IVR call")
+	}
+
+	// with no wait, our response will hang up after playing the message
+	response, err := ResponseForSprint(rt.Config, number, "", evts, true)
+	if err != nil {
+		return errors.Wrap(err, "unable to build voicemail response for IVR call")
+	}
+
+	_, err = w.Write([]byte(response))
+	return errors.Wrap(err, "error writing IVR response")
+}
+
 func (s *service) WriteRejectResponse(w http.ResponseWriter) error {
 	return s.writeResponse(w, &Response{
 		Commands: []any{Reject{}},
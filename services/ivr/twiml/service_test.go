@@ -3,6 +3,8 @@ package twiml_test
 import (
 	"encoding/xml"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"strconv"
 	"strings"
 	"testing"
@@ -15,10 +17,12 @@ import (
 	"github.com/nyaruka/goflow/flows/events"
 	"github.com/nyaruka/goflow/flows/routers/waits/hints"
 	"github.com/nyaruka/mailroom/core/ivr"
+	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/services/ivr/twiml"
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/nyaruka/mailroom/testsuite/testdata"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestResponseForSprint(t *testing.T) {
@@ -137,6 +141,107 @@ func TestURNForRequest(t *testing.T) {
 	assert.EqualError(t, err, "no Caller or From parameter found in request")
 }
 
+func TestMachineDetection(t *testing.T) {
+	_, rt, _, _ := testsuite.Get()
+
+	var form url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		if strings.HasSuffix(r.URL.Path, "Calls.json") {
+			w.WriteHeader(http.StatusCreated)
+		}
+		w.Write([]byte(`{"sid": "Call1"}`))
+	}))
+	defer ts.Close()
+
+	defer func(u string) { twiml.BaseURL = u }(twiml.BaseURL)
+	twiml.BaseURL = ts.URL
+
+	oa := testdata.Org1.Load(rt)
+	s, err := twiml.NewServiceFromChannel(http.DefaultClient, oa.ChannelByUUID(testdata.TwilioChannel.UUID))
+	require.NoError(t, err)
+
+	tcs := []struct {
+		detection ivr.MachineDetection
+		expected  string
+	}{
+		{ivr.MachineDetectionNone, ""},
+		{ivr.MachineDetectionHangup, "Enable"},
+		{ivr.MachineDetectionVoicemail, "DetectMessageEnd"},
+	}
+
+	for _, tc := range tcs {
+		callID, _, err := s.RequestCall(urns.URN("tel:+12067799294"), "http://temba.io/handle", "http://temba.io/status", tc.detection)
+		assert.NoError(t, err)
+		assert.Equal(t, ivr.CallID("Call1"), callID)
+		assert.Equal(t, tc.expected, form.Get("MachineDetection"), "machine detection mismatch for %s", tc.detection)
+	}
+
+	// voicemail detection is async with its own callback so that the flow starts as soon as the call is answered
+	assert.Equal(t, "true", form.Get("AsyncAmd"))
+	assert.Equal(t, "http://temba.io/status", form.Get("AsyncAmdStatusCallback"))
+
+	makeRequest := func(body string) *http.Request {
+		r, _ := http.NewRequest("POST", "http://nyaruka.com/12345/handle", strings.NewReader(body))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+
+	assert.Equal(t, models.CallError(""), s.CheckStartRequest(makeRequest(`CallSid=12345&AnsweredBy=human`)))
+	assert.Equal(t, models.CallErrorMachine, s.CheckStartRequest(makeRequest(`CallSid=12345&AnsweredBy=machine_start`)))
+	assert.Equal(t, models.CallErrorMachine, s.CheckStartRequest(makeRequest(`CallSid=12345&AnsweredBy=machine_end_beep`)))
+	assert.Equal(t, models.CallErrorMachine, s.CheckStartRequest(makeRequest(`CallSid=12345&AnsweredBy=fax`)))
+
+	// async detection callbacks have no call status
+	r := makeRequest(`CallSid=12345&AnsweredBy=machine_end_beep`)
+	r.ParseForm()
+	status, errorReason, _ := s.StatusForRequest(r)
+	assert.Equal(t, models.CallStatusErrored, status)
+	assert.Equal(t, models.CallErrorMachine, errorReason)
+
+	r = makeRequest(`CallSid=12345&AnsweredBy=human`)
+	r.ParseForm()
+	status, errorReason, _ = s.StatusForRequest(r)
+	assert.Equal(t, models.CallStatusInProgress, status)
+	assert.Equal(t, models.CallError(""), errorReason)
+
+	// calls can be redirected to fetch new TWIML
+	_, err = s.RedirectCall("Call1", "http://temba.io/handle?action=resume&wait_type=machine")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://temba.io/handle?action=resume&wait_type=machine", form.Get("Url"))
+
+	// which resumes the flow with the machine
+	r = makeRequest(`CallSid=12345&CallStatus=in-progress`)
+	r.URL.RawQuery = "action=resume&wait_type=machine"
+	r.ParseForm()
+	resume, err := s.ResumeForRequest(r)
+	assert.NoError(t, err)
+	assert.Equal(t, ivr.MachineResume{}, resume)
+}
+
+func TestWriteVoicemailResponse(t *testing.T) {
+	ctx, rt, _, _ := testsuite.Get()
+
+	s := twiml.NewService(http.DefaultClient, "12345", "sesame")
+	urn := urns.URN("tel:+12067799294")
+	channelRef := assets.NewChannelReference(assets.ChannelUUID(uuids.New()), "Twilio Channel")
+
+	// a message with only text is spoken
+	w := httptest.NewRecorder()
+	err := s.WriteVoicemailResponse(ctx, rt, nil, nil, urn, flows.NewIVRMsgOut(urn, channelRef, "Sorry we missed you", "", "eng-US"), w)
+	assert.NoError(t, err)
+	assert.Contains(t, w.Body.String(), `<Say language="en-US">Sorry we missed you</Say>`)
+	assert.Contains(t, w.Body.String(), `<Hangup></Hangup>`)
+
+	// and one with audio is played
+	w = httptest.NewRecorder()
+	err = s.WriteVoicemailResponse(ctx, rt, nil, nil, urn, flows.NewIVRMsgOut(urn, channelRef, "Sorry we missed you", "http://temba.io/missed.mp3", ""), w)
+	assert.NoError(t, err)
+	assert.Contains(t, w.Body.String(), `<Play>http://temba.io/missed.mp3</Play>`)
+	assert.NotContains(t, w.Body.String(), `<Say`)
+}
+
 func TestRedactValues(t *testing.T) {
 	_, rt, _, _ := testsuite.Get()
 
@@ -366,7 +366,7 @@ func (s *service) PreprocessResume(ctx context.Context, rt *runtime.Runtime, cal
 }
 
 // RequestCall requests a new outgoing call for this service
-func (s *service) RequestCall(number urns.URN, resumeURL string, statusURL string, machineDetection bool) (ivr.CallID, *httpx.Trace, error) {
+func (s *service) RequestCall(number urns.URN, resumeURL string, statusURL string, machineDetection ivr.MachineDetection) (ivr.CallID, *httpx.Trace, error) {
 	callR := &CallRequest{
 		AnswerURL:    []string{resumeURL + "&sig=" + url.QueryEscape(s.calculateSignature(resumeURL))},
 		AnswerMethod: http.MethodPost,
@@ -375,8 +375,13 @@ func (s *service) RequestCall(number urns.URN, resumeURL string, statusURL strin
 		EventMethod: http.MethodPost,
 	}
 
-	if machineDetection {
-		callR.MachineDetection = "hangup" // if an answering machine answers, just hangup
+	// Vonage only tells us about machines via status events once the call is underway, so to leave a voicemail we let
+	// the call continue and it's redirected when the machine event arrives
+	switch machineDetection {
+	case ivr.MachineDetectionHangup:
+		callR.MachineDetection = "hangup"
+	case ivr.MachineDetectionVoicemail:
+		callR.MachineDetection = "continue"
 	}
 
 	callR.To = append(callR.To, Phone{Type: "phone", Number: strings.TrimLeft(number.Path(), "+")})
@@ -422,6 +427,26 @@ func (s *service) HangupCall(callID string) (*httpx.Trace, error) {
 	return trace, nil
 }
 
+// RedirectCall asks Vonage to transfer the passed in in-progress call to the NCCO at the passed in URL
+func (s *service) RedirectCall(callID string, redirectURL string) (*httpx.Trace, error) {
+	transferBody := map[string]any{
+		"action": "transfer",
+		"destination": map[string]any{
+			"type": "ncco",
+			"url":  []string{redirectURL + "&sig=" + url.QueryEscape(s.calculateSignature(redirectURL))},
+		},
+	}
+	trace, err := s.makeRequest(http.MethodPut, s.callURL+"/"+callID, transferBody)
+	if err != nil {
+		return trace, errors.Wrapf(err, "error trying to redirect call")
+	}
+
+	if trace.Response.StatusCode != http.StatusNoContent {
+		return trace, errors.Errorf("received non 204 status for call redirect: %d", trace.Response.StatusCode)
+	}
+	return trace, nil
+}
+
 type NCCOInput struct {
 	DTMF             string `json:"dtmf"`
 	TimedOut         bool   `json:"timed_out"`
@@ -430,7 +455,7 @@ type NCCOInput struct {
 	Timestamp        string `json:"timestamp"`
 }
 
-// ResumeForRequest returns the resume (input or dial) for the passed in request, if any
+// ResumeForRequest returns the resume (input, dial or machine) for the passed in request, if any
 func (s *service) ResumeForRequest(r *http.Request) (ivr.Resume, error) {
 	// this could be empty, in which case we return nothing at all
 	empty := r.Form.Get("empty")
@@ -477,6 +502,11 @@ func (s *service) ResumeForRequest(r *http.Request) (ivr.Resume, error) {
 		}
 	}
 
+	// we've been redirected here because a machine answered the call
+	if waitType == "machine" {
+		return ivr.MachineResume{}, nil
+	}
+
 	// only remaining type should be dial
 	if waitType != "dial" {
 		return nil, errors.Errorf("unknown wait_type: %s", waitType)
@@ -601,8 +631,14 @@ func (s *service) WriteSessionResponse(ctx context.Context, rt *runtime.Runtime,
 		return errors.Errorf("cannot write IVR response for session with no sprint")
 	}
 
+	// render any prompts without audio using our TTS service if we have one
+	evts, err := ivr.SynthesizePrompts(ctx, rt, channel, call, sprint.Events())
+	if err != nil {
+		return errors.Wrap(err, "unable to synthesize prompts for IVR call")
+	}
+
 	// get our response
-	response, err := s.responseForSprint(ctx, rt.RP, channel, call, resumeURL, sprint.Events())
+	response, err := s.responseForSprint(ctx, rt.RP, channel, call, resumeURL, evts)
 	if err != nil {
 		return errors.Wrap(err, "unable to build response for IVR call")
 	}
@@ -616,6 +652,24 @@ func (s *service) WriteSessionResponse(ctx context.Context, rt *runtime.Runtime,
 	return nil
 }
 
+// WriteVoicemailResponse writes a NCCO response which plays the passed in voicemail message and hangs up
+func (s *service) WriteVoicemailResponse(ctx context.Context, rt *runtime.Runtime, channel *models.Channel, call *models.Call, number urns.URN, msg *flows.MsgOut, w http.ResponseWriter) error {
+	evts, err := ivr.SynthesizePrompts(ctx, rt, channel, call, []flows.Event{events.NewIVRCreated(msg)})
+	if err != nil {
+		return errors.Wrap(err, "unable to synthesize voicemail for IVR call")
+	}
+
+	// with no wait actions, the call will end after the message is played
+	response, err := s.responseForSprint(ctx, rt.RP, channel, call, "", evts)
+	if err != nil {
+		return errors.Wrap(err, "unable to build voicemail response for IVR call")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write([]byte(response))
+	return errors.Wrap(err, "error writing IVR response")
+}
+
 func (s *service) WriteRejectResponse(w http.ResponseWriter) error {
 	w.Header().Set("Content-Type", "application/json")
 	_, err := w.Write(jsonx.MustMarshal([]any{Talk{
@@ -3,6 +3,8 @@ package vonage
 import (
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -142,6 +144,100 @@ func TestResponseForSprint(t *testing.T) {
 	assert.Equal(t, float64(7200), decodedBody["length_timer"])
 }
 
+func TestMachineDetection(t *testing.T) {
+	_, rt, _, _ := testsuite.Get()
+
+	mockVonage := httpx.NewMockRequestor(map[string][]*httpx.MockResponse{
+		"https://api.nexmo.com/v1/calls": {
+			httpx.NewMockResponse(201, nil, []byte(`{"uuid": "Call1", "status": "started", "direction": "outbound"}`)),
+			httpx.NewMockResponse(201, nil, []byte(`{"uuid": "Call1", "status": "started", "direction": "outbound"}`)),
+			httpx.NewMockResponse(201, nil, []byte(`{"uuid": "Call1", "status": "started", "direction": "outbound"}`)),
+		},
+		"https://api.nexmo.com/v1/calls/Call1": {
+			httpx.NewMockResponse(204, nil, nil),
+		},
+	})
+
+	defer httpx.SetRequestor(httpx.DefaultRequestor)
+	httpx.SetRequestor(mockVonage)
+
+	oa := testdata.Org1.Load(rt)
+	p, err := NewServiceFromChannel(http.DefaultClient, oa.ChannelByUUID(testdata.VonageChannel.UUID))
+	require.NoError(t, err)
+
+	tcs := []struct {
+		detection ivr.MachineDetection
+		expected  string
+	}{
+		{ivr.MachineDetectionNone, ""},
+		{ivr.MachineDetectionHangup, "hangup"},
+		{ivr.MachineDetectionVoicemail, "continue"},
+	}
+
+	for i, tc := range tcs {
+		callID, _, err := p.RequestCall(urns.URN("tel:+12067799294"), "http://temba.io/handle?action=start", "http://temba.io/status", tc.detection)
+		assert.NoError(t, err)
+		assert.Equal(t, ivr.CallID("Call1"), callID)
+
+		body, _ := io.ReadAll(mockVonage.Requests()[i].Body)
+		request := &CallRequest{}
+		jsonx.MustUnmarshal(body, request)
+		assert.Equal(t, tc.expected, request.MachineDetection, "machine detection mismatch for %s", tc.detection)
+	}
+
+	// machines are reported as errors in status callbacks
+	r, _ := http.NewRequest(http.MethodPost, "http://temba.io/status", strings.NewReader(`{"uuid": "Call1", "status": "machine"}`))
+	status, errorReason, _ := p.StatusForRequest(r)
+	assert.Equal(t, models.CallStatusErrored, status)
+	assert.Equal(t, models.CallErrorMachine, errorReason)
+
+	// and calls can be transferred to a new NCCO
+	_, err = p.RedirectCall("Call1", "http://temba.io/handle?action=resume&wait_type=machine")
+	assert.NoError(t, err)
+
+	body, _ := io.ReadAll(mockVonage.Requests()[3].Body)
+	assert.Equal(t, http.MethodPut, mockVonage.Requests()[3].Method)
+	assert.Contains(t, string(body), `"action":"transfer"`)
+	assert.Contains(t, string(body), `http://temba.io/handle?action=resume&wait_type=machine&sig=`)
+
+	// which resumes the flow with the machine
+	r, _ = http.NewRequest(http.MethodPost, "http://temba.io/handle?action=resume&wait_type=machine", strings.NewReader(`{"uuid": "Call1"}`))
+	r.ParseForm()
+	resume, err := p.ResumeForRequest(r)
+	assert.NoError(t, err)
+	assert.Equal(t, ivr.MachineResume{}, resume)
+}
+
+func TestWriteVoicemailResponse(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	oa := testdata.Org1.Load(rt)
+	channel := oa.ChannelByUUID(testdata.VonageChannel.UUID)
+	p, err := NewServiceFromChannel(http.DefaultClient, channel)
+	require.NoError(t, err)
+
+	call, err := models.InsertCall(ctx, db, testdata.Org1.ID, testdata.VonageChannel.ID, models.NilStartID, testdata.Bob.ID, testdata.Bob.URNID, models.CallDirectionOut, models.CallStatusInProgress, "Call1")
+	require.NoError(t, err)
+
+	indentMarshal = false
+	defer func() { indentMarshal = true }()
+
+	urn := urns.URN("tel:+12067799294")
+
+	w := httptest.NewRecorder()
+	err = p.WriteVoicemailResponse(ctx, rt, channel, call, urn, flows.NewIVRMsgOut(urn, channel.ChannelReference(), "Sorry we missed you", "", ""), w)
+	assert.NoError(t, err)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, `[{"action":"talk","text":"Sorry we missed you"}]`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	err = p.WriteVoicemailResponse(ctx, rt, channel, call, urn, flows.NewIVRMsgOut(urn, channel.ChannelReference(), "Sorry we missed you", "https://temba.io/missed.mp3", ""), w)
+	assert.NoError(t, err)
+	assert.Equal(t, `[{"action":"stream","streamUrl":["https://temba.io/missed.mp3"]}]`, w.Body.String())
+}
+
 func TestRedactValues(t *testing.T) {
 	_, rt, _, _ := testsuite.Get()
 
@@ -0,0 +1,61 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/mailroom/core/ivr"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+)
+
+const (
+	typeCommand = "command"
+)
+
+func init() {
+	ivr.RegisterSTTServiceType(typeCommand, NewService)
+}
+
+type service struct {
+	args []string
+}
+
+// NewService creates a new STT service which transcribes audio by running a local command like whisper. The audio
+// to be transcribed is written to the command's stdin and the transcript is read from its stdout.
+func NewService(cfg *runtime.Config) (ivr.STTService, error) {
+	args := strings.Fields(cfg.STTCommand)
+	if len(args) == 0 {
+		return nil, errors.New("missing STT command")
+	}
+	return &service{args: args}, nil
+}
+
+// Transcribe runs our command to transcribe the given audio
+func (s *service) Transcribe(ctx context.Context, contentType string, content []byte, locale envs.Locale) (string, error) {
+	lang, _ := locale.ToParts()
+	replacer := strings.NewReplacer("{locale}", locale.ToBCP47(), "{language}", string(lang), "{content_type}", contentType)
+
+	args := make([]string, len(s.args))
+	for i, a := range s.args {
+		args[i] = replacer.Replace(a)
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(content)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return "", errors.Errorf("error running STT command: %s", strings.TrimSpace(stderr.String()))
+		}
+		return "", errors.Wrap(err, "error running STT command")
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
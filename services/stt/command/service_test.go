@@ -0,0 +1,60 @@
+package command_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/mailroom/core/ivr"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/services/stt/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService(t *testing.T) {
+	ctx := context.Background()
+	cfg := runtime.NewDefaultConfig()
+
+	// no command, no service
+	cfg.STTCommand = ""
+	_, err := command.NewService(cfg)
+	assert.EqualError(t, err, "missing STT command")
+
+	// audio is written to stdin and transcript read from stdout
+	cfg.STTCommand = "cat"
+	svc, err := command.NewService(cfg)
+	require.NoError(t, err)
+
+	transcript, err := svc.Transcribe(ctx, "audio/mp3", []byte(" yes please\n"), envs.NewLocale("eng", "US"))
+	assert.NoError(t, err)
+	assert.Equal(t, "yes please", transcript)
+
+	// locale and content type are substituted into the command
+	cfg.STTCommand = "echo -n {locale} {language} {content_type}"
+	svc, err = command.NewService(cfg)
+	require.NoError(t, err)
+
+	transcript, err = svc.Transcribe(ctx, "audio/wav", []byte{}, envs.NewLocale("fra", "RW"))
+	assert.NoError(t, err)
+	assert.Equal(t, "fr-RW fra audio/wav", transcript)
+
+	// command errors are returned
+	cfg.STTCommand = "false"
+	svc, err = command.NewService(cfg)
+	require.NoError(t, err)
+
+	_, err = svc.Transcribe(ctx, "audio/mp3", []byte{}, envs.NilLocale)
+	assert.EqualError(t, err, "error running STT command: exit status 1")
+
+	// service is registered with the IVR package
+	cfg.STTService = "command"
+	cfg.STTCommand = "cat"
+	stt, err := ivr.GetSTTService(cfg)
+	assert.NoError(t, err)
+	assert.NotNil(t, stt)
+
+	cfg.STTService = "xxx"
+	_, err = ivr.GetSTTService(cfg)
+	assert.EqualError(t, err, "no STT service of type: xxx")
+}
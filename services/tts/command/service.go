@@ -0,0 +1,78 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"mime"
+	"os/exec"
+	"strings"
+
+	"github.com/nyaruka/gocommon/httpx"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/mailroom/core/ivr"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/pkg/errors"
+)
+
+const (
+	typeCommand = "command"
+)
+
+func init() {
+	ivr.RegisterTTSServiceType(typeCommand, NewService)
+}
+
+type service struct {
+	args []string
+}
+
+// NewService creates a new TTS service which renders audio by running a local command like espeak-ng. The text to be
+// rendered is written to the command's stdin and the audio is read from its stdout.
+func NewService(cfg *runtime.Config) (ivr.TTSService, error) {
+	args := strings.Fields(cfg.TTSCommand)
+	if len(args) == 0 {
+		return nil, errors.New("missing TTS command")
+	}
+	return &service{args: args}, nil
+}
+
+// Synthesize runs our command to render the given text as audio
+func (s *service) Synthesize(ctx context.Context, text string, locale envs.Locale, voice string) (string, []byte, error) {
+	lang, _ := locale.ToParts()
+	if voice == "" {
+		voice = defaultVoice(locale)
+	}
+	replacer := strings.NewReplacer("{voice}", voice, "{locale}", locale.ToBCP47(), "{language}", string(lang))
+
+	args := make([]string, len(s.args))
+	for i, a := range s.args {
+		args[i] = replacer.Replace(a)
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return "", nil, errors.Errorf("error running TTS command: %s", strings.TrimSpace(stderr.String()))
+		}
+		return "", nil, errors.Wrap(err, "error running TTS command")
+	}
+
+	contentType, _ := httpx.DetectContentType(stdout.Bytes())
+	contentType, _, _ = mime.ParseMediaType(contentType)
+
+	return contentType, stdout.Bytes(), nil
+}
+
+// gets a voice for the given locale for channels which don't have one configured, i.e. its two letter language code
+// which is what espeak-ng uses to name its voices
+func defaultVoice(locale envs.Locale) string {
+	if code := strings.SplitN(locale.ToBCP47(), "-", 2)[0]; code != "" {
+		return strings.ToLower(code)
+	}
+	return "en"
+}
@@ -0,0 +1,70 @@
+package command_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/mailroom/core/ivr"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/services/tts/command"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService(t *testing.T) {
+	ctx := context.Background()
+	cfg := runtime.NewDefaultConfig()
+
+	// no command, no service
+	cfg.TTSCommand = ""
+	_, err := command.NewService(cfg)
+	assert.EqualError(t, err, "missing TTS command")
+
+	// text is written to stdin and audio read from stdout
+	cfg.TTSCommand = "cat"
+	svc, err := command.NewService(cfg)
+	require.NoError(t, err)
+
+	contentType, audio, err := svc.Synthesize(ctx, "Hello world", envs.NewLocale("eng", "US"), "")
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain", contentType)
+	assert.Equal(t, "Hello world", string(audio))
+
+	// voice and locale are substituted into the command
+	cfg.TTSCommand = "echo -n {voice} {locale} {language}"
+	svc, err = command.NewService(cfg)
+	require.NoError(t, err)
+
+	_, audio, err = svc.Synthesize(ctx, "Hello world", envs.NewLocale("fra", "RW"), "female")
+	assert.NoError(t, err)
+	assert.Equal(t, "female fr-RW fra", string(audio))
+
+	// channels without a voice get one derived from the locale
+	_, audio, err = svc.Synthesize(ctx, "Hello world", envs.NewLocale("fra", "RW"), "")
+	assert.NoError(t, err)
+	assert.Equal(t, "fr fr-RW fra", string(audio))
+
+	_, audio, err = svc.Synthesize(ctx, "Hello world", envs.NilLocale, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "en  ", string(audio))
+
+	// command errors are returned
+	cfg.TTSCommand = "false"
+	svc, err = command.NewService(cfg)
+	require.NoError(t, err)
+
+	_, _, err = svc.Synthesize(ctx, "Hello world", envs.NilLocale, "")
+	assert.EqualError(t, err, "error running TTS command: exit status 1")
+
+	// service is registered with the IVR package
+	cfg.TTSService = "command"
+	cfg.TTSCommand = "cat"
+	tts, err := ivr.GetTTSService(cfg)
+	assert.NoError(t, err)
+	assert.NotNil(t, tts)
+
+	cfg.TTSService = "xxx"
+	_, err = ivr.GetTTSService(cfg)
+	assert.EqualError(t, err, "no TTS service of type: xxx")
+}
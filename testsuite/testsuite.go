@@ -10,7 +10,6 @@ import (
 	"testing"
 
 	"github.com/nyaruka/gocommon/jsonx"
-	"github.com/nyaruka/gocommon/storage"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/core/queue"
 	"github.com/nyaruka/mailroom/runtime"
@@ -32,6 +31,7 @@ var _db *sqlx.DB
 
 const AttachmentStorageDir = "_test_attachments_storage"
 const SessionStorageDir = "_test_session_storage"
+const ExportStorageDir = "_test_export_storage"
 
 // Refresh is our type for the pieces of org assets we want fresh (not cached)
 type ResetFlag int
@@ -71,8 +71,9 @@ func Get() (context.Context, *runtime.Runtime, *sqlx.DB, *redis.Pool) {
 		ReadonlyDB:        db,
 		RP:                rp,
 		ES:                nil,
-		AttachmentStorage: storage.NewFS(AttachmentStorageDir, 0766),
-		SessionStorage:    storage.NewFS(SessionStorageDir, 0766),
+		AttachmentStorage: runtime.NewFSStorage(AttachmentStorageDir, 0766),
+		SessionStorage:    runtime.NewFSStorage(SessionStorageDir, 0766),
+		ExportStorage:     runtime.NewFSStorage(ExportStorageDir, 0766),
 		Config:            runtime.NewDefaultConfig(),
 	}
 
@@ -136,6 +137,8 @@ func getRC() redis.Conn {
 // then copying the mailroom_test.dump file to your mailroom root directory
 //
 //	% cp mailroom_test.dump ../mailroom
+//
+// tables and columns which mailroom uses but which aren't yet in the dump are created by mailroom_test_schema.sql
 func resetDB() {
 	db := getDB()
 	db.MustExec("DROP OWNED BY mailroom_test CASCADE")
@@ -153,6 +156,7 @@ func loadTestDump() {
 	}
 
 	mustExec("pg_restore", "-h", "localhost", "-d", "mailroom_test", "-U", "mailroom_test", path.Join(dir, "./mailroom_test.dump"))
+	mustExec("psql", "-q", "-h", "localhost", "-d", "mailroom_test", "-U", "mailroom_test", "-f", path.Join(dir, "./mailroom_test_schema.sql"))
 
 	// force re-connection
 	if _db != nil {
@@ -178,11 +182,14 @@ func resetRedis() {
 func resetStorage() {
 	must(os.RemoveAll(AttachmentStorageDir))
 	must(os.RemoveAll(SessionStorageDir))
+	must(os.RemoveAll(ExportStorageDir))
 }
 
 var sqlResetTestData = `
 UPDATE contacts_contact SET current_flow_id = NULL;
 
+DELETE FROM schedules_schedulefire;
+DELETE FROM contacts_contactfieldhistory;
 DELETE FROM notifications_notification;
 DELETE FROM notifications_incident;
 DELETE FROM request_logs_httplog;
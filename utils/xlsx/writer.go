@@ -0,0 +1,133 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Writer writes rows of strings to a single sheet XLSX file. Rows are streamed into the sheet as they are written, with
+// the rest of the file structure written on Close.
+type Writer struct {
+	zip   *zip.Writer
+	sheet io.Writer
+	rows  int
+}
+
+// NewWriter creates a new writer which writes an XLSX file to the given writer
+func NewWriter(w io.Writer) (*Writer, error) {
+	z := zip.NewWriter(w)
+
+	sheet, err := z.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(sheet, xml.Header+`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`); err != nil {
+		return nil, err
+	}
+
+	return &Writer{zip: z, sheet: sheet}, nil
+}
+
+// Write writes a row of cells
+func (w *Writer) Write(row []string) error {
+	w.rows++
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, `<row r="%d">`, w.rows)
+	for i, v := range row {
+		fmt.Fprintf(b, `<c r="%s%d" t="inlineStr"><is><t xml:space="preserve">`, columnName(i), w.rows)
+		if err := xml.EscapeText(b, []byte(v)); err != nil {
+			return err
+		}
+		b.WriteString(`</t></is></c>`)
+	}
+	b.WriteString(`</row>`)
+
+	_, err := io.WriteString(w.sheet, b.String())
+	return err
+}
+
+// Close finishes the sheet and writes the rest of the file structure
+func (w *Writer) Close() error {
+	if _, err := io.WriteString(w.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+
+	for _, part := range fileParts {
+		f, err := w.zip.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, xml.Header+part.content); err != nil {
+			return err
+		}
+	}
+
+	return w.zip.Close()
+}
+
+// Write is a convenience function to write the given rows as a complete XLSX file
+func Write(rows [][]string) ([]byte, error) {
+	b := &bytes.Buffer{}
+	w, err := NewWriter(b)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// gets the spreadsheet name of the column with the given zero-based index, e.g. 0 -> A, 26 -> AA
+func columnName(i int) string {
+	name := ""
+	for i >= 0 {
+		name = string(rune('A'+i%26)) + name
+		i = i/26 - 1
+	}
+	return name
+}
+
+// the parts of the file other than the sheet itself
+var fileParts = []struct {
+	name    string
+	content string
+}{
+	{
+		"[Content_Types].xml",
+		`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+			`</Types>`,
+	},
+	{
+		"_rels/.rels",
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`,
+	},
+	{
+		"xl/workbook.xml",
+		`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets>` +
+			`</workbook>`,
+	},
+	{
+		"xl/_rels/workbook.xml.rels",
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`</Relationships>`,
+	},
+}
@@ -0,0 +1,46 @@
+package xlsx_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/nyaruka/mailroom/utils/xlsx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	header := make([]string, 28)
+	for i := range header {
+		header[i] = "col"
+	}
+
+	content, err := xlsx.Write([][]string{header, {"Bob", "<b>5 & 6</b>", " spaced "}})
+	require.NoError(t, err)
+
+	r, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+
+	files := make(map[string]string, len(r.File))
+	for _, f := range r.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		files[f.Name] = string(b)
+	}
+
+	assert.Contains(t, files, "[Content_Types].xml")
+	assert.Contains(t, files, "_rels/.rels")
+	assert.Contains(t, files, "xl/workbook.xml")
+	assert.Contains(t, files, "xl/_rels/workbook.xml.rels")
+
+	sheet := files["xl/worksheets/sheet1.xml"]
+	assert.Contains(t, sheet, `<row r="1"><c r="A1" t="inlineStr">`)
+	assert.Contains(t, sheet, `<c r="Z1" t="inlineStr">`)
+	assert.Contains(t, sheet, `<c r="AB1" t="inlineStr">`)
+	assert.Contains(t, sheet, `<c r="B2" t="inlineStr"><is><t xml:space="preserve">&lt;b&gt;5 &amp; 6&lt;/b&gt;</t></is></c>`)
+	assert.Contains(t, sheet, `<t xml:space="preserve"> spaced </t>`)
+}
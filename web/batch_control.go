@@ -0,0 +1,89 @@
+package web
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/queue"
+	"github.com/nyaruka/mailroom/runtime"
+
+	"github.com/pkg/errors"
+)
+
+// BatchParent is a broadcast or flow start which is processed in batches and so can be cancelled, paused or resumed
+type BatchParent interface {
+	// Describe returns what the parent is, e.g. "broadcast", for use in errors
+	Describe() string
+
+	// CheckStatus returns why the parent can no longer be controlled, e.g. because it's been sent, or empty if it can be
+	CheckStatus(ctx context.Context, db models.Queryer, orgID models.OrgID) (string, error)
+
+	SetControlState(rp *redis.Pool, state models.ControlState) (models.ControlState, []*models.ParkedBatch, error)
+	GetControl(rp *redis.Pool) (*models.BatchControl, error)
+	MarkInterrupted(ctx context.Context, db models.Queryer) error
+}
+
+// NewBatchControlHandler creates a handler which changes the control state of the batch parent read from each request
+// to the given state, and responds with the parent's new control state
+func NewBatchControlHandler(state models.ControlState, readRequest func(*http.Request) (models.OrgID, BatchParent, error)) JSONHandler {
+	return func(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+		orgID, parent, err := readRequest(r)
+		if err != nil {
+			return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+		}
+
+		problem, err := parent.CheckStatus(ctx, rt.DB, orgID)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if problem != "" {
+			return errors.New(problem), http.StatusBadRequest, nil
+		}
+
+		previous, parked, err := parent.SetControlState(rt.RP, state)
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.Wrapf(err, "error changing %s state", parent.Describe())
+		}
+		if previous == models.ControlStateCancelled {
+			return errors.Errorf("%s has been cancelled", parent.Describe()), http.StatusBadRequest, nil
+		}
+
+		if state == models.ControlStateRunning {
+			if err := requeueParkedBatches(rt, orgID, parked); err != nil {
+				return nil, http.StatusInternalServerError, err
+			}
+		} else if state == models.ControlStateCancelled {
+			if err := parent.MarkInterrupted(ctx, rt.DB); err != nil {
+				return nil, http.StatusInternalServerError, err
+			}
+		}
+
+		control, err := parent.GetControl(rt.RP)
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.Wrapf(err, "error getting %s state", parent.Describe())
+		}
+
+		return control, http.StatusOK, nil
+	}
+}
+
+// re-queues batches which were parked while their parent was paused
+func requeueParkedBatches(rt *runtime.Runtime, orgID models.OrgID, batches []*models.ParkedBatch) error {
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	for _, b := range batches {
+		// IVR batches go ahead of other batches as they do when first queued by the runner
+		priority := queue.DefaultPriority
+		if b.TaskType == queue.StartIVRFlowBatch {
+			priority = queue.HighPriority
+		}
+
+		if err := queue.AddTask(rc, queue.BatchQueue, b.TaskType, int(orgID), b.Task, priority); err != nil {
+			return errors.Wrapf(err, "error re-queuing parked batch")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package broadcast
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/search"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/broadcast/preview", web.RequireAuthToken(handlePreview))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/broadcast/variant_stats", web.RequireAuthToken(handleVariantStats))
+}
+
+// Generates a preview of which contacts will receive a broadcast. The returned query should be saved on the broadcast
+// so that recipients are resolved again when it is sent.
+//
+//	{
+//	  "org_id": 1,
+//	  "include": {
+//	    "group_uuids": ["5fa925e4-edd8-4e2a-ab24-b3dbb5932ddd", "2912b95f-5b89-4d39-a2a8-5292602f357f"],
+//	    "contact_uuids": ["e5bb9e6f-7703-4ba1-afba-0b12791de38b"],
+//	    "urns": ["tel:+1234567890"],
+//	    "query": ""
+//	  },
+//	  "exclude": {
+//	    "non_active": false,
+//	    "in_a_flow": true,
+//	    "not_seen_since_days": 90
+//	  },
+//	  "sample_size": 5
+//	}
+//
+//	{
+//	  "query": "(group = "No Age" OR group = "No Name" OR uuid = "e5bb9e6f-7703-4ba1-afba-0b12791de38b" OR tel = "+1234567890") AND flow = \"\"",
+//	  "total": 567,
+//	  "sample_ids": [12, 34, 56, 67, 78],
+//	  "metadata": {
+//	    "fields": [
+//	      {"key": "age", "name": "Age"}
+//	    ],
+//	    "allow_as_group": true
+//	  }
+//	}
+type previewRequest struct {
+	OrgID      models.OrgID          `json:"org_id"       validate:"required"`
+	Include    web.RecipientsInclude `json:"include"      validate:"required"`
+	Exclude    search.Exclusions     `json:"exclude"`
+	SampleSize int                   `json:"sample_size"  validate:"required"`
+}
+
+func handlePreview(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &previewRequest{}
+	if err := web.ReadAndValidateJSON(r, request); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	oa, err := models.GetOrgAssets(ctx, rt, request.OrgID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "unable to load org assets")
+	}
+
+	return web.PreviewRecipients(ctx, rt, oa, &request.Include, request.SampleSize, func(groups []*models.Group) (string, error) {
+		return search.BuildBroadcastQuery(oa, groups, request.Include.ContactUUIDs, request.Include.URNs, request.Include.Query, request.Exclude)
+	})
+}
+
+// Request the send, fail and reply counts of each variant of a broadcast.
+//
+//	{
+//	  "org_id": 1,
+//	  "broadcast_id": 12345
+//	}
+//
+// Response is the stats for each variant and the number of contacts who were held out.
+//
+//	{
+//	  "variants": [
+//	    {"key": "a", "assigned": 52, "sent": 50, "failed": 2, "replies": 12},
+//	    {"key": "b", "assigned": 48, "sent": 48, "failed": 0, "replies": 17}
+//	  ],
+//	  "holdout": 10
+//	}
+type variantStatsRequest struct {
+	OrgID       models.OrgID       `json:"org_id"       validate:"required"`
+	BroadcastID models.BroadcastID `json:"broadcast_id" validate:"required"`
+}
+
+// handles a request for the stats of a broadcast's variants
+func handleVariantStats(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &variantStatsRequest{}
+	if err := web.ReadAndValidateJSON(r, request); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	stats, holdout, err := models.GetBroadcastVariantStats(ctx, rt.DB, rt.RP, request.OrgID, request.BroadcastID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error loading broadcast variant stats")
+	}
+
+	return map[string]interface{}{"variants": stats, "holdout": holdout}, http.StatusOK, nil
+}
@@ -0,0 +1,60 @@
+package broadcast_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/testsuite"
+	"github.com/nyaruka/mailroom/testsuite/testdata"
+	"github.com/nyaruka/mailroom/web"
+)
+
+func TestPreview(t *testing.T) {
+	ctx, rt, _, _ := testsuite.Get()
+
+	mockES := testsuite.NewMockElasticServer()
+	defer mockES.Close()
+
+	rt.ES = mockES.Client()
+
+	mockES.AddResponse(testdata.Cathy.ID)
+	mockES.AddResponse(testdata.Bob.ID)
+
+	web.RunWebTests(t, ctx, rt, "testdata/preview.json", nil)
+}
+
+func TestVariantStats(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	bcastID := testdata.InsertBroadcast(db, testdata.Org1, "eng", map[envs.Language]string{"eng": "Hi"}, models.NilScheduleID, nil, nil)
+
+	cathyMsg := testdata.InsertOutgoingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.Cathy, "Hi from A", nil, models.MsgStatusSent, false)
+	bobMsg := testdata.InsertOutgoingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.Bob, "Hi from B", nil, models.MsgStatusFailed, false)
+	db.MustExec(`UPDATE msgs_msg SET broadcast_id = $1, metadata = '{"variant": "a"}' WHERE id = $2`, bcastID, cathyMsg.ID())
+	db.MustExec(`UPDATE msgs_msg SET broadcast_id = $1, metadata = '{"variant": "b"}' WHERE id = $2`, bcastID, bobMsg.ID())
+
+	rc.Do("HSET", fmt.Sprintf("broadcast_variants:%d:%d", testdata.Org1.ID, bcastID), "a", 1, "b", 1, "holdout", 2)
+
+	web.RunWebTests(t, ctx, rt, "testdata/variant_stats.json", map[string]string{"broadcast_id": fmt.Sprintf("%d", bcastID)})
+}
+
+func TestControl(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	bcastID := testdata.InsertBroadcast(db, testdata.Org1, "eng", map[envs.Language]string{"eng": "Hi"}, models.NilScheduleID, nil, nil)
+	sentID := testdata.InsertBroadcast(db, testdata.Org1, "eng", map[envs.Language]string{"eng": "Bye"}, models.NilScheduleID, nil, nil)
+	db.MustExec(`UPDATE msgs_broadcast SET status = 'S' WHERE id = $1`, sentID)
+
+	web.RunWebTests(t, ctx, rt, "testdata/control.json", map[string]string{
+		"broadcast_id": fmt.Sprintf("%d", bcastID),
+		"sent_id":      fmt.Sprintf("%d", sentID),
+	})
+}
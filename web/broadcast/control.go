@@ -0,0 +1,80 @@
+package broadcast
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/web"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/broadcast/cancel", web.RequireAuthToken(web.NewBatchControlHandler(models.ControlStateCancelled, readControlRequest)))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/broadcast/pause", web.RequireAuthToken(web.NewBatchControlHandler(models.ControlStatePaused, readControlRequest)))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/broadcast/resume", web.RequireAuthToken(web.NewBatchControlHandler(models.ControlStateRunning, readControlRequest)))
+}
+
+// Request to cancel, pause or resume a broadcast which is being sent.
+//
+//	{
+//	  "org_id": 1,
+//	  "broadcast_id": 12345
+//	}
+//
+// Response is the new state of the broadcast and the number of contacts in batches which have been processed, skipped
+// because of cancellation, or parked because of pausing.
+//
+//	{
+//	  "state": "cancelled",
+//	  "processed": 2500,
+//	  "skipped": 497500,
+//	  "parked": 0
+//	}
+type controlRequest struct {
+	OrgID       models.OrgID       `json:"org_id"       validate:"required"`
+	BroadcastID models.BroadcastID `json:"broadcast_id" validate:"required"`
+}
+
+func readControlRequest(r *http.Request) (models.OrgID, web.BatchParent, error) {
+	request := &controlRequest{}
+	if err := web.ReadAndValidateJSON(r, request); err != nil {
+		return models.NilOrgID, nil, err
+	}
+	return request.OrgID, controlledBroadcast(request.BroadcastID), nil
+}
+
+// a broadcast which is being sent in batches
+type controlledBroadcast models.BroadcastID
+
+func (b controlledBroadcast) Describe() string { return "broadcast" }
+
+func (b controlledBroadcast) CheckStatus(ctx context.Context, db models.Queryer, orgID models.OrgID) (string, error) {
+	status, err := models.GetBroadcastStatus(ctx, db, orgID, models.BroadcastID(b))
+	if err == models.ErrNotFound {
+		return fmt.Sprintf("no such broadcast: %d", b), nil
+	} else if err != nil {
+		return "", err
+	}
+
+	switch models.MsgStatus(status) {
+	case models.MsgStatusSent:
+		return "broadcast has already been sent", nil
+	case models.MsgStatusFailed:
+		return "broadcast has been cancelled", nil
+	}
+	return "", nil
+}
+
+func (b controlledBroadcast) SetControlState(rp *redis.Pool, state models.ControlState) (models.ControlState, []*models.ParkedBatch, error) {
+	return models.SetBroadcastControlState(rp, models.BroadcastID(b), state)
+}
+
+func (b controlledBroadcast) GetControl(rp *redis.Pool) (*models.BatchControl, error) {
+	return models.GetBroadcastControl(rp, models.BroadcastID(b))
+}
+
+func (b controlledBroadcast) MarkInterrupted(ctx context.Context, db models.Queryer) error {
+	return models.MarkBroadcastInterrupted(ctx, db, models.BroadcastID(b))
+}
@@ -0,0 +1,80 @@
+package campaign
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/campaign/preview", web.RequireAuthToken(handlePreview))
+}
+
+// Generates a preview of the fires a campaign event would create for the contacts in a group, without creating them.
+// Fires are counted in a histogram by hour or day (the default) in the org's timezone.
+//
+//	{
+//	  "org_id": 1,
+//	  "group_uuid": "5fa925e4-edd8-4e2a-ab24-b3dbb5932ddd",
+//	  "relative_to": "joined",
+//	  "offset": 2,
+//	  "unit": "D",
+//	  "delivery_hour": 9,
+//	  "interval": "day"
+//	}
+//
+//	{
+//	  "total": 567,
+//	  "scheduled": 500,
+//	  "skipped": {"no_value": 45, "in_past": 22},
+//	  "first_fire": "2022-12-01T09:00:00+02:00",
+//	  "last_fire": "2022-12-02T09:00:00+02:00",
+//	  "histogram": [
+//	    {"start": "2022-12-01T00:00:00+02:00", "count": 300},
+//	    {"start": "2022-12-02T00:00:00+02:00", "count": 200}
+//	  ]
+//	}
+type previewRequest struct {
+	OrgID        models.OrgID                   `json:"org_id"        validate:"required"`
+	GroupUUID    assets.GroupUUID               `json:"group_uuid"    validate:"required"`
+	RelativeTo   string                         `json:"relative_to"   validate:"required"`
+	Offset       int                            `json:"offset"`
+	Unit         models.OffsetUnit              `json:"unit"          validate:"required,oneof=M H D W"`
+	DeliveryHour int                            `json:"delivery_hour" validate:"min=-1,max=23"`
+	Interval     models.CampaignPreviewInterval `json:"interval"      validate:"omitempty,oneof=hour day"`
+}
+
+func handlePreview(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &previewRequest{}
+	if err := web.ReadAndValidateJSON(r, request); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	oa, err := models.GetOrgAssets(ctx, rt, request.OrgID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "unable to load org assets")
+	}
+
+	group := oa.GroupByUUID(request.GroupUUID)
+	if group == nil {
+		return errors.Errorf("no such group: %s", request.GroupUUID), http.StatusBadRequest, nil
+	}
+	if oa.FieldByKey(request.RelativeTo) == nil {
+		return errors.Errorf("no such field: %s", request.RelativeTo), http.StatusBadRequest, nil
+	}
+
+	event := models.NewCampaignEvent(nil, request.RelativeTo, request.Offset, request.Unit, request.DeliveryHour)
+
+	preview, err := models.PreviewCampaignEvent(ctx, rt.DB, oa, group.ID(), event, request.Interval, time.Now())
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error previewing campaign event")
+	}
+
+	return preview, http.StatusOK, nil
+}
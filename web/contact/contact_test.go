@@ -10,6 +10,7 @@ import (
 	"github.com/nyaruka/mailroom/testsuite"
 	"github.com/nyaruka/mailroom/testsuite/testdata"
 	"github.com/nyaruka/mailroom/web"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCreateContacts(t *testing.T) {
@@ -79,3 +80,114 @@ func TestInterruptContact(t *testing.T) {
 
 	web.RunWebTests(t, ctx, rt, "testdata/interrupt.json", nil)
 }
+
+func TestMergeContacts(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	// give Cathy and Bob waiting sessions
+	testdata.InsertWaitingSession(db, testdata.Org1, testdata.Cathy, models.FlowTypeMessaging, testdata.Favorites, models.NilCallID, time.Now(), time.Now().Add(time.Hour), true, nil)
+	bobSessionID := testdata.InsertWaitingSession(db, testdata.Org1, testdata.Bob, models.FlowTypeMessaging, testdata.PickANumber, models.NilCallID, time.Now(), time.Now().Add(time.Hour), true, nil)
+	testdata.InsertFlowRun(db, testdata.Org1, bobSessionID, testdata.Bob, testdata.PickANumber, models.RunStatusWaiting)
+
+	// and Bob some messages, a ticket, a field value and a group that Cathy doesn't have
+	testdata.InsertIncomingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.Bob, "hello", models.MsgStatusHandled)
+	testdata.InsertIncomingMsg(db, testdata.Org1, testdata.TwilioChannel, testdata.Bob, "it's me", models.MsgStatusHandled)
+	testdata.InsertOpenTicket(db, testdata.Org1, testdata.Bob, testdata.Internal, testdata.DefaultTopic, "Help", "", time.Now(), nil)
+	db.MustExec(`UPDATE contacts_contact SET fields = '{"903f51da-2717-47c7-a0d3-f2f32877013d": {"text": "33", "number": 33}}' WHERE id = $1`, testdata.Bob.ID)
+	db.MustExec(`DELETE FROM contacts_contactgroup_contacts WHERE contact_id = $1 AND contactgroup_id = $2`, testdata.Cathy.ID, testdata.TestersGroup.ID)
+	testdata.TestersGroup.Add(db, testdata.Bob)
+
+	web.RunWebTests(t, ctx, rt, "testdata/merge.json", nil)
+}
+
+func TestDuplicates(t *testing.T) {
+	ctx, rt, _, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetRedis)
+
+	candidates := []*models.DuplicateCandidate{
+		{Reason: models.DuplicateReasonPhone, Key: "16055741111", ContactIDs: []models.ContactID{testdata.Cathy.ID, testdata.Bob.ID, testdata.George.ID}},
+		{Reason: models.DuplicateReasonEmail, Key: "bob@nyaruka.com", ContactIDs: []models.ContactID{testdata.Bob.ID, testdata.Alexandria.ID}},
+		{Reason: models.DuplicateReasonName, Key: "cathy", ContactIDs: []models.ContactID{testdata.Cathy.ID, testdata.George.ID}},
+	}
+	err := models.SaveDuplicateReport(rc, testdata.Org1.ID, candidates, time.Date(2022, 5, 11, 13, 45, 0, 123456000, time.UTC))
+	require.NoError(t, err)
+
+	web.RunWebTests(t, ctx, rt, "testdata/duplicates.json", nil)
+}
+
+func TestEraseAndExportData(t *testing.T) {
+	ctx, rt, _, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	web.RunWebTests(t, ctx, rt, "testdata/privacy.json", nil)
+}
+
+func TestModifyProgress(t *testing.T) {
+	ctx, rt, _, rp := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetRedis)
+
+	rc := rp.Get()
+	defer rc.Close()
+
+	err := models.SetContactModifyProgress(rc, testdata.Org1.ID, "5a8345c1-514a-4d1b-aee5-6f39b2f53cfa", &models.ContactModifyProgress{Status: models.ContactModifyStatusProcessing, Total: 1250, Processed: 400})
+	require.NoError(t, err)
+
+	web.RunWebTests(t, ctx, rt, "testdata/modify_progress.json", nil)
+}
+
+func TestImportDryRun(t *testing.T) {
+	ctx, rt, _, _ := testsuite.Get()
+
+	web.RunWebTests(t, ctx, rt, "testdata/import_dry_run.json", nil)
+}
+
+func TestFieldHistory(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	// org 1 records field history
+	db.MustExec(`UPDATE orgs_org SET config = '{"contact_field_history": {}}'::jsonb WHERE id = $1`, testdata.Org1.ID)
+	models.FlushCache()
+
+	db.MustExec(`INSERT INTO contacts_contactfieldhistory(org_id, contact_id, field_id, old_value, new_value, changed_by_id, flow_id, changed_on) VALUES
+		($1, $2, $3, NULL, '23', NULL, $5, '2022-05-01T10:00:00Z'),
+		($1, $2, $3, '23', '24', $6, NULL, '2022-05-02T10:00:00Z'),
+		($1, $2, $4, NULL, 'F', NULL, NULL, '2022-05-03T10:00:00Z')`,
+		testdata.Org1.ID, testdata.Cathy.ID, testdata.AgeField.ID, testdata.GenderField.ID, testdata.Favorites.ID, testdata.Admin.ID)
+
+	web.RunWebTests(t, ctx, rt, "testdata/field_history.json", nil)
+}
+
+func TestAggregate(t *testing.T) {
+	ctx, rt, _, _ := testsuite.Get()
+
+	mockES := testsuite.NewMockElasticServer()
+	defer mockES.Close()
+
+	rt.ES = mockES.Client()
+	defer func() { rt.ES = nil }()
+
+	mockES.Responses = append(mockES.Responses, []byte(`{
+		"took": 2,
+		"timed_out": false,
+		"hits": {"total": {"value": 2, "relation": "eq"}, "hits": []},
+		"aggregations": {
+			"field_gender": {
+				"doc_count": 2,
+				"field": {"doc_count": 2, "values": {"buckets": [{"key": "f", "doc_count": 1}, {"key": "m", "doc_count": 1}]}}
+			},
+			"groups": {"buckets": [{"key": 10000, "doc_count": 1}]},
+			"schemes": {"doc_count": 2, "schemes": {"buckets": [{"key": "tel", "doc_count": 2, "contacts": {"doc_count": 2}}]}}
+		}
+	}`))
+
+	web.RunWebTests(t, ctx, rt, "testdata/aggregate.json", nil)
+}
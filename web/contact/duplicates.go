@@ -0,0 +1,70 @@
+package contact
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/contact/duplicates", web.RequireAuthToken(handleDuplicates))
+}
+
+// Request for a page of the duplicate contacts report for an org, which is generated by the find_duplicate_contacts task.
+//
+//	{
+//	  "org_id": 1,
+//	  "offset": 0,
+//	  "limit": 50
+//	}
+type duplicatesRequest struct {
+	OrgID  models.OrgID `json:"org_id"  validate:"required"`
+	Offset int          `json:"offset"  validate:"min=0"`
+	Limit  int          `json:"limit"   validate:"min=0,max=500"`
+}
+
+// Response for a page of the duplicate contacts report, with the largest sets of likely duplicates first.
+//
+//	{
+//	  "status": "complete",
+//	  "total": 23,
+//	  "generated_on": "2022-05-11T13:45:00.123456Z",
+//	  "offset": 0,
+//	  "candidates": [
+//	    {"reason": "phone", "key": "250788123123", "contact_ids": [1234, 2345]},
+//	    {"reason": "email", "key": "bob@nyaruka.com", "contact_ids": [3456, 4567]}
+//	  ]
+//	}
+type duplicatesResponse struct {
+	*models.DuplicateReport
+	Offset     int                          `json:"offset"`
+	Candidates []*models.DuplicateCandidate `json:"candidates"`
+}
+
+// handles a request for a page of the duplicate contacts report
+func handleDuplicates(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &duplicatesRequest{}
+	if err := web.ReadAndValidateJSON(r, request); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+	if request.Limit == 0 {
+		request.Limit = 50
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	report, candidates, err := models.GetDuplicateReport(rc, request.OrgID, request.Offset, request.Limit)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if report == nil {
+		return errors.Errorf("no duplicates report for org: %d", request.OrgID), http.StatusNotFound, nil
+	}
+
+	return &duplicatesResponse{DuplicateReport: report, Offset: request.Offset, Candidates: candidates}, http.StatusOK, nil
+}
@@ -0,0 +1,94 @@
+package contact
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/contact/field_history", web.RequireAuthToken(handleFieldHistory))
+}
+
+// Request for the timeline of a contact's field values, optionally limited to some fields. History is only recorded
+// for orgs which have enabled it.
+//
+//	{
+//	  "org_id": 1,
+//	  "contact_id": 10000,
+//	  "fields": ["age"],
+//	  "limit": 50
+//	}
+type fieldHistoryRequest struct {
+	OrgID     models.OrgID     `json:"org_id"     validate:"required"`
+	ContactID models.ContactID `json:"contact_id" validate:"required"`
+	Fields    []string         `json:"fields"`
+	Limit     int              `json:"limit"      validate:"min=0,max=1000"`
+}
+
+// Response for a contact's field timeline, with the most recent changes first.
+//
+//	{
+//	  "history": [
+//	    {
+//	      "field": {"key": "age", "name": "Age"},
+//	      "old_value": "23",
+//	      "new_value": "24",
+//	      "flow": {"uuid": "9de3663f-c5c5-4c92-9f45-ecbc09abcc85", "name": "Registration"},
+//	      "changed_on": "2022-05-11T13:45:00.123456Z"
+//	    }
+//	  ]
+//	}
+type fieldHistoryResponse struct {
+	History []*models.FieldHistoryEntry `json:"history"`
+}
+
+// handles a request for the history of a contact's field values
+func handleFieldHistory(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &fieldHistoryRequest{}
+	if err := web.ReadAndValidateJSON(r, request); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+	if request.Limit == 0 {
+		request.Limit = 50
+	}
+
+	oa, err := models.GetOrgAssets(ctx, rt, request.OrgID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "unable to load org assets")
+	}
+
+	for _, key := range request.Fields {
+		if oa.FieldByKey(key) == nil {
+			return errors.Errorf("no such field with key: %s", key), http.StatusBadRequest, nil
+		}
+	}
+
+	contacts, err := models.LoadContacts(ctx, rt.ReadonlyDB, oa, []models.ContactID{request.ContactID})
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "unable to load contact")
+	}
+	if len(contacts) == 0 {
+		return errors.New("no such contact"), http.StatusBadRequest, nil
+	}
+
+	// orgs which don't record field history have none
+	historyConfig, err := models.GetFieldHistoryConfig(ctx, rt.ReadonlyDB, oa.Org())
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if historyConfig == nil {
+		return &fieldHistoryResponse{History: []*models.FieldHistoryEntry{}}, http.StatusOK, nil
+	}
+
+	history, err := models.LoadContactFieldHistory(ctx, rt.ReadonlyDB, request.ContactID, request.Fields, request.Limit)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error loading field history")
+	}
+
+	return &fieldHistoryResponse{History: history}, http.StatusOK, nil
+}
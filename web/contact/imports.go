@@ -0,0 +1,66 @@
+package contact
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/contact/import_dry_run", web.RequireAuthToken(handleImportDryRun))
+}
+
+// Request to validate all the records of an import without creating or updating any contacts.
+//
+//	{
+//	  "org_id": 1,
+//	  "import_id": 123
+//	}
+type importDryRunRequest struct {
+	OrgID    models.OrgID           `json:"org_id"    validate:"required"`
+	ImportID models.ContactImportID `json:"import_id" validate:"required"`
+}
+
+// Response for an import dry run with the projected counts and any problems found.
+//
+//	{
+//	  "num_records": 3,
+//	  "num_created": 1,
+//	  "num_updated": 1,
+//	  "num_errored": 1,
+//	  "error_counts": {"contact_not_found": 1, "invalid_language": 1},
+//	  "errors": [
+//	    {"record": 1, "row": 3, "type": "contact_not_found", "message": "Unable to find contact with UUID '...'"},
+//	    {"record": 2, "row": 4, "type": "invalid_language", "message": "'xxx' is not a valid language code"}
+//	  ]
+//	}
+func handleImportDryRun(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &importDryRunRequest{}
+	if err := web.ReadAndValidateJSON(r, request); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, request.OrgID, models.RefreshFields|models.RefreshGroups)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "unable to load org assets")
+	}
+
+	imp, err := models.LoadContactImport(ctx, rt.ReadonlyDB, request.ImportID)
+	if errors.Cause(err) == sql.ErrNoRows || (err == nil && imp.OrgID != oa.OrgID()) {
+		return errors.New("no such import"), http.StatusBadRequest, nil
+	} else if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	result, err := models.DryRunContactImport(ctx, rt.ReadonlyDB, oa, request.ImportID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error validating import")
+	}
+
+	return result, http.StatusOK, nil
+}
@@ -0,0 +1,136 @@
+package contact
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/contact/merge", web.RequireAuthToken(handleMerge))
+}
+
+// Request that a source contact is merged into a target contact. Precedence determines which contact's name, language
+// and field values are kept when both have a value, and defaults to the target.
+//
+//	{
+//	  "org_id": 1,
+//	  "user_id": 3,
+//	  "source_id": 10001,
+//	  "target_id": 10000,
+//	  "precedence": "source"
+//	}
+type mergeRequest struct {
+	OrgID      models.OrgID                  `json:"org_id"     validate:"required"`
+	UserID     models.UserID                 `json:"user_id"    validate:"required"`
+	SourceID   models.ContactID              `json:"source_id"  validate:"required"`
+	TargetID   models.ContactID              `json:"target_id"  validate:"required,nefield=SourceID"`
+	Precedence models.ContactMergePrecedence `json:"precedence" validate:"omitempty,oneof=target source"`
+}
+
+// Response for a contact merge. Counts are the number of each type of item moved from the source to the target.
+//
+//	{
+//	  "source_uuid": "b699a406-7e44-49be-9f01-1a82893e8a10",
+//	  "target_uuid": "6393abc0-283d-4c9b-a1b3-641a035c34bf",
+//	  "counts": {"messages": 3, "runs": 1, ...}
+//	}
+type mergeResponse struct {
+	SourceUUID flows.ContactUUID      `json:"source_uuid"`
+	TargetUUID flows.ContactUUID      `json:"target_uuid"`
+	Counts     map[string]interface{} `json:"counts"`
+}
+
+// handles a request to merge two contacts
+func handleMerge(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &mergeRequest{Precedence: models.ContactMergePrecedenceTarget}
+	if err := web.ReadAndValidateJSON(r, request); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	oa, err := models.GetOrgAssets(ctx, rt, request.OrgID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "unable to load org assets")
+	}
+
+	// grab the locks for both contacts so neither is being handled while we merge
+	release, locked, err := lockContacts(rt, oa.OrgID(), []models.ContactID{request.SourceID, request.TargetID})
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if !locked {
+		return errors.New("contacts are busy, try again later"), http.StatusConflict, nil
+	}
+	defer release()
+
+	contacts, err := models.LoadContacts(ctx, rt.DB, oa, []models.ContactID{request.SourceID, request.TargetID})
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "unable to load contacts")
+	}
+
+	var source, target *models.Contact
+	for _, c := range contacts {
+		if c.ID() == request.SourceID {
+			source = c
+		} else {
+			target = c
+		}
+	}
+	if source == nil || target == nil {
+		return errors.New("no such contact"), http.StatusBadRequest, nil
+	}
+
+	tx, err := rt.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "unable to start transaction")
+	}
+
+	counts, err := models.MergeContacts(ctx, tx, oa, request.UserID, source, target, request.Precedence)
+	if err != nil {
+		tx.Rollback()
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error merging contacts")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error committing contact merge")
+	}
+
+	return &mergeResponse{SourceUUID: source.UUID(), TargetUUID: target.UUID(), Counts: counts}, http.StatusOK, nil
+}
+
+// grabs the locks for the given contacts in order of their ids to avoid deadlocks, returning a function to release them
+// and whether they could all be grabbed before timing out
+func lockContacts(rt *runtime.Runtime, orgID models.OrgID, contactIDs []models.ContactID) (func(), bool, error) {
+	ids := make([]models.ContactID, len(contactIDs))
+	copy(ids, contactIDs)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	locks := make(map[models.ContactID]string, len(ids))
+	release := func() {
+		for id, lock := range locks {
+			models.GetContactLocker(orgID, id).Release(rt.RP, lock)
+		}
+	}
+
+	for _, id := range ids {
+		lock, err := models.GetContactLocker(orgID, id).Grab(rt.RP, time.Second*10)
+		if err != nil {
+			release()
+			return nil, false, errors.Wrapf(err, "error grabbing lock for contact: %d", id)
+		}
+		if lock == "" {
+			release()
+			return nil, false, nil
+		}
+		locks[id] = lock
+	}
+
+	return release, true, nil
+}
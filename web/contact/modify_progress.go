@@ -0,0 +1,53 @@
+package contact
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/contact/modify_progress", web.RequireAuthToken(handleModifyProgress))
+}
+
+// Request for the progress of a bulk contact modification being performed by the bulk_modify_contacts task.
+//
+//	{
+//	  "org_id": 1,
+//	  "uuid": "5a8345c1-514a-4d1b-aee5-6f39b2f53cfa"
+//	}
+type modifyProgressRequest struct {
+	OrgID models.OrgID `json:"org_id" validate:"required"`
+	UUID  string       `json:"uuid"   validate:"required"`
+}
+
+// handles a request for the progress of a bulk contact modification, e.g.
+//
+//	{
+//	  "status": "processing",
+//	  "total": 1250,
+//	  "processed": 400
+//	}
+func handleModifyProgress(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &modifyProgressRequest{}
+	if err := web.ReadAndValidateJSON(r, request); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	progress, err := models.GetContactModifyProgress(rc, request.OrgID, request.UUID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if progress == nil {
+		return errors.Errorf("no such contact modification: %s", request.UUID), http.StatusNotFound, nil
+	}
+
+	return progress, http.StatusOK, nil
+}
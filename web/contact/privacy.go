@@ -0,0 +1,114 @@
+package contact
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/contact/erase", web.RequireAuthToken(web.WithHTTPLogs(handleErase)))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/contact/export_data", web.RequireAuthToken(handleExportData))
+}
+
+// Request that a contact's personal data is erased.
+//
+//	{
+//	  "org_id": 1,
+//	  "user_id": 3,
+//	  "contact_id": 10000
+//	}
+type eraseRequest struct {
+	OrgID     models.OrgID     `json:"org_id"     validate:"required"`
+	UserID    models.UserID    `json:"user_id"    validate:"required"`
+	ContactID models.ContactID `json:"contact_id" validate:"required"`
+}
+
+// Response for a contact erasure. Counts are the number of each type of item erased.
+//
+//	{
+//	  "counts": {"messages": 12, "attachments": 2, "urns": 1, ...}
+//	}
+type eraseResponse struct {
+	Counts map[string]int `json:"counts"`
+}
+
+// handles a request to erase a contact's personal data
+func handleErase(ctx context.Context, rt *runtime.Runtime, r *http.Request, l *models.HTTPLogger) (interface{}, int, error) {
+	request := &eraseRequest{}
+	if err := web.ReadAndValidateJSON(r, request); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	oa, err := models.GetOrgAssets(ctx, rt, request.OrgID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "unable to load org assets")
+	}
+
+	release, locked, err := lockContacts(rt, oa.OrgID(), []models.ContactID{request.ContactID})
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if !locked {
+		return errors.New("contact is busy, try again later"), http.StatusConflict, nil
+	}
+	defer release()
+
+	contacts, err := models.LoadContacts(ctx, rt.DB, oa, []models.ContactID{request.ContactID})
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "unable to load contact")
+	}
+	if len(contacts) == 0 {
+		return errors.New("no such contact"), http.StatusBadRequest, nil
+	}
+
+	counts, err := models.EraseContact(ctx, rt, oa, request.UserID, contacts[0], l)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error erasing contact")
+	}
+
+	return &eraseResponse{Counts: counts}, http.StatusOK, nil
+}
+
+// Request for all the data held about a contact.
+//
+//	{
+//	  "org_id": 1,
+//	  "contact_id": 10000
+//	}
+type exportDataRequest struct {
+	OrgID     models.OrgID     `json:"org_id"     validate:"required"`
+	ContactID models.ContactID `json:"contact_id" validate:"required"`
+}
+
+// handles a request to export all the data held about a contact as a single document
+func handleExportData(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &exportDataRequest{}
+	if err := web.ReadAndValidateJSON(r, request); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	oa, err := models.GetOrgAssets(ctx, rt, request.OrgID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "unable to load org assets")
+	}
+
+	contacts, err := models.LoadContacts(ctx, rt.ReadonlyDB, oa, []models.ContactID{request.ContactID})
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "unable to load contact")
+	}
+	if len(contacts) == 0 {
+		return errors.New("no such contact"), http.StatusBadRequest, nil
+	}
+
+	data, err := models.LoadContactData(ctx, rt.ReadonlyDB, oa, contacts[0])
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error loading contact data")
+	}
+
+	return data, http.StatusOK, nil
+}
@@ -17,6 +17,7 @@ import (
 func init() {
 	web.RegisterJSONRoute(http.MethodPost, "/mr/contact/search", web.RequireAuthToken(handleSearch))
 	web.RegisterJSONRoute(http.MethodPost, "/mr/contact/parse_query", web.RequireAuthToken(handleParseQuery))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/contact/aggregate", web.RequireAuthToken(handleAggregate))
 }
 
 // Searches the contacts for an org
@@ -86,7 +87,7 @@ func handleSearch(ctx context.Context, rt *runtime.Runtime, r *http.Request) (in
 	}
 
 	// perform our search
-	parsed, hits, total, err := search.GetContactIDsForQueryPage(ctx, rt.ES, oa, group, request.ExcludeIDs, request.Query, request.Sort, request.Offset, request.PageSize)
+	parsed, hits, total, err := search.GetContactIDsForQueryPage(ctx, rt, oa, group, request.ExcludeIDs, request.Query, request.Sort, request.Offset, request.PageSize)
 
 	if err != nil {
 		isQueryError, qerr := contactql.IsQueryError(err)
@@ -208,3 +209,104 @@ func handleParseQuery(ctx context.Context, rt *runtime.Runtime, r *http.Request)
 
 	return response, http.StatusOK, nil
 }
+
+// Request for aggregations over the contacts matching a query
+//
+//	{
+//	  "org_id": 1,
+//	  "group_id": 234,
+//	  "query": "age > 10",
+//	  "fields": ["gender", "age", "joined"],
+//	  "limit": 20,
+//	  "number_interval": 10,
+//	  "date_interval": "month"
+//	}
+type aggregateRequest struct {
+	OrgID          models.OrgID   `json:"org_id"          validate:"required"`
+	GroupID        models.GroupID `json:"group_id"`
+	Query          string         `json:"query"`
+	Fields         []string       `json:"fields"`
+	Limit          int            `json:"limit"           validate:"min=1,max=1000"`
+	NumberInterval float64        `json:"number_interval" validate:"gt=0"`
+	DateInterval   string         `json:"date_interval"   validate:"omitempty,oneof=day week month quarter year"`
+}
+
+// Response for a contact aggregation
+//
+//	{
+//	  "query": "age > 10",
+//	  "total": 345,
+//	  "fields": {
+//	    "gender": {"type": "text", "buckets": [{"key": "female", "count": 200}, {"key": "male", "count": 140}]},
+//	    "age": {"type": "number", "buckets": [{"key": 10, "count": 120}, {"key": 20, "count": 225}]},
+//	    "joined": {"type": "datetime", "buckets": [{"key": "2022-01-01T00:00:00.000+02:00", "count": 345}]}
+//	  },
+//	  "groups": [{"uuid": "c153e265-f7c9-4539-9dbc-9b358714b638", "name": "Doctors", "count": 121}],
+//	  "schemes": {"tel": 340, "whatsapp": 21},
+//	  "metadata": {
+//	    "fields": [
+//	      {"key": "age", "name": "Age"}
+//	    ],
+//	    "allow_as_group": true
+//	  }
+//	}
+type aggregateResponse struct {
+	Query string `json:"query"`
+	*search.ContactAggregations
+	Metadata *contactql.Inspection `json:"metadata,omitempty"`
+}
+
+// handles a contact aggregation request
+func handleAggregate(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &aggregateRequest{
+		Limit:          20,
+		NumberInterval: 10,
+		DateInterval:   "month",
+	}
+	if err := web.ReadAndValidateJSON(r, request); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+
+	oa, err := models.GetOrgAssetsWithRefresh(ctx, rt, request.OrgID, models.RefreshFields|models.RefreshGroups)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "unable to load org assets")
+	}
+
+	for _, key := range request.Fields {
+		if oa.FieldByKey(key) == nil {
+			return errors.Errorf("no such field with key: %s", key), http.StatusBadRequest, nil
+		}
+	}
+
+	var group *models.Group
+	if request.GroupID != 0 {
+		group = oa.GroupByID(request.GroupID)
+		if group == nil {
+			return errors.Errorf("no such group with id: %d", request.GroupID), http.StatusBadRequest, nil
+		}
+	}
+
+	opts := &search.AggregationOptions{
+		Fields:         request.Fields,
+		TermsLimit:     request.Limit,
+		NumberInterval: request.NumberInterval,
+		DateInterval:   request.DateInterval,
+	}
+
+	parsed, aggs, err := search.GetContactAggregations(ctx, rt, oa, group, request.Query, opts)
+	if err != nil {
+		isQueryError, qerr := contactql.IsQueryError(err)
+		if isQueryError {
+			return qerr, http.StatusBadRequest, nil
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+
+	response := &aggregateResponse{ContactAggregations: aggs}
+	if parsed != nil {
+		response.Query = parsed.String()
+		response.Metadata = contactql.Inspect(parsed)
+	}
+
+	return response, http.StatusOK, nil
+}
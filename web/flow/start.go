@@ -4,10 +4,6 @@ import (
 	"context"
 	"net/http"
 
-	"github.com/nyaruka/gocommon/urns"
-	"github.com/nyaruka/goflow/assets"
-	"github.com/nyaruka/goflow/contactql"
-	"github.com/nyaruka/goflow/flows"
 	"github.com/nyaruka/mailroom/core/models"
 	"github.com/nyaruka/mailroom/core/search"
 	"github.com/nyaruka/mailroom/runtime"
@@ -51,23 +47,11 @@ func init() {
 //	  }
 //	}
 type previewStartRequest struct {
-	OrgID   models.OrgID  `json:"org_id"    validate:"required"`
-	FlowID  models.FlowID `json:"flow_id"   validate:"required"`
-	Include struct {
-		GroupUUIDs   []assets.GroupUUID  `json:"group_uuids"`
-		ContactUUIDs []flows.ContactUUID `json:"contact_uuids"`
-		URNs         []urns.URN          `json:"urns"`
-		Query        string              `json:"query"`
-	} `json:"include"   validate:"required"`
-	Exclude    search.Exclusions `json:"exclude"`
-	SampleSize int               `json:"sample_size"  validate:"required"`
-}
-
-type previewStartResponse struct {
-	Query     string                `json:"query"`
-	Total     int                   `json:"total"`
-	SampleIDs []models.ContactID    `json:"sample_ids"`
-	Metadata  *contactql.Inspection `json:"metadata,omitempty"`
+	OrgID      models.OrgID          `json:"org_id"       validate:"required"`
+	FlowID     models.FlowID         `json:"flow_id"      validate:"required"`
+	Include    web.RecipientsInclude `json:"include"      validate:"required"`
+	Exclude    search.Exclusions     `json:"exclude"`
+	SampleSize int                   `json:"sample_size"  validate:"required"`
 }
 
 func handlePreviewStart(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
@@ -86,37 +70,7 @@ func handlePreviewStart(ctx context.Context, rt *runtime.Runtime, r *http.Reques
 		return nil, http.StatusInternalServerError, errors.Wrapf(err, "unable to load flow")
 	}
 
-	groups := make([]*models.Group, 0, len(request.Include.GroupUUIDs))
-	for _, groupUUID := range request.Include.GroupUUIDs {
-		g := oa.GroupByUUID(groupUUID)
-		if g != nil {
-			groups = append(groups, g)
-		}
-	}
-
-	query, err := search.BuildStartQuery(oa, flow, groups, request.Include.ContactUUIDs, request.Include.URNs, request.Include.Query, request.Exclude)
-	if err != nil {
-		isQueryError, qerr := contactql.IsQueryError(err)
-		if isQueryError {
-			return qerr, http.StatusBadRequest, nil
-		}
-		return nil, http.StatusInternalServerError, err
-	}
-	if query == "" {
-		return &previewStartResponse{SampleIDs: []models.ContactID{}}, http.StatusOK, nil
-	}
-
-	parsedQuery, sampleIDs, total, err := search.GetContactIDsForQueryPage(ctx, rt.ES, oa, nil, nil, query, "", 0, request.SampleSize)
-	if err != nil {
-		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error querying preview")
-	}
-
-	inspection := contactql.Inspect(parsedQuery)
-
-	return &previewStartResponse{
-		Query:     parsedQuery.String(),
-		Total:     int(total),
-		SampleIDs: sampleIDs,
-		Metadata:  inspection,
-	}, http.StatusOK, nil
+	return web.PreviewRecipients(ctx, rt, oa, &request.Include, request.SampleSize, func(groups []*models.Group) (string, error) {
+		return search.BuildStartQuery(oa, flow, groups, request.Include.ContactUUIDs, request.Include.URNs, request.Include.Query, request.Exclude)
+	})
 }
@@ -0,0 +1,80 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/web"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/flow_start/cancel", web.RequireAuthToken(web.NewBatchControlHandler(models.ControlStateCancelled, readStartControlRequest)))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/flow_start/pause", web.RequireAuthToken(web.NewBatchControlHandler(models.ControlStatePaused, readStartControlRequest)))
+	web.RegisterJSONRoute(http.MethodPost, "/mr/flow_start/resume", web.RequireAuthToken(web.NewBatchControlHandler(models.ControlStateRunning, readStartControlRequest)))
+}
+
+// Request to cancel, pause or resume a flow start which is being started.
+//
+//	{
+//	  "org_id": 1,
+//	  "start_id": 12345
+//	}
+//
+// Response is the new state of the start and the number of contacts in batches which have been processed, skipped
+// because of cancellation, or parked because of pausing.
+//
+//	{
+//	  "state": "paused",
+//	  "processed": 2500,
+//	  "skipped": 0,
+//	  "parked": 300
+//	}
+type startControlRequest struct {
+	OrgID   models.OrgID   `json:"org_id"   validate:"required"`
+	StartID models.StartID `json:"start_id" validate:"required"`
+}
+
+func readStartControlRequest(r *http.Request) (models.OrgID, web.BatchParent, error) {
+	request := &startControlRequest{}
+	if err := web.ReadAndValidateJSON(r, request); err != nil {
+		return models.NilOrgID, nil, err
+	}
+	return request.OrgID, controlledStart(request.StartID), nil
+}
+
+// a flow start which is being started in batches
+type controlledStart models.StartID
+
+func (s controlledStart) Describe() string { return "flow start" }
+
+func (s controlledStart) CheckStatus(ctx context.Context, db models.Queryer, orgID models.OrgID) (string, error) {
+	status, err := models.GetStartStatus(ctx, db, orgID, models.StartID(s))
+	if err == models.ErrNotFound {
+		return fmt.Sprintf("no such flow start: %d", s), nil
+	} else if err != nil {
+		return "", err
+	}
+
+	switch status {
+	case models.StartStatusComplete, models.StartStatusFailed:
+		return "flow start has already completed", nil
+	case models.StartStatusInterrupted:
+		return "flow start has been cancelled", nil
+	}
+	return "", nil
+}
+
+func (s controlledStart) SetControlState(rp *redis.Pool, state models.ControlState) (models.ControlState, []*models.ParkedBatch, error) {
+	return models.SetStartControlState(rp, models.StartID(s), state)
+}
+
+func (s controlledStart) GetControl(rp *redis.Pool) (*models.BatchControl, error) {
+	return models.GetStartControl(rp, models.StartID(s))
+}
+
+func (s controlledStart) MarkInterrupted(ctx context.Context, db models.Queryer) error {
+	return models.MarkStartInterrupted(ctx, db, models.StartID(s))
+}
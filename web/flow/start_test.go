@@ -1,6 +1,7 @@
 package flow_test
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/nyaruka/mailroom/testsuite"
@@ -23,3 +24,18 @@ func TestPreviewStart(t *testing.T) {
 
 	web.RunWebTests(t, ctx, rt, "testdata/preview_start.json", nil)
 }
+
+func TestStartControl(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData | testsuite.ResetRedis)
+
+	startID := testdata.InsertFlowStart(db, testdata.Org1, testdata.Favorites, []*testdata.Contact{testdata.Cathy})
+	completeID := testdata.InsertFlowStart(db, testdata.Org1, testdata.Favorites, []*testdata.Contact{testdata.Bob})
+	db.MustExec(`UPDATE flows_flowstart SET status = 'C' WHERE id = $1`, completeID)
+
+	web.RunWebTests(t, ctx, rt, "testdata/start_control.json", map[string]string{
+		"start_id":    fmt.Sprintf("%d", startID),
+		"complete_id": fmt.Sprintf("%d", completeID),
+	})
+}
@@ -639,3 +639,175 @@ func TestVonageIVR(t *testing.T) {
 
 	assertdb.Query(t, db, `SELECT count(*) FROM ivr_call WHERE status = 'D' AND contact_id = $1`, testdata.George.ID).Returns(1)
 }
+
+func TestTwilioIVRVoicemail(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	var requests []url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mockTwilioHandler(w, r)
+		requests = append(requests, r.Form)
+	}))
+	defer ts.Close()
+
+	twiml.BaseURL = ts.URL
+	twiml.IgnoreSignatures = true
+
+	wg := &sync.WaitGroup{}
+	server := web.NewServer(ctx, rt, wg)
+	server.Start()
+	defer server.Stop()
+
+	db.MustExec(`UPDATE channels_channel SET config = config::jsonb || '{"callback_domain": "localhost:8090", "machine_detection": true}'::jsonb WHERE id = $1`, testdata.TwilioChannel.ID)
+	db.MustExec(`UPDATE flows_flow SET metadata = '{"ivr_voicemail": {"eng": {"text": "Sorry we missed you"}}}' WHERE id = $1`, testdata.IVRFlow.ID)
+
+	start := models.NewFlowStart(testdata.Org1.ID, models.StartTypeTrigger, models.FlowTypeVoice, testdata.IVRFlow.ID).
+		WithContactIDs([]models.ContactID{testdata.Cathy.ID})
+	err := models.InsertFlowStarts(ctx, db, []*models.FlowStart{start})
+	require.NoError(t, err)
+
+	err = starts.CreateFlowBatches(ctx, rt, start)
+	require.NoError(t, err)
+
+	task, err := queue.PopNextTask(rc, queue.HandlerQueue)
+	require.NoError(t, err)
+	batch := &models.FlowStartBatch{}
+	jsonx.MustUnmarshal(task.Task, batch)
+
+	err = ivr_tasks.HandleFlowStartBatch(ctx, rt, batch)
+	require.NoError(t, err)
+
+	// call is requested with async detection which calls us back separately when it knows who answered
+	require.Len(t, requests, 1)
+	assert.Equal(t, "DetectMessageEnd", requests[0].Get("MachineDetection"))
+	assert.Equal(t, "true", requests[0].Get("AsyncAmd"))
+	assert.Equal(t, fmt.Sprintf("https://localhost:8090/mr/ivr/c/%s/status", testdata.TwilioChannel.UUID), requests[0].Get("AsyncAmdStatusCallback"))
+
+	post := func(path string, form url.Values) string {
+		req, err := http.NewRequest(http.MethodPost, "http://localhost:8090/mr"+path, strings.NewReader(form.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	// Twilio calls us to start the flow as soon as the call is answered
+	body := post(fmt.Sprintf("/ivr/c/%s/handle?action=start&connection=1", testdata.TwilioChannel.UUID), url.Values{"CallSid": []string{"Call1"}, "CallStatus": []string{"in-progress"}})
+	assert.Contains(t, body, "Hello there. Please enter one or two.")
+
+	// and then tells us that a machine answered once its greeting has finished, so we have the call redirected to
+	// resume the flow with that
+	body = post(fmt.Sprintf("/ivr/c/%s/status", testdata.TwilioChannel.UUID), url.Values{"CallSid": []string{"Call1"}, "AnsweredBy": []string{"machine_end_beep"}})
+	assert.Contains(t, body, `<!--machine detected, redirected to voicemail-->`)
+
+	require.Len(t, requests, 2)
+	assert.Contains(t, requests[1].Get("Url"), "action=resume")
+	assert.Contains(t, requests[1].Get("Url"), "wait_type=machine")
+
+	body = post(fmt.Sprintf("/ivr/c/%s/handle?action=resume&connection=1&wait_type=machine", testdata.TwilioChannel.UUID), url.Values{"CallSid": []string{"Call1"}, "CallStatus": []string{"in-progress"}})
+	assert.Contains(t, body, `Sorry we missed you</Say><Hangup></Hangup></Response>`)
+	assert.NotContains(t, body, `<Gather`)
+
+	// call is recorded as a voicemail, and the flow was resumed with the machine input but can't wait for more input
+	assertdb.Query(t, db, `SELECT status, error_reason FROM ivr_call WHERE external_id = 'Call1'`).Columns(map[string]interface{}{"status": "V", "error_reason": "M"})
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE contact_id = $1 AND direction = 'I' AND text = 'machine'`, testdata.Cathy.ID).Returns(1)
+	assertdb.Query(t, db, `SELECT count(*) FROM flows_flowsession WHERE contact_id = $1 AND status = 'I'`, testdata.Cathy.ID).Returns(1)
+
+	// and later status callbacks don't change that
+	body = post(fmt.Sprintf("/ivr/c/%s/status", testdata.TwilioChannel.UUID), url.Values{"CallSid": []string{"Call1"}, "CallStatus": []string{"completed"}, "CallDuration": []string{"20"}})
+	assert.Contains(t, body, `<!--status D ignored, voicemail left-->`)
+
+	assertdb.Query(t, db, `SELECT status FROM ivr_call WHERE external_id = 'Call1'`).Returns("V")
+}
+
+func TestVonageIVRVoicemail(t *testing.T) {
+	ctx, rt, db, rp := testsuite.Get()
+	rc := rp.Get()
+	defer rc.Close()
+
+	defer testsuite.Reset(testsuite.ResetAll)
+
+	db.MustExec(`UPDATE channels_channel SET is_active = FALSE WHERE id = $1`, testdata.TwilioChannel.ID)
+	db.MustExec(`UPDATE channels_channel SET config = config::jsonb || '{"callback_domain": "localhost:8090", "machine_detection": true}'::jsonb, role='SRCA' WHERE id = $1`, testdata.VonageChannel.ID)
+	db.MustExec(`UPDATE flows_flow SET metadata = '{"ivr_voicemail": {"eng": {"text": "Sorry we missed you"}}}' WHERE id = $1`, testdata.IVRFlow.ID)
+
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, string(body))
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+		mockVonageHandler(w, r)
+	}))
+	defer ts.Close()
+
+	wg := &sync.WaitGroup{}
+	server := web.NewServer(ctx, rt, wg)
+	server.Start()
+	defer server.Stop()
+
+	vonage.CallURL = ts.URL
+	vonage.IgnoreSignatures = true
+
+	start := models.NewFlowStart(testdata.Org1.ID, models.StartTypeTrigger, models.FlowTypeVoice, testdata.IVRFlow.ID).
+		WithContactIDs([]models.ContactID{testdata.Cathy.ID})
+	err := models.InsertFlowStarts(ctx, db, []*models.FlowStart{start})
+	require.NoError(t, err)
+
+	err = starts.CreateFlowBatches(ctx, rt, start)
+	require.NoError(t, err)
+
+	task, err := queue.PopNextTask(rc, queue.HandlerQueue)
+	require.NoError(t, err)
+	batch := &models.FlowStartBatch{}
+	jsonx.MustUnmarshal(task.Task, batch)
+
+	err = ivr_tasks.HandleFlowStartBatch(ctx, rt, batch)
+	require.NoError(t, err)
+
+	// call is requested with detection which lets it continue when a machine answers
+	require.Len(t, requests, 1)
+	assert.Contains(t, requests[0], `"machine_detection":"continue"`)
+
+	post := func(path, body string) string {
+		req, err := http.NewRequest(http.MethodPost, "http://localhost:8090/mr"+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+
+		respBody, _ := io.ReadAll(resp.Body)
+		return string(respBody)
+	}
+
+	// Vonage calls us to start the flow as soon as the call is answered
+	body := post(fmt.Sprintf("/ivr/c/%s/handle?action=start&connection=1", testdata.VonageChannel.UUID), `{"uuid":"Call1"}`)
+	assert.Contains(t, body, "Hello there. Please enter one or two.")
+
+	// and then tells us that a machine answered, so we have the call redirected to resume the flow with that
+	body = post(fmt.Sprintf("/ivr/c/%s/status", testdata.VonageChannel.UUID), `{"uuid":"Call1","status":"machine"}`)
+	test.AssertEqualJSON(t, []byte(`{"_message":"machine detected, redirected to voicemail"}`), []byte(body))
+
+	require.Len(t, requests, 2)
+	assert.Contains(t, requests[1], `"action":"transfer"`)
+	assert.Contains(t, requests[1], `action=resume`)
+	assert.Contains(t, requests[1], `wait_type=machine`)
+
+	body = post(fmt.Sprintf("/ivr/c/%s/handle?action=resume&connection=1&wait_type=machine", testdata.VonageChannel.UUID), `{"uuid":"Call1"}`)
+	assert.Contains(t, body, "Sorry we missed you")
+	assert.NotContains(t, body, `"input"`)
+
+	assertdb.Query(t, db, `SELECT status FROM ivr_call WHERE external_id = 'Call1'`).Returns("V")
+	assertdb.Query(t, db, `SELECT count(*) FROM msgs_msg WHERE contact_id = $1 AND direction = 'I' AND text = 'machine'`, testdata.Cathy.ID).Returns(1)
+	assertdb.Query(t, db, `SELECT count(*) FROM flows_flowsession WHERE contact_id = $1 AND status = 'I'`, testdata.Cathy.ID).Returns(1)
+}
@@ -0,0 +1,232 @@
+package msg
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/contactql"
+	"github.com/nyaruka/goflow/envs"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/goflow/flows/actions"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/search"
+	"github.com/nyaruka/mailroom/runtime"
+	"github.com/nyaruka/mailroom/web"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	web.RegisterJSONRoute(http.MethodPost, "/mr/msg/estimate_cost", web.RequireAuthToken(handleEstimateCost))
+}
+
+// Request to estimate the SMS segments of a broadcast or flow, and the cost of sending it to the contacts matching a
+// query on each channel which has a per-segment price. Either translations or flow_id must be provided.
+//
+//	{
+//	  "org_id": 1,
+//	  "translations": {"eng": {"text": "Don’t forget your appointment"}},
+//	  "flow_id": 123,
+//	  "query": "group = \"Doctors\""
+//	}
+//
+// Response is the estimated segments of each message in each language, the number of recipients, and the estimated cost
+// on each priced channel. Costs are upper bounds as they assume every recipient gets the most expensive language and,
+// for flows, every message.
+//
+//	{
+//	  "languages": {
+//	    "eng": {
+//	      "messages": [{
+//	        "text": "Don’t forget your appointment",
+//	        "encoding": "ucs2",
+//	        "characters": 29,
+//	        "segments": 1,
+//	        "unicode_chars": [{"char": "’", "position": 3}],
+//	        "gsm7_text": "Don't forget your appointment",
+//	        "gsm7_segments": 1
+//	      }],
+//	      "msg_count": 1
+//	    }
+//	  },
+//	  "recipients": 120,
+//	  "costs": [
+//	    {"channel": {"uuid": "74729f45-7f29-4868-9dc4-90e491e3c7d8", "name": "Twilio"}, "msg_count": 120, "amount": 0.9, "currency": "USD"}
+//	  ]
+//	}
+type estimateCostRequest struct {
+	OrgID        models.OrgID                                   `json:"org_id"   validate:"required"`
+	Translations map[envs.Language]*models.BroadcastTranslation `json:"translations"`
+	FlowID       models.FlowID                                  `json:"flow_id"`
+	Query        string                                         `json:"query"`
+}
+
+type languageEstimate struct {
+	Messages []*models.SegmentEstimate `json:"messages"`
+	MsgCount int                       `json:"msg_count"`
+}
+
+type channelCost struct {
+	Channel  *assets.ChannelReference `json:"channel"`
+	MsgCount int                      `json:"msg_count"`
+	Amount   float64                  `json:"amount"`
+	Currency string                   `json:"currency"`
+}
+
+type estimateCostResponse struct {
+	Languages  map[envs.Language]*languageEstimate `json:"languages"`
+	Recipients int                                 `json:"recipients"`
+	Costs      []*channelCost                      `json:"costs"`
+}
+
+// a message's text and number of attachments
+type estimateMsg struct {
+	text        string
+	attachments int
+}
+
+// handles a request to estimate the segments and cost of sending a broadcast or flow
+func handleEstimateCost(ctx context.Context, rt *runtime.Runtime, r *http.Request) (interface{}, int, error) {
+	request := &estimateCostRequest{}
+	if err := web.ReadAndValidateJSON(r, request); err != nil {
+		return errors.Wrapf(err, "request failed validation"), http.StatusBadRequest, nil
+	}
+	if (len(request.Translations) == 0) == (request.FlowID == models.NilFlowID) {
+		return errors.New("must provide either translations or a flow"), http.StatusBadRequest, nil
+	}
+
+	oa, err := models.GetOrgAssets(ctx, rt, request.OrgID)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "unable to load org assets")
+	}
+
+	var msgsByLang map[envs.Language][]estimateMsg
+	if request.FlowID != models.NilFlowID {
+		msgsByLang, err = flowMsgsByLanguage(oa, request.FlowID)
+		if err != nil {
+			return errors.Wrapf(err, "unable to load flow"), http.StatusBadRequest, nil
+		}
+	} else {
+		msgsByLang = make(map[envs.Language][]estimateMsg, len(request.Translations))
+		for lang, t := range request.Translations {
+			msgsByLang[lang] = []estimateMsg{{text: t.Text, attachments: len(t.Attachments)}}
+		}
+	}
+
+	response := &estimateCostResponse{
+		Languages: make(map[envs.Language]*languageEstimate, len(msgsByLang)),
+		Costs:     []*channelCost{},
+	}
+
+	// messages are counted the same way as when they're created, i.e. segments of text plus attachments
+	maxMsgCount := 0
+	for lang, msgs := range msgsByLang {
+		estimate := &languageEstimate{Messages: make([]*models.SegmentEstimate, len(msgs))}
+		for i, m := range msgs {
+			estimate.Messages[i] = models.EstimateSegments(m.text)
+			estimate.MsgCount += estimate.Messages[i].Segments + m.attachments
+		}
+		if estimate.MsgCount > maxMsgCount {
+			maxMsgCount = estimate.MsgCount
+		}
+		response.Languages[lang] = estimate
+	}
+
+	if request.Query != "" {
+		// only active contacts can be sent messages
+		_, total, err := search.GetContactTotal(ctx, rt, oa, request.Query)
+		if err != nil {
+			isQueryError, qerr := contactql.IsQueryError(err)
+			if isQueryError {
+				return qerr, http.StatusBadRequest, nil
+			}
+			return nil, http.StatusInternalServerError, errors.Wrapf(err, "error querying recipients")
+		}
+		response.Recipients = int(total)
+	}
+
+	channels, err := oa.Channels()
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "unable to load channels")
+	}
+
+	for _, c := range channels {
+		channel := c.(*models.Channel)
+		price := channel.MsgSegmentPrice()
+		if price == nil || !isSMSChannel(channel) {
+			continue
+		}
+
+		msgCount := maxMsgCount * response.Recipients
+
+		response.Costs = append(response.Costs, &channelCost{
+			Channel:  channel.ChannelReference(),
+			MsgCount: msgCount,
+			Amount:   math.Round(float64(msgCount)*price.Amount*1000000) / 1000000,
+			Currency: price.Currency,
+		})
+	}
+	sort.Slice(response.Costs, func(i, j int) bool { return response.Costs[i].Channel.Name < response.Costs[j].Channel.Name })
+
+	return response, http.StatusOK, nil
+}
+
+// gets the text and number of attachments of every send_msg action in the given flow, in each of its languages
+func flowMsgsByLanguage(oa *models.OrgAssets, flowID models.FlowID) (map[envs.Language][]estimateMsg, error) {
+	dbFlow, err := oa.FlowByID(flowID)
+	if err != nil {
+		return nil, err
+	}
+
+	flow, err := oa.SessionAssets().Flows().Get(dbFlow.UUID())
+	if err != nil {
+		return nil, err
+	}
+
+	languages := append([]envs.Language{flow.Language()}, flow.Localization().Languages()...)
+	msgsByLang := make(map[envs.Language][]estimateMsg, len(languages))
+
+	for _, lang := range languages {
+		if _, seen := msgsByLang[lang]; seen {
+			continue
+		}
+
+		msgs := make([]estimateMsg, 0, 5)
+
+		for _, node := range flow.Nodes() {
+			for _, action := range node.Actions() {
+				send, isSend := action.(*actions.SendMsgAction)
+				if !isSend {
+					continue
+				}
+
+				text, attachments := send.Text, send.Attachments
+				if lang != flow.Language() {
+					if t := flow.Localization().GetItemTranslation(lang, uuids.UUID(send.UUID()), "text"); len(t) > 0 {
+						text = t[0]
+					}
+					if t := flow.Localization().GetItemTranslation(lang, uuids.UUID(send.UUID()), "attachments"); t != nil {
+						attachments = t
+					}
+				}
+
+				msgs = append(msgs, estimateMsg{text: text, attachments: len(attachments)})
+			}
+		}
+
+		msgsByLang[lang] = msgs
+	}
+
+	return msgsByLang, nil
+}
+
+// whether the given channel can send messages to tel URNs
+func isSMSChannel(channel *models.Channel) bool {
+	flowChannel := flows.NewChannel(channel)
+	return flowChannel.SupportsScheme(urns.TelScheme) && flowChannel.HasRole(assets.ChannelRoleSend)
+}
@@ -28,3 +28,22 @@ func TestServer(t *testing.T) {
 		"george_msgout_id": fmt.Sprintf("%d", georgeOut.ID()),
 	})
 }
+
+func TestEstimateCost(t *testing.T) {
+	ctx, rt, db, _ := testsuite.Get()
+
+	defer testsuite.Reset(testsuite.ResetData)
+
+	mockES := testsuite.NewMockElasticServer()
+	defer mockES.Close()
+
+	rt.ES = mockES.Client()
+
+	mockES.Responses = append(mockES.Responses, []byte(`{"count": 2, "_shards": {"total": 1, "successful": 1, "skipped": 0, "failed": 0}}`))
+
+	db.MustExec(`UPDATE channels_channel SET name = 'Twilio', config = '{"msg_segment_price": {"amount": 0.0075, "currency": "USD"}}' WHERE id = $1`, testdata.TwilioChannel.ID)
+	db.MustExec(`UPDATE channels_channel SET name = 'Vonage', config = '{"msg_segment_price": {"amount": 0.01, "currency": "EUR"}}' WHERE id = $1`, testdata.VonageChannel.ID)
+	models.FlushCache()
+
+	web.RunWebTests(t, ctx, rt, "testdata/estimate_cost.json", nil)
+}
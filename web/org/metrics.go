@@ -6,6 +6,7 @@ import (
 
 	"github.com/go-chi/chi"
 	"github.com/golang/protobuf/proto"
+	"github.com/nyaruka/gocommon/dates"
 	"github.com/nyaruka/gocommon/uuids"
 	"github.com/nyaruka/goflow/assets"
 	"github.com/nyaruka/mailroom/core/models"
@@ -233,6 +234,84 @@ func calculateChannelCounts(ctx context.Context, rt *runtime.Runtime, org *model
 	return family, err
 }
 
+func calculateCampaignBacklog(rt *runtime.Runtime, org *models.OrgReference) (*dto.MetricFamily, error) {
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	backlog, err := models.GetCampaignFireBacklog(rc, org.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	family := &dto.MetricFamily{
+		Name:   proto.String("rapidpro_campaign_fire_backlog"),
+		Help:   proto.String("the number of due campaign event fires held back by throttling"),
+		Type:   dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{},
+	}
+
+	for eventUUID, count := range backlog {
+		family.Metric = append(family.Metric,
+			&dto.Metric{
+				Label: []*dto.LabelPair{
+					{
+						Name:  proto.String("campaign_event_uuid"),
+						Value: proto.String(string(eventUUID)),
+					},
+					{
+						Name:  proto.String("org"),
+						Value: proto.String(org.Name),
+					},
+				},
+				Gauge: &dto.Gauge{
+					Value: proto.Float64(float64(count)),
+				},
+			},
+		)
+	}
+
+	return family, nil
+}
+
+func calculateFrequencyCapped(rt *runtime.Runtime, org *models.OrgReference) (*dto.MetricFamily, error) {
+	rc := rt.RP.Get()
+	defer rc.Close()
+
+	counts, err := models.GetMsgFrequencyCappedCounts(rc, org.ID, dates.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	family := &dto.MetricFamily{
+		Name:   proto.String("rapidpro_msgs_frequency_capped_count"),
+		Help:   proto.String("the number of messages failed or deferred today by the contact frequency cap"),
+		Type:   dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{},
+	}
+
+	for action, count := range counts {
+		family.Metric = append(family.Metric,
+			&dto.Metric{
+				Label: []*dto.LabelPair{
+					{
+						Name:  proto.String("action"),
+						Value: proto.String(action),
+					},
+					{
+						Name:  proto.String("org"),
+						Value: proto.String(org.Name),
+					},
+				},
+				Gauge: &dto.Gauge{
+					Value: proto.Float64(float64(count)),
+				},
+			},
+		)
+	}
+
+	return family, nil
+}
+
 func handleMetrics(ctx context.Context, rt *runtime.Runtime, r *http.Request, rawW http.ResponseWriter) error {
 	// we should have basic auth headers, username should be metrics
 	username, token, ok := r.BasicAuth()
@@ -264,6 +343,16 @@ func handleMetrics(ctx context.Context, rt *runtime.Runtime, r *http.Request, ra
 		return errors.Wrapf(err, "error calculating channel counts for org: %d", org.ID)
 	}
 
+	backlog, err := calculateCampaignBacklog(rt, org)
+	if err != nil {
+		return errors.Wrapf(err, "error calculating campaign backlog for org: %d", org.ID)
+	}
+
+	capped, err := calculateFrequencyCapped(rt, org)
+	if err != nil {
+		return errors.Wrapf(err, "error calculating frequency capped msgs for org: %d", org.ID)
+	}
+
 	rawW.WriteHeader(http.StatusOK)
 
 	_, err = expfmt.MetricFamilyToText(rawW, groups)
@@ -278,5 +367,19 @@ func handleMetrics(ctx context.Context, rt *runtime.Runtime, r *http.Request, ra
 		}
 	}
 
+	if len(backlog.Metric) > 0 {
+		_, err = expfmt.MetricFamilyToText(rawW, backlog)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(capped.Metric) > 0 {
+		_, err = expfmt.MetricFamilyToText(rawW, capped)
+		if err != nil {
+			return err
+		}
+	}
+
 	return err
 }
@@ -0,0 +1,69 @@
+package web
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/goflow/assets"
+	"github.com/nyaruka/goflow/contactql"
+	"github.com/nyaruka/goflow/flows"
+	"github.com/nyaruka/mailroom/core/models"
+	"github.com/nyaruka/mailroom/core/search"
+	"github.com/nyaruka/mailroom/runtime"
+
+	"github.com/pkg/errors"
+)
+
+// RecipientsInclude is the groups, contacts, URNs and query whose contacts are the recipients of a broadcast or flow start
+type RecipientsInclude struct {
+	GroupUUIDs   []assets.GroupUUID  `json:"group_uuids"`
+	ContactUUIDs []flows.ContactUUID `json:"contact_uuids"`
+	URNs         []urns.URN          `json:"urns"`
+	Query        string              `json:"query"`
+}
+
+// RecipientsPreview is the query for the recipients of a broadcast or flow start, with the total number of contacts
+// matching it and a sample of them
+type RecipientsPreview struct {
+	Query     string                `json:"query"`
+	Total     int                   `json:"total"`
+	SampleIDs []models.ContactID    `json:"sample_ids"`
+	Metadata  *contactql.Inspection `json:"metadata,omitempty"`
+}
+
+// PreviewRecipients builds the query for the included recipients using the given function, and responds with a preview
+// of the contacts who match it
+func PreviewRecipients(ctx context.Context, rt *runtime.Runtime, oa *models.OrgAssets, include *RecipientsInclude, sampleSize int, buildQuery func([]*models.Group) (string, error)) (interface{}, int, error) {
+	groups := make([]*models.Group, 0, len(include.GroupUUIDs))
+	for _, groupUUID := range include.GroupUUIDs {
+		g := oa.GroupByUUID(groupUUID)
+		if g != nil {
+			groups = append(groups, g)
+		}
+	}
+
+	query, err := buildQuery(groups)
+	if err != nil {
+		isQueryError, qerr := contactql.IsQueryError(err)
+		if isQueryError {
+			return qerr, http.StatusBadRequest, nil
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+	if query == "" {
+		return &RecipientsPreview{SampleIDs: []models.ContactID{}}, http.StatusOK, nil
+	}
+
+	parsedQuery, sampleIDs, total, err := search.GetContactIDsForQueryPage(ctx, rt, oa, nil, nil, query, "", 0, sampleSize)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.Wrapf(err, "error querying preview")
+	}
+
+	return &RecipientsPreview{
+		Query:     parsedQuery.String(),
+		Total:     int(total),
+		SampleIDs: sampleIDs,
+		Metadata:  contactql.Inspect(parsedQuery),
+	}, http.StatusOK, nil
+}